@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/openshift/ocs-operator/pkg/controller/placement"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ocs-placement prints where the operator places the storage nodes and the
+// OSDs of the given device sets, along with warnings about the placement,
+// without talking to a cluster
+func main() {
+	nodesFile := flag.String("nodes", "", "file holding the storage nodes, as a list or as YAML documents")
+	deviceSetsFile := flag.String("device-sets", "", "file holding the list of StorageDeviceSets")
+	failureDomain := flag.String("failure-domain", "", "failure domain of the StorageCluster spec")
+	topologyKeys := flag.String("topology-keys", "", "comma separated node labels making up the topology")
+	resourceProfile := flag.String("resource-profile", "", "resource profile of the OSDs")
+	flag.Parse()
+
+	if *nodesFile == "" || *deviceSetsFile == "" {
+		fmt.Fprintln(os.Stderr, "--nodes and --device-sets are required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	in := placement.Input{
+		FailureDomain:   *failureDomain,
+		ResourceProfile: *resourceProfile,
+	}
+	if *topologyKeys != "" {
+		in.TopologyKeys = strings.Split(*topologyKeys, ",")
+	}
+
+	err := simulate(*nodesFile, *deviceSetsFile, in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func simulate(nodesFile, deviceSetsFile string, in placement.Input) error {
+	nodes, err := readNodes(nodesFile)
+	if err != nil {
+		return err
+	}
+	in.Nodes = nodes
+
+	data, err := ioutil.ReadFile(deviceSetsFile)
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(data, &in.DeviceSets)
+	if err != nil {
+		return fmt.Errorf("Failed to decode %s: %v", deviceSetsFile, err)
+	}
+
+	data, err = yaml.Marshal(placement.Simulate(in))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s", data)
+	return nil
+}
+
+// readNodes decodes the nodes of every YAML or JSON document in the file
+func readNodes(file string) ([]corev1.Node, error) {
+	scheme := runtime.NewScheme()
+	err := corev1.AddToScheme(scheme)
+	if err != nil {
+		return nil, err
+	}
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	nodes := []corev1.Node{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nodes, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %v", file, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode %s: %v", file, err)
+		}
+		switch o := obj.(type) {
+		case *corev1.Node:
+			nodes = append(nodes, *o)
+		case *corev1.NodeList:
+			nodes = append(nodes, o.Items...)
+		case *corev1.List:
+			for _, item := range o.Items {
+				node, _, err := decoder.Decode(item.Raw, nil, nil)
+				if err != nil {
+					return nil, fmt.Errorf("Failed to decode list item in %s: %v", file, err)
+				}
+				if n, ok := node.(*corev1.Node); ok {
+					nodes = append(nodes, *n)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("Expected nodes in %s, found %s", file, obj.GetObjectKind().GroupVersionKind().Kind)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/storagecluster"
+	"github.com/openshift/ocs-operator/pkg/controller/storagecluster/render"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// ocs-render prints the objects the operator creates for a StorageCluster,
+// along with the StorageCluster carrying its computed status, without
+// talking to a cluster
+func main() {
+	storageClusterFile := flag.String("storagecluster", "", "file holding the StorageCluster")
+	nodesFile := flag.String("nodes", "", "file holding the nodes, as a list or as YAML documents")
+	namespace := flag.String("namespace", "openshift-storage", "namespace of the StorageCluster if it names none")
+	cephImage := flag.String("ceph-image", os.Getenv("CEPH_IMAGE"), "Ceph image")
+	noobaaCoreImage := flag.String("noobaa-core-image", os.Getenv("NOOBAA_CORE_IMAGE"), "NooBaa core image")
+	noobaaDBImage := flag.String("noobaa-db-image", os.Getenv("NOOBAA_DB_IMAGE"), "NooBaa DB image")
+	flag.Parse()
+
+	if *storageClusterFile == "" {
+		fmt.Fprintln(os.Stderr, "--storagecluster is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := renderFiles(*storageClusterFile, *nodesFile, *namespace, storagecluster.ReconcilerOptions{
+		CephImage:       *cephImage,
+		NoobaaCoreImage: *noobaaCoreImage,
+		NoobaaDBImage:   *noobaaDBImage,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func renderFiles(storageClusterFile, nodesFile, namespace string, opts storagecluster.ReconcilerOptions) error {
+	scheme, err := render.NewScheme()
+	if err != nil {
+		return err
+	}
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+
+	objects, err := readObjects(decoder, storageClusterFile)
+	if err != nil {
+		return err
+	}
+	if len(objects) != 1 {
+		return fmt.Errorf("Expected one StorageCluster in %s, found %d objects", storageClusterFile, len(objects))
+	}
+	sc, ok := objects[0].(*ocsv1.StorageCluster)
+	if !ok {
+		return fmt.Errorf("Expected a StorageCluster in %s, found %s", storageClusterFile, objects[0].GetObjectKind().GroupVersionKind().Kind)
+	}
+	if sc.Namespace == "" {
+		sc.Namespace = namespace
+	}
+
+	nodes := []corev1.Node{}
+	if nodesFile != "" {
+		objects, err = readObjects(decoder, nodesFile)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			switch o := obj.(type) {
+			case *corev1.Node:
+				nodes = append(nodes, *o)
+			case *corev1.NodeList:
+				nodes = append(nodes, o.Items...)
+			case *corev1.List:
+				for _, item := range o.Items {
+					node, _, err := decoder.Decode(item.Raw, nil, nil)
+					if err != nil {
+						return fmt.Errorf("Failed to decode list item in %s: %v", nodesFile, err)
+					}
+					if n, ok := node.(*corev1.Node); ok {
+						nodes = append(nodes, *n)
+					}
+				}
+			default:
+				return fmt.Errorf("Expected nodes in %s, found %s", nodesFile, obj.GetObjectKind().GroupVersionKind().Kind)
+			}
+		}
+	}
+
+	result, err := render.Render(sc, nodes, opts)
+	if err != nil {
+		return err
+	}
+
+	data, err := result.Marshal()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s", data)
+	return nil
+}
+
+// readObjects decodes every YAML or JSON document in the file
+func readObjects(decoder runtime.Decoder, file string) ([]runtime.Object, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	objects := []runtime.Object{}
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(f))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %v", file, err)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode %s: %v", file, err)
+		}
+		objects = append(objects, obj)
+	}
+}
@@ -1,6 +1,7 @@
 package v1
 
 import (
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
 	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
 	rookalpha "github.com/rook/rook/pkg/apis/rook.io/v1alpha2"
 	corev1 "k8s.io/api/core/v1"
@@ -14,14 +15,299 @@ import (
 
 // StorageClusterSpec defines the desired state of StorageCluster
 type StorageClusterSpec struct {
-	ManageNodes  bool   `json:"manageNodes,omitempty"`
+	// ManageNodes has the operator pick the storage nodes itself and label
+	// them, as configured by NodeManagement
+	ManageNodes bool `json:"manageNodes,omitempty"`
+	// InstanceType is the cloud instance type of the storage nodes, e.g.
+	// "m5.4xlarge". Known instance types set the default ResourceProfile
+	// and the default size and StorageClass of the device sets
+	// +optional
 	InstanceType string `json:"instanceType,omitempty"`
+	// NodeManagement configures how the storage nodes are picked when
+	// ManageNodes is set
+	// +optional
+	NodeManagement NodeManagementSpec `json:"nodeManagement,omitempty"`
 	// HostNetwork defaults to false
 	HostNetwork bool `json:"hostNetwork,omitempty"`
-	// Resources follows the conventions of and is mapped to CephCluster.Spec.Resources
+	// ResourceProfile selects the default resources of all daemons. It is
+	// one of "lean", "balanced" or "performance". Defaults to the profile
+	// of the InstanceType, or "balanced"
+	// +kubebuilder:validation:Enum=lean;balanced;performance
+	// +optional
+	ResourceProfile string `json:"resourceProfile,omitempty"`
+	// ResourceFit configures the check that the daemons fit on the storage
+	// nodes before they are deployed
+	// +optional
+	ResourceFit ResourceFitSpec `json:"resourceFit,omitempty"`
+	// Resources follows the conventions of and is mapped to CephCluster.Spec.Resources.
+	// The resources of a daemon set here take precedence over its ResourceProfile
 	Resources         map[string]corev1.ResourceRequirements `json:"resources,omitempty"`
 	StorageDeviceSets []StorageDeviceSet                     `json:"storageDeviceSets,omitempty"`
 	MonPVCTemplate    *corev1.PersistentVolumeClaim          `json:"monPVCTemplate,omitempty"`
+	// TopologyKeys are the node labels, in order of preference, collected
+	// into the NodeTopologies. The part of a key after the last "/" is the
+	// failure domain it stands for, e.g. "zone". Labels are matched
+	// exactly. Defaults to the GA and deprecated zone and region labels
+	// and the Rook topology labels
+	// +optional
+	TopologyKeys []string `json:"topologyKeys,omitempty"`
+	// FailureDomain is the CRUSH failure domain the data replicas are
+	// spread across. It is one of "host", "rack" or "zone", or a node label
+	// whose part after the last "/" names the failure domain, such as
+	// "example.com/rack". Defaults to "zone" if the storage nodes are in at
+	// least three zones, and to "rack" otherwise. It is only changed on a
+	// deployed StorageCluster if no data has to move
+	// +optional
+	FailureDomain string `json:"failureDomain,omitempty"`
+	// RackRebalance configures the rebalancing of the racks the operator
+	// labels the storage nodes with
+	// +optional
+	RackRebalance RackRebalanceSpec `json:"rackRebalance,omitempty"`
+	// DedicatedNodes reserves the storage nodes for OCS
+	// +optional
+	DedicatedNodes DedicatedNodesSpec `json:"dedicatedNodes,omitempty"`
+	// Components toggles the optional components deployed for the
+	// StorageCluster. All components are enabled by default.
+	// +optional
+	Components ComponentsSpec `json:"components,omitempty"`
+	// MultiCloudGateway configures the backing stores and bucket classes
+	// of the NooBaa system
+	// +optional
+	MultiCloudGateway *MultiCloudGatewaySpec `json:"multiCloudGateway,omitempty"`
+	// ObjectStore configures the endpoint of the Ceph object store (RGW)
+	// +optional
+	ObjectStore ObjectStoreSpec `json:"objectStore,omitempty"`
+	// Uninstall configures how the StorageCluster and everything created
+	// for it is cleaned up when the StorageCluster is deleted
+	// +optional
+	Uninstall UninstallSpec `json:"uninstall,omitempty"`
+	// ReconcileStrategy is "Apply" to bring the objects of the
+	// StorageCluster to their desired state, or "Plan" to only work out the
+	// changes that would be made. The plan is published under the "plan"
+	// key of the "<name>-reconcile-plan" ConfigMap. Defaults to "Apply"
+	// +kubebuilder:validation:Enum=Apply;Plan
+	// +optional
+	ReconcileStrategy string `json:"reconcileStrategy,omitempty"`
+}
+
+// RackRebalanceSpec defines how the storage nodes are moved between the racks
+// made up by the operator when the racks become uneven
+type RackRebalanceSpec struct {
+	// Enabled has the moves of storage nodes between racks that balance
+	// them proposed in the status. Storage nodes running OSDs are not
+	// moved, as their OSDs would stay in their rack in CRUSH
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Apply has the proposed moves applied, one node at a time, each once
+	// Ceph is healthy again after the previous one
+	// +optional
+	Apply bool `json:"apply,omitempty"`
+}
+
+// ComponentsSpec defines which optional components are deployed
+type ComponentsSpec struct {
+	// DisableObjectStore turns off the Ceph object store (RGW), its user
+	// and its bucket StorageClass
+	// +optional
+	DisableObjectStore bool `json:"disableObjectStore,omitempty"`
+
+	// DisableMultiCloudGateway turns off the NooBaa system and its bucket
+	// StorageClass
+	// +optional
+	DisableMultiCloudGateway bool `json:"disableMultiCloudGateway,omitempty"`
+}
+
+// MultiCloudGatewaySpec defines the tiers of the multicloud gateway
+type MultiCloudGatewaySpec struct {
+	// BackingStores are reconciled into NooBaa BackingStores
+	// +optional
+	BackingStores []MultiCloudGatewayBackingStore `json:"backingStores,omitempty"`
+
+	// BucketClasses are reconciled into NooBaa BucketClasses
+	// +optional
+	BucketClasses []MultiCloudGatewayBucketClass `json:"bucketClasses,omitempty"`
+}
+
+// MultiCloudGatewayBackingStore defines a NooBaa BackingStore. Exactly the
+// section matching the Type must be set. Its credentials Secret must be in
+// the namespace of the StorageCluster.
+type MultiCloudGatewayBackingStore struct {
+	// Name is the name of the BackingStore
+	Name string `json:"name"`
+
+	// Type is one of aws-s3, s3-compatible, azure-blob or pv-pool
+	// +kubebuilder:validation:Enum=aws-s3;s3-compatible;azure-blob;pv-pool
+	Type nbv1.StoreType `json:"type"`
+
+	// +optional
+	AWSS3 *nbv1.AWSS3Spec `json:"awsS3,omitempty"`
+	// +optional
+	S3Compatible *nbv1.S3CompatibleSpec `json:"s3Compatible,omitempty"`
+	// +optional
+	AzureBlob *nbv1.AzureBlobSpec `json:"azureBlob,omitempty"`
+	// +optional
+	PVPool *nbv1.PVPoolSpec `json:"pvPool,omitempty"`
+}
+
+// MultiCloudGatewayBucketClass defines a NooBaa BucketClass
+type MultiCloudGatewayBucketClass struct {
+	// Name is the name of the BucketClass
+	Name string `json:"name"`
+
+	// PlacementPolicy lists the tiers of backing stores, by name, that
+	// the buckets of this class are placed on
+	PlacementPolicy nbv1.PlacementPolicy `json:"placementPolicy"`
+}
+
+// DedicatedNodesSpec defines how the storage nodes are set apart from the
+// other nodes. Turning an option off rolls back the changes it made to the
+// nodes
+type DedicatedNodesSpec struct {
+	// Taint taints the storage nodes so that only OCS pods are scheduled
+	// on them. A node running other pods is only tainted once they are
+	// gone, unless Force is set
+	// +optional
+	Taint bool `json:"taint,omitempty"`
+
+	// Force taints the storage nodes even if other pods run on them. These
+	// pods keep running, but are not scheduled on the node again
+	// +optional
+	Force bool `json:"force,omitempty"`
+
+	// Infra marks the storage nodes as infra nodes
+	// +optional
+	Infra bool `json:"infra,omitempty"`
+}
+
+// NodeManagementSpec defines which nodes the operator turns into storage
+// nodes
+type NodeManagementSpec struct {
+	// NodeSelector selects the nodes that may become storage nodes.
+	// Defaults to the worker nodes, of the InstanceType if it is set
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Count is the desired number of storage nodes. Defaults to the
+	// largest Replica of the device sets
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Count int `json:"count,omitempty"`
+
+	// Taint taints the managed storage nodes like DedicatedNodes.Taint.
+	// Deprecated: use DedicatedNodes.Taint instead
+	// +optional
+	Taint bool `json:"taint,omitempty"`
+}
+
+// ResourceFitSpec defines how the resource requests of the daemons are
+// checked against the allocatable resources of the storage nodes
+type ResourceFitSpec struct {
+	// DisableCheck turns off the check. Daemons that do not fit are then
+	// left Pending by the scheduler
+	// +optional
+	DisableCheck bool `json:"disableCheck,omitempty"`
+
+	// AutoScaleDown lowers the ResourceProfile, down to "lean", until the
+	// daemons fit on the storage nodes. The profile is only lowered before
+	// the cluster is first deployed. Daemons with resources set in
+	// Resources are not scaled down
+	// +optional
+	AutoScaleDown bool `json:"autoScaleDown,omitempty"`
+}
+
+// ObjectStoreSpec defines how the S3 endpoint of the Ceph object store is
+// served and exposed
+type ObjectStoreSpec struct {
+	// TLS serves the S3 endpoint over HTTPS in addition to HTTP
+	// +optional
+	TLS *ObjectStoreTLSSpec `json:"tls,omitempty"`
+
+	// Exposure publishes the S3 endpoint outside of the cluster
+	// +optional
+	Exposure *ObjectStoreExposureSpec `json:"exposure,omitempty"`
+}
+
+// CertificateSourceType is the type of the source of a TLS certificate
+type CertificateSourceType string
+
+const (
+	// CertificateSourceServiceServingCA has the OpenShift service-serving CA
+	// issue and rotate the certificate of the RGW service
+	CertificateSourceServiceServingCA CertificateSourceType = "ServiceServingCA"
+	// CertificateSourceSecret uses the certificate of a user-supplied
+	// kubernetes.io/tls Secret
+	CertificateSourceSecret CertificateSourceType = "Secret"
+)
+
+// ObjectStoreTLSSpec defines where the certificate of the S3 endpoint comes
+// from
+type ObjectStoreTLSSpec struct {
+	// Source is either "ServiceServingCA" or "Secret". Defaults to
+	// "ServiceServingCA"
+	// +kubebuilder:validation:Enum=ServiceServingCA;Secret
+	// +optional
+	Source CertificateSourceType `json:"source,omitempty"`
+
+	// SecretName is the name of the kubernetes.io/tls Secret, in the
+	// StorageCluster namespace, holding the certificate. Required if Source
+	// is "Secret"
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// ExposureType is the type of the object exposing an endpoint
+type ExposureType string
+
+const (
+	// ExposureRoute exposes the endpoint with an OpenShift Route
+	ExposureRoute ExposureType = "Route"
+	// ExposureIngress exposes the endpoint with an Ingress
+	ExposureIngress ExposureType = "Ingress"
+)
+
+// ObjectStoreExposureSpec defines how the S3 endpoint is exposed outside of
+// the cluster
+type ObjectStoreExposureSpec struct {
+	// Type is either "Route" or "Ingress". Defaults to "Route"
+	// +kubebuilder:validation:Enum=Route;Ingress
+	// +optional
+	Type ExposureType `json:"type,omitempty"`
+
+	// Hostname is the external hostname of the S3 endpoint. Required for an
+	// Ingress. The router picks one for a Route if it is not set
+	// +optional
+	Hostname string `json:"hostname,omitempty"`
+}
+
+// UninstallModeType is the type of the uninstall mode
+type UninstallModeType string
+
+const (
+	// UninstallModeGraceful blocks the deletion of a StorageCluster while
+	// any PVCs, VolumeSnapshots or OBCs still use the StorageClasses it
+	// created
+	UninstallModeGraceful UninstallModeType = "graceful"
+	// UninstallModeForced deletes a StorageCluster and all of its
+	// resources regardless of any remaining consumers
+	UninstallModeForced UninstallModeType = "forced"
+)
+
+// ForceDeletionAnnotation can be set to "true" on a StorageCluster to delete
+// it in forced mode, regardless of its Spec.Uninstall.Mode
+const ForceDeletionAnnotation = "uninstall.ocs.openshift.io/force-deletion"
+
+// UninstallSpec defines the uninstall policy of a StorageCluster
+type UninstallSpec struct {
+	// Mode is either "graceful" or "forced". Defaults to "graceful"
+	// +kubebuilder:validation:Enum=graceful;forced
+	// +optional
+	Mode UninstallModeType `json:"mode,omitempty"`
+
+	// WipeDevices says whether the cleanup Jobs should also wipe the local
+	// devices that backed the OSDs, in addition to the DataDirHostPath
+	// +optional
+	WipeDevices bool `json:"wipeDevices,omitempty"`
 }
 
 // StorageDeviceSet defines a set of storage devices.
@@ -59,6 +345,97 @@ type StorageDeviceSet struct {
 // TODO: Fill in the members when the actual configurable options are defined in rook-ceph
 type StorageDeviceSetConfig struct{}
 
+// StorageNodeTopology holds the topology labels of a storage node
+type StorageNodeTopology struct {
+	// Name is the name of the node
+	Name string `json:"name"`
+
+	// Labels are the topology labels of the node
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// StaleTopologyLabel is a topology label value no longer found on any
+// storage node
+type StaleTopologyLabel struct {
+	// Label is the topology label
+	Label string `json:"label"`
+
+	// Value is the value of the label
+	Value string `json:"value"`
+
+	// MissingSince is when the value was first found missing
+	MissingSince metav1.Time `json:"missingSince"`
+}
+
+// RackMove is a move of a storage node from one rack to another
+type RackMove struct {
+	// Node is the name of the node
+	Node string `json:"node"`
+
+	// FromRack is the rack the node is in
+	FromRack string `json:"fromRack"`
+
+	// ToRack is the rack the node is moved to
+	ToRack string `json:"toRack"`
+}
+
+// RackRebalanceStatus describes the rebalancing of the racks
+type RackRebalanceStatus struct {
+	// ProposedMoves are the moves that balance the racks, in the order
+	// they are applied
+	// +optional
+	ProposedMoves []RackMove `json:"proposedMoves,omitempty"`
+
+	// Message explains the proposed moves, or why the next one is not
+	// applied yet
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastMove is the move applied last
+	// +optional
+	LastMove *RackMove `json:"lastMove,omitempty"`
+
+	// LastMoveTime is when the last move was applied
+	// +optional
+	LastMoveTime *metav1.Time `json:"lastMoveTime,omitempty"`
+}
+
+// ReconcileStepResult is the outcome of a step of the reconcile
+type ReconcileStepResult string
+
+// Outcomes of a step of the reconcile
+const (
+	// ReconcileStepSucceeded is used when the step ran to completion
+	ReconcileStepSucceeded ReconcileStepResult = "Succeeded"
+	// ReconcileStepFailed is used when the step returned an error
+	ReconcileStepFailed ReconcileStepResult = "Failed"
+	// ReconcileStepBlocked is used when the step did not run because a
+	// step it depends on did not succeed
+	ReconcileStepBlocked ReconcileStepResult = "Blocked"
+	// ReconcileStepSkipped is used when the step does not apply to the
+	// StorageCluster
+	ReconcileStepSkipped ReconcileStepResult = "Skipped"
+)
+
+// ReconcileStepStatus is the outcome of a step of the last reconcile
+type ReconcileStepStatus struct {
+	// Name is the name of the step
+	Name string `json:"name"`
+
+	// Result is the outcome of the step
+	Result ReconcileStepResult `json:"result"`
+
+	// Duration is how long the step took to run, if it ran
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// Error is the error the step failed with, or the steps it is blocked
+	// by
+	// +optional
+	Error string `json:"error,omitempty"`
+}
+
 // StorageClusterStatus defines the observed state of StorageCluster
 // +k8s:openapi-gen=true
 type StorageClusterStatus struct {
@@ -71,6 +448,11 @@ type StorageClusterStatus struct {
 	// +optional
 	Conditions []conditionsv1.Condition `json:"conditions,omitempty"`
 
+	// ObservedGeneration is the generation of the StorageCluster the status
+	// was last written for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// RelatedObjects is a list of objects created and maintained by this
 	// operator. Object references will be added to this list after they have
 	// been created AND found in the cluster.
@@ -82,16 +464,63 @@ type StorageClusterStatus struct {
 	// +optional
 	NodeTopologies *NodeTopologyMap `json:"nodeTopologies,omitempty"`
 
+	// StorageNodes lists the topology labels of each storage node
+	// +optional
+	StorageNodes []StorageNodeTopology `json:"storageNodes,omitempty"`
+
+	// StaleNodeTopologies are the values in NodeTopologies that are no
+	// longer found on any storage node. They are pruned from NodeTopologies
+	// once they have been missing for a grace period
+	// +optional
+	StaleNodeTopologies []StaleTopologyLabel `json:"staleNodeTopologies,omitempty"`
+
 	// FailureDomain is the base CRUSH element Ceph will use to distribute
 	// its data replicas for the default CephBlockPool
 	// +optional
 	FailureDomain string `json:"failureDomain,omitempty"`
 
+	// FailureDomainKey is the node label holding the FailureDomain of the
+	// storage nodes
+	// +optional
+	FailureDomainKey string `json:"failureDomainKey,omitempty"`
+
+	// RackRebalance describes the rebalancing of the racks, if enabled
+	// +optional
+	RackRebalance *RackRebalanceStatus `json:"rackRebalance,omitempty"`
+
+	// ObjectStoreEndpoint is the external URL of the S3 endpoint of the
+	// Ceph object store. It is only set while the endpoint is exposed
+	// +optional
+	ObjectStoreEndpoint string `json:"objectStoreEndpoint,omitempty"`
+
+	// ManagedNodes are the nodes that were made storage nodes by the
+	// operator because ManageNodes is set
+	// +optional
+	ManagedNodes []string `json:"managedNodes,omitempty"`
+
+	// ResourceProfile is the resource profile in use. It differs from the
+	// one in the spec if it was scaled down to fit on the storage nodes
+	// +optional
+	ResourceProfile string `json:"resourceProfile,omitempty"`
+
+	// EffectiveResources are the resources of each daemon after applying
+	// the ResourceProfile and the Resources overrides. The OSDs of each
+	// StorageDeviceSet are listed as "osd-<name>"
+	// +optional
+	EffectiveResources map[string]corev1.ResourceRequirements `json:"effectiveResources,omitempty"`
+
+	// ReconcileSteps are the outcomes of the steps of the last reconcile,
+	// in the order they ran
+	// +optional
+	ReconcileSteps []ReconcileStepStatus `json:"reconcileSteps,omitempty"`
+
 	StorageClassesCreated       bool `json:"storageClassesCreated,omitempty"`
 	CephObjectStoresCreated     bool `json:"cephObjectStoresCreated,omitempty"`
 	CephBlockPoolsCreated       bool `json:"cephBlockPoolsCreated,omitempty"`
 	CephObjectStoreUsersCreated bool `json:"cephObjectStoreUsersCreated,omitempty"`
 	CephFilesystemsCreated      bool `json:"cephFilesystemsCreated,omitempty"`
+	SnapshotClassesCreated      bool `json:"snapshotClassesCreated,omitempty"`
+	BucketStorageClassCreated   bool `json:"bucketStorageClassCreated,omitempty"`
 }
 
 // TopologyLabelValues is a list of values for a topology label
@@ -112,6 +541,22 @@ type NodeTopologyMap struct {
 // reconcile functionality. Basically, is the Reconcile function running to completion.
 const ConditionReconcileComplete conditionsv1.ConditionType = "ReconcileComplete"
 
+// ConditionDeletionBlocked communicates that the deletion of the StorageCluster
+// is held back by consumers of the StorageClasses it created.
+const ConditionDeletionBlocked conditionsv1.ConditionType = "DeletionBlocked"
+
+// ConditionStorageNodesDedicated communicates whether all storage nodes are
+// set apart as asked for by the DedicatedNodes spec.
+const ConditionStorageNodesDedicated conditionsv1.ConditionType = "StorageNodesDedicated"
+
+// ConditionFailureDomainChangeBlocked communicates that the FailureDomain of
+// the spec is not applied because the data of the OSDs would have to move.
+const ConditionFailureDomainChangeBlocked conditionsv1.ConditionType = "FailureDomainChangeBlocked"
+
+// ConditionResourcesInsufficient communicates that the daemons of the
+// StorageCluster do not fit on the allocatable resources of its storage nodes.
+const ConditionResourcesInsufficient conditionsv1.ConditionType = "ResourcesInsufficient"
+
 // List of constants to show different different reconciliation messages and statuses.
 const (
 	ReconcileFailed           = "ReconcileFailed"
@@ -120,6 +565,16 @@ const (
 	ReconcileCompletedMessage = "Reconcile completed successfully"
 )
 
+// Strategies of reconciling a StorageCluster
+const (
+	// ReconcileStrategyApply brings the objects of the StorageCluster to
+	// their desired state
+	ReconcileStrategyApply = "Apply"
+	// ReconcileStrategyPlan only publishes the changes that applying the
+	// spec would make
+	ReconcileStrategyPlan = "Plan"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // StorageCluster is the Schema for the storageclusters API
@@ -1,9 +1,5 @@
 package v1
 
-import (
-	"strings"
-)
-
 // NewNodeTopologyMap returns an initialized NodeTopologyMap
 func NewNodeTopologyMap() *NodeTopologyMap {
 	return &NodeTopologyMap{
@@ -35,18 +31,12 @@ func (m *NodeTopologyMap) Add(topologyKey string, value string) {
 	m.Labels[topologyKey] = append(m.Labels[topologyKey], value)
 }
 
-// GetKeyValues returns a node label matching the topologyKey and all values
-// for that label across all storage nodes
+// GetKeyValues returns the topologyKey and all values for that node label
+// across all storage nodes. The label must match the topologyKey exactly.
 func (m *NodeTopologyMap) GetKeyValues(topologyKey string) (string, []string) {
-	values := []string{}
-
-	for label, labelValues := range m.Labels {
-		if strings.Contains(label, topologyKey) {
-			topologyKey = label
-			values = labelValues
-			break
-		}
+	if values, ok := m.Labels[topologyKey]; ok {
+		return topologyKey, values
 	}
 
-	return topologyKey, values
+	return topologyKey, []string{}
 }
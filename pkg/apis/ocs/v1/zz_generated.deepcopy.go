@@ -5,11 +5,151 @@
 package v1
 
 import (
+	v1alpha1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
 	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ComponentsSpec) DeepCopyInto(out *ComponentsSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ComponentsSpec.
+func (in *ComponentsSpec) DeepCopy() *ComponentsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ComponentsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DedicatedNodesSpec) DeepCopyInto(out *DedicatedNodesSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DedicatedNodesSpec.
+func (in *DedicatedNodesSpec) DeepCopy() *DedicatedNodesSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(DedicatedNodesSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultiCloudGatewayBackingStore) DeepCopyInto(out *MultiCloudGatewayBackingStore) {
+	*out = *in
+	if in.AWSS3 != nil {
+		in, out := &in.AWSS3, &out.AWSS3
+		*out = new(v1alpha1.AWSS3Spec)
+		**out = **in
+	}
+	if in.S3Compatible != nil {
+		in, out := &in.S3Compatible, &out.S3Compatible
+		*out = new(v1alpha1.S3CompatibleSpec)
+		**out = **in
+	}
+	if in.AzureBlob != nil {
+		in, out := &in.AzureBlob, &out.AzureBlob
+		*out = new(v1alpha1.AzureBlobSpec)
+		**out = **in
+	}
+	if in.PVPool != nil {
+		in, out := &in.PVPool, &out.PVPool
+		*out = new(v1alpha1.PVPoolSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultiCloudGatewayBackingStore.
+func (in *MultiCloudGatewayBackingStore) DeepCopy() *MultiCloudGatewayBackingStore {
+	if in == nil {
+		return nil
+	}
+	out := new(MultiCloudGatewayBackingStore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultiCloudGatewayBucketClass) DeepCopyInto(out *MultiCloudGatewayBucketClass) {
+	*out = *in
+	in.PlacementPolicy.DeepCopyInto(&out.PlacementPolicy)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultiCloudGatewayBucketClass.
+func (in *MultiCloudGatewayBucketClass) DeepCopy() *MultiCloudGatewayBucketClass {
+	if in == nil {
+		return nil
+	}
+	out := new(MultiCloudGatewayBucketClass)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MultiCloudGatewaySpec) DeepCopyInto(out *MultiCloudGatewaySpec) {
+	*out = *in
+	if in.BackingStores != nil {
+		in, out := &in.BackingStores, &out.BackingStores
+		*out = make([]MultiCloudGatewayBackingStore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BucketClasses != nil {
+		in, out := &in.BucketClasses, &out.BucketClasses
+		*out = make([]MultiCloudGatewayBucketClass, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MultiCloudGatewaySpec.
+func (in *MultiCloudGatewaySpec) DeepCopy() *MultiCloudGatewaySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MultiCloudGatewaySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeManagementSpec) DeepCopyInto(out *NodeManagementSpec) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeManagementSpec.
+func (in *NodeManagementSpec) DeepCopy() *NodeManagementSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeManagementSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodeTopologyMap) DeepCopyInto(out *NodeTopologyMap) {
 	*out = *in
@@ -146,6 +286,180 @@ func (in *OCSInitializationStatus) DeepCopy() *OCSInitializationStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectStoreExposureSpec) DeepCopyInto(out *ObjectStoreExposureSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectStoreExposureSpec.
+func (in *ObjectStoreExposureSpec) DeepCopy() *ObjectStoreExposureSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectStoreExposureSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectStoreSpec) DeepCopyInto(out *ObjectStoreSpec) {
+	*out = *in
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(ObjectStoreTLSSpec)
+		**out = **in
+	}
+	if in.Exposure != nil {
+		in, out := &in.Exposure, &out.Exposure
+		*out = new(ObjectStoreExposureSpec)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectStoreSpec.
+func (in *ObjectStoreSpec) DeepCopy() *ObjectStoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectStoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObjectStoreTLSSpec) DeepCopyInto(out *ObjectStoreTLSSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectStoreTLSSpec.
+func (in *ObjectStoreTLSSpec) DeepCopy() *ObjectStoreTLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ObjectStoreTLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RackMove) DeepCopyInto(out *RackMove) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RackMove.
+func (in *RackMove) DeepCopy() *RackMove {
+	if in == nil {
+		return nil
+	}
+	out := new(RackMove)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RackRebalanceSpec) DeepCopyInto(out *RackRebalanceSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RackRebalanceSpec.
+func (in *RackRebalanceSpec) DeepCopy() *RackRebalanceSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RackRebalanceSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RackRebalanceStatus) DeepCopyInto(out *RackRebalanceStatus) {
+	*out = *in
+	if in.ProposedMoves != nil {
+		in, out := &in.ProposedMoves, &out.ProposedMoves
+		*out = make([]RackMove, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastMove != nil {
+		in, out := &in.LastMove, &out.LastMove
+		*out = new(RackMove)
+		**out = **in
+	}
+	if in.LastMoveTime != nil {
+		in, out := &in.LastMoveTime, &out.LastMoveTime
+		*out = (*in).DeepCopy()
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RackRebalanceStatus.
+func (in *RackRebalanceStatus) DeepCopy() *RackRebalanceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RackRebalanceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReconcileStepStatus) DeepCopyInto(out *ReconcileStepStatus) {
+	*out = *in
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReconcileStepStatus.
+func (in *ReconcileStepStatus) DeepCopy() *ReconcileStepStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReconcileStepStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResourceFitSpec) DeepCopyInto(out *ResourceFitSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResourceFitSpec.
+func (in *ResourceFitSpec) DeepCopy() *ResourceFitSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ResourceFitSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StaleTopologyLabel) DeepCopyInto(out *StaleTopologyLabel) {
+	*out = *in
+	in.MissingSince.DeepCopyInto(&out.MissingSince)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StaleTopologyLabel.
+func (in *StaleTopologyLabel) DeepCopy() *StaleTopologyLabel {
+	if in == nil {
+		return nil
+	}
+	out := new(StaleTopologyLabel)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StorageCluster) DeepCopyInto(out *StorageCluster) {
 	*out = *in
@@ -303,6 +617,8 @@ func (in *StorageClusterList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *StorageClusterSpec) DeepCopyInto(out *StorageClusterSpec) {
 	*out = *in
+	in.NodeManagement.DeepCopyInto(&out.NodeManagement)
+	out.ResourceFit = in.ResourceFit
 	if in.Resources != nil {
 		in, out := &in.Resources, &out.Resources
 		*out = make(map[string]corev1.ResourceRequirements, len(*in))
@@ -322,6 +638,21 @@ func (in *StorageClusterSpec) DeepCopyInto(out *StorageClusterSpec) {
 		*out = new(corev1.PersistentVolumeClaim)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.TopologyKeys != nil {
+		in, out := &in.TopologyKeys, &out.TopologyKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.RackRebalance = in.RackRebalance
+	out.DedicatedNodes = in.DedicatedNodes
+	out.Components = in.Components
+	if in.MultiCloudGateway != nil {
+		in, out := &in.MultiCloudGateway, &out.MultiCloudGateway
+		*out = new(MultiCloudGatewaySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	in.ObjectStore.DeepCopyInto(&out.ObjectStore)
+	out.Uninstall = in.Uninstall
 	return
 }
 
@@ -355,6 +686,44 @@ func (in *StorageClusterStatus) DeepCopyInto(out *StorageClusterStatus) {
 		*out = new(NodeTopologyMap)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.StorageNodes != nil {
+		in, out := &in.StorageNodes, &out.StorageNodes
+		*out = make([]StorageNodeTopology, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.StaleNodeTopologies != nil {
+		in, out := &in.StaleNodeTopologies, &out.StaleNodeTopologies
+		*out = make([]StaleTopologyLabel, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RackRebalance != nil {
+		in, out := &in.RackRebalance, &out.RackRebalance
+		*out = new(RackRebalanceStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ManagedNodes != nil {
+		in, out := &in.ManagedNodes, &out.ManagedNodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.EffectiveResources != nil {
+		in, out := &in.EffectiveResources, &out.EffectiveResources
+		*out = make(map[string]corev1.ResourceRequirements, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ReconcileSteps != nil {
+		in, out := &in.ReconcileSteps, &out.ReconcileSteps
+		*out = make([]ReconcileStepStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -404,6 +773,29 @@ func (in *StorageDeviceSetConfig) DeepCopy() *StorageDeviceSetConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StorageNodeTopology) DeepCopyInto(out *StorageNodeTopology) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StorageNodeTopology.
+func (in *StorageNodeTopology) DeepCopy() *StorageNodeTopology {
+	if in == nil {
+		return nil
+	}
+	out := new(StorageNodeTopology)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in TopologyLabelValues) DeepCopyInto(out *TopologyLabelValues) {
 	{
@@ -423,3 +815,19 @@ func (in TopologyLabelValues) DeepCopy() TopologyLabelValues {
 	in.DeepCopyInto(out)
 	return *out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *UninstallSpec) DeepCopyInto(out *UninstallSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new UninstallSpec.
+func (in *UninstallSpec) DeepCopy() *UninstallSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(UninstallSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -280,6 +280,13 @@ func schema_pkg_apis_ocs_v1_StorageClusterStatus(ref common.ReferenceCallback) c
 							},
 						},
 					},
+					"observedGeneration": {
+						SchemaProps: spec.SchemaProps{
+							Description: "ObservedGeneration is the generation of the StorageCluster the status was last written for",
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
 					"relatedObjects": {
 						SchemaProps: spec.SchemaProps{
 							Description: "RelatedObjects is a list of objects created and maintained by this operator. Object references will be added to this list after they have been created AND found in the cluster.",
@@ -299,6 +306,32 @@ func schema_pkg_apis_ocs_v1_StorageClusterStatus(ref common.ReferenceCallback) c
 							Ref:         ref("github.com/openshift/ocs-operator/pkg/apis/ocs/v1.NodeTopologyMap"),
 						},
 					},
+					"storageNodes": {
+						SchemaProps: spec.SchemaProps{
+							Description: "StorageNodes lists the topology labels of each storage node",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/openshift/ocs-operator/pkg/apis/ocs/v1.StorageNodeTopology"),
+									},
+								},
+							},
+						},
+					},
+					"staleNodeTopologies": {
+						SchemaProps: spec.SchemaProps{
+							Description: "StaleNodeTopologies are the values in NodeTopologies that are no longer found on any storage node. They are pruned from NodeTopologies once they have been missing for a grace period",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/openshift/ocs-operator/pkg/apis/ocs/v1.StaleTopologyLabel"),
+									},
+								},
+							},
+						},
+					},
 					"failureDomain": {
 						SchemaProps: spec.SchemaProps{
 							Description: "FailureDomain is the base CRUSH element Ceph will use to distribute its data replicas for the default CephBlockPool",
@@ -306,6 +339,74 @@ func schema_pkg_apis_ocs_v1_StorageClusterStatus(ref common.ReferenceCallback) c
 							Format:      "",
 						},
 					},
+					"failureDomainKey": {
+						SchemaProps: spec.SchemaProps{
+							Description: "FailureDomainKey is the node label holding the FailureDomain of the storage nodes",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"rackRebalance": {
+						SchemaProps: spec.SchemaProps{
+							Description: "RackRebalance describes the rebalancing of the racks, if enabled",
+							Ref:         ref("github.com/openshift/ocs-operator/pkg/apis/ocs/v1.RackRebalanceStatus"),
+						},
+					},
+					"objectStoreEndpoint": {
+						SchemaProps: spec.SchemaProps{
+							Description: "ObjectStoreEndpoint is the external URL of the S3 endpoint of the Ceph object store. It is only set while the endpoint is exposed",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"managedNodes": {
+						SchemaProps: spec.SchemaProps{
+							Description: "ManagedNodes are the nodes that were made storage nodes by the operator because ManageNodes is set",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Type:   []string{"string"},
+										Format: "",
+									},
+								},
+							},
+						},
+					},
+					"resourceProfile": {
+						SchemaProps: spec.SchemaProps{
+							Description: "ResourceProfile is the resource profile in use. It differs from the one in the spec if it was scaled down to fit on the storage nodes",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"effectiveResources": {
+						SchemaProps: spec.SchemaProps{
+							Description: "EffectiveResources are the resources of each daemon after applying the ResourceProfile and the Resources overrides. The OSDs of each StorageDeviceSet are listed as \"osd-<name>\"",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("k8s.io/api/core/v1.ResourceRequirements"),
+									},
+								},
+							},
+						},
+					},
+					"reconcileSteps": {
+						SchemaProps: spec.SchemaProps{
+							Description: "ReconcileSteps are the outcomes of the steps of the last reconcile, in the order they ran",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Ref: ref("github.com/openshift/ocs-operator/pkg/apis/ocs/v1.ReconcileStepStatus"),
+									},
+								},
+							},
+						},
+					},
 					"storageClassesCreated": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"boolean"},
@@ -336,10 +437,22 @@ func schema_pkg_apis_ocs_v1_StorageClusterStatus(ref common.ReferenceCallback) c
 							Format: "",
 						},
 					},
+					"snapshotClassesCreated": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"boolean"},
+							Format: "",
+						},
+					},
+					"bucketStorageClassCreated": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"boolean"},
+							Format: "",
+						},
+					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"github.com/openshift/custom-resource-status/conditions/v1.Condition", "github.com/openshift/ocs-operator/pkg/apis/ocs/v1.NodeTopologyMap", "k8s.io/api/core/v1.ObjectReference"},
+			"github.com/openshift/custom-resource-status/conditions/v1.Condition", "github.com/openshift/ocs-operator/pkg/apis/ocs/v1.NodeTopologyMap", "github.com/openshift/ocs-operator/pkg/apis/ocs/v1.RackRebalanceStatus", "github.com/openshift/ocs-operator/pkg/apis/ocs/v1.ReconcileStepStatus", "github.com/openshift/ocs-operator/pkg/apis/ocs/v1.StaleTopologyLabel", "github.com/openshift/ocs-operator/pkg/apis/ocs/v1.StorageNodeTopology", "k8s.io/api/core/v1.ObjectReference", "k8s.io/api/core/v1.ResourceRequirements"},
 	}
 }
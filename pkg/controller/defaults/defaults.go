@@ -14,6 +14,24 @@ const (
 )
 
 var (
+	// TopologyKeys are the node labels collected into the NodeTopologies of
+	// a StorageCluster, in order of preference. The GA labels come before
+	// the deprecated ones.
+	TopologyKeys = []string{
+		"topology.kubernetes.io/zone",
+		"topology.kubernetes.io/region",
+		"failure-domain.beta.kubernetes.io/zone",
+		"failure-domain.beta.kubernetes.io/region",
+		"failure-domain.kubernetes.io/zone",
+		"failure-domain.kubernetes.io/region",
+		RackTopologyKey,
+		"topology.rook.io/row",
+		"topology.rook.io/room",
+		"topology.rook.io/datacenter",
+		"topology.rook.io/chassis",
+		"topology.rook.io/pod",
+		"topology.rook.io/pdu",
+	}
 	// MonCount is the default number of monitors to be configured for the CephCluster
 	MonCount = 3
 	// DeviceSetReplica is the default number of Rook-Ceph
@@ -0,0 +1,73 @@
+package defaults
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// InstanceProfile holds the defaults of a StorageCluster whose storage nodes
+// are of a known cloud instance type
+type InstanceProfile struct {
+	// ResourceProfile is the resource profile matching the CPU and memory
+	// of the instance type
+	ResourceProfile string
+	// StorageClassName is the StorageClass of the cloud block volumes
+	// backing the OSDs
+	StorageClassName string
+	// DeviceSize is the size of each OSD device
+	DeviceSize resource.Quantity
+}
+
+var (
+	// InstanceProfiles map contains the defaults for the cloud instance
+	// types known to OCS
+	InstanceProfiles = map[string]InstanceProfile{
+		// AWS
+		"m5.2xlarge": InstanceProfile{
+			ResourceProfile:  ResourceProfileLean,
+			StorageClassName: "gp2",
+			DeviceSize:       resource.MustParse("512Gi"),
+		},
+		"m5.4xlarge": InstanceProfile{
+			ResourceProfile:  ResourceProfileBalanced,
+			StorageClassName: "gp2",
+			DeviceSize:       resource.MustParse("2Ti"),
+		},
+		"m5.8xlarge": InstanceProfile{
+			ResourceProfile:  ResourceProfilePerformance,
+			StorageClassName: "gp2",
+			DeviceSize:       resource.MustParse("4Ti"),
+		},
+		// Azure
+		"Standard_D8s_v3": InstanceProfile{
+			ResourceProfile:  ResourceProfileLean,
+			StorageClassName: "managed-premium",
+			DeviceSize:       resource.MustParse("512Gi"),
+		},
+		"Standard_D16s_v3": InstanceProfile{
+			ResourceProfile:  ResourceProfileBalanced,
+			StorageClassName: "managed-premium",
+			DeviceSize:       resource.MustParse("2Ti"),
+		},
+		"Standard_D32s_v3": InstanceProfile{
+			ResourceProfile:  ResourceProfilePerformance,
+			StorageClassName: "managed-premium",
+			DeviceSize:       resource.MustParse("4Ti"),
+		},
+		// GCP
+		"n1-standard-8": InstanceProfile{
+			ResourceProfile:  ResourceProfileLean,
+			StorageClassName: "standard",
+			DeviceSize:       resource.MustParse("512Gi"),
+		},
+		"n1-standard-16": InstanceProfile{
+			ResourceProfile:  ResourceProfileBalanced,
+			StorageClassName: "standard",
+			DeviceSize:       resource.MustParse("2Ti"),
+		},
+		"n1-standard-32": InstanceProfile{
+			ResourceProfile:  ResourceProfilePerformance,
+			StorageClassName: "standard",
+			DeviceSize:       resource.MustParse("4Ti"),
+		},
+	}
+)
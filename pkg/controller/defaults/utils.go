@@ -5,11 +5,18 @@ import (
 )
 
 // GetDaemonResources returns a custom ResourceRequirements for the passed
-// name, if found in the passed resource map. If not, it returns the default
-// value for the given name.
-func GetDaemonResources(name string, custom map[string]corev1.ResourceRequirements) corev1.ResourceRequirements {
+// name, if found in the passed resource map. If not, it returns the value of
+// the given resource profile for the given name. Unknown profiles fall back
+// to the balanced profile. The result is a copy, as it ends up in objects
+// that clients decode into.
+func GetDaemonResources(name string, profile string, custom map[string]corev1.ResourceRequirements) corev1.ResourceRequirements {
 	if res, ok := custom[name]; ok {
-		return res
+		return *res.DeepCopy()
 	}
-	return DaemonResources[name]
+	if resources, ok := ProfileDaemonResources[profile]; ok {
+		res := resources[name]
+		return *res.DeepCopy()
+	}
+	res := DaemonResources[name]
+	return *res.DeepCopy()
 }
@@ -0,0 +1,282 @@
+// Package fault provides a client.Client for tests that injects the failures
+// a controller meets against a real apiserver: errors, conflicts, latency and
+// stale reads from the cache. Which calls fail is set by a Scenario of rules
+// matched against the verb and the kind of the object.
+package fault
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// Verb is the client call a rule matches
+type Verb string
+
+// The verbs of the client calls
+const (
+	Get          Verb = "get"
+	List         Verb = "list"
+	Create       Verb = "create"
+	Update       Verb = "update"
+	Patch        Verb = "patch"
+	Delete       Verb = "delete"
+	DeleteAllOf  Verb = "deleteallof"
+	StatusUpdate Verb = "status-update"
+	StatusPatch  Verb = "status-patch"
+)
+
+// Type is the kind of fault a rule injects
+type Type string
+
+const (
+	// Error fails the call with the error of the rule, or an internal error
+	Error Type = "Error"
+	// Conflict fails the call as if the object was changed in the meantime
+	Conflict Type = "Conflict"
+	// Latency delays the call by the latency of the rule
+	Latency Type = "Latency"
+	// StaleRead answers a get with the object as it was the previous time it
+	// was read, as a lagging cache would
+	StaleRead Type = "StaleRead"
+)
+
+// Rule injects a fault into the calls it matches
+type Rule struct {
+	// Verb is the call to match, or any call if empty
+	Verb Verb
+	// Kind is the kind of the object to match, or any kind if empty. Lists
+	// match the kind of their items.
+	Kind string
+	// Type is the fault to inject
+	Type Type
+	// Err is the error returned by an Error fault
+	Err error
+	// Latency is how long a Latency fault delays the call
+	Latency time.Duration
+	// After is the number of matching calls let through before the first
+	// fault
+	After int
+	// Times is the number of faults to inject, or no limit if zero
+	Times int
+}
+
+// Scenario is the set of rules a client follows. Every matching rule
+// applies to a call, in order, and the first failure ends it.
+type Scenario []Rule
+
+// Client passes calls through to another client, injecting the faults of a
+// scenario on the way
+type Client struct {
+	client   client.Client
+	scheme   *runtime.Scheme
+	scenario Scenario
+
+	mu sync.Mutex
+	// matched counts the calls matched by each rule
+	matched []int
+	// injected counts the faults injected
+	injected int
+	// reads holds the object last read for every kind and key, served by
+	// stale reads
+	reads map[string]runtime.Object
+}
+
+var _ client.Client = &Client{}
+
+// NewClient returns a client injecting the faults of the scenario into the
+// calls to c. The scheme maps the objects to their kinds.
+func NewClient(c client.Client, scheme *runtime.Scheme, scenario Scenario) *Client {
+	return &Client{
+		client:   c,
+		scheme:   scheme,
+		scenario: scenario,
+		matched:  make([]int, len(scenario)),
+		reads:    map[string]runtime.Object{},
+	}
+}
+
+// Injected returns the number of faults injected so far
+func (c *Client) Injected() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.injected
+}
+
+// Done tells whether every rule limited in times has used them up, after
+// which no more faults are injected by those rules
+func (c *Client) Done() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, rule := range c.scenario {
+		if rule.Times > 0 && c.matched[i] < rule.After+rule.Times {
+			return false
+		}
+	}
+	return true
+}
+
+// inject applies the rules matching the call. It returns whether the call
+// is to be answered with a stale read, and the error to fail it with.
+func (c *Client) inject(verb Verb, obj runtime.Object, name string) (bool, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return false, err
+	}
+	kind := gvk.Kind
+	if verb == List {
+		kind = strings.TrimSuffix(kind, "List")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stale := false
+	for i, rule := range c.scenario {
+		if (rule.Verb != "" && rule.Verb != verb) || (rule.Kind != "" && rule.Kind != kind) {
+			continue
+		}
+		c.matched[i]++
+		if c.matched[i] <= rule.After || (rule.Times > 0 && c.matched[i] > rule.After+rule.Times) {
+			continue
+		}
+		c.injected++
+
+		switch rule.Type {
+		case Error:
+			if rule.Err != nil {
+				return false, rule.Err
+			}
+			return false, errors.NewInternalError(fmt.Errorf("injected %s %s fault", verb, kind))
+		case Conflict:
+			resource := schema.GroupResource{Group: gvk.Group, Resource: strings.ToLower(kind)}
+			return false, errors.NewConflict(resource, name, fmt.Errorf("injected %s conflict", verb))
+		case Latency:
+			time.Sleep(rule.Latency)
+		case StaleRead:
+			stale = true
+		}
+	}
+	return stale, nil
+}
+
+// readKey identifies an object for the stale reads
+func (c *Client) readKey(obj runtime.Object, key client.ObjectKey) string {
+	gvk, _ := apiutil.GVKForObject(obj, c.scheme)
+	return gvk.Kind + "/" + key.String()
+}
+
+// Get reads the object, or the copy read the previous time on a stale read
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	stale, err := c.inject(Get, obj, key.Name)
+	if err != nil {
+		return err
+	}
+
+	readKey := c.readKey(obj, key)
+	c.mu.Lock()
+	previous, ok := c.reads[readKey]
+	c.mu.Unlock()
+	if stale && ok {
+		reflect.ValueOf(obj).Elem().Set(reflect.ValueOf(previous.DeepCopyObject()).Elem())
+		return nil
+	}
+
+	err = c.client.Get(ctx, key, obj)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.reads[readKey] = obj.DeepCopyObject()
+	c.mu.Unlock()
+	return nil
+}
+
+// List lists the objects
+func (c *Client) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	if _, err := c.inject(List, list, ""); err != nil {
+		return err
+	}
+	return c.client.List(ctx, list, opts...)
+}
+
+// Create creates the object
+func (c *Client) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if _, err := c.inject(Create, obj, objectName(obj)); err != nil {
+		return err
+	}
+	return c.client.Create(ctx, obj, opts...)
+}
+
+// Update updates the object
+func (c *Client) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if _, err := c.inject(Update, obj, objectName(obj)); err != nil {
+		return err
+	}
+	return c.client.Update(ctx, obj, opts...)
+}
+
+// Patch patches the object
+func (c *Client) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if _, err := c.inject(Patch, obj, objectName(obj)); err != nil {
+		return err
+	}
+	return c.client.Patch(ctx, obj, patch, opts...)
+}
+
+// Delete deletes the object
+func (c *Client) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	if _, err := c.inject(Delete, obj, objectName(obj)); err != nil {
+		return err
+	}
+	return c.client.Delete(ctx, obj, opts...)
+}
+
+// DeleteAllOf deletes the objects matching the options
+func (c *Client) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	if _, err := c.inject(DeleteAllOf, obj, ""); err != nil {
+		return err
+	}
+	return c.client.DeleteAllOf(ctx, obj, opts...)
+}
+
+// Status returns a writer injecting faults into the status writes
+func (c *Client) Status() client.StatusWriter {
+	return &statusWriter{client: c}
+}
+
+type statusWriter struct {
+	client *Client
+}
+
+// Update updates the status of the object
+func (w *statusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if _, err := w.client.inject(StatusUpdate, obj, objectName(obj)); err != nil {
+		return err
+	}
+	return w.client.client.Status().Update(ctx, obj, opts...)
+}
+
+// Patch patches the status of the object
+func (w *statusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if _, err := w.client.inject(StatusPatch, obj, objectName(obj)); err != nil {
+		return err
+	}
+	return w.client.client.Status().Patch(ctx, obj, patch, opts...)
+}
+
+func objectName(obj runtime.Object) string {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return ""
+	}
+	return key.Name
+}
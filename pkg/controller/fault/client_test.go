@@ -0,0 +1,121 @@
+package fault
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var configMapKey = types.NamespacedName{Name: "config", Namespace: "test"}
+
+func newConfigMap(value string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: configMapKey.Name, Namespace: configMapKey.Namespace},
+		Data:       map[string]string{"value": value},
+	}
+}
+
+func newFaultClient(scenario Scenario) *Client {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	return NewClient(fake.NewFakeClientWithScheme(scheme, newConfigMap("1")), scheme, scenario)
+}
+
+func TestClientError(t *testing.T) {
+	injected := fmt.Errorf("disk full")
+	c := newFaultClient(Scenario{
+		{Verb: Create, Kind: "ConfigMap", Type: Error, Err: injected},
+		{Verb: Update, Kind: "Secret", Type: Error},
+	})
+
+	err := c.Create(context.TODO(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "test"}})
+	assert.Equal(t, injected, err)
+
+	// Neither the verb nor the kind of the second rule match
+	err = c.Update(context.TODO(), newConfigMap("2"))
+	assert.NoError(t, err)
+	err = c.Create(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "test"}})
+	assert.NoError(t, err)
+
+	err = c.Update(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "test"}})
+	assert.True(t, errors.IsInternalError(err))
+	assert.Equal(t, 2, c.Injected())
+}
+
+func TestClientConflict(t *testing.T) {
+	c := newFaultClient(Scenario{
+		{Verb: StatusUpdate, Type: Conflict, After: 1, Times: 2},
+	})
+
+	results := []bool{}
+	for i := 0; i < 5; i++ {
+		err := c.Status().Update(context.TODO(), newConfigMap("1"))
+		results = append(results, errors.IsConflict(err))
+	}
+	assert.Equal(t, []bool{false, true, true, false, false}, results)
+	assert.True(t, c.Done())
+
+	// Writes to the object itself are not status writes
+	err := c.Update(context.TODO(), newConfigMap("2"))
+	assert.NoError(t, err)
+}
+
+func TestClientList(t *testing.T) {
+	c := newFaultClient(Scenario{
+		{Verb: List, Kind: "ConfigMap", Type: Error, Times: 1},
+	})
+
+	err := c.List(context.TODO(), &corev1.SecretList{})
+	assert.NoError(t, err)
+	err = c.List(context.TODO(), &corev1.ConfigMapList{})
+	assert.Error(t, err)
+
+	list := &corev1.ConfigMapList{}
+	err = c.List(context.TODO(), list)
+	assert.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+}
+
+func TestClientStaleRead(t *testing.T) {
+	c := newFaultClient(Scenario{
+		{Verb: Get, Kind: "ConfigMap", Type: StaleRead, After: 1, Times: 1},
+	})
+
+	found := &corev1.ConfigMap{}
+	err := c.Get(context.TODO(), configMapKey, found)
+	assert.NoError(t, err)
+	found.Data["value"] = "2"
+	err = c.Update(context.TODO(), found)
+	assert.NoError(t, err)
+
+	// The cache has yet to see the update
+	stale := &corev1.ConfigMap{}
+	err = c.Get(context.TODO(), configMapKey, stale)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", stale.Data["value"])
+
+	fresh := &corev1.ConfigMap{}
+	err = c.Get(context.TODO(), configMapKey, fresh)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", fresh.Data["value"])
+}
+
+func TestClientLatency(t *testing.T) {
+	c := newFaultClient(Scenario{
+		{Verb: Get, Type: Latency, Latency: 20 * time.Millisecond},
+	})
+
+	start := time.Now()
+	err := c.Get(context.TODO(), configMapKey, &corev1.ConfigMap{})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 20*time.Millisecond)
+}
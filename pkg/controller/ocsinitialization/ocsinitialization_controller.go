@@ -16,6 +16,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -27,13 +28,21 @@ import (
 
 var log = logf.Log.WithName("controller_ocsinitialization")
 
-// watchNamespace is the namespace the operator is watching.
+// watchNamespace is the namespace the operator is watching, or its own
+// namespace when it watches all namespaces.
 var watchNamespace string
 
 const wrongNamespacedName = "Ignoring this resource. Only one should exist, and this one has the wrong name and/or namespace."
 
 const rookCephToolDeploymentName = "rook-ceph-tools"
 
+// NewSecurityClient returns the client the SecurityContextConstraints are
+// managed with. It is replaced in tests running against an apiserver that does
+// not serve the OpenShift security API.
+var NewSecurityClient = func(cfg *rest.Config) secv1client.SecurityV1Interface {
+	return secv1client.NewForConfigOrDie(cfg)
+}
+
 // InitNamespacedName returns a NamespacedName for the singleton instance that
 // should exist.
 func InitNamespacedName() types.NamespacedName {
@@ -58,16 +67,27 @@ func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 
 	return &ReconcileOCSInitialization{
 		client:    mgr.GetClient(),
-		secClient: secv1client.NewForConfigOrDie(mgr.GetConfig()),
+		secClient: NewSecurityClient(mgr.GetConfig()),
 		scheme:    mgr.GetScheme(),
 		rookImage: rookImage,
 	}
 }
 
+// getInitNamespace returns the namespace of the OCSInitialization resource.
+// It is the watched namespace, or the namespace of the operator when it
+// watches all namespaces.
+func getInitNamespace() (string, error) {
+	ns, err := k8sutil.GetWatchNamespace()
+	if err != nil || ns != "" {
+		return ns, err
+	}
+	return k8sutil.GetOperatorNamespace()
+}
+
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
 	// set the watchNamespace so we know where to create the OCSInitialization resource
-	ns, err := k8sutil.GetWatchNamespace()
+	ns, err := getInitNamespace()
 	if err != nil {
 		return err
 	}
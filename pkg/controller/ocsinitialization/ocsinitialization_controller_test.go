@@ -3,6 +3,8 @@ package ocsinitialization
 import (
 	"fmt"
 
+	secv1 "github.com/openshift/api/security/v1"
+	secscheme "github.com/openshift/client-go/security/clientset/versioned/scheme"
 	fakeSecClient "github.com/openshift/client-go/security/clientset/versioned/typed/security/v1/fake"
 	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
 	v1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
@@ -99,6 +101,28 @@ func TestSCCsEnsured(t *testing.T) {
 	assert.True(t, obj.Status.SCCsCreated)
 }
 
+func TestSCCsKeepAddedUsers(t *testing.T) {
+	_, request, reconciler := getTestParams(false, t)
+	// Added by the StorageCluster of another namespace
+	added := "system:serviceaccount:other-ns:rook-ceph-osd"
+	scc := newRookCephSCC(request.Namespace)
+	scc.Users = append(scc.Users, added)
+	tracker := testingClient.NewObjectTracker(secscheme.Scheme, secscheme.Codecs.UniversalDecoder())
+	err := tracker.Create(secv1.GroupVersion.WithResource("securitycontextconstraints"), scc, "")
+	assert.NoError(t, err)
+	secFake := &testingClient.Fake{}
+	secFake.AddReactor("*", "*", testingClient.ObjectReaction(tracker))
+	reconciler.secClient = &fakeSecClient.FakeSecurityV1{Fake: secFake}
+
+	_, err = reconciler.Reconcile(request)
+	assert.NoError(t, err)
+
+	found, err := reconciler.secClient.SecurityContextConstraints().Get(scc.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, found.Users, added)
+	assert.Subset(t, found.Users, newRookCephSCC(request.Namespace).Users)
+}
+
 func TestReconcileCompleteConditions(t *testing.T) {
 	_, request, reconciler := getTestParams(false, t)
 
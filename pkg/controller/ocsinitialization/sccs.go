@@ -24,6 +24,13 @@ func (r *ReconcileOCSInitialization) ensureSCCs(initialData *ocsv1.OCSInitializa
 			}
 		} else if err == nil {
 			scc.ObjectMeta = found.ObjectMeta
+			// Keep the service accounts the StorageClusters of other
+			// namespaces added
+			for _, user := range found.Users {
+				if !containsUser(scc.Users, user) {
+					scc.Users = append(scc.Users, user)
+				}
+			}
 			reqLogger.Info(fmt.Sprintf("Updating %s SecurityContextConstraint", scc.Name))
 			_, err := r.secClient.SecurityContextConstraints().Update(scc)
 			if err != nil {
@@ -37,6 +44,15 @@ func (r *ReconcileOCSInitialization) ensureSCCs(initialData *ocsv1.OCSInitializa
 	return nil
 }
 
+func containsUser(users []string, user string) bool {
+	for _, u := range users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
 func getAllSCCs(namespace string) []*secv1.SecurityContextConstraints {
 	return []*secv1.SecurityContextConstraints{
 		newRookCephSCC(namespace),
@@ -0,0 +1,262 @@
+// Package placement decides how the storage nodes and the OSDs of a
+// StorageCluster are spread across failure domains
+package placement
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MinZones is the least number of zones the storage nodes have to be in for
+// the zone to be the default failure domain
+const MinZones = 3
+
+// KeyType returns the failure domain a topology label stands for, which is
+// its name without the prefix
+func KeyType(key string) string {
+	return key[strings.LastIndex(key, "/")+1:]
+}
+
+// FindKey returns the first of the given keys that stands for the failure
+// domain and is found in the topology map, or an empty string
+func FindKey(topologyMap *ocsv1.NodeTopologyMap, keys []string, failureDomain string) string {
+	if topologyMap == nil {
+		return ""
+	}
+	for _, key := range keys {
+		if KeyType(key) != failureDomain {
+			continue
+		}
+		if _, ok := topologyMap.Labels[key]; ok {
+			return key
+		}
+	}
+	return ""
+}
+
+// NodeZone returns the value of the first of the given topology keys
+// standing for a zone that the node is labeled with, or an empty string
+func NodeZone(node corev1.Node, keys []string) string {
+	for _, key := range keys {
+		if KeyType(key) != "zone" {
+			continue
+		}
+		if value, ok := node.Labels[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// DefaultFailureDomain returns the failure domain of storage nodes with the
+// given topology: "zone" if they are in at least MinZones zones, and "rack"
+// otherwise
+func DefaultFailureDomain(topologyMap *ocsv1.NodeTopologyMap) string {
+	failureDomain := "rack"
+	if topologyMap == nil {
+		return failureDomain
+	}
+	for label, labelValues := range topologyMap.Labels {
+		if KeyType(label) == "zone" {
+			if len(labelValues) >= MinZones {
+				failureDomain = "zone"
+			}
+		}
+	}
+	return failureDomain
+}
+
+// DetermineRack sorts the list of known racks in alphabetical order,
+// counts the number of Nodes in each rack, then returns the first rack with
+// the fewest number of Nodes. If there are fewer than minRacks racks, define
+// new racks so that there are at least minRacks. It also ensures that only
+// racks with either no nodes or nodes in the same AZ are considered valid
+// racks, and defines a new rack if there is no valid one.
+func DetermineRack(nodes *corev1.NodeList, node corev1.Node, minRacks int, nodeRacks *ocsv1.NodeTopologyMap, topologyKeys []string) string {
+	rackList := []string{}
+
+	if len(nodeRacks.Labels) < minRacks {
+		for i := len(nodeRacks.Labels); i < minRacks; i++ {
+			for j := 0; j <= i; j++ {
+				newRack := fmt.Sprintf("rack%d", j)
+				if _, ok := nodeRacks.Labels[newRack]; !ok {
+					nodeRacks.Labels[newRack] = ocsv1.TopologyLabelValues{}
+					break
+				}
+			}
+		}
+	}
+
+	targetAZ := NodeZone(node, topologyKeys)
+
+	if len(targetAZ) > 0 {
+		for rack := range nodeRacks.Labels {
+			nodeNames := nodeRacks.Labels[rack]
+			if len(nodeNames) == 0 {
+				rackList = append(rackList, rack)
+				continue
+			}
+
+			validRack := false
+			for _, nodeName := range nodeNames {
+				for _, n := range nodes.Items {
+					if n.Name == nodeName {
+						validRack = NodeZone(n, topologyKeys) == targetAZ
+						break
+					}
+				}
+				if validRack {
+					break
+				}
+			}
+			if validRack {
+				rackList = append(rackList, rack)
+			}
+		}
+	} else {
+		for rack := range nodeRacks.Labels {
+			rackList = append(rackList, rack)
+		}
+	}
+
+	// Every rack holds nodes of other AZs, so a new one is made up
+	if len(rackList) == 0 {
+		for i := 0; ; i++ {
+			newRack := fmt.Sprintf("rack%d", i)
+			if _, ok := nodeRacks.Labels[newRack]; !ok {
+				nodeRacks.Labels[newRack] = ocsv1.TopologyLabelValues{}
+				rackList = append(rackList, newRack)
+				break
+			}
+		}
+	}
+
+	sort.Strings(rackList)
+	rack := rackList[0]
+
+	for _, r := range rackList {
+		if len(nodeRacks.Labels[r]) < len(nodeRacks.Labels[rack]) {
+			rack = r
+		}
+	}
+
+	return rack
+}
+
+// DeviceSetReplica returns the number of Rook device sets a StorageDeviceSet
+// is split into, and the number of OSDs in each
+func DeviceSetReplica(ds ocsv1.StorageDeviceSet) (int, int) {
+	count := ds.Count
+	replica := ds.Replica
+	if replica == 0 {
+		replica = defaults.DeviceSetReplica
+
+		// This is a temporary hack in place due to limitations
+		// in the current implementation of the OCP console.
+		// The console is hardcoded to create a StorageCluster
+		// with a Count of 3, as made sense for the previous
+		// behavior, but it cannot be updated until the next
+		// z-stream release of OCP 4.2. This workaround is to
+		// enable the new behavior while the console is waiting
+		// to be updated.
+		// TODO: Remove this behavior when OCP console is updated
+		count = count / 3
+	}
+	return replica, count
+}
+
+// SpreadValue returns the value of the failure domain the Rook device set
+// with the given index is pinned to. Device sets are only pinned, and
+// portable, if there are at least as many values as replicas, and are
+// otherwise left to the scheduler.
+func SpreadValue(values []string, replica, index int) (string, bool) {
+	if len(values) == 0 || len(values) < replica {
+		return "", false
+	}
+	return values[index%len(values)], true
+}
+
+// Topology is what the device sets of a StorageCluster are placed on
+type Topology struct {
+	// NodeTopologies are the values of the topology keys found on the
+	// storage nodes
+	NodeTopologies *ocsv1.NodeTopologyMap
+	// TopologyKeys are the node labels making up the topology
+	TopologyKeys []string
+	// FailureDomain is the failure domain of the StorageCluster, and
+	// FailureDomainKey the node label holding it, if known
+	FailureDomain    string
+	FailureDomainKey string
+}
+
+// ResolveKey returns the node label for the given topology key. A failure
+// domain such as "zone" stands for the label holding the failure domain, or
+// else for the first topology key of its type found on the storage nodes.
+func (t Topology) ResolveKey(key string) string {
+	if t.NodeTopologies != nil {
+		if _, ok := t.NodeTopologies.Labels[key]; ok {
+			return key
+		}
+	}
+	if t.FailureDomainKey != "" && key == t.FailureDomain {
+		return t.FailureDomainKey
+	}
+	if found := FindKey(t.NodeTopologies, t.TopologyKeys, key); found != "" {
+		return found
+	}
+	return key
+}
+
+// DeviceSetPlacement is where the Rook device sets made of a
+// StorageDeviceSet, one per replica, are placed
+type DeviceSetPlacement struct {
+	// Custom is set if the device set brings its own placement
+	Custom bool
+	// TopologyKey is the node label the replicas are spread across
+	TopologyKey string
+	// KeyValues are the values of the TopologyKey found on the storage
+	// nodes
+	KeyValues []string
+	// Values hold, for each replica, the value of the TopologyKey it is
+	// pinned to, or an empty string if it is left to the scheduler
+	Values []string
+}
+
+// PlaceDeviceSet pins the replicas of a StorageDeviceSet to the values of
+// its topology key, or else of the failure domain. A device set bringing its
+// own placement is not pinned.
+func PlaceDeviceSet(ds ocsv1.StorageDeviceSet, t Topology) DeviceSetPlacement {
+	replica, _ := DeviceSetReplica(ds)
+	p := DeviceSetPlacement{
+		Custom:      ds.Placement.NodeAffinity != nil || ds.Placement.PodAffinity != nil || ds.Placement.PodAntiAffinity != nil,
+		TopologyKey: ds.TopologyKey,
+		KeyValues:   []string{},
+		Values:      make([]string, replica),
+	}
+	if p.Custom {
+		return p
+	}
+
+	if p.TopologyKey == "" {
+		p.TopologyKey = t.FailureDomain
+	}
+	if t.NodeTopologies != nil {
+		p.TopologyKey, p.KeyValues = t.NodeTopologies.GetKeyValues(t.ResolveKey(p.TopologyKey))
+	}
+	// With the host failure domain the OSDs are only spread across hosts,
+	// and are not portable so that CRUSH finds them under the host they
+	// run on
+	if p.TopologyKey == corev1.LabelHostname {
+		p.KeyValues = []string{}
+	}
+
+	for i := range p.Values {
+		p.Values[i], _ = SpreadValue(p.KeyValues, replica, i)
+	}
+	return p
+}
@@ -0,0 +1,302 @@
+package placement
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const zoneKey = "failure-domain.beta.kubernetes.io/zone"
+
+func newNode(name string, labels map[string]string) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("16"),
+				corev1.ResourceMemory: resource.MustParse("64Gi"),
+			},
+		},
+	}
+}
+
+func newZoneNodes(zones ...string) []corev1.Node {
+	nodes := []corev1.Node{}
+	for i, zone := range zones {
+		labels := map[string]string{corev1.LabelHostname: fmt.Sprintf("node%d", i)}
+		if zone != "" {
+			labels[zoneKey] = zone
+		}
+		nodes = append(nodes, newNode(fmt.Sprintf("node%d", i), labels))
+	}
+	return nodes
+}
+
+func newDeviceSet(name string, count, replica int) ocsv1.StorageDeviceSet {
+	return ocsv1.StorageDeviceSet{Name: name, Count: count, Replica: replica}
+}
+
+func TestKeyType(t *testing.T) {
+	assert.Equal(t, "zone", KeyType("topology.kubernetes.io/zone"))
+	assert.Equal(t, "rack", KeyType("rack"))
+}
+
+func TestDefaultFailureDomain(t *testing.T) {
+	topologyMap := ocsv1.NewNodeTopologyMap()
+	assert.Equal(t, "rack", DefaultFailureDomain(nil))
+	topologyMap.Labels[zoneKey] = ocsv1.TopologyLabelValues{"a", "b"}
+	assert.Equal(t, "rack", DefaultFailureDomain(topologyMap))
+	topologyMap.Labels[zoneKey] = ocsv1.TopologyLabelValues{"a", "b", "c"}
+	assert.Equal(t, "zone", DefaultFailureDomain(topologyMap))
+}
+
+func TestDetermineRackWithoutValidRack(t *testing.T) {
+	nodes := &corev1.NodeList{Items: newZoneNodes("a", "a", "a", "b")}
+	nodeRacks := ocsv1.NewNodeTopologyMap()
+	for _, node := range nodes.Items[:3] {
+		nodeRacks.Add(DetermineRack(nodes, node, 3, nodeRacks, defaults.TopologyKeys), node.Name)
+	}
+	rack := DetermineRack(nodes, nodes.Items[3], 3, nodeRacks, defaults.TopologyKeys)
+	assert.Equal(t, "rack3", rack)
+}
+
+func TestSpreadValue(t *testing.T) {
+	value, pinned := SpreadValue([]string{"a", "b", "c"}, 3, 4)
+	assert.True(t, pinned)
+	assert.Equal(t, "b", value)
+	_, pinned = SpreadValue([]string{"a", "b"}, 3, 0)
+	assert.False(t, pinned)
+	_, pinned = SpreadValue(nil, 0, 0)
+	assert.False(t, pinned)
+}
+
+func TestDeviceSetReplica(t *testing.T) {
+	replica, count := DeviceSetReplica(newDeviceSet("a", 3, 0))
+	assert.Equal(t, defaults.DeviceSetReplica, replica)
+	assert.Equal(t, 1, count)
+	replica, count = DeviceSetReplica(newDeviceSet("a", 2, 4))
+	assert.Equal(t, 4, replica)
+	assert.Equal(t, 2, count)
+}
+
+func TestPlaceDeviceSet(t *testing.T) {
+	topologyMap := ocsv1.NewNodeTopologyMap()
+	topologyMap.Labels[zoneKey] = ocsv1.TopologyLabelValues{"a", "b", "c"}
+	topologyMap.Labels[corev1.LabelHostname] = ocsv1.TopologyLabelValues{"node0", "node1", "node2"}
+	topology := Topology{
+		NodeTopologies: topologyMap,
+		TopologyKeys:   []string{zoneKey, corev1.LabelHostname},
+		FailureDomain:  "zone",
+	}
+
+	p := PlaceDeviceSet(newDeviceSet("a", 3, 0), topology)
+	assert.False(t, p.Custom)
+	assert.Equal(t, zoneKey, p.TopologyKey)
+	assert.Equal(t, []string{"a", "b", "c"}, p.Values)
+
+	ds := newDeviceSet("a", 3, 0)
+	ds.TopologyKey = corev1.LabelHostname
+	p = PlaceDeviceSet(ds, topology)
+	assert.Equal(t, corev1.LabelHostname, p.TopologyKey)
+	assert.Equal(t, []string{"", "", ""}, p.Values)
+
+	ds = newDeviceSet("a", 2, 4)
+	p = PlaceDeviceSet(ds, topology)
+	assert.Equal(t, []string{"", "", "", ""}, p.Values)
+
+	ds = newDeviceSet("a", 3, 0)
+	ds.Placement.NodeAffinity = &corev1.NodeAffinity{}
+	p = PlaceDeviceSet(ds, topology)
+	assert.True(t, p.Custom)
+	assert.Equal(t, []string{"", "", ""}, p.Values)
+}
+
+func TestSimulate(t *testing.T) {
+	cases := []struct {
+		label            string
+		input            Input
+		failureDomain    string
+		failureDomainKey string
+		values           []string
+		warnings         int
+	}{
+		{
+			label:            "Case 1: three zones",
+			input:            Input{Nodes: newZoneNodes("a", "b", "c"), DeviceSets: []ocsv1.StorageDeviceSet{newDeviceSet("set", 3, 0)}},
+			failureDomain:    "zone",
+			failureDomainKey: zoneKey,
+			values:           []string{"a", "b", "c"},
+		},
+		{
+			label:            "Case 2: one zone gets made-up racks",
+			input:            Input{Nodes: newZoneNodes("a", "a", "a"), DeviceSets: []ocsv1.StorageDeviceSet{newDeviceSet("set", 3, 0)}},
+			failureDomain:    "rack",
+			failureDomainKey: defaults.RackTopologyKey,
+			values:           []string{"rack0", "rack1", "rack2"},
+		},
+		{
+			label:            "Case 3: host failure domain",
+			input:            Input{Nodes: newZoneNodes("a", "b", "c"), DeviceSets: []ocsv1.StorageDeviceSet{newDeviceSet("set", 3, 0)}, FailureDomain: "host"},
+			failureDomain:    "host",
+			failureDomainKey: corev1.LabelHostname,
+			values:           []string{"", "", ""},
+		},
+		{
+			label:            "Case 4: more replicas than zones",
+			input:            Input{Nodes: newZoneNodes("a", "b", "c"), DeviceSets: []ocsv1.StorageDeviceSet{newDeviceSet("set", 1, 4)}, FailureDomain: "zone"},
+			failureDomain:    "zone",
+			failureDomainKey: zoneKey,
+			values:           []string{"", "", "", ""},
+			warnings:         2,
+		},
+	}
+
+	for _, c := range cases {
+		result := Simulate(c.input)
+		assert.Equalf(t, c.failureDomain, result.FailureDomain, "[%s]", c.label)
+		assert.Equalf(t, c.failureDomainKey, result.FailureDomainKey, "[%s]", c.label)
+		values := []string{}
+		for _, set := range result.OSDSets {
+			values = append(values, set.TopologyValue)
+			assert.Equalf(t, set.TopologyValue != "", set.Portable, "[%s]", c.label)
+		}
+		assert.Equalf(t, c.values, values, "[%s]", c.label)
+		assert.Lenf(t, result.Warnings, c.warnings, "[%s] %v", c.label, result.Warnings)
+	}
+}
+
+func TestSimulateResources(t *testing.T) {
+	nodes := newZoneNodes("a", "b", "c")
+	nodes[0].Status.Allocatable[corev1.ResourceCPU] = resource.MustParse("1")
+	deviceSet := newDeviceSet("set", 2, 3)
+	deviceSet.Resources = corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+	}
+
+	result := Simulate(Input{Nodes: nodes, DeviceSets: []ocsv1.StorageDeviceSet{deviceSet}})
+	assert.Equal(t, []string{fmt.Sprintf("OSDs on %s=a request 2 cpu, but only 1 is allocatable", zoneKey)}, result.Warnings)
+}
+
+// randomCluster generates storage nodes spread across up to five zones, or
+// none, and device sets with up to five replicas
+type randomCluster struct {
+	Input Input
+}
+
+// Generate implements quick.Generator
+func (randomCluster) Generate(rand *rand.Rand, size int) reflect.Value {
+	zones := rand.Intn(6)
+	nodeCount := 3 + rand.Intn(10)
+	nodeZones := []string{}
+	for i := 0; i < nodeCount; i++ {
+		zone := ""
+		if zones > 0 {
+			zone = fmt.Sprintf("zone%d", rand.Intn(zones))
+		}
+		nodeZones = append(nodeZones, zone)
+	}
+	deviceSets := []ocsv1.StorageDeviceSet{}
+	for i := 0; i < 1+rand.Intn(3); i++ {
+		deviceSets = append(deviceSets, newDeviceSet(fmt.Sprintf("set%d", i), 1+rand.Intn(6), rand.Intn(6)))
+	}
+	return reflect.ValueOf(randomCluster{Input: Input{Nodes: newZoneNodes(nodeZones...), DeviceSets: deviceSets}})
+}
+
+func TestSimulateProperties(t *testing.T) {
+	// Never fewer racks than replicas, given enough nodes, which the
+	// StorageCluster controller requires
+	enoughRacks := func(c randomCluster) bool {
+		result := Simulate(c.Input)
+		replicas := defaults.DeviceSetReplica
+		for _, ds := range c.Input.DeviceSets {
+			if ds.Replica > replicas {
+				replicas = ds.Replica
+			}
+		}
+		if result.FailureDomain != "rack" || len(c.Input.Nodes) < replicas {
+			return true
+		}
+		return len(result.NodeTopologies.Labels[defaults.RackTopologyKey]) >= replicas
+	}
+
+	// Every rack made up holds nodes of a single zone, and every node is
+	// in a rack
+	sameZoneRacks := func(c randomCluster) bool {
+		result := Simulate(c.Input)
+		if result.FailureDomain != "rack" {
+			return true
+		}
+		rackZones := map[string]string{}
+		for _, node := range result.Nodes {
+			if node.Rack == "" {
+				return false
+			}
+			if zone, ok := rackZones[node.Rack]; ok && zone != node.Zone {
+				return false
+			}
+			rackZones[node.Rack] = node.Zone
+		}
+		return true
+	}
+
+	// The device sets split from one StorageDeviceSet are pinned to
+	// distinct values of the failure domain, on nodes holding that value,
+	// or are all left unpinned
+	distinctValues := func(c randomCluster) bool {
+		result := Simulate(c.Input)
+		pinned := map[string]map[string]bool{}
+		unpinned := map[string]int{}
+		for _, set := range result.OSDSets {
+			if set.TopologyValue == "" {
+				unpinned[set.DeviceSet]++
+				continue
+			}
+			if pinned[set.DeviceSet] == nil {
+				pinned[set.DeviceSet] = map[string]bool{}
+			}
+			if pinned[set.DeviceSet][set.TopologyValue] {
+				return false
+			}
+			pinned[set.DeviceSet][set.TopologyValue] = true
+			if len(set.Nodes) == 0 {
+				return false
+			}
+		}
+		for deviceSet := range pinned {
+			if unpinned[deviceSet] > 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Each StorageDeviceSet is split into as many device sets as replicas
+	allReplicas := func(c randomCluster) bool {
+		result := Simulate(c.Input)
+		expected := 0
+		for _, ds := range c.Input.DeviceSets {
+			replica, _ := DeviceSetReplica(ds)
+			expected += replica
+		}
+		return len(result.OSDSets) == expected
+	}
+
+	for name, property := range map[string]interface{}{
+		"enoughRacks":    enoughRacks,
+		"sameZoneRacks":  sameZoneRacks,
+		"distinctValues": distinctValues,
+		"allReplicas":    allReplicas,
+	} {
+		err := quick.Check(property, &quick.Config{MaxCount: 500})
+		assert.NoError(t, err, name)
+	}
+}
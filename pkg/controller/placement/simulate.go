@@ -0,0 +1,314 @@
+package placement
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Input describes the storage nodes and the device sets to place
+type Input struct {
+	// Nodes are the storage nodes, with their labels and allocatable
+	// resources
+	Nodes []corev1.Node
+	// DeviceSets are the StorageDeviceSets of the StorageCluster
+	DeviceSets []ocsv1.StorageDeviceSet
+	// TopologyKeys are the node labels making up the topology, and default
+	// to defaults.TopologyKeys
+	TopologyKeys []string
+	// FailureDomain is the FailureDomain of the StorageCluster spec. If it
+	// is empty, the failure domain is chosen from the topology.
+	FailureDomain string
+	// ResourceProfile selects the default OSD resources
+	ResourceProfile string
+}
+
+// Result describes where the storage nodes and the OSDs end up
+type Result struct {
+	FailureDomain    string                 `json:"failureDomain"`
+	FailureDomainKey string                 `json:"failureDomainKey,omitempty"`
+	NodeTopologies   *ocsv1.NodeTopologyMap `json:"nodeTopologies"`
+	Nodes            []NodePlacement        `json:"nodes"`
+	OSDSets          []OSDSetPlacement      `json:"osdSets"`
+	Warnings         []string               `json:"warnings,omitempty"`
+}
+
+// NodePlacement is the zone and rack of a storage node
+type NodePlacement struct {
+	Name string `json:"name"`
+	Zone string `json:"zone,omitempty"`
+	Rack string `json:"rack,omitempty"`
+	// RackMadeUp is set if the node is not labeled with the rack, and
+	// the operator would label it
+	RackMadeUp bool `json:"rackMadeUp,omitempty"`
+}
+
+// OSDSetPlacement is where the OSDs of one Rook device set may run
+type OSDSetPlacement struct {
+	Name      string `json:"name"`
+	DeviceSet string `json:"deviceSet"`
+	Count     int    `json:"count"`
+	Portable  bool   `json:"portable"`
+	// TopologyKey and TopologyValue are the node label and value the
+	// device set is pinned to, if it is pinned
+	TopologyKey   string `json:"topologyKey,omitempty"`
+	TopologyValue string `json:"topologyValue,omitempty"`
+	// CustomPlacement is set if the device set brings its own placement,
+	// which is not simulated
+	CustomPlacement bool     `json:"customPlacement,omitempty"`
+	Zones           []string `json:"zones,omitempty"`
+	Racks           []string `json:"racks,omitempty"`
+	Nodes           []string `json:"nodes"`
+}
+
+// Simulate places the storage nodes and the device sets the way the
+// StorageCluster controller does on its first reconcile
+func Simulate(in Input) *Result {
+	topologyKeys := in.TopologyKeys
+	if len(topologyKeys) == 0 {
+		topologyKeys = defaults.TopologyKeys
+	}
+	nodes := &corev1.NodeList{Items: make([]corev1.Node, len(in.Nodes))}
+	for i := range in.Nodes {
+		in.Nodes[i].DeepCopyInto(&nodes.Items[i])
+	}
+	sort.Slice(nodes.Items, func(i, j int) bool {
+		return nodes.Items[i].Name < nodes.Items[j].Name
+	})
+
+	result := &Result{
+		NodeTopologies: ocsv1.NewNodeTopologyMap(),
+		Nodes:          []NodePlacement{},
+		OSDSets:        []OSDSetPlacement{},
+	}
+
+	minNodes := defaults.DeviceSetReplica
+	for _, ds := range in.DeviceSets {
+		if ds.Replica > minNodes {
+			minNodes = ds.Replica
+		}
+	}
+	if len(nodes.Items) < minNodes {
+		result.warn("Not enough nodes: expected %d, found %d", minNodes, len(nodes.Items))
+	}
+
+	nodeRacks := ocsv1.NewNodeTopologyMap()
+	for _, node := range nodes.Items {
+		for _, key := range topologyKeys {
+			value, ok := node.Labels[key]
+			if !ok {
+				continue
+			}
+			if !result.NodeTopologies.Contains(key, value) {
+				result.NodeTopologies.Add(key, value)
+			}
+			if KeyType(key) == "rack" && !nodeRacks.Contains(value, node.Name) {
+				nodeRacks.Add(value, node.Name)
+			}
+		}
+	}
+
+	failureDomain, failureDomainKey := in.FailureDomain, ""
+	switch {
+	case failureDomain == "":
+		failureDomain = DefaultFailureDomain(result.NodeTopologies)
+	case failureDomain == "host" || failureDomain == corev1.LabelHostname:
+		failureDomain, failureDomainKey = "host", corev1.LabelHostname
+	case strings.Contains(failureDomain, "/"):
+		failureDomain, failureDomainKey = KeyType(failureDomain), failureDomain
+	}
+	result.FailureDomain = failureDomain
+
+	madeUp := map[string]bool{}
+	if failureDomain == "rack" && failureDomainKey == "" {
+		for i, node := range nodes.Items {
+			if _, ok := node.Labels[defaults.RackTopologyKey]; ok {
+				continue
+			}
+			rack := DetermineRack(nodes, node, minNodes, nodeRacks, topologyKeys)
+			nodeRacks.Add(rack, node.Name)
+			if !result.NodeTopologies.Contains(defaults.RackTopologyKey, rack) {
+				result.NodeTopologies.Add(defaults.RackTopologyKey, rack)
+			}
+			if nodes.Items[i].Labels == nil {
+				nodes.Items[i].Labels = map[string]string{}
+			}
+			nodes.Items[i].Labels[defaults.RackTopologyKey] = rack
+			madeUp[node.Name] = true
+		}
+	}
+	if failureDomainKey == "" {
+		failureDomainKey = FindKey(result.NodeTopologies, topologyKeys, failureDomain)
+	}
+	result.FailureDomainKey = failureDomainKey
+
+	for _, node := range nodes.Items {
+		result.Nodes = append(result.Nodes, NodePlacement{
+			Name:       node.Name,
+			Zone:       NodeZone(node, topologyKeys),
+			Rack:       node.Labels[defaults.RackTopologyKey],
+			RackMadeUp: madeUp[node.Name],
+		})
+	}
+
+	for _, ds := range in.DeviceSets {
+		result.placeDeviceSet(ds, nodes.Items, topologyKeys)
+	}
+	result.checkResources(in.DeviceSets, nodes.Items, in.ResourceProfile)
+
+	return result
+}
+
+// placeDeviceSet splits a StorageDeviceSet into Rook device sets and pins
+// them to the values of its topology key
+func (result *Result) placeDeviceSet(ds ocsv1.StorageDeviceSet, nodes []corev1.Node, topologyKeys []string) {
+	replica, count := DeviceSetReplica(ds)
+	dsPlacement := PlaceDeviceSet(ds, Topology{
+		NodeTopologies:   result.NodeTopologies,
+		TopologyKeys:     topologyKeys,
+		FailureDomain:    result.FailureDomain,
+		FailureDomainKey: result.FailureDomainKey,
+	})
+	topologyKey, values := dsPlacement.TopologyKey, dsPlacement.KeyValues
+
+	if !dsPlacement.Custom && topologyKey != corev1.LabelHostname && len(values) < replica {
+		result.warn("Device set %s is not portable: %d values of %s for %d replicas", ds.Name, len(values), topologyKey, replica)
+	}
+
+	sets := map[string]int{}
+	for i := 0; i < replica; i++ {
+		set := OSDSetPlacement{
+			Name:            fmt.Sprintf("%s-%d", ds.Name, i),
+			DeviceSet:       ds.Name,
+			Count:           count,
+			Portable:        ds.Portable,
+			CustomPlacement: dsPlacement.Custom,
+		}
+		value := dsPlacement.Values[i]
+		pinned := value != ""
+		if !dsPlacement.Custom {
+			set.Portable = pinned
+		}
+		if pinned {
+			set.TopologyKey = topologyKey
+			set.TopologyValue = value
+			sets[value]++
+		}
+		for _, node := range nodes {
+			if pinned && node.Labels[topologyKey] != value {
+				continue
+			}
+			set.Nodes = append(set.Nodes, node.Name)
+			set.Zones = appendValue(set.Zones, NodeZone(node, topologyKeys))
+			set.Racks = appendValue(set.Racks, node.Labels[defaults.RackTopologyKey])
+		}
+		if len(set.Nodes) == 0 {
+			set.Nodes = []string{}
+			result.warn("No node for device set %s", set.Name)
+		}
+		result.OSDSets = append(result.OSDSets, set)
+	}
+
+	if len(sets) > 0 {
+		least, most := replica, 0
+		for _, value := range values {
+			if sets[value] < least {
+				least = sets[value]
+			}
+			if sets[value] > most {
+				most = sets[value]
+			}
+		}
+		if most != least {
+			result.warn("Device set %s is spread unevenly across %s: %d to %d device sets per value", ds.Name, topologyKey, least, most)
+		}
+	}
+}
+
+// checkResources warns of failure domain values whose nodes cannot fit the
+// OSDs pinned to them
+func (result *Result) checkResources(deviceSets []ocsv1.StorageDeviceSet, nodes []corev1.Node, profile string) {
+	requests := map[string]corev1.ResourceList{}
+	keys := map[string]string{}
+	for _, set := range result.OSDSets {
+		if set.TopologyKey == "" {
+			continue
+		}
+		resources := corev1.ResourceRequirements{}
+		for _, ds := range deviceSets {
+			if ds.Name == set.DeviceSet {
+				resources = ds.Resources
+			}
+		}
+		if resources.Requests == nil && resources.Limits == nil {
+			resources = defaults.GetDaemonResources("osd", profile, nil)
+		}
+		id := set.TopologyKey + "=" + set.TopologyValue
+		keys[id] = set.TopologyKey
+		if requests[id] == nil {
+			requests[id] = corev1.ResourceList{}
+		}
+		for name, quantity := range resources.Requests {
+			total := requests[id][name]
+			for i := 0; i < set.Count; i++ {
+				total.Add(quantity)
+			}
+			requests[id][name] = total
+		}
+	}
+
+	ids := []string{}
+	for id := range requests {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		key := keys[id]
+		value := strings.TrimPrefix(id, key+"=")
+		allocatable := corev1.ResourceList{}
+		for _, node := range nodes {
+			if node.Labels[key] != value {
+				continue
+			}
+			for name, quantity := range node.Status.Allocatable {
+				total := allocatable[name]
+				total.Add(quantity)
+				allocatable[name] = total
+			}
+		}
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			requested, ok := requests[id][name]
+			if !ok {
+				continue
+			}
+			available, ok := allocatable[name]
+			if !ok {
+				continue
+			}
+			if requested.Cmp(available) > 0 {
+				result.warn("OSDs on %s request %s %s, but only %s is allocatable", id, requested.String(), name, available.String())
+			}
+		}
+	}
+}
+
+func (result *Result) warn(format string, args ...interface{}) {
+	result.Warnings = append(result.Warnings, fmt.Sprintf(format, args...))
+}
+
+// appendValue appends a non-empty value to a list unless already in it
+func appendValue(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
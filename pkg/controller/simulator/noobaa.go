@@ -0,0 +1,100 @@
+package simulator
+
+import (
+	"context"
+	"reflect"
+
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NooBaaReady brings a NooBaa system up after a tick of creation
+var NooBaaReady = Scenario{
+	Create: []Step{
+		{State: string(nbv1.SystemPhaseCreating), Ticks: 1},
+		{State: string(nbv1.SystemPhaseReady)},
+	},
+}
+
+// NooBaaSlowCreate goes through the phases of a NooBaa system coming up,
+// holding each of them for the given number of ticks
+func NooBaaSlowCreate(ticks int) Scenario {
+	return Scenario{
+		Create: []Step{
+			{State: string(nbv1.SystemPhaseVerifying), Ticks: ticks},
+			{State: string(nbv1.SystemPhaseCreating), Ticks: ticks},
+			{State: string(nbv1.SystemPhaseConnecting), Ticks: ticks},
+			{State: string(nbv1.SystemPhaseConfiguring), Ticks: ticks},
+			{State: string(nbv1.SystemPhaseReady)},
+		},
+	}
+}
+
+// NooBaaRejected rejects the spec of a NooBaa system right away
+var NooBaaRejected = Scenario{
+	Create: []Step{
+		{State: string(nbv1.SystemPhaseRejected)},
+	},
+}
+
+// NooBaaOperator plays a scenario on every NooBaa system it finds
+type NooBaaOperator struct {
+	client   client.Client
+	scenario Scenario
+	systems  map[types.NamespacedName]*progress
+}
+
+var _ Operator = &NooBaaOperator{}
+
+// NewNooBaaOperator returns a simulated NooBaa operator playing the scenario
+func NewNooBaaOperator(c client.Client, scenario Scenario) *NooBaaOperator {
+	return &NooBaaOperator{
+		client:   c,
+		scenario: scenario,
+		systems:  map[types.NamespacedName]*progress{},
+	}
+}
+
+// Tick moves every NooBaa system on to the next step of the scenario
+func (o *NooBaaOperator) Tick() error {
+	systems := &nbv1.NooBaaList{}
+	err := o.client.List(context.TODO(), systems)
+	if err != nil {
+		return err
+	}
+
+	found := map[types.NamespacedName]bool{}
+	for i := range systems.Items {
+		system := &systems.Items[i]
+		key := types.NamespacedName{Name: system.Name, Namespace: system.Namespace}
+		found[key] = true
+
+		spec := system.Spec.DeepCopy()
+		p, ok := o.systems[key]
+		if !ok {
+			p = newProgress(o.scenario.Create, spec)
+			o.systems[key] = p
+		} else if !reflect.DeepEqual(p.spec, spec) {
+			p.restart(o.scenario.Update, spec)
+		}
+
+		step, ok := p.advance()
+		if !ok || string(system.Status.Phase) == step.State {
+			continue
+		}
+		system.Status.Phase = nbv1.SystemPhase(step.State)
+		err = o.client.Status().Update(context.TODO(), system)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A NooBaa system created again under the same name starts over
+	for key := range o.systems {
+		if !found[key] {
+			delete(o.systems, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package simulator
+
+import (
+	"context"
+	"reflect"
+
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CephClusterCreated brings a CephCluster up after a tick of creation, and
+// goes through an update whenever the spec changes, as when the cluster is
+// expanded
+var CephClusterCreated = Scenario{
+	Create: []Step{
+		{State: string(cephv1.ClusterStateCreating), Ticks: 1},
+		{State: string(cephv1.ClusterStateCreated)},
+	},
+	Update: []Step{
+		{State: string(cephv1.ClusterStateCreated), Ticks: 1},
+		{State: string(cephv1.ClusterStateUpdating), Ticks: 2},
+		{State: string(cephv1.ClusterStateCreated)},
+	},
+}
+
+// CephClusterSlowCreate keeps a CephCluster creating for the given number of
+// ticks before bringing it up
+func CephClusterSlowCreate(ticks int) Scenario {
+	return Scenario{
+		Create: []Step{
+			{State: string(cephv1.ClusterStateCreating), Message: "Cluster is creating", Ticks: ticks},
+			{State: string(cephv1.ClusterStateCreated)},
+		},
+		Update: CephClusterCreated.Update,
+	}
+}
+
+// CephClusterFailing brings a CephCluster up and keeps it running for the
+// given number of ticks, before it fails with the message
+func CephClusterFailing(ticks int, message string) Scenario {
+	return Scenario{
+		Create: []Step{
+			{State: string(cephv1.ClusterStateCreating), Ticks: 1},
+			{State: string(cephv1.ClusterStateCreated), Ticks: ticks},
+			{State: string(cephv1.ClusterStateError), Message: message},
+		},
+	}
+}
+
+// RookOperator plays a scenario on every CephCluster it finds
+type RookOperator struct {
+	client   client.Client
+	scenario Scenario
+	clusters map[types.NamespacedName]*progress
+}
+
+var _ Operator = &RookOperator{}
+
+// NewRookOperator returns a simulated Rook operator playing the scenario
+func NewRookOperator(c client.Client, scenario Scenario) *RookOperator {
+	return &RookOperator{
+		client:   c,
+		scenario: scenario,
+		clusters: map[types.NamespacedName]*progress{},
+	}
+}
+
+// Tick moves every CephCluster on to the next step of the scenario
+func (o *RookOperator) Tick() error {
+	clusters := &cephv1.CephClusterList{}
+	err := o.client.List(context.TODO(), clusters)
+	if err != nil {
+		return err
+	}
+
+	found := map[types.NamespacedName]bool{}
+	for i := range clusters.Items {
+		cluster := &clusters.Items[i]
+		key := types.NamespacedName{Name: cluster.Name, Namespace: cluster.Namespace}
+		found[key] = true
+
+		spec := cluster.Spec.DeepCopy()
+		p, ok := o.clusters[key]
+		if !ok {
+			p = newProgress(o.scenario.Create, spec)
+			o.clusters[key] = p
+		} else if !reflect.DeepEqual(p.spec, spec) {
+			p.restart(o.scenario.Update, spec)
+		}
+
+		step, ok := p.advance()
+		if !ok {
+			continue
+		}
+		if string(cluster.Status.State) == step.State && cluster.Status.Message == step.Message {
+			continue
+		}
+		cluster.Status.State = cephv1.ClusterState(step.State)
+		cluster.Status.Message = step.Message
+		// The CephCluster CRD has no status subresource, so the status is
+		// written with the rest of the object
+		err = o.client.Update(context.TODO(), cluster)
+		if err != nil {
+			return err
+		}
+	}
+
+	// A CephCluster created again under the same name starts over
+	for key := range o.clusters {
+		if !found[key] {
+			delete(o.clusters, key)
+		}
+	}
+	return nil
+}
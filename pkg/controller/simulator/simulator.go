@@ -0,0 +1,154 @@
+// Package simulator stands in for the component operators in tests. Its
+// operators move the status of the CephClusters and NooBaa systems through
+// scripted scenarios, so that the phases and conditions the StorageCluster
+// controller derives from them can be observed without a live cluster.
+package simulator
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("simulator")
+
+// Step is a state a simulated operator reports for a number of ticks
+type Step struct {
+	// State is the CephCluster state or the NooBaa phase
+	State string
+	// Message is reported along with the state, where the resource has one
+	Message string
+	// Ticks is how many ticks the state is reported for before the next
+	// step is played. The last step of a scenario is held for good.
+	Ticks int
+}
+
+// Scenario is what a simulated operator reports for each of its resources
+type Scenario struct {
+	// Create is played once the resource shows up
+	Create []Step
+	// Update is played every time the spec of the resource is changed
+	// afterwards. The state is left alone if it is empty.
+	Update []Step
+}
+
+// Operator is a simulated component operator
+type Operator interface {
+	// Tick advances the status of every resource the operator looks after
+	// by one step of its scenario
+	Tick() error
+}
+
+// Run ticks the operators at every interval until stop is closed, for them to
+// run alongside a manager. Errors are logged, as an operator would.
+func Run(operators []Operator, interval time.Duration, stop <-chan struct{}) {
+	wait.Until(func() {
+		for _, operator := range operators {
+			err := operator.Tick()
+			if err != nil {
+				log.Error(err, "Simulated operator failed")
+			}
+		}
+	}, interval, stop)
+}
+
+// progress tracks where a resource is in a scenario
+type progress struct {
+	steps   []Step
+	current int
+	elapsed int
+	// spec is the last spec seen, to tell when an update is to be played
+	spec interface{}
+}
+
+func newProgress(steps []Step, spec interface{}) *progress {
+	return &progress{steps: steps, spec: spec}
+}
+
+// restart plays the steps from the start
+func (p *progress) restart(steps []Step, spec interface{}) {
+	p.spec = spec
+	if len(steps) == 0 {
+		return
+	}
+	p.steps = steps
+	p.current = 0
+	p.elapsed = 0
+}
+
+// advance returns the step to report for this tick and moves on to the next
+// step once it has been reported for long enough. It returns false if there
+// is nothing to report.
+func (p *progress) advance() (Step, bool) {
+	if len(p.steps) == 0 {
+		return Step{}, false
+	}
+	step := p.steps[p.current]
+	p.elapsed++
+	if p.elapsed >= step.Ticks && p.current < len(p.steps)-1 {
+		p.current++
+		p.elapsed = 0
+	}
+	return step, true
+}
+
+// Harness reconciles a request and lets the simulated operators react to the
+// outcome, round after round, like the controller and the component
+// operators would in a cluster
+type Harness struct {
+	Reconciler reconcile.Reconciler
+	Request    reconcile.Request
+	Operators  []Operator
+}
+
+// Round reconciles the request once, then ticks every operator. The error of
+// the reconcile is returned, as the controller would requeue on it.
+func (h *Harness) Round() (reconcile.Result, error) {
+	result, err := h.Reconciler.Reconcile(h.Request)
+	tickErr := h.tick()
+	if tickErr != nil {
+		return result, tickErr
+	}
+	return result, err
+}
+
+func (h *Harness) tick() error {
+	for _, operator := range h.Operators {
+		err := operator.Tick()
+		if err != nil {
+			return fmt.Errorf("Simulated operator failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// RunUntil plays rounds until done returns true, and fails if it does not
+// within the given number of rounds. Reconcile errors are not fatal, as the
+// scenario may well cause them.
+func (h *Harness) RunUntil(rounds int, done func() (bool, error)) error {
+	var lastErr error
+	for i := 0; i < rounds; i++ {
+		_, err := h.Reconciler.Reconcile(h.Request)
+		if err != nil {
+			lastErr = err
+		}
+		err = h.tick()
+		if err != nil {
+			return err
+		}
+		ok, err := done()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("Condition not met after %d rounds, last reconcile error: %v", rounds, lastErr)
+	}
+	return fmt.Errorf("Condition not met after %d rounds", rounds)
+}
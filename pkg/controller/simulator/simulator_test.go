@@ -0,0 +1,150 @@
+package simulator
+
+import (
+	"context"
+	"testing"
+
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestProgressAdvance(t *testing.T) {
+	p := newProgress([]Step{
+		{State: "a", Ticks: 2},
+		{State: "b", Ticks: 0},
+		{State: "c", Ticks: 1},
+	}, nil)
+
+	states := []string{}
+	for i := 0; i < 6; i++ {
+		step, ok := p.advance()
+		assert.True(t, ok)
+		states = append(states, step.State)
+	}
+	// A step of no ticks is still reported once, and the last is held
+	assert.Equal(t, []string{"a", "a", "b", "c", "c", "c"}, states)
+
+	// An update without steps leaves the state alone
+	p.restart(nil, "changed")
+	step, _ := p.advance()
+	assert.Equal(t, "c", step.State)
+	assert.Equal(t, "changed", p.spec)
+
+	p.restart([]Step{{State: "d"}}, "changed again")
+	step, _ = p.advance()
+	assert.Equal(t, "d", step.State)
+
+	_, ok := newProgress(nil, nil).advance()
+	assert.False(t, ok)
+}
+
+func TestRookOperator(t *testing.T) {
+	cluster := &cephv1.CephCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "ns"},
+	}
+	c := fake.NewFakeClientWithScheme(createFakeScheme(t), cluster)
+	operator := NewRookOperator(c, CephClusterCreated)
+
+	states := []cephv1.ClusterState{}
+	tick := func() {
+		assert.NoError(t, operator.Tick())
+		found := &cephv1.CephCluster{}
+		assert.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: "cluster", Namespace: "ns"}, found))
+		states = append(states, found.Status.State)
+	}
+	tick()
+	tick()
+	tick()
+	assert.Equal(t, []cephv1.ClusterState{
+		cephv1.ClusterStateCreating,
+		cephv1.ClusterStateCreated,
+		cephv1.ClusterStateCreated,
+	}, states)
+
+	// Change the spec of the cluster
+	found := &cephv1.CephCluster{}
+	assert.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: "cluster", Namespace: "ns"}, found))
+	found.Spec.Mon.Count = 3
+	assert.NoError(t, c.Update(context.TODO(), found))
+
+	states = nil
+	for i := 0; i < 5; i++ {
+		tick()
+	}
+	assert.Equal(t, []cephv1.ClusterState{
+		cephv1.ClusterStateCreated,
+		cephv1.ClusterStateUpdating,
+		cephv1.ClusterStateUpdating,
+		cephv1.ClusterStateCreated,
+		cephv1.ClusterStateCreated,
+	}, states)
+}
+
+func TestNooBaaOperator(t *testing.T) {
+	system := &nbv1.NooBaa{
+		ObjectMeta: metav1.ObjectMeta{Name: "noobaa", Namespace: "ns"},
+	}
+	c := fake.NewFakeClientWithScheme(createFakeScheme(t), system)
+	operator := NewNooBaaOperator(c, NooBaaRejected)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, operator.Tick())
+		found := &nbv1.NooBaa{}
+		assert.NoError(t, c.Get(context.TODO(), types.NamespacedName{Name: "noobaa", Namespace: "ns"}, found))
+		assert.Equal(t, nbv1.SystemPhaseRejected, found.Status.Phase)
+	}
+}
+
+// countingReconciler reconciles by counting, and fails as long as asked to
+type countingReconciler struct {
+	count    int
+	failures int
+}
+
+func (r *countingReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	r.count++
+	if r.count <= r.failures {
+		return reconcile.Result{}, assert.AnError
+	}
+	return reconcile.Result{}, nil
+}
+
+func TestHarnessRunUntil(t *testing.T) {
+	c := fake.NewFakeClientWithScheme(createFakeScheme(t))
+	r := &countingReconciler{failures: 2}
+	h := &Harness{
+		Reconciler: r,
+		Operators:  []Operator{NewRookOperator(c, CephClusterCreated)},
+	}
+
+	// Reconcile errors do not stop the run
+	err := h.RunUntil(5, func() (bool, error) { return r.count == 3, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.count)
+
+	err = h.RunUntil(2, func() (bool, error) { return false, nil })
+	assert.Error(t, err)
+	assert.Equal(t, 5, r.count)
+
+	_, err = h.Round()
+	assert.NoError(t, err)
+}
+
+func createFakeScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	err := cephv1.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add rookCephv1 scheme")
+	}
+	err = nbv1.SchemeBuilder.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add noobaa scheme")
+	}
+	return scheme
+}
@@ -0,0 +1,230 @@
+package storagecluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The StorageClusters of all namespaces share the cluster-scoped objects,
+// such as the StorageClasses created for them and the labels of the storage
+// nodes. As they may be reconciled concurrently, the changes to these
+// objects are made under a lock, and the objects created for a
+// StorageCluster record it so that no other one takes them over. The nodes
+// record every StorageCluster relying on the changes made to them, so that
+// a change is only rolled back once none of them does.
+
+const (
+	// storageClusterAnnotation names the StorageCluster a cluster-scoped
+	// object was created for, as namespace/name. On nodes it maps each
+	// StorageCluster to the changes it relies on, as JSON.
+	storageClusterAnnotation = "ocs.openshift.io/storagecluster"
+	// nodesLockKey is the key of the lock held while changing the labels
+	// and taints of nodes
+	nodesLockKey = "Node"
+	// nodeRackChange is the change recorded for the rack label of a node
+	nodeRackChange = "rack"
+)
+
+// keyedLocks hands out a lock per key, such as the kind and name of an
+// object
+type keyedLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newKeyedLocks() *keyedLocks {
+	return &keyedLocks{locks: map[string]*sync.Mutex{}}
+}
+
+// lock waits for the lock of the key, and returns the function releasing it
+func (l *keyedLocks) lock(key string) func() {
+	l.mu.Lock()
+	m, ok := l.locks[key]
+	if !ok {
+		m = &sync.Mutex{}
+		l.locks[key] = m
+	}
+	l.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// clusterScopedLockKey returns the key of the lock of a cluster-scoped object
+func clusterScopedLockKey(kind, name string) string {
+	return fmt.Sprintf("%s/%s", kind, name)
+}
+
+// setClusterScopedOwner records the StorageCluster a cluster-scoped object is
+// created for
+func setClusterScopedOwner(sc *ocsv1.StorageCluster, obj metav1.Object) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[storageClusterAnnotation] = fmt.Sprintf("%s/%s", sc.Namespace, sc.Name)
+	obj.SetAnnotations(annotations)
+}
+
+// isClusterScopedOwner tells whether a cluster-scoped object was created for
+// the StorageCluster. Objects created before their StorageCluster was
+// recorded are taken to be its own.
+func isClusterScopedOwner(sc *ocsv1.StorageCluster, obj metav1.Object) bool {
+	owner, ok := obj.GetAnnotations()[storageClusterAnnotation]
+	return !ok || owner == fmt.Sprintf("%s/%s", sc.Namespace, sc.Name)
+}
+
+// getClusterScopedName returns the name of the cluster-scoped object created
+// for the StorageCluster under the given name. The name is kept, so that the
+// PVCs and OBCs of the StorageClasses keep working, unless the object of that
+// name belongs to another StorageCluster. The name then starts with the
+// namespace of the StorageCluster. An object already created under the
+// namespaced name keeps it. The obj is only used to read the objects.
+func (r *ReconcileStorageCluster) getClusterScopedName(sc *ocsv1.StorageCluster, obj runtime.Object, name string) (string, error) {
+	namespacedName := generateNameForNamespacedClusterScoped(sc, name)
+	owned, err := r.isClusterScopedNameOwned(sc, obj, namespacedName)
+	if err != nil {
+		return "", err
+	}
+	if owned {
+		return namespacedName, nil
+	}
+
+	taken, err := r.isClusterScopedNameTaken(sc, obj, name)
+	if err != nil {
+		return "", err
+	}
+	if taken {
+		return namespacedName, nil
+	}
+	return name, nil
+}
+
+// getClusterScopedNames returns both names the cluster-scoped object created
+// for the StorageCluster under the given name may have, leaving out the ones
+// taken by the objects of other StorageClusters
+func (r *ReconcileStorageCluster) getClusterScopedNames(sc *ocsv1.StorageCluster, obj runtime.Object, name string) ([]string, error) {
+	names := []string{}
+	for _, candidate := range []string{name, generateNameForNamespacedClusterScoped(sc, name)} {
+		taken, err := r.isClusterScopedNameTaken(sc, obj, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			names = append(names, candidate)
+		}
+	}
+	return names, nil
+}
+
+// isClusterScopedNameOwned tells whether the cluster-scoped object of the
+// name exists and was created for the StorageCluster
+func (r *ReconcileStorageCluster) isClusterScopedNameOwned(sc *ocsv1.StorageCluster, obj runtime.Object, name string) (bool, error) {
+	accessor, err := r.getClusterScopedObject(obj, name)
+	if accessor == nil || err != nil {
+		return false, err
+	}
+	return isClusterScopedOwner(sc, accessor), nil
+}
+
+// isClusterScopedNameTaken tells whether the cluster-scoped object of the
+// name exists and was created for another StorageCluster
+func (r *ReconcileStorageCluster) isClusterScopedNameTaken(sc *ocsv1.StorageCluster, obj runtime.Object, name string) (bool, error) {
+	accessor, err := r.getClusterScopedObject(obj, name)
+	if accessor == nil || err != nil {
+		return false, err
+	}
+	return !isClusterScopedOwner(sc, accessor), nil
+}
+
+// getClusterScopedObject reads the cluster-scoped object of the name into
+// obj, and returns nil if it does not exist
+func (r *ReconcileStorageCluster) getClusterScopedObject(obj runtime.Object, name string) (metav1.Object, error) {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name}, obj)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta.Accessor(obj)
+}
+
+// checkClusterScopedOwner returns an error if a cluster-scoped object was
+// created for another StorageCluster
+func checkClusterScopedOwner(sc *ocsv1.StorageCluster, kind string, obj metav1.Object) error {
+	if isClusterScopedOwner(sc, obj) {
+		return nil
+	}
+	return fmt.Errorf("%s %s belongs to StorageCluster %s, rename one of the StorageClusters", kind, obj.GetName(), obj.GetAnnotations()[storageClusterAnnotation])
+}
+
+// getNodeChangeOwners returns the changes made to the node by the operator,
+// such as its rack label and taint, by the StorageCluster relying on them.
+// The storage nodes are shared by all StorageClusters, so the
+// storageClusterAnnotation of a node lists them all. A record that can not
+// be read is taken to be empty.
+func getNodeChangeOwners(node corev1.Node) map[string][]string {
+	owners := map[string][]string{}
+	value, ok := node.Annotations[storageClusterAnnotation]
+	if !ok || json.Unmarshal([]byte(value), &owners) != nil {
+		return map[string][]string{}
+	}
+	return owners
+}
+
+// setNodeChangeOwner records whether the StorageCluster relies on the change
+// made to the node, and returns whether the record changed
+func setNodeChangeOwner(sc *ocsv1.StorageCluster, node *corev1.Node, change string, owned bool) bool {
+	owners := getNodeChangeOwners(*node)
+	owner := fmt.Sprintf("%s/%s", sc.Namespace, sc.Name)
+	if contains(owners[owner], change) == owned {
+		return false
+	}
+
+	if owned {
+		owners[owner] = append(owners[owner], change)
+		sort.Strings(owners[owner])
+	} else {
+		owners[owner] = remove(owners[owner], change)
+	}
+	if len(owners[owner]) == 0 {
+		delete(owners, owner)
+	}
+
+	if len(owners) == 0 {
+		delete(node.Annotations, storageClusterAnnotation)
+		return true
+	}
+	// A map of string slices always marshals
+	value, _ := json.Marshal(owners)
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[storageClusterAnnotation] = string(value)
+	return true
+}
+
+// hasOtherNodeChangeOwner says whether a StorageCluster other than the given
+// one relies on the change made to the node. Changes made before their
+// StorageClusters were recorded are rolled back by the first StorageCluster
+// to roll them back.
+func hasOtherNodeChangeOwner(sc *ocsv1.StorageCluster, node corev1.Node, change string) bool {
+	for owner, changes := range getNodeChangeOwners(node) {
+		if owner != fmt.Sprintf("%s/%s", sc.Namespace, sc.Name) && contains(changes, change) {
+			return true
+		}
+	}
+	return false
+}
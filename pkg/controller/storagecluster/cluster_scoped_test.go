@@ -0,0 +1,273 @@
+package storagecluster
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	rookCephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestKeyedLocks(t *testing.T) {
+	locks := newKeyedLocks()
+	holders := map[string]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		key := []string{"a", "b"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer locks.lock(key)()
+			mu.Lock()
+			holders[key]++
+			assert.Equal(t, 1, holders[key], "lock %s held twice", key)
+			mu.Unlock()
+
+			runtime.Gosched()
+
+			mu.Lock()
+			holders[key]--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClusterScopedOwner(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	other := mockStorageCluster.DeepCopy()
+	other.Namespace = "other-ns"
+
+	storageClass := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "storage-test-ceph-rbd"}}
+	// Objects created before their StorageCluster was recorded are adopted
+	assert.True(t, isClusterScopedOwner(sc, storageClass))
+
+	setClusterScopedOwner(sc, storageClass)
+	assert.Equal(t, "storage-test-ns/storage-test", storageClass.Annotations[storageClusterAnnotation])
+	assert.True(t, isClusterScopedOwner(sc, storageClass))
+	assert.NoError(t, checkClusterScopedOwner(sc, "StorageClass", storageClass))
+	assert.False(t, isClusterScopedOwner(other, storageClass))
+	assert.EqualError(t, checkClusterScopedOwner(other, "StorageClass", storageClass),
+		"StorageClass storage-test-ceph-rbd belongs to StorageCluster storage-test-ns/storage-test, rename one of the StorageClusters")
+}
+
+func TestNodeChangeOwners(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	other := mockStorageCluster.DeepCopy()
+	other.Namespace = "other-ns"
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	// Changes made before their StorageClusters were recorded have none
+	assert.False(t, hasOtherNodeChangeOwner(sc, *node, nodeRackChange))
+
+	assert.True(t, setNodeChangeOwner(sc, node, nodeRackChange, true))
+	assert.False(t, setNodeChangeOwner(sc, node, nodeRackChange, true))
+	assert.True(t, setNodeChangeOwner(other, node, dedicatedNodeTaint, true))
+	assert.True(t, setNodeChangeOwner(other, node, nodeRackChange, true))
+	assert.Equal(t, `{"other-ns/storage-test":["rack","taint"],"storage-test-ns/storage-test":["rack"]}`, node.Annotations[storageClusterAnnotation])
+	assert.True(t, hasOtherNodeChangeOwner(sc, *node, nodeRackChange))
+	assert.True(t, hasOtherNodeChangeOwner(sc, *node, dedicatedNodeTaint))
+	assert.False(t, hasOtherNodeChangeOwner(other, *node, dedicatedNodeTaint))
+
+	assert.True(t, setNodeChangeOwner(other, node, nodeRackChange, false))
+	assert.True(t, setNodeChangeOwner(other, node, dedicatedNodeTaint, false))
+	assert.False(t, hasOtherNodeChangeOwner(sc, *node, nodeRackChange))
+	assert.True(t, setNodeChangeOwner(sc, node, nodeRackChange, false))
+	assert.NotContains(t, node.Annotations, storageClusterAnnotation)
+
+	// A record that can not be read is taken to be empty
+	node.Annotations[storageClusterAnnotation] = "storage-test-ns/storage-test"
+	assert.False(t, hasOtherNodeChangeOwner(sc, *node, nodeRackChange))
+	assert.True(t, setNodeChangeOwner(sc, node, nodeRackChange, true))
+	assert.Equal(t, `{"storage-test-ns/storage-test":["rack"]}`, node.Annotations[storageClusterAnnotation])
+}
+
+// newNamespacedStorageCluster returns a StorageCluster to be set up from
+// scratch in the namespace
+func newNamespacedStorageCluster(name, namespace string) *api.StorageCluster {
+	sc := &api.StorageCluster{}
+	sc.Name = name
+	sc.Namespace = namespace
+	return sc
+}
+
+// reconcileConcurrently reconciles the StorageClusters at the same time, for
+// the given number of rounds
+func reconcileConcurrently(reconciler *ReconcileStorageCluster, rounds int, scs ...*api.StorageCluster) {
+	for i := 0; i < rounds; i++ {
+		var wg sync.WaitGroup
+		for _, sc := range scs {
+			wg.Add(1)
+			go func(request reconcile.Request) {
+				defer wg.Done()
+				// Errors are left for the status of each StorageCluster
+				_, _ = reconciler.Reconcile(request)
+			}(reconcile.Request{NamespacedName: types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace}})
+		}
+		wg.Wait()
+	}
+}
+
+func getStepResult(t *testing.T, reconciler *ReconcileStorageCluster, sc *api.StorageCluster, name string) api.ReconcileStepStatus {
+	found := &api.StorageCluster{}
+	err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace}, found)
+	assert.NoError(t, err)
+	for _, step := range found.Status.ReconcileSteps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return api.ReconcileStepStatus{}
+}
+
+func TestConcurrentStorageClusters(t *testing.T) {
+	clusterA := newNamespacedStorageCluster("cluster-a", "ns-a")
+	clusterB := newNamespacedStorageCluster("cluster-b", "ns-b")
+	reconciler := createFakeStorageClusterReconciler(t, clusterA, clusterB, mockNodeList.DeepCopy())
+	reconcileConcurrently(&reconciler, 3, clusterA, clusterB)
+
+	for _, sc := range []*api.StorageCluster{clusterA, clusterB} {
+		assert.Equal(t, api.ReconcileStepSucceeded, getStepResult(t, &reconciler, sc, "storageClasses").Result, sc.Namespace)
+
+		cephCluster := &rookCephv1.CephCluster{}
+		err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, cephCluster)
+		assert.NoError(t, err, sc.Namespace)
+		assert.Equal(t, sc.Namespace, cephCluster.Spec.Monitoring.RulesNamespace)
+
+		storageClass := &storagev1.StorageClass{}
+		err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephBlockPoolSC(sc)}, storageClass)
+		assert.NoError(t, err, sc.Namespace)
+		assert.True(t, isClusterScopedOwner(sc, storageClass), sc.Namespace)
+		assert.Equal(t, sc.Namespace, storageClass.Parameters["clusterID"])
+	}
+}
+
+func TestConcurrentStorageClustersSameName(t *testing.T) {
+	clusterA := newNamespacedStorageCluster("storage-test", "ns-a")
+	clusterB := newNamespacedStorageCluster("storage-test", "ns-b")
+	reconciler := createFakeStorageClusterReconciler(t, clusterA, clusterB, mockNodeList.DeepCopy())
+	reconcileConcurrently(&reconciler, 3, clusterA, clusterB)
+
+	// Whichever StorageCluster got to a StorageClass first keeps its name,
+	// the other one puts its namespace in front of it
+	for _, name := range []string{generateNameForCephFilesystemSC(clusterA), generateNameForCephBlockPoolSC(clusterA)} {
+		storageClass := &storagev1.StorageClass{}
+		err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: name}, storageClass)
+		assert.NoError(t, err, name)
+		owner, other := clusterA, clusterB
+		if !isClusterScopedOwner(clusterA, storageClass) {
+			owner, other = clusterB, clusterA
+		}
+		assert.True(t, isClusterScopedOwner(owner, storageClass), name)
+		assert.Equal(t, owner.Namespace, storageClass.Parameters["clusterID"], name)
+
+		err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForNamespacedClusterScoped(other, name)}, storageClass)
+		assert.NoError(t, err, name)
+		assert.True(t, isClusterScopedOwner(other, storageClass), name)
+		assert.Equal(t, other.Namespace, storageClass.Parameters["clusterID"], name)
+	}
+	for _, sc := range []*api.StorageCluster{clusterA, clusterB} {
+		assert.Equal(t, api.ReconcileStepSucceeded, getStepResult(t, &reconciler, sc, "storageClasses").Result, sc.Namespace)
+	}
+
+	// Uninstalling one StorageCluster leaves the StorageClasses of the
+	// other one alone
+	_, err := reconciler.deleteStorageClasses(clusterB, reconciler.reqLogger)
+	assert.NoError(t, err)
+	storageClasses := &storagev1.StorageClassList{}
+	err = reconciler.client.List(context.TODO(), storageClasses)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, storageClasses.Items)
+	for _, storageClass := range storageClasses.Items {
+		assert.True(t, isClusterScopedOwner(clusterA, &storageClass), storageClass.Name)
+	}
+	_, err = reconciler.deleteStorageClasses(clusterA, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.client.List(context.TODO(), storageClasses)
+	assert.NoError(t, err)
+	assert.Empty(t, storageClasses.Items)
+}
+
+func TestGetClusterScopedName(t *testing.T) {
+	sc := newNamespacedStorageCluster("storage-test", "ns-a")
+	other := newNamespacedStorageCluster("storage-test", "ns-b")
+	name := generateNameForCephBlockPoolSC(sc)
+	namespacedName := generateNameForNamespacedClusterScoped(sc, name)
+	newStorageClass := func(name string, owner *api.StorageCluster) *storagev1.StorageClass {
+		storageClass := &storagev1.StorageClass{}
+		storageClass.Name = name
+		if owner != nil {
+			setClusterScopedOwner(owner, storageClass)
+		}
+		return storageClass
+	}
+
+	cases := []struct {
+		label    string
+		objects  []*storagev1.StorageClass
+		expected string
+	}{
+		{
+			label:    "name is free",
+			expected: name,
+		},
+		{
+			label:    "class of an older release is adopted",
+			objects:  []*storagev1.StorageClass{newStorageClass(name, nil)},
+			expected: name,
+		},
+		{
+			label:    "name is taken by another StorageCluster",
+			objects:  []*storagev1.StorageClass{newStorageClass(name, other)},
+			expected: namespacedName,
+		},
+		{
+			label:    "class created under the namespaced name keeps it",
+			objects:  []*storagev1.StorageClass{newStorageClass(namespacedName, sc)},
+			expected: namespacedName,
+		},
+	}
+
+	for _, c := range cases {
+		reconciler := createFakeStorageClusterReconciler(t)
+		for _, obj := range c.objects {
+			err := reconciler.client.Create(context.TODO(), obj)
+			assert.NoError(t, err, c.label)
+		}
+		actual, err := reconciler.getClusterScopedName(sc, &storagev1.StorageClass{}, name)
+		assert.NoError(t, err, c.label)
+		assert.Equal(t, c.expected, actual, c.label)
+	}
+}
+
+func TestEnsureStorageClassesNamesTaken(t *testing.T) {
+	// Namespace ns-a with StorageCluster b-x and namespace ns-a-b with
+	// StorageCluster x make up the same namespaced names
+	sc := newNamespacedStorageCluster("x", "ns-a-b")
+	other := newNamespacedStorageCluster("b-x", "ns-a")
+	name := generateNameForCephFilesystemSC(sc)
+	reconciler := createFakeStorageClusterReconciler(t)
+	for _, owner := range []*api.StorageCluster{other, newNamespacedStorageCluster("x", "ns-c")} {
+		for _, storageClassName := range []string{name, generateNameForNamespacedClusterScoped(sc, name)} {
+			storageClass := &storagev1.StorageClass{}
+			storageClass.Name = storageClassName
+			setClusterScopedOwner(owner, storageClass)
+			// Only the first owner of a name gets it
+			_ = reconciler.client.Create(context.TODO(), storageClass)
+		}
+	}
+
+	err := reconciler.ensureStorageClasses(sc, reconciler.reqLogger)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "belongs to StorageCluster")
+	assert.False(t, sc.Status.StorageClassesCreated)
+}
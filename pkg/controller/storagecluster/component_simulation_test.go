@@ -0,0 +1,187 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/simulator"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// simulation drives the StorageCluster controller against simulated Rook and
+// NooBaa operators
+type simulation struct {
+	t       *testing.T
+	client  client.Client
+	harness *simulator.Harness
+	// phases are the phases the StorageCluster went through, one per round
+	phases []string
+}
+
+func newSimulation(t *testing.T, cephCluster, noobaa simulator.Scenario) *simulation {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.ObjectMeta.DeepCopyInto(&sc.ObjectMeta)
+	sc.Spec.StorageDeviceSets = []api.StorageDeviceSet{}
+	for _, ds := range mockDeviceSets {
+		sc.Spec.StorageDeviceSets = append(sc.Spec.StorageDeviceSets, *ds.DeepCopy())
+	}
+
+	scheme := createFakeScheme(t)
+	objects := []runtime.Object{sc}
+	for i := range mockNodeList.Items {
+		objects = append(objects, mockNodeList.Items[i].DeepCopy())
+	}
+	for _, obj := range objects {
+		statusutil.SetSelfLink(scheme, obj)
+	}
+	fakeClient := fake.NewFakeClientWithScheme(scheme, objects...)
+	c := &selfLinkClient{Client: fakeClient, scheme: scheme}
+
+	return &simulation{
+		t:      t,
+		client: fakeClient,
+		harness: &simulator.Harness{
+			Reconciler: NewReconciler(c, c, scheme, ReconcilerOptions{}),
+			Request:    mockStorageClusterRequest,
+			Operators: []simulator.Operator{
+				simulator.NewRookOperator(fakeClient, cephCluster),
+				simulator.NewNooBaaOperator(fakeClient, noobaa),
+			},
+		},
+	}
+}
+
+// selfLinkClient sets the selfLinks of the objects it creates, which the
+// object references in the status are made from, like the apiserver does
+type selfLinkClient struct {
+	client.Client
+	scheme *runtime.Scheme
+}
+
+func (c *selfLinkClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	statusutil.SetSelfLink(c.scheme, obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (s *simulation) storageCluster() *api.StorageCluster {
+	sc := &api.StorageCluster{}
+	err := s.client.Get(context.TODO(), mockStorageClusterRequest.NamespacedName, sc)
+	assert.NoError(s.t, err)
+	return sc
+}
+
+// runUntilPhase plays rounds until the StorageCluster reaches the phase
+func (s *simulation) runUntilPhase(rounds int, phase string) {
+	err := s.harness.RunUntil(rounds, func() (bool, error) {
+		sc := s.storageCluster()
+		s.phases = append(s.phases, sc.Status.Phase)
+		return sc.Status.Phase == phase, nil
+	})
+	assert.NoError(s.t, err, "phases went through: %v", s.phases)
+}
+
+func (s *simulation) cephClusterState() cephv1.ClusterState {
+	cephCluster := &cephv1.CephCluster{}
+	err := s.client.Get(context.TODO(), mockCephClusterNamespacedName, cephCluster)
+	if err != nil && !errors.IsNotFound(err) {
+		assert.NoError(s.t, err)
+	}
+	return cephCluster.Status.State
+}
+
+func (s *simulation) assertCondition(conditionType conditionsv1.ConditionType, status corev1.ConditionStatus, reason string) {
+	condition := conditionsv1.FindStatusCondition(s.storageCluster().Status.Conditions, conditionType)
+	if assert.NotNil(s.t, condition, "condition %s not found", conditionType) {
+		assert.Equal(s.t, status, condition.Status)
+		assert.Equal(s.t, reason, condition.Reason)
+	}
+}
+
+func TestSimulationSlowCreate(t *testing.T) {
+	s := newSimulation(t, simulator.CephClusterSlowCreate(3), simulator.NooBaaSlowCreate(1))
+
+	_, err := s.harness.Round()
+	assert.NoError(t, err)
+	_, err = s.harness.Round()
+	assert.NoError(t, err)
+	assert.Equal(t, statusutil.PhaseProgressing, s.storageCluster().Status.Phase)
+	s.assertCondition(conditionsv1.ConditionProgressing, corev1.ConditionTrue, "ClusterStateCreating")
+	s.assertCondition(conditionsv1.ConditionUpgradeable, corev1.ConditionFalse, "ClusterStateCreating")
+
+	s.runUntilPhase(20, statusutil.PhaseReady)
+	s.assertCondition(conditionsv1.ConditionAvailable, corev1.ConditionTrue, api.ReconcileCompleted)
+	s.assertCondition(conditionsv1.ConditionProgressing, corev1.ConditionFalse, api.ReconcileCompleted)
+	// The NooBaa system kept it progressing while coming up
+	assert.Contains(t, s.phases, statusutil.PhaseProgressing)
+}
+
+func TestSimulationCephClusterError(t *testing.T) {
+	s := newSimulation(t, simulator.CephClusterFailing(5, "mon quorum lost"), simulator.NooBaaReady)
+
+	s.runUntilPhase(20, statusutil.PhaseReady)
+	s.runUntilPhase(20, statusutil.PhaseError)
+	s.assertCondition(conditionsv1.ConditionAvailable, corev1.ConditionFalse, "ClusterStateError")
+	s.assertCondition(conditionsv1.ConditionDegraded, corev1.ConditionTrue, "ClusterStateError")
+}
+
+func TestSimulationExpansion(t *testing.T) {
+	s := newSimulation(t, simulator.CephClusterCreated, simulator.NooBaaReady)
+	// Nothing is reported negatively before Rook gets to the CephCluster,
+	// so the StorageCluster is ready right away
+	err := s.harness.RunUntil(20, func() (bool, error) {
+		return s.storageCluster().Status.Phase == statusutil.PhaseReady &&
+			s.cephClusterState() == cephv1.ClusterStateCreated, nil
+	})
+	assert.NoError(t, err)
+
+	// Add an OSD to each replica of the device set
+	sc := s.storageCluster()
+	sc.Spec.StorageDeviceSets[0].Count += 3
+	err = s.client.Update(context.TODO(), sc)
+	assert.NoError(t, err)
+
+	// Play until Rook is done updating the CephCluster
+	phases := []string{}
+	updated := false
+	err = s.harness.RunUntil(20, func() (bool, error) {
+		phase := s.storageCluster().Status.Phase
+		phases = append(phases, phase)
+		state := s.cephClusterState()
+		if state == cephv1.ClusterStateUpdating {
+			updated = true
+		}
+		return updated && state == cephv1.ClusterStateCreated && phase == statusutil.PhaseReady, nil
+	})
+	assert.NoError(t, err, "phases went through: %v", phases)
+	// Expanding at first, then progressing while Rook updates the
+	// CephCluster
+	assert.Equal(t, statusutil.PhaseClusterExpanding, phases[0])
+	assert.Contains(t, phases, statusutil.PhaseProgressing)
+}
+
+func TestSimulationNooBaaRejected(t *testing.T) {
+	s := newSimulation(t, simulator.CephClusterCreated, simulator.NooBaaRejected)
+
+	err := s.harness.RunUntil(20, func() (bool, error) {
+		condition := conditionsv1.FindStatusCondition(s.storageCluster().Status.Conditions, conditionsv1.ConditionDegraded)
+		return condition != nil && condition.Status == corev1.ConditionTrue, nil
+	})
+	assert.NoError(t, err)
+	s.assertCondition(conditionsv1.ConditionDegraded, corev1.ConditionTrue, "NoobaaSpecRejected")
+
+	// A rejected NooBaa system keeps the StorageCluster from being ready
+	for i := 0; i < 3; i++ {
+		_, err = s.harness.Round()
+		assert.NoError(t, err)
+		assert.NotEqual(t, statusutil.PhaseReady, s.storageCluster().Status.Phase)
+	}
+}
@@ -0,0 +1,89 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ensureDaemonResources publishes the effective resources of all daemons in
+// the status. The CephObjectStore and CephFilesystem are only created once,
+// so changes of the ResourceProfile or of the Resources overrides are carried
+// over to them here. The CephCluster and NooBaa system pick them up on their
+// own.
+func (r *ReconcileStorageCluster) ensureDaemonResources(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	sc.Status.EffectiveResources = newEffectiveResources(sc, getResourceProfile(sc))
+
+	if !sc.Spec.Components.DisableObjectStore {
+		store := &cephv1.CephObjectStore{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, store)
+		switch {
+		case err == nil:
+			resources := sc.Status.EffectiveResources["rgw"]
+			if !reflect.DeepEqual(resources, store.Spec.Gateway.Resources) {
+				reqLogger.Info(fmt.Sprintf("Updating gateway resources of CephObjectStore %s", store.Name))
+				store.Spec.Gateway.Resources = resources
+				err = r.client.Update(context.TODO(), store)
+				if err != nil {
+					return err
+				}
+			}
+		case !errors.IsNotFound(err):
+			return err
+		}
+	}
+
+	fs := &cephv1.CephFilesystem{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephFilesystem(sc), Namespace: sc.Namespace}, fs)
+	switch {
+	case err == nil:
+		resources := sc.Status.EffectiveResources["mds"]
+		if !reflect.DeepEqual(resources, fs.Spec.MetadataServer.Resources) {
+			reqLogger.Info(fmt.Sprintf("Updating metadata server resources of CephFilesystem %s", fs.Name))
+			fs.Spec.MetadataServer.Resources = resources
+			err = r.client.Update(context.TODO(), fs)
+			if err != nil {
+				return err
+			}
+		}
+	case !errors.IsNotFound(err):
+		return err
+	}
+
+	return nil
+}
+
+// newEffectiveResources returns the resources of every daemon deployed for
+// the StorageCluster with the given resource profile
+func newEffectiveResources(sc *ocsv1.StorageCluster, profile string) map[string]corev1.ResourceRequirements {
+	resources := newCephDaemonResources(profile, sc.Spec.Resources)
+	resources["mds"] = defaults.GetDaemonResources("mds", profile, sc.Spec.Resources)
+
+	if !sc.Spec.Components.DisableObjectStore {
+		resources["rgw"] = defaults.GetDaemonResources("rgw", profile, sc.Spec.Resources)
+	}
+
+	if !sc.Spec.Components.DisableMultiCloudGateway {
+		for _, name := range []string{"noobaa-core", "noobaa-db", "noobaa-db-vol"} {
+			resources[name] = defaults.GetDaemonResources(name, profile, sc.Spec.Resources)
+		}
+	}
+
+	for _, ds := range getStorageDeviceSets(sc) {
+		osdResources := ds.Resources
+		if osdResources.Requests == nil && osdResources.Limits == nil {
+			osdResources = defaults.GetDaemonResources("osd", profile, sc.Spec.Resources)
+		}
+		resources[fmt.Sprintf("osd-%s", ds.Name)] = osdResources
+	}
+
+	return resources
+}
@@ -0,0 +1,161 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestEffectiveResources(t *testing.T) {
+	override := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("250m"),
+		},
+	}
+
+	cases := []struct {
+		label      string
+		profile    string
+		custom     map[string]corev1.ResourceRequirements
+		components api.ComponentsSpec
+		expected   map[string]corev1.ResourceRequirements
+		absent     []string
+	}{
+		{
+			label:    "default profile is balanced",
+			expected: defaults.DaemonResources,
+		},
+		{
+			label:    "unknown profile falls back to balanced",
+			profile:  "huge",
+			expected: defaults.DaemonResources,
+		},
+		{
+			label:    "lean profile",
+			profile:  defaults.ResourceProfileLean,
+			expected: defaults.LeanDaemonResources,
+		},
+		{
+			label:    "performance profile",
+			profile:  defaults.ResourceProfilePerformance,
+			expected: defaults.PerformanceDaemonResources,
+		},
+		{
+			label:   "overrides win over the profile",
+			profile: defaults.ResourceProfileLean,
+			custom: map[string]corev1.ResourceRequirements{
+				"mon": override,
+				"osd": override,
+			},
+			expected: map[string]corev1.ResourceRequirements{
+				"mon": override,
+				"mgr": defaults.LeanDaemonResources["mgr"],
+				"osd": override,
+			},
+		},
+		{
+			label:   "disabled components are not listed",
+			profile: defaults.ResourceProfilePerformance,
+			components: api.ComponentsSpec{
+				DisableObjectStore:       true,
+				DisableMultiCloudGateway: true,
+			},
+			expected: map[string]corev1.ResourceRequirements{
+				"mds": defaults.PerformanceDaemonResources["mds"],
+			},
+			absent: []string{"rgw", "noobaa-core", "noobaa-db", "noobaa-db-vol"},
+		},
+	}
+
+	for _, c := range cases {
+		sc := mockStorageCluster.DeepCopy()
+		sc.Spec.ResourceProfile = c.profile
+		sc.Spec.Resources = c.custom
+		sc.Spec.Components = c.components
+		sc.Spec.StorageDeviceSets = []api.StorageDeviceSet{
+			{Name: "default"},
+			{Name: "custom", Resources: override},
+		}
+
+		actual := newEffectiveResources(sc, getResourceProfile(sc))
+		for name, expected := range c.expected {
+			if name == "osd" {
+				assert.Equal(t, expected, actual["osd-default"], c.label)
+				continue
+			}
+			assert.Equal(t, expected, actual[name], "%s: %s", c.label, name)
+		}
+		for _, name := range c.absent {
+			assert.NotContains(t, actual, name, c.label)
+		}
+		assert.Equal(t, override, actual["osd-custom"], c.label)
+	}
+}
+
+func TestEnsureDaemonResources(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ResourceProfile = defaults.ResourceProfileLean
+	store := &cephv1.CephObjectStore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephObjectStore(sc),
+			Namespace: sc.Namespace,
+		},
+		Spec: cephv1.ObjectStoreSpec{
+			Gateway: cephv1.GatewaySpec{
+				Resources: defaults.DaemonResources["rgw"],
+			},
+		},
+	}
+	fs := &cephv1.CephFilesystem{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephFilesystem(sc),
+			Namespace: sc.Namespace,
+		},
+		Spec: cephv1.FilesystemSpec{
+			MetadataServer: cephv1.MetadataServerSpec{
+				Resources: defaults.DaemonResources["mds"],
+			},
+		},
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, store, fs)
+
+	err := reconciler.ensureDaemonResources(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults.LeanDaemonResources["mon"], sc.Status.EffectiveResources["mon"])
+
+	foundStore := &cephv1.CephObjectStore{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: store.Name, Namespace: store.Namespace}, foundStore)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults.LeanDaemonResources["rgw"], foundStore.Spec.Gateway.Resources)
+
+	foundFs := &cephv1.CephFilesystem{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: fs.Name, Namespace: fs.Namespace}, foundFs)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults.LeanDaemonResources["mds"], foundFs.Spec.MetadataServer.Resources)
+}
+
+func TestGetDaemonResourcesCopies(t *testing.T) {
+	custom := map[string]corev1.ResourceRequirements{
+		"mgr": {Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")}},
+	}
+	balanced := defaults.DaemonResources["mds"].Requests[corev1.ResourceCPU]
+	lean := defaults.ProfileDaemonResources[defaults.ResourceProfileLean]["mds"].Requests[corev1.ResourceCPU]
+
+	for _, profile := range []string{"", defaults.ResourceProfileLean} {
+		for _, name := range []string{"mds", "mgr"} {
+			res := defaults.GetDaemonResources(name, profile, custom)
+			res.Requests[corev1.ResourceCPU] = resource.MustParse("42")
+		}
+	}
+	assert.Equal(t, balanced, defaults.DaemonResources["mds"].Requests[corev1.ResourceCPU])
+	assert.Equal(t, lean, defaults.ProfileDaemonResources[defaults.ResourceProfileLean]["mds"].Requests[corev1.ResourceCPU])
+	assert.Equal(t, resource.MustParse("250m"), custom["mgr"].Requests[corev1.ResourceCPU])
+}
@@ -0,0 +1,281 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// dedicatedNodeAnnotation lists the changes made to a node by
+	// ensureDedicatedNodes, so that they can be rolled back
+	dedicatedNodeAnnotation = "ocs.openshift.io/dedicated-node"
+	dedicatedNodeTaint      = "taint"
+	dedicatedNodeInfra      = "infra"
+
+	// infraNodeRoleLabel is the label of the nodes that run infrastructure
+	// workloads rather than user workloads
+	infraNodeRoleLabel = "node-role.kubernetes.io/infra"
+
+	// mirrorPodAnnotation is set on the API objects of static pods
+	mirrorPodAnnotation = "kubernetes.io/config.mirror"
+
+	// maxListedPods caps the number of pods named per node in the
+	// StorageNodesDedicated condition message
+	maxListedPods = 5
+)
+
+// storageNodeTaint reserves a storage node for the OCS daemons, which all
+// tolerate it
+var storageNodeTaint = corev1.Taint{
+	Key:    defaults.NodeTolerationKey,
+	Value:  "true",
+	Effect: corev1.TaintEffectNoSchedule,
+}
+
+// ensureDedicatedNodes taints the storage nodes and marks them as infra nodes
+// as asked for by the DedicatedNodes spec, and rolls back the changes that
+// are no longer asked for. A storage node is not tainted while pods that do
+// not tolerate the taint run on it, unless forced, since they would not be
+// scheduled on it again. The StorageNodesDedicated condition names these
+// pods. The deprecated NodeManagement.Taint is still honored for the managed
+// nodes.
+func (r *ReconcileStorageCluster) ensureDedicatedNodes(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	tainted := map[string]bool{}
+	if sc.Spec.ManageNodes && sc.Spec.NodeManagement.Taint {
+		for _, name := range sc.Status.ManagedNodes {
+			tainted[name] = true
+		}
+	}
+
+	blocked, err := r.dedicateNodes(sc, sc.Spec.DedicatedNodes, tainted, reqLogger)
+	if err != nil {
+		return err
+	}
+
+	if !sc.Spec.DedicatedNodes.Taint && !sc.Spec.DedicatedNodes.Infra && len(tainted) == 0 {
+		conditionsv1.RemoveStatusCondition(&sc.Status.Conditions, ocsv1.ConditionStorageNodesDedicated)
+		return nil
+	}
+
+	if len(blocked) > 0 {
+		nodeNames := []string{}
+		for name := range blocked {
+			nodeNames = append(nodeNames, name)
+		}
+		sort.Strings(nodeNames)
+		nodePods := []string{}
+		for _, name := range nodeNames {
+			pods := blocked[name]
+			if len(pods) > maxListedPods {
+				pods = append(pods[:maxListedPods:maxListedPods], fmt.Sprintf("and %d more", len(blocked[name])-maxListedPods))
+			}
+			nodePods = append(nodePods, fmt.Sprintf("%s (%s)", name, strings.Join(pods, ", ")))
+		}
+		message := fmt.Sprintf("Not tainting storage nodes running pods that do not tolerate the %s taint: %s. Move these pods to other nodes, or set force to taint the nodes anyway",
+			storageNodeTaint.Key, strings.Join(nodePods, "; "))
+		reqLogger.Info(message)
+		conditionsv1.SetStatusCondition(&sc.Status.Conditions, conditionsv1.Condition{
+			Type:    ocsv1.ConditionStorageNodesDedicated,
+			Status:  corev1.ConditionFalse,
+			Reason:  "NonStoragePodsRunning",
+			Message: message,
+		})
+		return nil
+	}
+
+	conditionsv1.SetStatusCondition(&sc.Status.Conditions, conditionsv1.Condition{
+		Type:    ocsv1.ConditionStorageNodesDedicated,
+		Status:  corev1.ConditionTrue,
+		Reason:  "StorageNodesDedicated",
+		Message: "All storage nodes are dedicated to OCS",
+	})
+	return nil
+}
+
+// dedicateNodes brings the taint and infra label of all nodes in line with
+// the given spec. Only the changes recorded in the dedicatedNodeAnnotation
+// are ever rolled back, and only once no other StorageCluster relies on
+// them. The tainted nodes are tainted as well, even if the spec does not ask
+// for it. It returns the pods, by node, that kept a storage node from being
+// tainted.
+func (r *ReconcileStorageCluster) dedicateNodes(sc *ocsv1.StorageCluster, spec ocsv1.DedicatedNodesSpec, tainted map[string]bool, reqLogger logr.Logger) (map[string][]string, error) {
+	defer r.locks.lock(nodesLockKey)()
+
+	nodes := &corev1.NodeList{}
+	err := r.client.List(context.TODO(), nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := map[string][]string{}
+
+	for _, node := range nodes.Items {
+		_, isStorageNode := node.Labels[defaults.NodeAffinityKey]
+		applied := getDedicatedNodeChanges(node)
+		newNode := node.DeepCopy()
+		changed := false
+
+		wantTaint := isStorageNode && (spec.Taint || tainted[node.Name])
+		hasTaint := false
+		for _, taint := range node.Spec.Taints {
+			if taint.MatchTaint(&storageNodeTaint) {
+				hasTaint = true
+			}
+		}
+		switch {
+		case wantTaint && !hasTaint:
+			if !spec.Force {
+				nodePods, err := r.getNodePods(node.Name)
+				if err != nil {
+					return nil, err
+				}
+				pods := getIntolerantPods(sc, nodePods)
+				if len(pods) > 0 {
+					blocked[node.Name] = pods
+					break
+				}
+			}
+			reqLogger.Info("Tainting storage node", "Node", node.Name, "Taint", storageNodeTaint.ToString())
+			newNode.Spec.Taints = append(newNode.Spec.Taints, storageNodeTaint)
+			applied[dedicatedNodeTaint] = true
+			changed = true
+		case !wantTaint && applied[dedicatedNodeTaint] && !hasOtherNodeChangeOwner(sc, node, dedicatedNodeTaint):
+			reqLogger.Info("Removing taint from node", "Node", node.Name, "Taint", storageNodeTaint.ToString())
+			newNode.Spec.Taints = []corev1.Taint{}
+			for _, taint := range node.Spec.Taints {
+				if !taint.MatchTaint(&storageNodeTaint) {
+					newNode.Spec.Taints = append(newNode.Spec.Taints, taint)
+				}
+			}
+			delete(applied, dedicatedNodeTaint)
+			changed = true
+		}
+
+		wantInfra := isStorageNode && spec.Infra
+		_, isInfra := node.Labels[infraNodeRoleLabel]
+		switch {
+		case wantInfra && !isInfra:
+			reqLogger.Info("Marking storage node as infra node", "Node", node.Name, "Label", infraNodeRoleLabel)
+			newNode.Labels[infraNodeRoleLabel] = ""
+			applied[dedicatedNodeInfra] = true
+			changed = true
+		case !wantInfra && applied[dedicatedNodeInfra] && !hasOtherNodeChangeOwner(sc, node, dedicatedNodeInfra):
+			reqLogger.Info("Removing infra node label from node", "Node", node.Name, "Label", infraNodeRoleLabel)
+			delete(newNode.Labels, infraNodeRoleLabel)
+			delete(applied, dedicatedNodeInfra)
+			changed = true
+		}
+
+		// The StorageCluster relies on the changes it wants that were
+		// made by the operator, whichever StorageCluster made them
+		if setNodeChangeOwner(sc, newNode, dedicatedNodeTaint, wantTaint && applied[dedicatedNodeTaint]) {
+			changed = true
+		}
+		if setNodeChangeOwner(sc, newNode, dedicatedNodeInfra, wantInfra && applied[dedicatedNodeInfra]) {
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+		setDedicatedNodeChanges(newNode, applied)
+		err = r.client.Update(context.TODO(), newNode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return blocked, nil
+}
+
+// getNodePods returns the pods of all namespaces scheduled on the node. The
+// cache only holds the namespaces watched by the manager, so they are read
+// from the apiserver.
+func (r *ReconcileStorageCluster) getNodePods(nodeName string) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	err := r.apiReader.List(context.TODO(), pods, client.MatchingField("spec.nodeName", nodeName))
+	if err != nil {
+		return nil, err
+	}
+
+	nodePods := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == nodeName {
+			nodePods = append(nodePods, pod)
+		}
+	}
+	return nodePods, nil
+}
+
+// getIntolerantPods returns the namespaced names of the running pods that
+// do not belong to the StorageCluster and do not tolerate the storage node
+// taint. Static pods are not scheduled, so they are left out.
+func getIntolerantPods(sc *ocsv1.StorageCluster, pods []corev1.Pod) []string {
+	names := []string{}
+	for _, pod := range pods {
+		if pod.Namespace == sc.Namespace ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if _, ok := pod.Annotations[mirrorPodAnnotation]; ok {
+			continue
+		}
+		tolerated := false
+		for _, toleration := range pod.Spec.Tolerations {
+			if toleration.ToleratesTaint(&storageNodeTaint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			names = append(names, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getDedicatedNodeChanges returns the changes recorded on the node
+func getDedicatedNodeChanges(node corev1.Node) map[string]bool {
+	applied := map[string]bool{}
+	for _, change := range strings.Split(node.Annotations[dedicatedNodeAnnotation], ",") {
+		if change != "" {
+			applied[change] = true
+		}
+	}
+	return applied
+}
+
+// setDedicatedNodeChanges records the given changes on the node
+func setDedicatedNodeChanges(node *corev1.Node, applied map[string]bool) {
+	changes := []string{}
+	for change := range applied {
+		changes = append(changes, change)
+	}
+	sort.Strings(changes)
+
+	if len(changes) == 0 {
+		delete(node.Annotations, dedicatedNodeAnnotation)
+		return
+	}
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[dedicatedNodeAnnotation] = strings.Join(changes, ",")
+}
+
+// deleteDedicatedNodeChanges rolls back the taints and infra labels added to
+// the nodes by ensureDedicatedNodes that no other StorageCluster relies on
+func (r *ReconcileStorageCluster) deleteDedicatedNodeChanges(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	_, err := r.dedicateNodes(sc, ocsv1.DedicatedNodesSpec{}, nil, reqLogger)
+	return err == nil, err
+}
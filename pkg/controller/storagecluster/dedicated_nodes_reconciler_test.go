@@ -0,0 +1,226 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newNodePod(name, namespace, node string, tolerations ...corev1.Toleration) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: corev1.PodSpec{
+			NodeName:    node,
+			Tolerations: tolerations,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+}
+
+func getTestNode(t *testing.T, reconciler ReconcileStorageCluster, name string) *corev1.Node {
+	node := &corev1.Node{}
+	err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: name}, node)
+	assert.NoError(t, err)
+	return node
+}
+
+func hasStorageNodeTaint(node *corev1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.MatchTaint(&storageNodeTaint) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEnsureDedicatedNodes(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.DedicatedNodes = api.DedicatedNodesSpec{Taint: true, Infra: true}
+
+	nodeList := mockNodeList.DeepCopy()
+	// node3 is already an infra node, which must be left alone
+	nodeList.Items[2].Labels[infraNodeRoleLabel] = ""
+	worker := newCandidateNode("worker1", "zone1")
+	objects := []runtime.Object{
+		sc, nodeList, worker,
+		// Pods of the StorageCluster, tolerating ones and static ones
+		// do not keep a node from being tainted
+		newNodePod("osd", sc.Namespace, "node1"),
+		newNodePod("dns", "openshift-dns", "node1", corev1.Toleration{Operator: corev1.TolerationOpExists}),
+		newNodePod("web", "default", "worker1"),
+		newNodePod("app", "default", "node2"),
+	}
+	static := newNodePod("etcd", "kube-system", "node3")
+	static.Annotations = map[string]string{mirrorPodAnnotation: "hash"}
+	objects = append(objects, static)
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	err := reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	node1 := getTestNode(t, reconciler, "node1")
+	assert.True(t, hasStorageNodeTaint(node1))
+	assert.Contains(t, node1.Labels, infraNodeRoleLabel)
+	assert.Equal(t, "infra,taint", node1.Annotations[dedicatedNodeAnnotation])
+
+	// node2 runs a pod that would not be scheduled on it again
+	node2 := getTestNode(t, reconciler, "node2")
+	assert.False(t, hasStorageNodeTaint(node2))
+	assert.Contains(t, node2.Labels, infraNodeRoleLabel)
+	assert.Equal(t, "infra", node2.Annotations[dedicatedNodeAnnotation])
+
+	node3 := getTestNode(t, reconciler, "node3")
+	assert.True(t, hasStorageNodeTaint(node3))
+	assert.Equal(t, "taint", node3.Annotations[dedicatedNodeAnnotation])
+
+	// worker1 is not a storage node
+	worker1 := getTestNode(t, reconciler, "worker1")
+	assert.Empty(t, worker1.Spec.Taints)
+	assert.NotContains(t, worker1.Labels, infraNodeRoleLabel)
+
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionStorageNodesDedicated)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, corev1.ConditionFalse, condition.Status)
+		assert.Equal(t, "NonStoragePodsRunning", condition.Reason)
+		assert.Contains(t, condition.Message, "node2 (default/app)")
+		assert.NotContains(t, condition.Message, "node1")
+	}
+
+	// Forcing taints node2 anyway
+	sc.Spec.DedicatedNodes.Force = true
+	err = reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, hasStorageNodeTaint(getTestNode(t, reconciler, "node2")))
+	condition = conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionStorageNodesDedicated)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, corev1.ConditionTrue, condition.Status)
+	}
+
+	// Turning the options off rolls back only what was changed
+	sc.Spec.DedicatedNodes = api.DedicatedNodesSpec{}
+	err = reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	for _, name := range []string{"node1", "node2", "node3"} {
+		node := getTestNode(t, reconciler, name)
+		assert.False(t, hasStorageNodeTaint(node), name)
+		assert.NotContains(t, node.Annotations, dedicatedNodeAnnotation, name)
+		assert.Contains(t, node.Labels, defaults.NodeAffinityKey, name)
+	}
+	assert.NotContains(t, getTestNode(t, reconciler, "node1").Labels, infraNodeRoleLabel)
+	assert.Contains(t, getTestNode(t, reconciler, "node3").Labels, infraNodeRoleLabel)
+	assert.Nil(t, conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionStorageNodesDedicated))
+}
+
+func TestEnsureDedicatedNodesUncachedPods(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.DedicatedNodes = api.DedicatedNodesSpec{Taint: true}
+	reconciler := createFakeStorageClusterReconciler(t, sc, mockNodeList.DeepCopy())
+	// The pod runs in a namespace the cache does not watch
+	reconciler.apiReader = fake.NewFakeClientWithScheme(reconciler.scheme, newNodePod("app", "other-ns", "node1"))
+
+	err := reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.False(t, hasStorageNodeTaint(getTestNode(t, reconciler, "node1")))
+	assert.True(t, hasStorageNodeTaint(getTestNode(t, reconciler, "node2")))
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionStorageNodesDedicated)
+	if assert.NotNil(t, condition) {
+		assert.Contains(t, condition.Message, "node1 (other-ns/app)")
+	}
+}
+
+func TestDeleteDedicatedNodeChanges(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.DedicatedNodes = api.DedicatedNodesSpec{Taint: true}
+	nodeList := mockNodeList.DeepCopy()
+	// An admin taint on the node is kept
+	adminTaint := corev1.Taint{Key: "admin", Effect: corev1.TaintEffectPreferNoSchedule}
+	nodeList.Items[0].Spec.Taints = []corev1.Taint{adminTaint}
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+
+	err := reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Len(t, getTestNode(t, reconciler, "node1").Spec.Taints, 2)
+
+	done, err := reconciler.deleteDedicatedNodeChanges(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []corev1.Taint{adminTaint}, getTestNode(t, reconciler, "node1").Spec.Taints)
+	assert.False(t, hasStorageNodeTaint(getTestNode(t, reconciler, "node2")))
+}
+
+func TestEnsureDedicatedNodesDeprecatedTaint(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ManageNodes = true
+	sc.Spec.NodeManagement.Taint = true
+	sc.Status.ManagedNodes = []string{"node1"}
+	reconciler := createFakeStorageClusterReconciler(t, sc, mockNodeList.DeepCopy())
+
+	// Only the managed nodes are tainted
+	err := reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, hasStorageNodeTaint(getTestNode(t, reconciler, "node1")))
+	assert.False(t, hasStorageNodeTaint(getTestNode(t, reconciler, "node2")))
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionStorageNodesDedicated)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, corev1.ConditionTrue, condition.Status)
+	}
+
+	// Turning it off removes the taint again
+	sc.Spec.NodeManagement.Taint = false
+	err = reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.False(t, hasStorageNodeTaint(getTestNode(t, reconciler, "node1")))
+	assert.Nil(t, conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionStorageNodesDedicated))
+}
+
+func TestDedicatedNodesSharedWithOtherStorageCluster(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.DedicatedNodes = api.DedicatedNodesSpec{Taint: true, Infra: true}
+	other := newNamespacedStorageCluster(sc.Name, "other-ns")
+	other.Spec.DedicatedNodes = api.DedicatedNodesSpec{Taint: true}
+	reconciler := createFakeStorageClusterReconciler(t, sc, other, mockNodeList.DeepCopy())
+
+	err := reconciler.ensureDedicatedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.ensureDedicatedNodes(other, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	// The other StorageCluster does not want the infra label, but it was
+	// not the one asking for it
+	node1 := getTestNode(t, reconciler, "node1")
+	assert.True(t, hasStorageNodeTaint(node1))
+	assert.Contains(t, node1.Labels, infraNodeRoleLabel)
+
+	// The taint stays as long as the other StorageCluster wants it
+	done, err := reconciler.deleteDedicatedNodeChanges(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	node1 = getTestNode(t, reconciler, "node1")
+	assert.True(t, hasStorageNodeTaint(node1))
+	assert.NotContains(t, node1.Labels, infraNodeRoleLabel)
+	assert.Equal(t, "taint", node1.Annotations[dedicatedNodeAnnotation])
+
+	done, err = reconciler.deleteDedicatedNodeChanges(other, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	for _, name := range []string{"node1", "node2", "node3"} {
+		node := getTestNode(t, reconciler, name)
+		assert.False(t, hasStorageNodeTaint(node), name)
+		assert.NotContains(t, node.Annotations, dedicatedNodeAnnotation, name)
+		assert.NotContains(t, node.Annotations, storageClusterAnnotation, name)
+	}
+}
@@ -0,0 +1,181 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/placement"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// crushFailureDomains are the CRUSH bucket types Rook places OSDs in
+var crushFailureDomains = []string{
+	"host",
+	"chassis",
+	"rack",
+	"row",
+	"pdu",
+	"pod",
+	"room",
+	"datacenter",
+	"zone",
+	"region",
+}
+
+// getSpecFailureDomain returns the failure domain asked for by the spec and
+// the node label holding it. The label is empty if the spec does not name
+// one, in which case it is found among the topology keys.
+func getSpecFailureDomain(sc *ocsv1.StorageCluster) (string, string) {
+	failureDomain := sc.Spec.FailureDomain
+	switch {
+	case failureDomain == "host" || failureDomain == corev1.LabelHostname:
+		return "host", corev1.LabelHostname
+	case strings.Contains(failureDomain, "/"):
+		return placement.KeyType(failureDomain), failureDomain
+	}
+	return failureDomain, ""
+}
+
+// validateFailureDomain checks that the FailureDomain of the spec stands for
+// a CRUSH bucket type
+func validateFailureDomain(sc *ocsv1.StorageCluster) error {
+	if sc.Spec.FailureDomain == "" {
+		return nil
+	}
+	failureDomain, _ := getSpecFailureDomain(sc)
+	for _, crushFailureDomain := range crushFailureDomains {
+		if failureDomain == crushFailureDomain {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid failure domain %q: expected one of %s, or a node label ending in one of them",
+		sc.Spec.FailureDomain, strings.Join(crushFailureDomains, ", "))
+}
+
+// ensureFailureDomain applies a change of the FailureDomain in the spec to a
+// StorageCluster whose failure domain is already set. The change is applied
+// right away if no OSDs were deployed yet. Otherwise only the node label
+// holding the failure domain may change, and only if it groups the storage
+// nodes exactly like the current one, so that no OSD has to move. The
+// FailureDomainChangeBlocked condition tells why any other change is not
+// applied.
+func (r *ReconcileStorageCluster) ensureFailureDomain(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	err := validateFailureDomain(sc)
+	if err != nil {
+		return err
+	}
+
+	failureDomain, failureDomainKey := getSpecFailureDomain(sc)
+	if sc.Spec.FailureDomain == "" || sc.Status.FailureDomain == "" ||
+		(failureDomain == sc.Status.FailureDomain && (failureDomainKey == "" || failureDomainKey == sc.Status.FailureDomainKey)) {
+		conditionsv1.RemoveStatusCondition(&sc.Status.Conditions, ocsv1.ConditionFailureDomainChangeBlocked)
+		return nil
+	}
+	if failureDomainKey == "" {
+		failureDomainKey = placement.FindKey(sc.Status.NodeTopologies, getTopologyKeys(sc), failureDomain)
+	}
+
+	cephCluster := &cephv1.CephCluster{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, cephCluster)
+	switch {
+	case errors.IsNotFound(err):
+		// Nothing was placed by the current failure domain yet, so the
+		// pools are created again with the new one
+		sc.Status.CephBlockPoolsCreated = false
+		sc.Status.CephFilesystemsCreated = false
+		sc.Status.CephObjectStoresCreated = false
+	case err != nil:
+		return err
+	default:
+		message, err := r.getFailureDomainChangeBlocker(sc, failureDomain, failureDomainKey)
+		if err != nil {
+			return err
+		}
+		if message != "" {
+			reqLogger.Info(message)
+			conditionsv1.SetStatusCondition(&sc.Status.Conditions, conditionsv1.Condition{
+				Type:    ocsv1.ConditionFailureDomainChangeBlocked,
+				Status:  corev1.ConditionTrue,
+				Reason:  "OSDsWouldMove",
+				Message: message,
+			})
+			return nil
+		}
+	}
+
+	reqLogger.Info("Changing failure domain", "FailureDomain", failureDomain, "Label", failureDomainKey,
+		"PreviousFailureDomain", sc.Status.FailureDomain, "PreviousLabel", sc.Status.FailureDomainKey)
+	sc.Status.FailureDomain = failureDomain
+	sc.Status.FailureDomainKey = failureDomainKey
+	conditionsv1.RemoveStatusCondition(&sc.Status.Conditions, ocsv1.ConditionFailureDomainChangeBlocked)
+	return nil
+}
+
+// getFailureDomainChangeBlocker returns why the failure domain of a deployed
+// StorageCluster cannot be changed to the given one, or an empty string if
+// it can. When it can, the values of the new label in the NodeTopologies are
+// put in the order of the matching values of the current label, so that each
+// device set stays in its failure domain.
+func (r *ReconcileStorageCluster) getFailureDomainChangeBlocker(sc *ocsv1.StorageCluster, failureDomain, failureDomainKey string) (string, error) {
+	current := sc.Status.FailureDomain
+	currentKey := sc.Status.FailureDomainKey
+	if failureDomain != current {
+		return fmt.Sprintf("Not changing the failure domain from %s to %s, since the data of the deployed OSDs would have to move", current, failureDomain), nil
+	}
+	if currentKey == "" || failureDomainKey == "" {
+		return fmt.Sprintf("Not changing the label of the %s failure domain, since it is not found on the storage nodes", failureDomain), nil
+	}
+
+	nodes, err := r.getStorageNodes()
+	if err != nil {
+		return "", err
+	}
+
+	// The labels must map one to one onto each other across all storage
+	// nodes
+	toNew := map[string]string{}
+	toCurrent := map[string]string{}
+	for _, node := range nodes.Items {
+		value, ok := node.Labels[currentKey]
+		newValue, newOk := node.Labels[failureDomainKey]
+		if !ok || !newOk {
+			return fmt.Sprintf("Not changing the label of the %s failure domain from %s to %s, since storage node %s does not have both labels",
+				failureDomain, currentKey, failureDomainKey, node.Name), nil
+		}
+		if v, ok := toNew[value]; ok && v != newValue {
+			return fmt.Sprintf("Not changing the label of the %s failure domain from %s to %s, since it splits %s %s",
+				failureDomain, currentKey, failureDomainKey, failureDomain, value), nil
+		}
+		if v, ok := toCurrent[newValue]; ok && v != value {
+			return fmt.Sprintf("Not changing the label of the %s failure domain from %s to %s, since it merges %s %s and %s",
+				failureDomain, currentKey, failureDomainKey, failureDomain, v, value), nil
+		}
+		toNew[value] = newValue
+		toCurrent[newValue] = value
+	}
+
+	topologyMap := sc.Status.NodeTopologies
+	if topologyMap == nil {
+		return "", nil
+	}
+	values := ocsv1.TopologyLabelValues{}
+	for _, value := range topologyMap.Labels[currentKey] {
+		if newValue, ok := toNew[value]; ok {
+			values = append(values, newValue)
+		}
+	}
+	for _, value := range topologyMap.Labels[failureDomainKey] {
+		if _, ok := toCurrent[value]; !ok {
+			values = append(values, value)
+		}
+	}
+	topologyMap.Labels[failureDomainKey] = values
+	return "", nil
+}
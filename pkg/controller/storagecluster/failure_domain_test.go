@@ -0,0 +1,190 @@
+package storagecluster
+
+import (
+	"testing"
+
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newMockCephCluster(sc *api.StorageCluster) *cephv1.CephCluster {
+	return &cephv1.CephCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephCluster(sc),
+			Namespace: sc.Namespace,
+		},
+	}
+}
+
+func TestGetSpecFailureDomain(t *testing.T) {
+	cases := []struct {
+		spec          string
+		failureDomain string
+		key           string
+		valid         bool
+	}{
+		{"", "", "", true},
+		{"host", "host", corev1.LabelHostname, true},
+		{corev1.LabelHostname, "host", corev1.LabelHostname, true},
+		{"rack", "rack", "", true},
+		{"zone", "zone", "", true},
+		{"example.com/rack", "rack", "example.com/rack", true},
+		{"example.com/building", "building", "example.com/building", false},
+		{"shelf", "shelf", "", false},
+	}
+
+	for _, c := range cases {
+		sc := mockStorageCluster.DeepCopy()
+		sc.Spec.FailureDomain = c.spec
+		failureDomain, key := getSpecFailureDomain(sc)
+		assert.Equal(t, c.failureDomain, failureDomain, c.spec)
+		assert.Equal(t, c.key, key, c.spec)
+		if c.valid {
+			assert.NoError(t, validateFailureDomain(sc), c.spec)
+		} else {
+			assert.Error(t, validateFailureDomain(sc), c.spec)
+		}
+	}
+}
+
+func TestHostFailureDomain(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.FailureDomain = "host"
+	sc.Spec.StorageDeviceSets = mockDeviceSets
+	nodeList := mockNodeList.DeepCopy()
+	nodeList.Items[2].Labels[zoneTopologyLabel] = "zone2"
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	// The nodes are not labeled with racks
+	assert.NotContains(t, sc.Status.NodeTopologies.Labels, defaults.RackTopologyKey)
+	assertStorageNodes(t, reconciler, []string{"node1", "node2", "node3"})
+	for _, storageNode := range sc.Status.StorageNodes {
+		assert.NotContains(t, storageNode.Labels, defaults.RackTopologyKey)
+	}
+
+	assert.Equal(t, "host", determineFailureDomain(sc))
+	assert.Equal(t, corev1.LabelHostname, determineFailureDomainKey(sc))
+	sc.Status.FailureDomain = determineFailureDomain(sc)
+	sc.Status.FailureDomainKey = determineFailureDomainKey(sc)
+
+	// The OSDs are spread across hosts without being pinned to any
+	for _, scds := range newStorageClassDeviceSets(sc) {
+		assert.False(t, scds.Portable)
+		assert.Equal(t, defaults.DaemonPlacements["osd"], scds.Placement)
+	}
+}
+
+func TestEnsureFailureDomainBeforeDeployment(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.FailureDomain = "host"
+	sc.Status.FailureDomain = "rack"
+	sc.Status.FailureDomainKey = defaults.RackTopologyKey
+	sc.Status.CephBlockPoolsCreated = true
+	sc.Status.CephFilesystemsCreated = true
+	sc.Status.CephObjectStoresCreated = true
+
+	reconciler := createFakeStorageClusterReconciler(t, sc)
+	err := reconciler.ensureFailureDomain(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	// Without OSDs the failure domain is changed, and the pools follow
+	assert.Equal(t, "host", sc.Status.FailureDomain)
+	assert.Equal(t, corev1.LabelHostname, sc.Status.FailureDomainKey)
+	assert.False(t, sc.Status.CephBlockPoolsCreated)
+	assert.False(t, sc.Status.CephFilesystemsCreated)
+	assert.False(t, sc.Status.CephObjectStoresCreated)
+	assert.Nil(t, conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionFailureDomainChangeBlocked))
+}
+
+func TestEnsureFailureDomainBlocked(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.FailureDomain = "host"
+	sc.Status.FailureDomain = "zone"
+	sc.Status.FailureDomainKey = zoneTopologyLabel
+	sc.Status.CephBlockPoolsCreated = true
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, newMockCephCluster(sc), mockNodeList.DeepCopy())
+	err := reconciler.ensureFailureDomain(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "zone", sc.Status.FailureDomain)
+	assert.Equal(t, zoneTopologyLabel, sc.Status.FailureDomainKey)
+	assert.True(t, sc.Status.CephBlockPoolsCreated)
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionFailureDomainChangeBlocked)
+	assert.NotNil(t, condition)
+	assert.Equal(t, corev1.ConditionTrue, condition.Status)
+
+	// The condition goes away when the spec is reverted
+	sc.Spec.FailureDomain = "zone"
+	err = reconciler.ensureFailureDomain(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Nil(t, conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionFailureDomainChangeBlocked))
+}
+
+func TestEnsureFailureDomainChangeLabel(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.FailureDomain = "example.com/zone"
+	sc.Status.FailureDomain = "zone"
+	sc.Status.FailureDomainKey = zoneTopologyLabel
+	nodeList := mockNodeList.DeepCopy()
+	for i, zone := range []string{"c", "b", "a"} {
+		nodeList.Items[i].Labels["example.com/zone"] = zone
+	}
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, newMockCephCluster(sc), nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	sc.Status.NodeTopologies.Labels["example.com/zone"] = api.TopologyLabelValues{"a", "b", "c"}
+
+	// A label grouping the nodes the same way is switched to, in the
+	// order of the current one
+	err = reconciler.ensureFailureDomain(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "zone", sc.Status.FailureDomain)
+	assert.Equal(t, "example.com/zone", sc.Status.FailureDomainKey)
+	assert.Equal(t, api.TopologyLabelValues{"c", "b", "a"}, sc.Status.NodeTopologies.Labels["example.com/zone"])
+
+	sc.Spec.StorageDeviceSets = mockDeviceSets
+	for i, scds := range newStorageClassDeviceSets(sc) {
+		matchExpressions := scds.Placement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
+		assert.Equal(t, "example.com/zone", matchExpressions[1].Key)
+		assert.Equal(t, nodeList.Items[i].Labels["example.com/zone"], matchExpressions[1].Values[0])
+	}
+}
+
+func TestEnsureFailureDomainChangeLabelBlocked(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.FailureDomain = "example.com/zone"
+	sc.Status.FailureDomain = "zone"
+	sc.Status.FailureDomainKey = zoneTopologyLabel
+	nodeList := mockNodeList.DeepCopy()
+	for i, zone := range []string{"a", "a", "b"} {
+		nodeList.Items[i].Labels["example.com/zone"] = zone
+	}
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, newMockCephCluster(sc), nodeList)
+	err := reconciler.ensureFailureDomain(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	assert.Equal(t, zoneTopologyLabel, sc.Status.FailureDomainKey)
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionFailureDomainChangeBlocked)
+	assert.NotNil(t, condition)
+	assert.Contains(t, condition.Message, "merges")
+}
+
+func TestEnsureFailureDomainInvalid(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.FailureDomain = "shelf"
+
+	reconciler := createFakeStorageClusterReconciler(t, sc)
+	err := reconciler.ensureFailureDomain(sc, reconciler.reqLogger)
+	assert.Error(t, err)
+}
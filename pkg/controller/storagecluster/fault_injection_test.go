@@ -0,0 +1,198 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/fault"
+	"github.com/openshift/ocs-operator/pkg/controller/simulator"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newFaultSimulation returns a simulation in which the calls of the
+// reconciler go through a client injecting the faults of the scenario. The
+// simulated operators see the cluster as it is.
+func newFaultSimulation(t *testing.T, scenario fault.Scenario) (*simulation, *fault.Client) {
+	s := newSimulation(t, simulator.CephClusterCreated, simulator.NooBaaReady)
+	reconciler := s.harness.Reconciler.(*ReconcileStorageCluster)
+	faults := fault.NewClient(reconciler.client, reconciler.scheme, scenario)
+	reconciler.client = faults
+	return s, faults
+}
+
+// assertStatusConsistent checks that the phase of the StorageCluster agrees
+// with its conditions
+func assertStatusConsistent(t *testing.T, sc *api.StorageCluster) {
+	conditions := sc.Status.Conditions
+	if sc.Status.Phase != "" {
+		assert.NotEmpty(t, conditions, "phase %s without conditions", sc.Status.Phase)
+	}
+	switch sc.Status.Phase {
+	case statusutil.PhaseReady:
+		assert.True(t, conditionsv1.IsStatusConditionTrue(conditions, api.ConditionReconcileComplete), "Ready without ReconcileComplete: %v", conditions)
+		assert.True(t, conditionsv1.IsStatusConditionTrue(conditions, conditionsv1.ConditionAvailable), "Ready without Available: %v", conditions)
+		assert.False(t, conditionsv1.IsStatusConditionTrue(conditions, conditionsv1.ConditionDegraded), "Ready and Degraded: %v", conditions)
+	case statusutil.PhaseError:
+		assert.True(t, conditionsv1.IsStatusConditionFalse(conditions, api.ConditionReconcileComplete) ||
+			conditionsv1.IsStatusConditionFalse(conditions, conditionsv1.ConditionAvailable) ||
+			conditionsv1.IsStatusConditionTrue(conditions, conditionsv1.ConditionDegraded),
+			"Error without a failed condition: %v", conditions)
+	}
+}
+
+// runUntilConverged plays rounds, checking the status after each of them,
+// until the faults are used up and the StorageCluster is ready with its
+// CephCluster and NooBaa system up
+func (s *simulation) runUntilConverged(rounds int, faults *fault.Client) {
+	err := s.harness.RunUntil(rounds, func() (bool, error) {
+		sc := s.storageCluster()
+		s.phases = append(s.phases, sc.Status.Phase)
+		assertStatusConsistent(s.t, sc)
+
+		noobaa := &nbv1.NooBaa{}
+		err := s.client.Get(context.TODO(), types.NamespacedName{Name: "noobaa", Namespace: sc.Namespace}, noobaa)
+		return faults.Done() && err == nil &&
+			sc.Status.Phase == statusutil.PhaseReady &&
+			s.cephClusterState() == cephv1.ClusterStateCreated, nil
+	})
+	assert.NoError(s.t, err, "phases went through: %v", s.phases)
+}
+
+func TestFaultInjectionConverges(t *testing.T) {
+	cases := []struct {
+		label    string
+		scenario fault.Scenario
+	}{
+		{
+			label: "create errors",
+			scenario: fault.Scenario{
+				{Verb: fault.Create, Kind: "ConfigMap", Type: fault.Error, Times: 2},
+				{Verb: fault.Create, Kind: "CephCluster", Type: fault.Error, Times: 2},
+				{Verb: fault.Create, Kind: "CephBlockPool", Type: fault.Error, Times: 1},
+				{Verb: fault.Create, Kind: "StorageClass", Type: fault.Error, Times: 1},
+				{Verb: fault.Create, Kind: "NooBaa", Type: fault.Error, Times: 2},
+			},
+		},
+		{
+			label: "get errors",
+			scenario: fault.Scenario{
+				{Verb: fault.Get, Type: fault.Error, After: 3, Times: 3},
+				{Verb: fault.Get, Kind: "CephCluster", Type: fault.Error, After: 4, Times: 2},
+				{Verb: fault.List, Kind: "Node", Type: fault.Error, Times: 1},
+			},
+		},
+		{
+			label: "conflicts",
+			scenario: fault.Scenario{
+				{Verb: fault.StatusPatch, Kind: "StorageCluster", Type: fault.Conflict, After: 1, Times: 4},
+				{Verb: fault.Update, Kind: "StorageCluster", Type: fault.Conflict, Times: 2},
+			},
+		},
+		{
+			label: "stale reads",
+			scenario: fault.Scenario{
+				{Verb: fault.Get, Kind: "StorageCluster", Type: fault.StaleRead, After: 2, Times: 3},
+				{Verb: fault.Get, Kind: "CephCluster", Type: fault.StaleRead, After: 2, Times: 3},
+				{Verb: fault.Get, Kind: "NooBaa", Type: fault.StaleRead, After: 1, Times: 2},
+			},
+		},
+		{
+			label: "latency",
+			scenario: fault.Scenario{
+				{Type: fault.Latency, Latency: time.Millisecond, Times: 20},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.label, func(t *testing.T) {
+			s, faults := newFaultSimulation(t, c.scenario)
+			s.runUntilConverged(40, faults)
+			assert.NotZero(t, faults.Injected())
+
+			// Once converged, it stays ready
+			for i := 0; i < 3; i++ {
+				_, err := s.harness.Round()
+				assert.NoError(t, err)
+				sc := s.storageCluster()
+				assert.Equal(t, statusutil.PhaseReady, sc.Status.Phase)
+				assertStatusConsistent(t, sc)
+			}
+		})
+	}
+}
+
+func TestFaultInjectionStatusOnFailure(t *testing.T) {
+	// The CephCluster cannot be created for a while
+	s, faults := newFaultSimulation(t, fault.Scenario{
+		{Verb: fault.Create, Kind: "CephCluster", Type: fault.Error, Times: 3},
+	})
+
+	for i := 0; i < 3; i++ {
+		_, err := s.harness.Round()
+		assert.Error(t, err)
+		sc := s.storageCluster()
+		assert.Equal(t, statusutil.PhaseError, sc.Status.Phase)
+		assertStatusConsistent(t, sc)
+		s.assertCondition(api.ConditionReconcileComplete, corev1.ConditionFalse, api.ReconcileFailed)
+	}
+
+	// The steps waiting on the CephCluster are held back, the others are not
+	results := map[string]api.ReconcileStepResult{}
+	for _, step := range s.storageCluster().Status.ReconcileSteps {
+		results[step.Name] = step.Result
+	}
+	assert.Equal(t, api.ReconcileStepFailed, results["cephCluster"])
+	assert.Equal(t, api.ReconcileStepBlocked, results["noobaaSystem"])
+	assert.Equal(t, api.ReconcileStepBlocked, results["multiCloudGateway"])
+	assert.Equal(t, api.ReconcileStepSucceeded, results["cephBlockPools"])
+
+	s.runUntilConverged(20, faults)
+	s.assertCondition(api.ConditionReconcileComplete, corev1.ConditionTrue, api.ReconcileCompleted)
+}
+
+func TestFaultInjectionStatusWrittenOnce(t *testing.T) {
+	// The status patches are counted as faults, and any status update fails
+	s, faults := newFaultSimulation(t, fault.Scenario{
+		{Verb: fault.StatusPatch, Kind: "StorageCluster", Type: fault.Latency},
+		{Verb: fault.StatusUpdate, Kind: "StorageCluster", Type: fault.Error},
+	})
+
+	sc := s.storageCluster()
+	sc.Generation = 3
+	err := s.client.Update(context.TODO(), sc)
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		before := faults.Injected()
+		_, _ = s.harness.Round()
+		assert.True(t, faults.Injected()-before <= 1, "status written %d times in round %d", faults.Injected()-before, i)
+
+		sc := s.storageCluster()
+		s.phases = append(s.phases, sc.Status.Phase)
+		assert.Equal(t, int64(3), sc.Status.ObservedGeneration)
+		assertStatusConsistent(t, sc)
+	}
+	assert.Equal(t, statusutil.PhaseReady, s.storageCluster().Status.Phase, "phases went through: %v", s.phases)
+}
+
+func TestFaultInjectionStatusConflictRetried(t *testing.T) {
+	s, faults := newFaultSimulation(t, fault.Scenario{
+		{Verb: fault.StatusPatch, Kind: "StorageCluster", Type: fault.Conflict, Times: 3},
+	})
+
+	_, _ = s.harness.Round()
+	assert.True(t, faults.Done())
+	sc := s.storageCluster()
+	assert.NotEmpty(t, sc.Status.Phase)
+	assert.NotEmpty(t, sc.Status.ReconcileSteps)
+	assertStatusConsistent(t, sc)
+}
@@ -2,6 +2,8 @@ package storagecluster
 
 import (
 	"fmt"
+	"hash/fnv"
+	"strings"
 
 	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 )
@@ -37,3 +39,64 @@ func generateNameForCephFilesystemSC(initData *ocsv1.StorageCluster) string {
 func generateNameForCephBlockPoolSC(initData *ocsv1.StorageCluster) string {
 	return fmt.Sprintf("%s-ceph-rbd", initData.Name)
 }
+
+func generateNameForCephRgwSC(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s-ceph-rgw", initData.Name)
+}
+
+func generateNameForCephFilesystemVSC(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s-cephfsplugin-snapclass", initData.Name)
+}
+
+func generateNameForCephBlockPoolVSC(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s-rbdplugin-snapclass", initData.Name)
+}
+
+// generateNameForNamespacedClusterScoped returns the name a cluster-scoped
+// object is created under when its usual name is taken by the object of a
+// StorageCluster of the same name in another namespace
+func generateNameForNamespacedClusterScoped(initData *ocsv1.StorageCluster, name string) string {
+	return fmt.Sprintf("%s-%s", initData.Namespace, name)
+}
+
+func generateNameForNooBaaOBCSC(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s.noobaa.io", initData.Namespace)
+}
+
+// generateNameForCleanupJob returns the name of the cleanup Job for a node.
+// Names that would not fit in a label value are shortened with a hash.
+func generateNameForCleanupJob(initData *ocsv1.StorageCluster, nodeName string) string {
+	name := fmt.Sprintf("%s-cleanup-%s", initData.Name, nodeName)
+	if len(name) <= 63 {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("%s-%08x", strings.TrimRight(name[:54], "-."), h.Sum32())
+}
+
+// generateNameForCleanupServiceAccount returns the name of the
+// ServiceAccount the cleanup Jobs run with
+func generateNameForCleanupServiceAccount(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s-cleanup", initData.Name)
+}
+
+// generateNameForCephRgwService returns the name of the Service Rook creates
+// for the gateway of the object store
+func generateNameForCephRgwService(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("rook-ceph-rgw-%s", generateNameForCephObjectStore(initData))
+}
+
+func generateNameForCephRgwServingCertSecret(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s-cephobjectstore-serving-cert", initData.Name)
+}
+
+func generateNameForCephRgwCertSecret(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s-cephobjectstore-cert", initData.Name)
+}
+
+// generateNameForReconcilePlan returns the name of the ConfigMap holding the
+// plan of a StorageCluster whose ReconcileStrategy is Plan
+func generateNameForReconcilePlan(initData *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("%s-reconcile-plan", initData.Name)
+}
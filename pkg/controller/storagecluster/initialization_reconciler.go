@@ -5,14 +5,19 @@ import (
 	"fmt"
 
 	"github.com/go-logr/logr"
+	objectreferencesv1 "github.com/openshift/custom-resource-status/objectreferences/v1"
 	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 	"github.com/openshift/ocs-operator/pkg/controller/defaults"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/reference"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -29,46 +34,163 @@ func (r *ReconcileStorageCluster) ensureStorageClasses(instance *ocsv1.StorageCl
 		return err
 	}
 	for _, sc := range scs {
-		existing := storagev1.StorageClass{}
-		err = r.client.Get(context.TODO(), types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace}, &existing)
+		err = r.ensureStorageClass(instance, sc, reqLogger)
+		if err != nil {
+			return err
+		}
 
-		switch {
-		case err == nil:
-			if existing.DeletionTimestamp != nil {
-				reqLogger.Info(fmt.Sprintf("Unable to restore init object because %s is marked for deletion", existing.Name))
-				return fmt.Errorf("failed to restore initialization object %s because it is marked for deletion", existing.Name)
-			}
+		// Typed objects lose their TypeMeta when written, and GetReference
+		// needs it when there is no selfLink to fall back to
+		sc.SetGroupVersionKind(storagev1.SchemeGroupVersion.WithKind("StorageClass"))
+		objectRef, err := reference.GetReference(r.scheme, sc)
+		if err != nil {
+			return err
+		}
+		objectreferencesv1.SetObjectReference(&instance.Status.RelatedObjects, *objectRef)
+	}
 
-			reqLogger.Info(fmt.Sprintf("Restoring original StorageClass %s", sc.Name))
-			existing.ObjectMeta.OwnerReferences = sc.ObjectMeta.OwnerReferences
-			sc.ObjectMeta = existing.ObjectMeta
+	instance.Status.StorageClassesCreated = true
 
-			err = r.client.Update(context.TODO(), sc)
-			if err != nil {
-				return err
-			}
-		case errors.IsNotFound(err):
-			reqLogger.Info(fmt.Sprintf("Creating StorageClass %s", sc.Name))
-			err = r.client.Create(context.TODO(), sc)
-			if err != nil {
-				return err
-			}
+	return nil
+}
+
+// ensureStorageClass creates the StorageClass, or restores it if it was
+// created for the StorageCluster already
+func (r *ReconcileStorageCluster) ensureStorageClass(instance *ocsv1.StorageCluster, sc *storagev1.StorageClass, reqLogger logr.Logger) error {
+	defer r.locks.lock(clusterScopedLockKey("StorageClass", sc.Name))()
+
+	existing := storagev1.StorageClass{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace}, &existing)
+
+	switch {
+	case err == nil:
+		if existing.DeletionTimestamp != nil {
+			reqLogger.Info(fmt.Sprintf("Unable to restore init object because %s is marked for deletion", existing.Name))
+			return fmt.Errorf("failed to restore initialization object %s because it is marked for deletion", existing.Name)
+		}
+		err = checkClusterScopedOwner(instance, "StorageClass", &existing)
+		if err != nil {
+			return err
 		}
+
+		reqLogger.Info(fmt.Sprintf("Restoring original StorageClass %s", sc.Name))
+		existing.ObjectMeta.OwnerReferences = sc.ObjectMeta.OwnerReferences
+		sc.ObjectMeta = existing.ObjectMeta
+		setClusterScopedOwner(instance, sc)
+
+		return r.client.Update(context.TODO(), sc)
+	case errors.IsNotFound(err):
+		reqLogger.Info(fmt.Sprintf("Creating StorageClass %s", sc.Name))
+		return r.client.Create(context.TODO(), sc)
 	}
+	return err
+}
 
-	instance.Status.StorageClassesCreated = true
+// ensureBucketStorageClasses ensures that the bucket StorageClass of the
+// Ceph object store exists while the object store is enabled, and removes it
+// once the object store is disabled. It has its own status flag, so that
+// StorageClusters deployed before it existed get it too. The bucket
+// StorageClass of the multi-cloud gateway is created and reconciled by the
+// NooBaa operator, so it is only referenced once found.
+func (r *ReconcileStorageCluster) ensureBucketStorageClasses(instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	rgwSC, err := r.newBucketStorageClass(instance)
+	if err != nil {
+		return err
+	}
+	if instance.Spec.Components.DisableObjectStore {
+		err := r.deleteClusterScopedObject(instance, &storagev1.StorageClass{}, "StorageClass", rgwSC.Name, reqLogger)
+		if err != nil {
+			return err
+		}
+		removeStorageClassReference(instance, rgwSC.Name)
+		instance.Status.BucketStorageClassCreated = false
+	} else if !instance.Status.BucketStorageClassCreated {
+		err := r.ensureStorageClass(instance, rgwSC, reqLogger)
+		if err != nil {
+			return err
+		}
+		rgwSC.SetGroupVersionKind(storagev1.SchemeGroupVersion.WithKind("StorageClass"))
+		objectRef, err := reference.GetReference(r.scheme, rgwSC)
+		if err != nil {
+			return err
+		}
+		objectreferencesv1.SetObjectReference(&instance.Status.RelatedObjects, *objectRef)
+		instance.Status.BucketStorageClassCreated = true
+	}
+
+	noobaaSCName := generateNameForNooBaaOBCSC(instance)
+	noobaaSC := &storagev1.StorageClass{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: noobaaSCName}, noobaaSC)
+	switch {
+	case err == nil && !instance.Spec.Components.DisableMultiCloudGateway:
+		noobaaSC.SetGroupVersionKind(storagev1.SchemeGroupVersion.WithKind("StorageClass"))
+		objectRef, err := reference.GetReference(r.scheme, noobaaSC)
+		if err != nil {
+			return err
+		}
+		objectreferencesv1.SetObjectReference(&instance.Status.RelatedObjects, *objectRef)
+	case err == nil || errors.IsNotFound(err):
+		removeStorageClassReference(instance, noobaaSCName)
+	default:
+		return err
+	}
 
 	return nil
 }
 
+// removeStorageClassReference removes the StorageClass from the related
+// objects of the StorageCluster
+func removeStorageClassReference(instance *ocsv1.StorageCluster, name string) {
+	objectreferencesv1.RemoveObjectReference(&instance.Status.RelatedObjects, corev1.ObjectReference{
+		APIVersion: storagev1.SchemeGroupVersion.String(),
+		Kind:       "StorageClass",
+		Name:       name,
+	})
+}
+
+// newBucketStorageClass returns the StorageClass provisioning buckets from
+// the Ceph object store
+func (r *ReconcileStorageCluster) newBucketStorageClass(initData *ocsv1.StorageCluster) (*storagev1.StorageClass, error) {
+	name, err := r.getClusterScopedName(initData, &storagev1.StorageClass{}, generateNameForCephRgwSC(initData))
+	if err != nil {
+		return nil, err
+	}
+
+	persistentVolumeReclaimDelete := corev1.PersistentVolumeReclaimDelete
+	sc := &storagev1.StorageClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Provisioner:   fmt.Sprintf("%s.ceph.rook.io/bucket", initData.Namespace),
+		ReclaimPolicy: &persistentVolumeReclaimDelete,
+		Parameters: map[string]string{
+			"objectStoreName":      generateNameForCephObjectStore(initData),
+			"objectStoreNamespace": initData.Namespace,
+			"region":               "us-east-1",
+		},
+	}
+	setClusterScopedOwner(initData, sc)
+	return sc, nil
+}
+
 // newStorageClasses returns the StorageClass instances that should be created
-// on first run.
+// on first run. They record the StorageCluster they are for, as
+// StorageClasses are shared by all namespaces.
 func (r *ReconcileStorageCluster) newStorageClasses(initData *ocsv1.StorageCluster) ([]*storagev1.StorageClass, error) {
+	cephfsName, err := r.getClusterScopedName(initData, &storagev1.StorageClass{}, generateNameForCephFilesystemSC(initData))
+	if err != nil {
+		return nil, err
+	}
+	rbdName, err := r.getClusterScopedName(initData, &storagev1.StorageClass{}, generateNameForCephBlockPoolSC(initData))
+	if err != nil {
+		return nil, err
+	}
+
 	persistentVolumeReclaimDelete := corev1.PersistentVolumeReclaimDelete
 	ret := []*storagev1.StorageClass{
 		&storagev1.StorageClass{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: generateNameForCephFilesystemSC(initData),
+				Name: cephfsName,
 			},
 			Provisioner:   fmt.Sprintf("%s.cephfs.csi.ceph.com", initData.Namespace),
 			ReclaimPolicy: &persistentVolumeReclaimDelete,
@@ -83,7 +205,7 @@ func (r *ReconcileStorageCluster) newStorageClasses(initData *ocsv1.StorageClust
 		},
 		&storagev1.StorageClass{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: generateNameForCephBlockPoolSC(initData),
+				Name: rbdName,
 			},
 			Provisioner:   fmt.Sprintf("%s.rbd.csi.ceph.com", initData.Namespace),
 			ReclaimPolicy: &persistentVolumeReclaimDelete,
@@ -101,13 +223,147 @@ func (r *ReconcileStorageCluster) newStorageClasses(initData *ocsv1.StorageClust
 		},
 	}
 
+	for _, sc := range ret {
+		setClusterScopedOwner(initData, sc)
+	}
+	return ret, nil
+}
+
+// volumeSnapshotGroupVersion is the API group and version of the CSI
+// VolumeSnapshot resources. They are handled unstructured, as the snapshot
+// API is not available on every cluster.
+var volumeSnapshotGroupVersion = schema.GroupVersion{Group: "snapshot.storage.k8s.io", Version: "v1alpha1"}
+
+// ensureSnapshotClasses ensures that VolumeSnapshotClass resources exist in
+// the desired state.
+func (r *ReconcileStorageCluster) ensureSnapshotClasses(instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	if instance.Status.SnapshotClassesCreated {
+		return nil
+	}
+
+	vscs, err := r.newSnapshotClasses(instance)
+	if meta.IsNoMatchError(err) {
+		reqLogger.Info("VolumeSnapshotClass API is not available, not creating snapshot classes")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, vsc := range vscs {
+		err = r.ensureSnapshotClass(instance, vsc, reqLogger)
+		if meta.IsNoMatchError(err) {
+			reqLogger.Info("VolumeSnapshotClass API is not available, not creating snapshot classes")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		objectRef, err := reference.GetReference(r.scheme, vsc)
+		if err != nil {
+			return err
+		}
+		objectreferencesv1.SetObjectReference(&instance.Status.RelatedObjects, *objectRef)
+	}
+
+	instance.Status.SnapshotClassesCreated = true
+
+	return nil
+}
+
+// ensureSnapshotClass creates the VolumeSnapshotClass, or restores it if it
+// was created for the StorageCluster already
+func (r *ReconcileStorageCluster) ensureSnapshotClass(instance *ocsv1.StorageCluster, vsc *unstructured.Unstructured, reqLogger logr.Logger) error {
+	defer r.locks.lock(clusterScopedLockKey("VolumeSnapshotClass", vsc.GetName()))()
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(vsc.GroupVersionKind())
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: vsc.GetName()}, existing)
+
+	switch {
+	case err == nil:
+		if existing.GetDeletionTimestamp() != nil {
+			reqLogger.Info(fmt.Sprintf("Unable to restore init object because %s is marked for deletion", existing.GetName()))
+			return fmt.Errorf("failed to restore initialization object %s because it is marked for deletion", existing.GetName())
+		}
+		err = checkClusterScopedOwner(instance, "VolumeSnapshotClass", existing)
+		if err != nil {
+			return err
+		}
+
+		reqLogger.Info(fmt.Sprintf("Restoring original VolumeSnapshotClass %s", vsc.GetName()))
+		vsc.Object["metadata"] = existing.Object["metadata"]
+		setClusterScopedOwner(instance, vsc)
+		return r.client.Update(context.TODO(), vsc)
+	case errors.IsNotFound(err):
+		reqLogger.Info(fmt.Sprintf("Creating VolumeSnapshotClass %s", vsc.GetName()))
+		return r.client.Create(context.TODO(), vsc)
+	}
+	return err
+}
+
+// newSnapshotClasses returns the VolumeSnapshotClass instances that should be
+// created on first run. They use the same CSI drivers and secrets as the
+// StorageClasses returned by newStorageClasses.
+func (r *ReconcileStorageCluster) newSnapshotClasses(initData *ocsv1.StorageCluster) ([]*unstructured.Unstructured, error) {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(volumeSnapshotGroupVersion.WithKind("VolumeSnapshotClass"))
+	cephfsName, err := r.getClusterScopedName(initData, existing, generateNameForCephFilesystemVSC(initData))
+	if err != nil {
+		return nil, err
+	}
+	rbdName, err := r.getClusterScopedName(initData, existing, generateNameForCephBlockPoolVSC(initData))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*unstructured.Unstructured{
+		&unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": volumeSnapshotGroupVersion.String(),
+				"kind":       "VolumeSnapshotClass",
+				"metadata": map[string]interface{}{
+					"name": cephfsName,
+				},
+				"snapshotter":    fmt.Sprintf("%s.cephfs.csi.ceph.com", initData.Namespace),
+				"deletionPolicy": "Delete",
+				"parameters": map[string]interface{}{
+					"clusterID": initData.Namespace,
+					"fsName":    fmt.Sprintf("%s-cephfilesystem", initData.Name),
+					"csi.storage.k8s.io/snapshotter-secret-name":      "rook-csi-cephfs-provisioner",
+					"csi.storage.k8s.io/snapshotter-secret-namespace": initData.Namespace,
+				},
+			},
+		},
+		&unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": volumeSnapshotGroupVersion.String(),
+				"kind":       "VolumeSnapshotClass",
+				"metadata": map[string]interface{}{
+					"name": rbdName,
+				},
+				"snapshotter":    fmt.Sprintf("%s.rbd.csi.ceph.com", initData.Namespace),
+				"deletionPolicy": "Delete",
+				"parameters": map[string]interface{}{
+					"clusterID": initData.Namespace,
+					"pool":      generateNameForCephBlockPool(initData),
+					"csi.storage.k8s.io/snapshotter-secret-name":      "rook-csi-rbd-provisioner",
+					"csi.storage.k8s.io/snapshotter-secret-namespace": initData.Namespace,
+				},
+			},
+		},
+	}
+
+	for _, vsc := range ret {
+		setClusterScopedOwner(initData, vsc)
+	}
 	return ret, nil
 }
 
 // ensureCephObjectStores ensures that CephObjectStore resources exist in the desired
 // state.
 func (r *ReconcileStorageCluster) ensureCephObjectStores(instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
-	if instance.Status.CephObjectStoresCreated {
+	if instance.Status.CephObjectStoresCreated || instance.Spec.Components.DisableObjectStore {
 		return nil
 	}
 
@@ -173,7 +429,7 @@ func (r *ReconcileStorageCluster) newCephObjectStoreInstances(initData *ocsv1.St
 					Port:      80,
 					Instances: 1,
 					Placement: defaults.DaemonPlacements["rgw"],
-					Resources: defaults.GetDaemonResources("rgw", initData.Spec.Resources),
+					Resources: defaults.GetDaemonResources("rgw", getResourceProfile(initData), initData.Spec.Resources),
 				},
 			},
 		},
@@ -261,7 +517,7 @@ func (r *ReconcileStorageCluster) newCephBlockPoolInstances(initData *ocsv1.Stor
 // state.
 func (r *ReconcileStorageCluster) ensureCephObjectStoreUsers(instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
 
-	if instance.Status.CephObjectStoreUsersCreated {
+	if instance.Status.CephObjectStoreUsersCreated || instance.Spec.Components.DisableObjectStore {
 		return nil
 	}
 
@@ -396,7 +652,7 @@ func (r *ReconcileStorageCluster) newCephFilesystemInstances(initData *ocsv1.Sto
 					ActiveCount:   1,
 					ActiveStandby: true,
 					Placement:     defaults.DaemonPlacements["mds"],
-					Resources:     defaults.GetDaemonResources("mds", initData.Spec.Resources),
+					Resources:     defaults.GetDaemonResources("mds", getResourceProfile(initData), initData.Spec.Resources),
 				},
 			},
 		},
@@ -4,13 +4,17 @@ import (
 	"testing"
 
 	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	secv1 "github.com/openshift/api/security/v1"
 	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/stretchr/testify/assert"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -108,14 +112,18 @@ func TestInitStorageClusterResourcesUpdate(t *testing.T) {
 	}
 	csfs := &storagev1.StorageClass{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "ocsinit-cephfs",
+			Name: generateNameForCephFilesystemSC(cr),
 		},
 	}
 	csrbd := &storagev1.StorageClass{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: "ocsinit-ceph-rbd",
+			Name: generateNameForCephBlockPoolSC(cr),
 		},
 	}
+	vscrbd := &unstructured.Unstructured{}
+	vscrbd.SetGroupVersionKind(volumeSnapshotGroupVersion.WithKind("VolumeSnapshotClass"))
+	vscrbd.SetName(generateNameForCephBlockPoolVSC(cr))
+	vscrbd.Object["snapshotter"] = "example.com/other-driver"
 	cfs := &cephv1.CephFilesystem{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "ocsinit-cephfilesystem",
@@ -151,6 +159,7 @@ func TestInitStorageClusterResourcesUpdate(t *testing.T) {
 	err = reconciler.client.Create(nil, cos)
 	err = reconciler.client.Create(nil, csfs)
 	err = reconciler.client.Create(nil, csrbd)
+	err = reconciler.client.Create(nil, vscrbd)
 
 	result, err := reconciler.Reconcile(request)
 	assert.NoError(t, err)
@@ -159,14 +168,140 @@ func TestInitStorageClusterResourcesUpdate(t *testing.T) {
 	assertExpectedResources(t, reconciler, cr, request)
 }
 
+func TestBucketStorageClasses(t *testing.T) {
+	cases := []struct {
+		label       string
+		components  api.ComponentsSpec
+		noobaaSC    bool
+		expectedRgw bool
+		expectedRef int
+	}{
+		{
+			label:       "all components enabled",
+			noobaaSC:    true,
+			expectedRgw: true,
+			expectedRef: 2,
+		},
+		{
+			label:       "NooBaa StorageClass not created yet",
+			expectedRgw: true,
+			expectedRef: 1,
+		},
+		{
+			label:       "object store disabled",
+			components:  api.ComponentsSpec{DisableObjectStore: true},
+			noobaaSC:    true,
+			expectedRef: 1,
+		},
+		{
+			label:       "object store and multi-cloud gateway disabled",
+			components:  api.ComponentsSpec{DisableObjectStore: true, DisableMultiCloudGateway: true},
+			noobaaSC:    true,
+			expectedRef: 0,
+		},
+	}
+
+	for _, c := range cases {
+		cr := &api.StorageCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "ocsinit",
+				Namespace: "openshift-storage",
+			},
+			Spec: api.StorageClusterSpec{
+				Components: c.components,
+			},
+		}
+		// Deployed before the bucket StorageClasses existed
+		cr.Status.StorageClassesCreated = true
+		reconciler := createFakeInitializationStorageClusterReconciler(t)
+		if c.noobaaSC {
+			// Created by the NooBaa operator
+			err := reconciler.client.Create(nil, &storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "openshift-storage.noobaa.io"},
+				Provisioner: "openshift-storage.noobaa.io/obc",
+			})
+			assert.NoError(t, err, c.label)
+		}
+
+		err := reconciler.ensureBucketStorageClasses(cr, logt)
+		assert.NoError(t, err, c.label)
+		assert.Len(t, cr.Status.RelatedObjects, c.expectedRef, c.label)
+		assert.Equal(t, c.expectedRgw, cr.Status.BucketStorageClassCreated, c.label)
+
+		rgwSC := &storagev1.StorageClass{}
+		err = reconciler.client.Get(nil, types.NamespacedName{Name: "ocsinit-ceph-rgw"}, rgwSC)
+		assert.Equal(t, c.expectedRgw, err == nil, c.label)
+		if c.expectedRgw {
+			assert.Equal(t, "openshift-storage.ceph.rook.io/bucket", rgwSC.Provisioner, c.label)
+			assert.Equal(t, "ocsinit-cephobjectstore", rgwSC.Parameters["objectStoreName"], c.label)
+			assert.Equal(t, "openshift-storage", rgwSC.Parameters["objectStoreNamespace"], c.label)
+		}
+
+		// The NooBaa StorageClass is left to the NooBaa operator
+		mcgSC := &storagev1.StorageClass{}
+		err = reconciler.client.Get(nil, types.NamespacedName{Name: "openshift-storage.noobaa.io"}, mcgSC)
+		assert.Equal(t, c.noobaaSC, err == nil, c.label)
+		assert.Empty(t, mcgSC.Annotations[storageClusterAnnotation], c.label)
+	}
+}
+
+func TestBucketStorageClassDisabledAfterInstall(t *testing.T) {
+	cr := &api.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ocsinit",
+			Namespace: "openshift-storage",
+		},
+	}
+	reconciler := createFakeInitializationStorageClusterReconciler(t)
+
+	err := reconciler.ensureBucketStorageClasses(cr, logt)
+	assert.NoError(t, err)
+	assert.Len(t, cr.Status.RelatedObjects, 1)
+
+	cr.Spec.Components.DisableObjectStore = true
+	err = reconciler.ensureBucketStorageClasses(cr, logt)
+	assert.NoError(t, err)
+	assert.Len(t, cr.Status.RelatedObjects, 0)
+	assert.False(t, cr.Status.BucketStorageClassCreated)
+	err = reconciler.client.Get(nil, types.NamespacedName{Name: "ocsinit-ceph-rgw"}, &storagev1.StorageClass{})
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestEnsureSnapshotClasses(t *testing.T) {
+	cr := &api.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ocsinit",
+			Namespace: "openshift-storage",
+		},
+	}
+	reconciler := createFakeInitializationStorageClusterReconciler(t)
+
+	err := reconciler.ensureSnapshotClasses(cr, logt)
+	assert.NoError(t, err)
+	assert.True(t, cr.Status.SnapshotClassesCreated)
+
+	expected, err := reconciler.newSnapshotClasses(cr)
+	assert.NoError(t, err)
+	assert.Len(t, cr.Status.RelatedObjects, len(expected))
+	for i, ref := range cr.Status.RelatedObjects {
+		assert.Equal(t, "VolumeSnapshotClass", ref.Kind)
+		assert.Equal(t, expected[i].GetName(), ref.Name)
+	}
+
+	params := expected[1].Object["parameters"].(map[string]interface{})
+	assert.Equal(t, "openshift-storage.rbd.csi.ceph.com", expected[1].Object["snapshotter"])
+	assert.Equal(t, "rook-csi-rbd-provisioner", params["csi.storage.k8s.io/snapshotter-secret-name"])
+	assert.Equal(t, "openshift-storage", params["csi.storage.k8s.io/snapshotter-secret-namespace"])
+}
+
 func assertExpectedResources(t assert.TestingT, reconciler ReconcileStorageCluster, cr *api.StorageCluster, request reconcile.Request) {
 	actualSc1 := &storagev1.StorageClass{}
 	actualSc2 := &storagev1.StorageClass{}
-	request.Name = "ocsinit-cephfs"
+	request.Name = generateNameForCephFilesystemSC(cr)
 	err := reconciler.client.Get(nil, request.NamespacedName, actualSc1)
 	assert.NoError(t, err)
 
-	request.Name = "ocsinit-ceph-rbd"
+	request.Name = generateNameForCephBlockPoolSC(cr)
 	err = reconciler.client.Get(nil, request.NamespacedName, actualSc2)
 	assert.NoError(t, err)
 
@@ -191,6 +326,23 @@ func assertExpectedResources(t assert.TestingT, reconciler ReconcileStorageClust
 	assert.Equal(t, expected[1].ReclaimPolicy, actualSc2.ReclaimPolicy)
 	assert.Equal(t, expected[1].Parameters, actualSc2.Parameters)
 
+	expectedVscs, err := reconciler.newSnapshotClasses(cr)
+	assert.NoError(t, err)
+	for _, expectedVsc := range expectedVscs {
+		actualVsc := &unstructured.Unstructured{}
+		actualVsc.SetGroupVersionKind(expectedVsc.GroupVersionKind())
+		request.Name = expectedVsc.GetName()
+		err = reconciler.client.Get(nil, request.NamespacedName, actualVsc)
+		assert.NoError(t, err)
+
+		// Like the StorageClasses, the VolumeSnapshotClasses must not be
+		// owned by the namespaced StorageCluster
+		assert.Len(t, actualVsc.GetOwnerReferences(), 0)
+		assert.Equal(t, expectedVsc.Object["snapshotter"], actualVsc.Object["snapshotter"])
+		assert.Equal(t, expectedVsc.Object["deletionPolicy"], actualVsc.Object["deletionPolicy"])
+		assert.Equal(t, expectedVsc.Object["parameters"], actualVsc.Object["parameters"])
+	}
+
 	actualFs := &cephv1.CephFilesystem{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "ocsinit-cephfilesystem",
@@ -270,19 +422,24 @@ func createFakeInitializationStorageClusterReconciler(t *testing.T, obj ...runti
 
 	return ReconcileStorageCluster{
 		client:    client,
+		apiReader: client,
 		scheme:    scheme,
 		reqLogger: logf.Log.WithName("controller_storagecluster_test"),
+		locks:     newKeyedLocks(),
 	}
 }
 
 func createFakeInitializationScheme(t *testing.T, obj ...runtime.Object) *runtime.Scheme {
-	registerObjs := obj
-	registerObjs = append(registerObjs)
-	api.SchemeBuilder.Register(registerObjs...)
 	scheme, err := api.SchemeBuilder.Build()
 	if err != nil {
 		assert.Fail(t, "unable to build scheme")
 	}
+	// The objects are registered with this scheme only, as registering them
+	// with the SchemeBuilder would leak into the schemes of other tests
+	scheme.AddKnownTypes(api.SchemeGroupVersion, obj...)
+	scheme.AddKnownTypes(nbv1.SchemeGroupVersion,
+		&nbv1.BackingStore{}, &nbv1.BackingStoreList{},
+		&nbv1.BucketClass{}, &nbv1.BucketClassList{})
 	err = corev1.AddToScheme(scheme)
 	if err != nil {
 		assert.Fail(t, "failed to add corev1 scheme")
@@ -295,5 +452,13 @@ func createFakeInitializationScheme(t *testing.T, obj ...runtime.Object) *runtim
 	if err != nil {
 		assert.Fail(t, "failed to add storagev1 scheme")
 	}
+	err = networkingv1beta1.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add networkingv1beta1 scheme")
+	}
+	err = secv1.Install(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add security/v1 scheme")
+	}
 	return scheme
 }
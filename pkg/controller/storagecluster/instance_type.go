@@ -0,0 +1,85 @@
+package storagecluster
+
+import (
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// instanceDeviceSetName is the name of the device set created for a known
+// InstanceType when the StorageCluster does not list any
+const instanceDeviceSetName = "ocs-deviceset"
+
+// getDesiredResourceProfile returns the resource profile asked for by the
+// StorageCluster, before any scale down
+func getDesiredResourceProfile(sc *ocsv1.StorageCluster) string {
+	if sc.Spec.ResourceProfile != "" {
+		return sc.Spec.ResourceProfile
+	}
+	if profile, ok := defaults.InstanceProfiles[sc.Spec.InstanceType]; ok {
+		return profile.ResourceProfile
+	}
+	return defaults.ResourceProfileBalanced
+}
+
+// getStorageDeviceSets returns the StorageDeviceSets of the StorageCluster
+// with the defaults of its InstanceType filled in. A single device set is
+// returned for a known InstanceType if none are listed.
+func getStorageDeviceSets(sc *ocsv1.StorageCluster) []ocsv1.StorageDeviceSet {
+	profile, ok := defaults.InstanceProfiles[sc.Spec.InstanceType]
+	if !ok {
+		return sc.Spec.StorageDeviceSets
+	}
+
+	if len(sc.Spec.StorageDeviceSets) == 0 {
+		return []ocsv1.StorageDeviceSet{newInstanceDeviceSet(profile)}
+	}
+
+	deviceSets := make([]ocsv1.StorageDeviceSet, len(sc.Spec.StorageDeviceSets))
+	for i := range sc.Spec.StorageDeviceSets {
+		sc.Spec.StorageDeviceSets[i].DeepCopyInto(&deviceSets[i])
+		pvcSpec := &deviceSets[i].DataPVCTemplate.Spec
+		if pvcSpec.StorageClassName == nil {
+			storageClassName := profile.StorageClassName
+			pvcSpec.StorageClassName = &storageClassName
+		}
+		if _, ok := pvcSpec.Resources.Requests[corev1.ResourceStorage]; !ok {
+			if pvcSpec.Resources.Requests == nil {
+				pvcSpec.Resources.Requests = corev1.ResourceList{}
+			}
+			pvcSpec.Resources.Requests[corev1.ResourceStorage] = profile.DeviceSize
+		}
+	}
+
+	return deviceSets
+}
+
+// newInstanceDeviceSet returns a device set of one portable OSD per replica
+// on the cloud block volumes of the given instance profile
+func newInstanceDeviceSet(profile defaults.InstanceProfile) ocsv1.StorageDeviceSet {
+	storageClassName := profile.StorageClassName
+	volumeMode := corev1.PersistentVolumeBlock
+
+	return ocsv1.StorageDeviceSet{
+		Name:     instanceDeviceSetName,
+		Count:    1,
+		Replica:  defaults.DeviceSetReplica,
+		Portable: true,
+		DataPVCTemplate: corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "data",
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClassName,
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				VolumeMode:       &volumeMode,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceStorage: profile.DeviceSize,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,69 @@
+package storagecluster
+
+import (
+	"testing"
+
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestGetDesiredResourceProfile(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	assert.Equal(t, defaults.ResourceProfileBalanced, getDesiredResourceProfile(sc))
+
+	sc.Spec.InstanceType = "unknown"
+	assert.Equal(t, defaults.ResourceProfileBalanced, getDesiredResourceProfile(sc))
+
+	sc.Spec.InstanceType = "m5.8xlarge"
+	assert.Equal(t, defaults.ResourceProfilePerformance, getDesiredResourceProfile(sc))
+
+	sc.Spec.ResourceProfile = defaults.ResourceProfileLean
+	assert.Equal(t, defaults.ResourceProfileLean, getDesiredResourceProfile(sc))
+}
+
+func TestGetStorageDeviceSets(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	assert.Empty(t, getStorageDeviceSets(sc))
+
+	// Without a known instance type the device sets are left alone
+	sc.Spec.StorageDeviceSets = []api.StorageDeviceSet{{Name: "bare", Count: 1}}
+	assert.Equal(t, sc.Spec.StorageDeviceSets, getStorageDeviceSets(sc))
+
+	// A device set is made up for a known instance type
+	sc.Spec.StorageDeviceSets = nil
+	sc.Spec.InstanceType = "m5.4xlarge"
+	deviceSets := getStorageDeviceSets(sc)
+	if assert.Len(t, deviceSets, 1) {
+		ds := deviceSets[0]
+		assert.Equal(t, instanceDeviceSetName, ds.Name)
+		assert.Equal(t, defaults.DeviceSetReplica, ds.Replica)
+		assert.True(t, ds.Portable)
+		assert.Equal(t, "gp2", *ds.DataPVCTemplate.Spec.StorageClassName)
+		assert.Equal(t, corev1.PersistentVolumeBlock, *ds.DataPVCTemplate.Spec.VolumeMode)
+		assert.Equal(t, resource.MustParse("2Ti"), ds.DataPVCTemplate.Spec.Resources.Requests[corev1.ResourceStorage])
+	}
+
+	// Listed device sets get the defaults they lack
+	sc.Spec.StorageDeviceSets = append([]api.StorageDeviceSet{{Name: "bare", Count: 1}}, mockDeviceSets...)
+	deviceSets = getStorageDeviceSets(sc)
+	if assert.Len(t, deviceSets, 2) {
+		assert.Equal(t, "gp2", *deviceSets[0].DataPVCTemplate.Spec.StorageClassName)
+		assert.Equal(t, resource.MustParse("2Ti"), deviceSets[0].DataPVCTemplate.Spec.Resources.Requests[corev1.ResourceStorage])
+		assert.Equal(t, mockDeviceSets[0], deviceSets[1])
+	}
+	assert.Nil(t, sc.Spec.StorageDeviceSets[0].DataPVCTemplate.Spec.StorageClassName)
+}
+
+func TestNewCephClusterWithInstanceType(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.InstanceType = "Standard_D8s_v3"
+	sc.Status.NodeTopologies = api.NewNodeTopologyMap()
+
+	cephCluster := newCephCluster(sc, "")
+	assert.Equal(t, defaults.LeanDaemonResources["mon"], cephCluster.Spec.Resources["mon"])
+	assert.Len(t, cephCluster.Spec.Storage.StorageClassDeviceSets, defaults.DeviceSetReplica)
+	assert.Equal(t, "managed-premium", *cephCluster.Spec.Mon.VolumeClaimTemplate.Spec.StorageClassName)
+}
@@ -0,0 +1,216 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-logr/logr"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/openshift/ocs-operator/pkg/controller/placement"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// workerNodeRoleLabel is the label of the nodes that run user workloads
+	workerNodeRoleLabel = "node-role.kubernetes.io/worker"
+	// managedNodeAnnotation names the StorageCluster, as namespace/name,
+	// that made a node a storage node. The status is only written at the
+	// end of the reconcile, so the nodes labeled by a reconcile that did
+	// not get that far are found again by it.
+	managedNodeAnnotation = "ocs.openshift.io/managed-node"
+)
+
+// ensureManagedNodes labels nodes picked by the NodeManagement selector as
+// storage nodes until there are as many storage nodes as desired. The new
+// storage nodes are spread across zones. The storage nodes labeled for other
+// StorageClusters are not counted. Storage nodes are never unlabeled here
+// since they may hold OSD data.
+func (r *ReconcileStorageCluster) ensureManagedNodes(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	if !sc.Spec.ManageNodes {
+		return nil
+	}
+
+	defer r.locks.lock(nodesLockKey)()
+
+	storageNodes, err := r.getStorageNodes()
+	if err != nil {
+		return err
+	}
+
+	candidates := &corev1.NodeList{}
+	err = r.client.List(context.TODO(), candidates, client.MatchingLabels(getManagedNodeSelector(sc)))
+	if err != nil {
+		return err
+	}
+
+	// Forget about managed nodes that are gone
+	wasManaged := map[string]bool{}
+	for _, name := range sc.Status.ManagedNodes {
+		wasManaged[name] = true
+	}
+	managed := []string{}
+	topologyKeys := getTopologyKeys(sc)
+	zoneNodes := map[string]int{}
+	n := 0
+	for _, node := range storageNodes.Items {
+		// The storage nodes labeled for other StorageClusters count
+		// towards theirs
+		if isOtherManagedNode(sc, node) {
+			continue
+		}
+		if wasManaged[node.Name] || isManagedNode(sc, node) {
+			managed = append(managed, node.Name)
+		}
+		zoneNodes[placement.NodeZone(node, topologyKeys)]++
+		n++
+	}
+
+	available := []corev1.Node{}
+	for _, node := range candidates.Items {
+		if _, ok := node.Labels[defaults.NodeAffinityKey]; ok {
+			continue
+		}
+		if node.Spec.Unschedulable || !isNodeReady(node) {
+			continue
+		}
+		available = append(available, node)
+	}
+
+	count := getManagedNodeCount(sc)
+	for ; n < count && len(available) > 0; n++ {
+		i := pickManagedNode(available, zoneNodes, topologyKeys)
+		node := available[i]
+		available = append(available[:i], available[i+1:]...)
+
+		reqLogger.Info("Labeling node as storage node", "Node", node.Name, "Label", defaults.NodeAffinityKey)
+		newNode := node.DeepCopy()
+		newNode.Labels[defaults.NodeAffinityKey] = ""
+		if newNode.Annotations == nil {
+			newNode.Annotations = map[string]string{}
+		}
+		newNode.Annotations[managedNodeAnnotation] = fmt.Sprintf("%s/%s", sc.Namespace, sc.Name)
+		err = r.client.Update(context.TODO(), newNode)
+		if err != nil {
+			return err
+		}
+		zoneNodes[placement.NodeZone(node, topologyKeys)]++
+		managed = append(managed, node.Name)
+	}
+	if n < count {
+		reqLogger.Info(fmt.Sprintf("Only %d of %d storage nodes are available", n, count))
+	}
+
+	sort.Strings(managed)
+	current := sc.Status.ManagedNodes
+	if current == nil {
+		current = []string{}
+	}
+	if !reflect.DeepEqual(managed, current) {
+		sc.Status.ManagedNodes = managed
+	}
+
+	return nil
+}
+
+// isManagedNode says whether the node was made a storage node by the
+// StorageCluster
+func isManagedNode(sc *ocsv1.StorageCluster, node corev1.Node) bool {
+	return node.Annotations[managedNodeAnnotation] == fmt.Sprintf("%s/%s", sc.Namespace, sc.Name)
+}
+
+// isOtherManagedNode says whether the node was made a storage node by
+// another StorageCluster
+func isOtherManagedNode(sc *ocsv1.StorageCluster, node corev1.Node) bool {
+	owner, ok := node.Annotations[managedNodeAnnotation]
+	return ok && owner != fmt.Sprintf("%s/%s", sc.Namespace, sc.Name)
+}
+
+// getManagedNodeSelector returns the labels of the nodes that may become
+// storage nodes
+func getManagedNodeSelector(sc *ocsv1.StorageCluster) map[string]string {
+	if len(sc.Spec.NodeManagement.NodeSelector) > 0 {
+		return sc.Spec.NodeManagement.NodeSelector
+	}
+
+	selector := map[string]string{workerNodeRoleLabel: ""}
+	if sc.Spec.InstanceType != "" {
+		selector[corev1.LabelInstanceType] = sc.Spec.InstanceType
+	}
+	return selector
+}
+
+// getManagedNodeCount returns the desired number of storage nodes
+func getManagedNodeCount(sc *ocsv1.StorageCluster) int {
+	if sc.Spec.NodeManagement.Count > 0 {
+		return sc.Spec.NodeManagement.Count
+	}
+
+	count := defaults.DeviceSetReplica
+	for _, ds := range getStorageDeviceSets(sc) {
+		if ds.Replica > count {
+			count = ds.Replica
+		}
+	}
+	return count
+}
+
+// pickManagedNode returns the index of the node in the zone with the fewest
+// storage nodes, by name if several zones tie
+func pickManagedNode(nodes []corev1.Node, zoneNodes map[string]int, topologyKeys []string) int {
+	best := 0
+	for i, node := range nodes {
+		bestCount := zoneNodes[placement.NodeZone(nodes[best], topologyKeys)]
+		count := zoneNodes[placement.NodeZone(node, topologyKeys)]
+		if count < bestCount || (count == bestCount && node.Name < nodes[best].Name) {
+			best = i
+		}
+	}
+	return best
+}
+
+// isNodeReady says whether the node does not report itself as not ready
+func isNodeReady(node corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return true
+}
+
+// deleteManagedNodeLabels removes the storage node label from the nodes that
+// were made storage nodes for the StorageCluster by ensureManagedNodes
+func (r *ReconcileStorageCluster) deleteManagedNodeLabels(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	defer r.locks.lock(nodesLockKey)()
+
+	nodes, err := r.getStorageNodes()
+	if err != nil {
+		return false, err
+	}
+
+	managed := map[string]bool{}
+	for _, name := range sc.Status.ManagedNodes {
+		managed[name] = true
+	}
+
+	for _, node := range nodes.Items {
+		if (!managed[node.Name] && !isManagedNode(sc, node)) || isOtherManagedNode(sc, node) {
+			continue
+		}
+
+		reqLogger.Info("Removing storage node label from node", "Node", node.Name, "Label", defaults.NodeAffinityKey)
+		newNode := node.DeepCopy()
+		delete(newNode.Labels, defaults.NodeAffinityKey)
+		delete(newNode.Annotations, managedNodeAnnotation)
+		err = r.client.Update(context.TODO(), newNode)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
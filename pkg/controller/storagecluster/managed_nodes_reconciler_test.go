@@ -0,0 +1,178 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newCandidateNode returns a worker node in the given zone
+func newCandidateNode(name, zone string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				hostnameLabel:       name,
+				zoneTopologyLabel:   zone,
+				workerNodeRoleLabel: "",
+			},
+		},
+	}
+}
+
+func newCandidateNodes() []*corev1.Node {
+	nodes := []*corev1.Node{
+		newCandidateNode("worker1", "zone1"),
+		newCandidateNode("worker2", "zone1"),
+		newCandidateNode("worker3", "zone2"),
+		newCandidateNode("worker4", "zone3"),
+		newCandidateNode("master1", "zone4"),
+		newCandidateNode("cordoned", "zone4"),
+		newCandidateNode("notready", "zone4"),
+	}
+	delete(nodes[4].Labels, workerNodeRoleLabel)
+	nodes[5].Spec.Unschedulable = true
+	nodes[6].Status.Conditions = []corev1.NodeCondition{
+		{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+	}
+	return nodes
+}
+
+func assertStorageNodes(t *testing.T, reconciler ReconcileStorageCluster, expected []string) {
+	nodes, err := reconciler.getStorageNodes()
+	assert.NoError(t, err)
+	actual := []string{}
+	for _, node := range nodes.Items {
+		actual = append(actual, node.Name)
+	}
+	assert.ElementsMatch(t, expected, actual)
+}
+
+func TestEnsureManagedNodes(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ManageNodes = true
+	objects := []runtime.Object{sc}
+	for _, node := range newCandidateNodes() {
+		objects = append(objects, node)
+	}
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	// One node is picked per zone, by name within a zone
+	err := reconciler.ensureManagedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker1", "worker3", "worker4"}, sc.Status.ManagedNodes)
+	assertStorageNodes(t, reconciler, []string{"worker1", "worker3", "worker4"})
+
+	// The managed nodes are found again if their status was not written
+	lost := sc.DeepCopy()
+	lost.Status.ManagedNodes = nil
+	err = reconciler.ensureManagedNodes(lost, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, sc.Status.ManagedNodes, lost.Status.ManagedNodes)
+	node := &corev1.Node{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "worker1"}, node)
+	assert.NoError(t, err)
+	assert.Equal(t, sc.Namespace+"/"+sc.Name, node.Annotations[managedNodeAnnotation])
+
+	// More nodes are labeled as the count grows
+	sc.Spec.NodeManagement.Count = 4
+	err = reconciler.ensureManagedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker1", "worker2", "worker3", "worker4"}, sc.Status.ManagedNodes)
+	assertStorageNodes(t, reconciler, []string{"worker1", "worker2", "worker3", "worker4"})
+
+	// Only the eligible nodes are picked
+	sc.Spec.NodeManagement.Count = 10
+	err = reconciler.ensureManagedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker1", "worker2", "worker3", "worker4"}, sc.Status.ManagedNodes)
+
+	// The managed nodes are released on uninstall, even those missing
+	// from the status
+	sc.Status.ManagedNodes = []string{"worker1"}
+	done, err := reconciler.deleteManagedNodeLabels(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assertStorageNodes(t, reconciler, []string{})
+	node = &corev1.Node{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "worker1"}, node)
+	assert.NoError(t, err)
+	assert.NotContains(t, node.Annotations, managedNodeAnnotation)
+}
+
+func TestEnsureManagedNodesDisabled(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	objects := []runtime.Object{sc}
+	for _, node := range newCandidateNodes() {
+		objects = append(objects, node)
+	}
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	err := reconciler.ensureManagedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Empty(t, sc.Status.ManagedNodes)
+	assertStorageNodes(t, reconciler, []string{})
+}
+
+func TestEnsureManagedNodesKeepsExistingStorageNodes(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ManageNodes = true
+	nodes := newCandidateNodes()
+	nodes[2].Labels[defaults.NodeAffinityKey] = ""
+	objects := []runtime.Object{sc}
+	for _, node := range nodes {
+		objects = append(objects, node)
+	}
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	err := reconciler.ensureManagedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker1", "worker4"}, sc.Status.ManagedNodes)
+	assertStorageNodes(t, reconciler, []string{"worker1", "worker3", "worker4"})
+}
+
+func TestEnsureManagedNodesOtherStorageCluster(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ManageNodes = true
+	nodes := newCandidateNodes()
+	// worker3 was labeled for a StorageCluster of another namespace
+	nodes[2].Labels[defaults.NodeAffinityKey] = ""
+	nodes[2].Annotations = map[string]string{managedNodeAnnotation: "other-ns/" + sc.Name}
+	objects := []runtime.Object{sc}
+	for _, node := range nodes {
+		objects = append(objects, node)
+	}
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	err := reconciler.ensureManagedNodes(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker1", "worker2", "worker4"}, sc.Status.ManagedNodes)
+	assertStorageNodes(t, reconciler, []string{"worker1", "worker2", "worker3", "worker4"})
+
+	// Its label is left alone on uninstall, even if the status names it
+	sc.Status.ManagedNodes = append(sc.Status.ManagedNodes, "worker3")
+	done, err := reconciler.deleteManagedNodeLabels(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assertStorageNodes(t, reconciler, []string{"worker3"})
+}
+
+func TestGetManagedNodeSelector(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	assert.Equal(t, map[string]string{workerNodeRoleLabel: ""}, getManagedNodeSelector(sc))
+
+	sc.Spec.InstanceType = "m5.4xlarge"
+	assert.Equal(t, map[string]string{
+		workerNodeRoleLabel:      "",
+		corev1.LabelInstanceType: "m5.4xlarge",
+	}, getManagedNodeSelector(sc))
+
+	sc.Spec.NodeManagement.NodeSelector = map[string]string{"storage": "yes"}
+	assert.Equal(t, map[string]string{"storage": "yes"}, getManagedNodeSelector(sc))
+}
@@ -0,0 +1,324 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	objectreferencesv1 "github.com/openshift/custom-resource-status/objectreferences/v1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/reference"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ensureMultiCloudGateway ensures that the BackingStores and BucketClasses
+// listed in the multiCloudGateway section exist in the desired state, and
+// deletes the ones created for the StorageCluster that are no longer listed
+func (r *ReconcileStorageCluster) ensureMultiCloudGateway(sc *ocsv1.StorageCluster, state *reconcileState, reqLogger logr.Logger) error {
+	if sc.Spec.Components.DisableMultiCloudGateway || sc.Spec.MultiCloudGateway == nil {
+		return r.pruneMultiCloudGateway(sc, map[string]bool{}, map[string]bool{}, reqLogger)
+	}
+
+	backingStores := map[string]bool{}
+	for _, bsSpec := range sc.Spec.MultiCloudGateway.BackingStores {
+		backingStores[bsSpec.Name] = true
+	}
+	bucketClasses := map[string]bool{}
+	for _, bcSpec := range sc.Spec.MultiCloudGateway.BucketClasses {
+		bucketClasses[bcSpec.Name] = true
+	}
+	err := r.pruneMultiCloudGateway(sc, backingStores, bucketClasses, reqLogger)
+	if err != nil {
+		return err
+	}
+
+	rbdSCName, err := r.getClusterScopedName(sc, &storagev1.StorageClass{}, generateNameForCephBlockPoolSC(sc))
+	if err != nil {
+		return err
+	}
+	for _, bsSpec := range sc.Spec.MultiCloudGateway.BackingStores {
+		bs, err := newBackingStore(sc, bsSpec, rbdSCName)
+		if err != nil {
+			reqLogger.Info("Invalid BackingStore", "Name", bsSpec.Name, "Error", err.Error())
+			statusutil.MapInvalidBackingStore(&state.conditions, "BackingStoreInvalid", err.Error())
+			continue
+		}
+
+		secretRef := getBackingStoreSecret(&bs.Spec)
+		if secretRef != nil {
+			err = r.client.Get(context.TODO(), types.NamespacedName{Name: secretRef.Name, Namespace: secretRef.Namespace}, &corev1.Secret{})
+			if err != nil {
+				if errors.IsNotFound(err) {
+					message := fmt.Sprintf("Secret %s/%s of BackingStore %s not found", secretRef.Namespace, secretRef.Name, bs.Name)
+					reqLogger.Info(message)
+					statusutil.MapInvalidBackingStore(&state.conditions, "BackingStoreSecretNotFound", message)
+					continue
+				}
+				return err
+			}
+		}
+
+		err = r.ensureBackingStore(sc, state, bs, reqLogger)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, bcSpec := range sc.Spec.MultiCloudGateway.BucketClasses {
+		err := r.ensureBucketClass(sc, state, newBucketClass(sc, bcSpec), reqLogger)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneMultiCloudGateway deletes the BackingStores and BucketClasses created
+// for the StorageCluster that are not among the given ones. Those created by
+// NooBaa itself, such as its default BackingStore, are left alone.
+func (r *ReconcileStorageCluster) pruneMultiCloudGateway(sc *ocsv1.StorageCluster, backingStores, bucketClasses map[string]bool, reqLogger logr.Logger) error {
+	bss := &nbv1.BackingStoreList{}
+	err := r.client.List(context.TODO(), bss, client.InNamespace(sc.Namespace))
+	if err != nil {
+		// NooBaa may not be installed
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	for i := range bss.Items {
+		bs := &bss.Items[i]
+		if backingStores[bs.Name] || !metav1.IsControlledBy(bs, sc) {
+			continue
+		}
+		reqLogger.Info(fmt.Sprintf("Deleting BackingStore %s no longer in the spec", bs.Name))
+		err = r.deleteMultiCloudGatewayObject(sc, bs, "BackingStore", bs.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	bcs := &nbv1.BucketClassList{}
+	err = r.client.List(context.TODO(), bcs, client.InNamespace(sc.Namespace))
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	for i := range bcs.Items {
+		bc := &bcs.Items[i]
+		if bucketClasses[bc.Name] || !metav1.IsControlledBy(bc, sc) {
+			continue
+		}
+		reqLogger.Info(fmt.Sprintf("Deleting BucketClass %s no longer in the spec", bc.Name))
+		err = r.deleteMultiCloudGatewayObject(sc, bc, "BucketClass", bc.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteMultiCloudGatewayObject deletes a BackingStore or BucketClass, and
+// removes it from the related objects
+func (r *ReconcileStorageCluster) deleteMultiCloudGatewayObject(sc *ocsv1.StorageCluster, obj runtime.Object, kind, name string) error {
+	err := r.client.Delete(context.TODO(), obj)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	objectreferencesv1.RemoveObjectReference(&sc.Status.RelatedObjects, corev1.ObjectReference{
+		APIVersion: nbv1.SchemeGroupVersion.String(),
+		Kind:       kind,
+		Name:       name,
+		Namespace:  sc.Namespace,
+	})
+	return nil
+}
+
+// ensureBackingStore creates or updates the given BackingStore and maps its
+// phase into the StorageCluster conditions
+func (r *ReconcileStorageCluster) ensureBackingStore(sc *ocsv1.StorageCluster, state *reconcileState, bs *nbv1.BackingStore, reqLogger logr.Logger) error {
+	err := controllerutil.SetControllerReference(sc, bs, r.scheme)
+	if err != nil {
+		return err
+	}
+
+	found := &nbv1.BackingStore{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: bs.Name, Namespace: bs.Namespace}, found)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		reqLogger.Info(fmt.Sprintf("Creating BackingStore %s", bs.Name))
+		err = r.client.Create(context.TODO(), bs)
+		if err != nil {
+			return err
+		}
+		found = bs
+	} else if !metav1.IsControlledBy(found, sc) {
+		message := fmt.Sprintf("BackingStore %s exists and is not controlled by the StorageCluster", bs.Name)
+		reqLogger.Info(message)
+		statusutil.MapMultiCloudGatewayObjectNotOwned(&state.conditions, "BackingStoreNotOwned", message)
+		return nil
+	} else if !reflect.DeepEqual(bs.Spec, found.Spec) {
+		reqLogger.Info(fmt.Sprintf("Updating spec for BackingStore %s", bs.Name))
+		found.Spec = bs.Spec
+		err = r.client.Update(context.TODO(), found)
+		if err != nil {
+			return err
+		}
+	}
+
+	found.SetGroupVersionKind(nbv1.SchemeGroupVersion.WithKind("BackingStore"))
+	objectRef, err := reference.GetReference(r.scheme, found)
+	if err != nil {
+		return err
+	}
+	objectreferencesv1.SetObjectReference(&sc.Status.RelatedObjects, *objectRef)
+
+	statusutil.MapBackingStoreNegativeConditions(&state.conditions, found)
+
+	return nil
+}
+
+// ensureBucketClass creates or updates the given BucketClass and maps its
+// phase into the StorageCluster conditions
+func (r *ReconcileStorageCluster) ensureBucketClass(sc *ocsv1.StorageCluster, state *reconcileState, bc *nbv1.BucketClass, reqLogger logr.Logger) error {
+	err := controllerutil.SetControllerReference(sc, bc, r.scheme)
+	if err != nil {
+		return err
+	}
+
+	found := &nbv1.BucketClass{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: bc.Name, Namespace: bc.Namespace}, found)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		reqLogger.Info(fmt.Sprintf("Creating BucketClass %s", bc.Name))
+		err = r.client.Create(context.TODO(), bc)
+		if err != nil {
+			return err
+		}
+		found = bc
+	} else if !metav1.IsControlledBy(found, sc) {
+		message := fmt.Sprintf("BucketClass %s exists and is not controlled by the StorageCluster", bc.Name)
+		reqLogger.Info(message)
+		statusutil.MapMultiCloudGatewayObjectNotOwned(&state.conditions, "BucketClassNotOwned", message)
+		return nil
+	} else if !reflect.DeepEqual(bc.Spec, found.Spec) {
+		reqLogger.Info(fmt.Sprintf("Updating spec for BucketClass %s", bc.Name))
+		found.Spec = bc.Spec
+		err = r.client.Update(context.TODO(), found)
+		if err != nil {
+			return err
+		}
+	}
+
+	found.SetGroupVersionKind(nbv1.SchemeGroupVersion.WithKind("BucketClass"))
+	objectRef, err := reference.GetReference(r.scheme, found)
+	if err != nil {
+		return err
+	}
+	objectreferencesv1.SetObjectReference(&sc.Status.RelatedObjects, *objectRef)
+
+	statusutil.MapBucketClassNegativeConditions(&state.conditions, found)
+
+	return nil
+}
+
+// newBackingStore returns the NooBaa BackingStore for the given spec. It
+// returns an error if the section matching the store type is not set, or if
+// the credentials Secret is in another namespace than the StorageCluster. A
+// pv-pool store defaults to the given StorageClass.
+func newBackingStore(sc *ocsv1.StorageCluster, bsSpec ocsv1.MultiCloudGatewayBackingStore, defaultStorageClass string) (*nbv1.BackingStore, error) {
+	bs := &nbv1.BackingStore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bsSpec.Name,
+			Namespace: sc.Namespace,
+		},
+		Spec: nbv1.BackingStoreSpec{
+			Type: bsSpec.Type,
+		},
+	}
+
+	switch bsSpec.Type {
+	case nbv1.StoreTypeAWSS3:
+		if bsSpec.AWSS3 == nil {
+			return nil, fmt.Errorf("BackingStore %s of type %s is missing the awsS3 section", bsSpec.Name, bsSpec.Type)
+		}
+		bs.Spec.AWSS3 = bsSpec.AWSS3.DeepCopy()
+	case nbv1.StoreTypeS3Compatible:
+		if bsSpec.S3Compatible == nil {
+			return nil, fmt.Errorf("BackingStore %s of type %s is missing the s3Compatible section", bsSpec.Name, bsSpec.Type)
+		}
+		bs.Spec.S3Compatible = bsSpec.S3Compatible.DeepCopy()
+	case nbv1.StoreTypeAzureBlob:
+		if bsSpec.AzureBlob == nil {
+			return nil, fmt.Errorf("BackingStore %s of type %s is missing the azureBlob section", bsSpec.Name, bsSpec.Type)
+		}
+		bs.Spec.AzureBlob = bsSpec.AzureBlob.DeepCopy()
+	case nbv1.StoreTypePVPool:
+		if bsSpec.PVPool == nil {
+			return nil, fmt.Errorf("BackingStore %s of type %s is missing the pvPool section", bsSpec.Name, bsSpec.Type)
+		}
+		bs.Spec.PVPool = bsSpec.PVPool.DeepCopy()
+		if bs.Spec.PVPool.StorageClass == "" {
+			bs.Spec.PVPool.StorageClass = defaultStorageClass
+		}
+	default:
+		return nil, fmt.Errorf("BackingStore %s has unsupported type %q", bsSpec.Name, bsSpec.Type)
+	}
+
+	// Credentials are only taken from the StorageCluster namespace
+	secretRef := getBackingStoreSecret(&bs.Spec)
+	if secretRef != nil {
+		if secretRef.Namespace != "" && secretRef.Namespace != sc.Namespace {
+			return nil, fmt.Errorf("Secret %s/%s of BackingStore %s is not in namespace %s", secretRef.Namespace, secretRef.Name, bsSpec.Name, sc.Namespace)
+		}
+		secretRef.Namespace = sc.Namespace
+	}
+
+	return bs, nil
+}
+
+// getBackingStoreSecret returns the credentials Secret reference of a
+// BackingStore, or nil if its type does not use one
+func getBackingStoreSecret(spec *nbv1.BackingStoreSpec) *corev1.SecretReference {
+	switch {
+	case spec.AWSS3 != nil:
+		return &spec.AWSS3.Secret
+	case spec.S3Compatible != nil:
+		return &spec.S3Compatible.Secret
+	case spec.AzureBlob != nil:
+		return &spec.AzureBlob.Secret
+	}
+	return nil
+}
+
+// newBucketClass returns the NooBaa BucketClass for the given spec
+func newBucketClass(sc *ocsv1.StorageCluster, bcSpec ocsv1.MultiCloudGatewayBucketClass) *nbv1.BucketClass {
+	return &nbv1.BucketClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bcSpec.Name,
+			Namespace: sc.Namespace,
+		},
+		Spec: nbv1.BucketClassSpec{
+			PlacementPolicy: *bcSpec.PlacementPolicy.DeepCopy(),
+		},
+	}
+}
@@ -0,0 +1,327 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var mockMCGStorageCluster = &api.StorageCluster{
+	ObjectMeta: metav1.ObjectMeta{
+		Name:      "mcg-test",
+		Namespace: "openshift-storage",
+	},
+	Spec: api.StorageClusterSpec{
+		MultiCloudGateway: &api.MultiCloudGatewaySpec{
+			BackingStores: []api.MultiCloudGatewayBackingStore{
+				{
+					Name: "aws",
+					Type: nbv1.StoreTypeAWSS3,
+					AWSS3: &nbv1.AWSS3Spec{
+						TargetBucket: "bucket",
+						Secret:       corev1.SecretReference{Name: "aws-creds"},
+					},
+				},
+				{
+					Name:   "pv",
+					Type:   nbv1.StoreTypePVPool,
+					PVPool: &nbv1.PVPoolSpec{NumVolumes: 3},
+				},
+			},
+			BucketClasses: []api.MultiCloudGatewayBucketClass{
+				{
+					Name: "mirrored",
+					PlacementPolicy: nbv1.PlacementPolicy{
+						Tiers: []nbv1.Tier{
+							{
+								Placement:     nbv1.TierPlacementMirror,
+								BackingStores: []string{"aws", "pv"},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestNewBackingStore(t *testing.T) {
+	sc := mockMCGStorageCluster.DeepCopy()
+
+	bs, err := newBackingStore(sc, sc.Spec.MultiCloudGateway.BackingStores[0], generateNameForCephBlockPoolSC(sc))
+	assert.NoError(t, err)
+	assert.Equal(t, sc.Namespace, bs.Namespace)
+	assert.Equal(t, sc.Namespace, bs.Spec.AWSS3.Secret.Namespace)
+	// The StorageCluster spec must not be modified
+	assert.Equal(t, "", sc.Spec.MultiCloudGateway.BackingStores[0].AWSS3.Secret.Namespace)
+
+	bs, err = newBackingStore(sc, sc.Spec.MultiCloudGateway.BackingStores[1], generateNameForCephBlockPoolSC(sc))
+	assert.NoError(t, err)
+	assert.Equal(t, generateNameForCephBlockPoolSC(sc), bs.Spec.PVPool.StorageClass)
+	assert.Nil(t, getBackingStoreSecret(&bs.Spec))
+
+	_, err = newBackingStore(sc, api.MultiCloudGatewayBackingStore{Name: "azure", Type: nbv1.StoreTypeAzureBlob}, generateNameForCephBlockPoolSC(sc))
+	assert.Error(t, err)
+
+	_, err = newBackingStore(sc, api.MultiCloudGatewayBackingStore{Name: "gcs", Type: nbv1.StoreTypeGoogleCloudStorage}, generateNameForCephBlockPoolSC(sc))
+	assert.Error(t, err)
+}
+
+func TestEnsureMultiCloudGateway(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "aws-creds",
+			Namespace: mockMCGStorageCluster.Namespace,
+		},
+	}
+
+	cases := []struct {
+		label              string
+		objects            []runtime.Object
+		expectedStores     []string
+		expectedMissing    []string
+		expectedConditions map[conditionsv1.ConditionType]string
+	}{
+		{
+			label:           "credentials Secret is missing",
+			expectedStores:  []string{"pv"},
+			expectedMissing: []string{"aws"},
+			expectedConditions: map[conditionsv1.ConditionType]string{
+				conditionsv1.ConditionDegraded:    "BackingStoreSecretNotFound",
+				conditionsv1.ConditionProgressing: "BackingStoreInitializing",
+			},
+		},
+		{
+			label:          "credentials Secret exists",
+			objects:        []runtime.Object{secret},
+			expectedStores: []string{"aws", "pv"},
+			expectedConditions: map[conditionsv1.ConditionType]string{
+				conditionsv1.ConditionProgressing: "BackingStoreInitializing",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		sc := mockMCGStorageCluster.DeepCopy()
+		reconciler := createFakeMCGReconciler(t, c.objects...)
+		state := &reconcileState{}
+
+		err := reconciler.ensureMultiCloudGateway(sc, state, reconciler.reqLogger)
+		assert.NoError(t, err, c.label)
+
+		for _, name := range c.expectedStores {
+			bs := &nbv1.BackingStore{}
+			err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: sc.Namespace}, bs)
+			assert.NoError(t, err, c.label)
+			assert.Len(t, bs.GetOwnerReferences(), 1, c.label)
+		}
+		for _, name := range c.expectedMissing {
+			bs := &nbv1.BackingStore{}
+			err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: sc.Namespace}, bs)
+			assert.True(t, errors.IsNotFound(err), c.label)
+		}
+
+		bc := &nbv1.BucketClass{}
+		err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "mirrored", Namespace: sc.Namespace}, bc)
+		assert.NoError(t, err, c.label)
+		assert.Equal(t, sc.Spec.MultiCloudGateway.BucketClasses[0].PlacementPolicy, bc.Spec.PlacementPolicy, c.label)
+
+		// Every created BackingStore and the BucketClass is a related object
+		assert.Len(t, sc.Status.RelatedObjects, len(c.expectedStores)+1, c.label)
+
+		for cType, reason := range c.expectedConditions {
+			condition := conditionsv1.FindStatusCondition(state.conditions, cType)
+			if assert.NotNil(t, condition, c.label) {
+				assert.Equal(t, corev1.ConditionTrue, condition.Status, c.label)
+				assert.Equal(t, reason, condition.Reason, c.label)
+			}
+		}
+	}
+}
+
+func TestEnsureMultiCloudGatewayPhases(t *testing.T) {
+	sc := mockMCGStorageCluster.DeepCopy()
+	sc.Spec.MultiCloudGateway.BackingStores = sc.Spec.MultiCloudGateway.BackingStores[1:]
+	sc.Spec.MultiCloudGateway.BucketClasses[0].PlacementPolicy.Tiers[0].BackingStores = []string{"pv"}
+
+	bs, err := newBackingStore(sc, sc.Spec.MultiCloudGateway.BackingStores[0], generateNameForCephBlockPoolSC(sc))
+	assert.NoError(t, err)
+	bs.Status.Phase = nbv1.BackingStorePhaseReady
+	bc := newBucketClass(sc, sc.Spec.MultiCloudGateway.BucketClasses[0])
+	bc.Status.Phase = nbv1.BucketClassPhaseRejected
+	ownerRef := metav1.NewControllerRef(sc, api.SchemeGroupVersion.WithKind("StorageCluster"))
+	bs.OwnerReferences = []metav1.OwnerReference{*ownerRef}
+	bc.OwnerReferences = []metav1.OwnerReference{*ownerRef}
+	reconciler := createFakeMCGReconciler(t, bs, bc)
+	state := &reconcileState{}
+
+	err = reconciler.ensureMultiCloudGateway(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	assert.Nil(t, conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionProgressing))
+	condition := conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionDegraded)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, "BucketClassRejected", condition.Reason)
+	}
+}
+
+func TestEnsureMultiCloudGatewayDisabled(t *testing.T) {
+	sc := mockMCGStorageCluster.DeepCopy()
+	sc.Spec.Components.DisableMultiCloudGateway = true
+	reconciler := createFakeMCGReconciler(t)
+	state := &reconcileState{}
+
+	err := reconciler.ensureMultiCloudGateway(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	bss := &nbv1.BackingStoreList{}
+	err = reconciler.client.List(context.TODO(), bss)
+	assert.NoError(t, err)
+	assert.Len(t, bss.Items, 0)
+}
+
+func TestEnsureMultiCloudGatewaySecretInOtherNamespace(t *testing.T) {
+	sc := mockMCGStorageCluster.DeepCopy()
+	sc.Spec.MultiCloudGateway.BackingStores[0].AWSS3.Secret.Namespace = "creds-ns"
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "aws-creds",
+			Namespace: "creds-ns",
+		},
+	}
+	reconciler := createFakeMCGReconciler(t, secret)
+	state := &reconcileState{}
+
+	// Credentials are not taken from other namespaces
+	err := reconciler.ensureMultiCloudGateway(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "aws", Namespace: sc.Namespace}, &nbv1.BackingStore{})
+	assert.True(t, errors.IsNotFound(err))
+	condition := conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionDegraded)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, "BackingStoreInvalid", condition.Reason)
+	}
+}
+
+func TestEnsureMultiCloudGatewayNotOwned(t *testing.T) {
+	sc := mockMCGStorageCluster.DeepCopy()
+	bs := &nbv1.BackingStore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pv",
+			Namespace: sc.Namespace,
+		},
+		Spec: nbv1.BackingStoreSpec{Type: nbv1.StoreTypeAWSS3},
+	}
+	bc := &nbv1.BucketClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "mirrored",
+			Namespace: sc.Namespace,
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "aws-creds",
+			Namespace: sc.Namespace,
+		},
+	}
+	reconciler := createFakeMCGReconciler(t, secret, bs, bc)
+	state := &reconcileState{}
+
+	err := reconciler.ensureMultiCloudGateway(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	// The objects made by someone else are left as they are
+	found := &nbv1.BackingStore{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "pv", Namespace: sc.Namespace}, found)
+	assert.NoError(t, err)
+	assert.Equal(t, bs.Spec, found.Spec)
+	assert.Len(t, found.OwnerReferences, 0)
+	foundClass := &nbv1.BucketClass{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "mirrored", Namespace: sc.Namespace}, foundClass)
+	assert.NoError(t, err)
+	assert.Equal(t, bc.Spec, foundClass.Spec)
+	assert.Len(t, foundClass.OwnerReferences, 0)
+
+	condition := conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionDegraded)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, "BackingStoreNotOwned", condition.Reason)
+	}
+	// Only the aws store is created and related
+	assert.Len(t, sc.Status.RelatedObjects, 1)
+}
+
+func TestEnsureMultiCloudGatewayPrunes(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "aws-creds",
+			Namespace: mockMCGStorageCluster.Namespace,
+		},
+	}
+	// Created by NooBaa itself
+	defaultStore := &nbv1.BackingStore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "noobaa-default-backing-store",
+			Namespace: mockMCGStorageCluster.Namespace,
+		},
+	}
+	sc := mockMCGStorageCluster.DeepCopy()
+	reconciler := createFakeMCGReconciler(t, secret, defaultStore)
+
+	err := reconciler.ensureMultiCloudGateway(sc, &reconcileState{}, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Len(t, sc.Status.RelatedObjects, 3)
+
+	// The aws store and the BucketClass are taken out of the spec
+	sc.Spec.MultiCloudGateway.BackingStores = sc.Spec.MultiCloudGateway.BackingStores[1:]
+	sc.Spec.MultiCloudGateway.BucketClasses = nil
+	err = reconciler.ensureMultiCloudGateway(sc, &reconcileState{}, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	bss := &nbv1.BackingStoreList{}
+	err = reconciler.client.List(context.TODO(), bss)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, bs := range bss.Items {
+		names = append(names, bs.Name)
+	}
+	assert.ElementsMatch(t, []string{"noobaa-default-backing-store", "pv"}, names)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "mirrored", Namespace: sc.Namespace}, &nbv1.BucketClass{})
+	assert.True(t, errors.IsNotFound(err))
+	assert.Len(t, sc.Status.RelatedObjects, 1)
+
+	// Disabling the multi-cloud gateway deletes the rest
+	sc.Spec.Components.DisableMultiCloudGateway = true
+	err = reconciler.ensureMultiCloudGateway(sc, &reconcileState{}, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "pv", Namespace: sc.Namespace}, &nbv1.BackingStore{})
+	assert.True(t, errors.IsNotFound(err))
+	assert.Len(t, sc.Status.RelatedObjects, 0)
+}
+
+func createFakeMCGReconciler(t *testing.T, obj ...runtime.Object) ReconcileStorageCluster {
+	scheme := createFakeScheme(t)
+	scheme.AddKnownTypes(nbv1.SchemeGroupVersion,
+		&nbv1.BackingStore{}, &nbv1.BackingStoreList{},
+		&nbv1.BucketClass{}, &nbv1.BucketClassList{})
+	client := fake.NewFakeClientWithScheme(scheme, obj...)
+
+	return ReconcileStorageCluster{
+		client:    client,
+		apiReader: client,
+		scheme:    scheme,
+		reqLogger: logf.Log.WithName("controller_storagecluster_test"),
+		locks:     newKeyedLocks(),
+	}
+}
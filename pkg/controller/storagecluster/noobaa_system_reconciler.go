@@ -10,9 +10,9 @@ import (
 	objectreferencesv1 "github.com/openshift/custom-resource-status/objectreferences/v1"
 	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 	"github.com/openshift/ocs-operator/pkg/controller/defaults"
-	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -21,15 +21,26 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// defaultNoobaaCreateDelay is how long to wait once the CephCluster is
+// created before creating the NooBaa system, to give the CephCluster time to
+// quiesce
+const defaultNoobaaCreateDelay = 5 * time.Second
+
 func (r *ReconcileStorageCluster) ensureNoobaaSystem(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	if sc.Spec.Components.DisableMultiCloudGateway {
+		return nil
+	}
 
-	nb := r.newNooBaaSystem(sc, reqLogger)
+	nb, err := r.newNooBaaSystem(sc, reqLogger)
+	if err != nil {
+		return err
+	}
 
 	cephClusterCreated := false
 
-	err := controllerutil.SetControllerReference(sc, nb, r.scheme)
+	err = controllerutil.SetControllerReference(sc, nb, r.scheme)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	// find cephCluster
@@ -54,10 +65,10 @@ func (r *ReconcileStorageCluster) ensureNoobaaSystem(sc *ocsv1.StorageCluster, r
 		if errors.IsNotFound(err) {
 
 			if cephClusterCreated {
-				// Arbitrary sleep to hopefully allow the
-				// CephCluster time to quiesce.
-				// TODO: Remove this
-				time.Sleep(5 * time.Second)
+				if r.getNoobaaCreateWait(foundCeph, time.Now()) > 0 {
+					reqLogger.Info("Waiting on ceph cluster to quiesce before starting noobaa")
+					return nil
+				}
 				// noobaa system not found - create one
 				reqLogger.Info("Creating NooBaa system")
 				err := r.client.Create(context.TODO(), nb)
@@ -93,16 +104,49 @@ func (r *ReconcileStorageCluster) ensureNoobaaSystem(sc *ocsv1.StorageCluster, r
 		objectreferencesv1.SetObjectReference(&sc.Status.RelatedObjects, *objectRef)
 	}
 
-	statusutil.MapNoobaaNegativeConditions(&r.conditions, found)
-
 	return nil
 }
 
-func (r *ReconcileStorageCluster) newNooBaaSystem(sc *ocsv1.StorageCluster, reqLogger logr.Logger) *nbv1.NooBaa {
-	storageClassName := generateNameForCephBlockPoolSC(sc)
-	coreResources := defaults.GetDaemonResources("noobaa-core", sc.Spec.Resources)
-	dbResources := defaults.GetDaemonResources("noobaa-db", sc.Spec.Resources)
-	dBVolumeResources := defaults.GetDaemonResources("noobaa-db-vol", sc.Spec.Resources)
+// getNoobaaCreateWait returns how long the CephCluster is still given to
+// quiesce after its creation before the NooBaa system is created
+func (r *ReconcileStorageCluster) getNoobaaCreateWait(cephCluster *cephv1.CephCluster, now time.Time) time.Duration {
+	after := cephCluster.CreationTimestamp.Add(r.noobaaCreateDelay).Sub(now)
+	if after <= 0 {
+		return 0
+	}
+	return after
+}
+
+// getNoobaaCreateRequeueAfter returns how long until the NooBaa system may be
+// created, or zero if it is not waiting on the CephCluster to quiesce
+func (r *ReconcileStorageCluster) getNoobaaCreateRequeueAfter(sc *ocsv1.StorageCluster, now time.Time) time.Duration {
+	if sc.Spec.Components.DisableMultiCloudGateway {
+		return 0
+	}
+
+	cephCluster := &cephv1.CephCluster{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, cephCluster)
+	if err != nil || cephCluster.Status.State != cephv1.ClusterStateCreated {
+		return 0
+	}
+
+	nb := &nbv1.NooBaa{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: "noobaa", Namespace: sc.Namespace}, nb)
+	if !errors.IsNotFound(err) {
+		return 0
+	}
+
+	return r.getNoobaaCreateWait(cephCluster, now)
+}
+
+func (r *ReconcileStorageCluster) newNooBaaSystem(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (*nbv1.NooBaa, error) {
+	storageClassName, err := r.getClusterScopedName(sc, &storagev1.StorageClass{}, generateNameForCephBlockPoolSC(sc))
+	if err != nil {
+		return nil, err
+	}
+	coreResources := defaults.GetDaemonResources("noobaa-core", getResourceProfile(sc), sc.Spec.Resources)
+	dbResources := defaults.GetDaemonResources("noobaa-db", getResourceProfile(sc), sc.Spec.Resources)
+	dBVolumeResources := defaults.GetDaemonResources("noobaa-db-vol", getResourceProfile(sc), sc.Spec.Resources)
 	nb := &nbv1.NooBaa{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "noobaa",
@@ -126,7 +170,7 @@ func (r *ReconcileStorageCluster) newNooBaaSystem(sc *ocsv1.StorageCluster, reqL
 	nb.Spec.Image = &r.noobaaCoreImage
 	nb.Spec.DBImage = &r.noobaaDBImage
 
-	return nb
+	return nb, nil
 }
 
 // Delete noobaa system in the namespace
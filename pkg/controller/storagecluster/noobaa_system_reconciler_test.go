@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
 	v1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/stretchr/testify/assert"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -118,6 +120,90 @@ func TestEnsureNooBaaSystem(t *testing.T) {
 	}
 }
 
+func TestEnsureNooBaaSystemDisabled(t *testing.T) {
+	namespacedName := types.NamespacedName{
+		Name:      "noobaa",
+		Namespace: "test_ns",
+	}
+	sc := v1.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespacedName.Name,
+			Namespace: namespacedName.Namespace,
+		},
+		Spec: v1.StorageClusterSpec{
+			Components: v1.ComponentsSpec{DisableMultiCloudGateway: true},
+		},
+	}
+	cephCluster := cephv1.CephCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephClusterFromString(namespacedName.Name),
+			Namespace: namespacedName.Namespace,
+		},
+	}
+	cephCluster.Status.State = cephv1.ClusterStateCreated
+
+	reconciler := getReconciler(t, &v1alpha1.NooBaa{})
+	reconciler.client.Create(context.TODO(), &cephCluster)
+
+	err := reconciler.ensureNoobaaSystem(&sc, nooBaaReconcileTestLogger)
+	assert.NoError(t, err)
+
+	noobaa := v1alpha1.NooBaa{}
+	err = reconciler.client.Get(context.TODO(), namespacedName, &noobaa)
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestEnsureNooBaaSystemCreateDelay(t *testing.T) {
+	namespacedName := types.NamespacedName{
+		Name:      "noobaa",
+		Namespace: "test_ns",
+	}
+	sc := v1.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespacedName.Name,
+			Namespace: namespacedName.Namespace,
+		},
+	}
+	cephCluster := cephv1.CephCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephClusterFromString(namespacedName.Name),
+			Namespace: namespacedName.Namespace,
+		},
+	}
+	cephCluster.Status.State = cephv1.ClusterStateCreated
+	// Timestamps are kept to the second
+	createdTime := time.Now().Truncate(time.Second)
+	cephCluster.CreationTimestamp = metav1.Time{Time: createdTime}
+
+	reconciler := getReconciler(t, &v1alpha1.NooBaa{})
+	reconciler.noobaaCreateDelay = time.Minute
+	reconciler.client.Create(context.TODO(), &cephCluster)
+
+	// The NooBaa system waits for the CephCluster to quiesce, and the
+	// reconcile is requeued for when it may be created
+	err := reconciler.ensureNoobaaSystem(&sc, nooBaaReconcileTestLogger)
+	assert.NoError(t, err)
+	noobaa := v1alpha1.NooBaa{}
+	err = reconciler.client.Get(context.TODO(), namespacedName, &noobaa)
+	assert.True(t, errors.IsNotFound(err))
+	requeueAfter := reconciler.getNoobaaCreateRequeueAfter(&sc, createdTime)
+	assert.Equal(t, time.Minute, requeueAfter)
+
+	// Once the delay after the creation of the CephCluster is over the
+	// NooBaa system is created
+	cephCluster.CreationTimestamp = metav1.Time{Time: createdTime.Add(-time.Minute)}
+	err = reconciler.client.Update(context.TODO(), &cephCluster)
+	assert.NoError(t, err)
+	assert.Zero(t, reconciler.getNoobaaCreateRequeueAfter(&sc, time.Now()))
+	err = reconciler.ensureNoobaaSystem(&sc, nooBaaReconcileTestLogger)
+	assert.NoError(t, err)
+	err = reconciler.client.Get(context.TODO(), namespacedName, &noobaa)
+	assert.NoError(t, err)
+
+	// The NooBaa system is no longer waited for
+	assert.Zero(t, reconciler.getNoobaaCreateRequeueAfter(&sc, createdTime))
+}
+
 func TestNewNooBaaSystem(t *testing.T) {
 	defaultInput := v1.StorageCluster{
 		ObjectMeta: metav1.ObjectMeta{
@@ -167,9 +253,10 @@ func TestNewNooBaaSystem(t *testing.T) {
 			assert.Failf(t, "[%s] unable to set env_var %s", c.label, dbEnvVar)
 		}
 
-		reconciler := ReconcileStorageCluster{}
+		reconciler := createFakeStorageClusterReconciler(t)
 		reconciler.initializeImageVars()
-		nooBaa := reconciler.newNooBaaSystem(&c.sc, nooBaaReconcileTestLogger)
+		nooBaa, err := reconciler.newNooBaaSystem(&c.sc, nooBaaReconcileTestLogger)
+		assert.NoErrorf(t, err, "[%s] failed to generate noobaa", c.label)
 
 		assert.Equalf(t, nooBaa.Name, "noobaa", "[%s] noobaa name not set correctly", c.label)
 		assert.NotEmptyf(t, nooBaa.Labels, "[%s] expected noobaa Labels not found", c.label)
@@ -190,20 +277,27 @@ func TestNewNooBaaSystem(t *testing.T) {
 func getReconciler(t *testing.T, objs ...runtime.Object) ReconcileStorageCluster {
 	registerObjs := []runtime.Object{&v1.StorageCluster{}}
 	registerObjs = append(registerObjs, objs...)
-	v1.SchemeBuilder.Register(registerObjs...)
 
 	scheme, err := v1.SchemeBuilder.Build()
 	if err != nil {
 		assert.Fail(t, "unable to build scheme")
 	}
+	// The objects are registered with this scheme only, as registering them
+	// with the SchemeBuilder would leak into the schemes of other tests
+	scheme.AddKnownTypes(v1.SchemeGroupVersion, registerObjs...)
 	err = cephv1.AddToScheme(scheme)
 	if err != nil {
 		assert.Fail(t, "failed to add rookCephv1 scheme")
 	}
+	err = storagev1.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add storagev1 scheme")
+	}
 	client := fake.NewFakeClientWithScheme(scheme, registerObjs...)
 
 	return ReconcileStorageCluster{
 		scheme: scheme,
 		client: client,
+		locks:  newKeyedLocks(),
 	}
 }
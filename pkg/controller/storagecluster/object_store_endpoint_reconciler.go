@@ -0,0 +1,537 @@
+package storagecluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var routeGroupVersion = schema.GroupVersion{Group: "route.openshift.io", Version: "v1"}
+
+const (
+	// servingCertAnnotation has the service-serving CA issue a certificate
+	// for a Service into the named Secret
+	servingCertAnnotation = "service.beta.openshift.io/serving-cert-secret-name"
+	// rgwCertHashAnnotation is set on the gateway pods so that they are
+	// restarted whenever their certificate is rotated
+	rgwCertHashAnnotation = "ocs.openshift.io/rgw-certificate-hash"
+	// rgwCertKey is the key of the combined certificate and private key in
+	// the Secret referenced by Gateway.SSLCertificateRef
+	rgwCertKey = "cert"
+	// rgwSecurePort is the port the gateway serves HTTPS on
+	rgwSecurePort = 443
+)
+
+// ensureObjectStoreEndpoint ensures that the gateway of the CephObjectStore
+// serves the configured certificate, that the S3 endpoint is exposed as
+// configured and that its external URL is published in the status
+func (r *ReconcileStorageCluster) ensureObjectStoreEndpoint(sc *ocsv1.StorageCluster, state *reconcileState, reqLogger logr.Logger) error {
+	if sc.Spec.Components.DisableObjectStore {
+		err := r.deleteObjectStoreRoute(sc, reqLogger)
+		if err != nil {
+			return err
+		}
+		err = r.deleteObjectStoreIngress(sc, reqLogger)
+		if err != nil {
+			return err
+		}
+		err = r.deleteObjectStoreCertificate(sc, reqLogger)
+		if err != nil {
+			return err
+		}
+		sc.Status.ObjectStoreEndpoint = ""
+		return nil
+	}
+
+	store := &cephv1.CephObjectStore{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, store)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			reqLogger.Info("CephObjectStore not found, not configuring its endpoint yet")
+			return nil
+		}
+		return err
+	}
+
+	certHash, err := r.ensureObjectStoreCertificate(sc, state, reqLogger)
+	if err != nil {
+		return err
+	}
+
+	gateway := store.Spec.Gateway.DeepCopy()
+	certSecretName := generateNameForCephRgwCertSecret(sc)
+	if certHash != "" {
+		gateway.SecurePort = rgwSecurePort
+		gateway.SSLCertificateRef = certSecretName
+		if gateway.Annotations == nil {
+			gateway.Annotations = map[string]string{}
+		}
+		gateway.Annotations[rgwCertHashAnnotation] = certHash
+	} else if gateway.SSLCertificateRef == certSecretName {
+		gateway.SecurePort = 0
+		gateway.SSLCertificateRef = ""
+		delete(gateway.Annotations, rgwCertHashAnnotation)
+	}
+	if !reflect.DeepEqual(*gateway, store.Spec.Gateway) {
+		reqLogger.Info(fmt.Sprintf("Updating gateway of CephObjectStore %s", store.Name))
+		store.Spec.Gateway = *gateway
+		err = r.client.Update(context.TODO(), store)
+		if err != nil {
+			return err
+		}
+	}
+
+	endpoint, err := r.ensureObjectStoreExposure(sc, state, certHash != "", reqLogger)
+	if err != nil {
+		return err
+	}
+	sc.Status.ObjectStoreEndpoint = endpoint
+
+	return nil
+}
+
+// ensureObjectStoreCertificate copies the configured certificate into the
+// Secret format Rook expects. It returns a hash of the certificate, or an
+// empty string if TLS is disabled or the certificate is not available yet.
+func (r *ReconcileStorageCluster) ensureObjectStoreCertificate(sc *ocsv1.StorageCluster, state *reconcileState, reqLogger logr.Logger) (string, error) {
+	certSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephRgwCertSecret(sc),
+			Namespace: sc.Namespace,
+		},
+	}
+
+	tls := sc.Spec.ObjectStore.TLS
+	if tls == nil {
+		return "", r.deleteObjectStoreCertificate(sc, reqLogger)
+	}
+
+	sourceName, err := r.getObjectStoreCertificateSource(sc, state, reqLogger)
+	if err != nil || sourceName == "" {
+		return "", err
+	}
+
+	source := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sourceName, Namespace: sc.Namespace}, source)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		message := fmt.Sprintf("Certificate Secret %s of the object store not found", sourceName)
+		reqLogger.Info(message)
+		if tls.Source == ocsv1.CertificateSourceSecret {
+			statusutil.MapInvalidObjectStoreEndpoint(&state.conditions, "ObjectStoreCertificateNotFound", message)
+		} else {
+			statusutil.MapObjectStoreEndpointPending(&state.conditions, "ObjectStoreCertificatePending", message)
+		}
+		return "", nil
+	}
+
+	crt := source.Data[corev1.TLSCertKey]
+	key := source.Data[corev1.TLSPrivateKeyKey]
+	if len(crt) == 0 || len(key) == 0 {
+		message := fmt.Sprintf("Certificate Secret %s of the object store must have both %s and %s", sourceName, corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		reqLogger.Info(message)
+		statusutil.MapInvalidObjectStoreEndpoint(&state.conditions, "ObjectStoreCertificateInvalid", message)
+		return "", nil
+	}
+
+	// The gateway reads its certificate and private key from a single
+	// PEM file
+	pem := bytes.Join([][]byte{bytes.TrimSpace(crt), bytes.TrimSpace(key)}, []byte("\n"))
+	pem = append(pem, '\n')
+	certSecret.Data = map[string][]byte{rgwCertKey: pem}
+	err = controllerutil.SetControllerReference(sc, certSecret, r.scheme)
+	if err != nil {
+		return "", err
+	}
+
+	found := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: certSecret.Name, Namespace: certSecret.Namespace}, found)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		reqLogger.Info(fmt.Sprintf("Creating certificate Secret %s", certSecret.Name))
+		err = r.client.Create(context.TODO(), certSecret)
+		if err != nil {
+			return "", err
+		}
+	} else if !reflect.DeepEqual(certSecret.Data, found.Data) {
+		reqLogger.Info(fmt.Sprintf("Updating certificate Secret %s", certSecret.Name))
+		found.Data = certSecret.Data
+		err = r.client.Update(context.TODO(), found)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(pem))[:16], nil
+}
+
+// getObjectStoreCertificateSource returns the name of the kubernetes.io/tls
+// Secret the certificate of the object store is read from. When the
+// service-serving CA is used, the RGW Service is annotated to have it issue
+// the certificate. It returns an empty string if there is no Secret to read
+// yet.
+func (r *ReconcileStorageCluster) getObjectStoreCertificateSource(sc *ocsv1.StorageCluster, state *reconcileState, reqLogger logr.Logger) (string, error) {
+	tls := sc.Spec.ObjectStore.TLS
+
+	switch tls.Source {
+	case ocsv1.CertificateSourceSecret:
+		if tls.SecretName == "" {
+			message := "The TLS secretName of the object store must be set when its source is Secret"
+			reqLogger.Info(message)
+			statusutil.MapInvalidObjectStoreEndpoint(&state.conditions, "ObjectStoreCertificateInvalid", message)
+			return "", nil
+		}
+		return tls.SecretName, nil
+	case "", ocsv1.CertificateSourceServiceServingCA:
+	default:
+		message := fmt.Sprintf("Unsupported TLS source %q for the object store", tls.Source)
+		reqLogger.Info(message)
+		statusutil.MapInvalidObjectStoreEndpoint(&state.conditions, "ObjectStoreCertificateInvalid", message)
+		return "", nil
+	}
+
+	secretName := generateNameForCephRgwServingCertSecret(sc)
+	service := &corev1.Service{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephRgwService(sc), Namespace: sc.Namespace}, service)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		message := fmt.Sprintf("Service %s of the object store not found", generateNameForCephRgwService(sc))
+		reqLogger.Info(message)
+		statusutil.MapObjectStoreEndpointPending(&state.conditions, "ObjectStoreServiceNotFound", message)
+		return "", nil
+	}
+
+	// Rook may rewrite the Service, so the annotation is checked on every
+	// reconcile
+	if service.Annotations[servingCertAnnotation] != secretName {
+		reqLogger.Info(fmt.Sprintf("Requesting a serving certificate for Service %s", service.Name))
+		if service.Annotations == nil {
+			service.Annotations = map[string]string{}
+		}
+		service.Annotations[servingCertAnnotation] = secretName
+		err = r.client.Update(context.TODO(), service)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return secretName, nil
+}
+
+// ensureObjectStoreExposure ensures that the configured Route or Ingress for
+// the S3 endpoint exists and that no other one does. It returns the external
+// URL of the endpoint, or an empty string if it is not exposed (yet).
+func (r *ReconcileStorageCluster) ensureObjectStoreExposure(sc *ocsv1.StorageCluster, state *reconcileState, secure bool, reqLogger logr.Logger) (string, error) {
+	exposure := sc.Spec.ObjectStore.Exposure
+	exposureType := ocsv1.ExposureType("")
+	if exposure != nil {
+		exposureType = exposure.Type
+		if exposureType == "" {
+			exposureType = ocsv1.ExposureRoute
+		}
+	}
+
+	if exposureType != ocsv1.ExposureRoute {
+		err := r.deleteObjectStoreRoute(sc, reqLogger)
+		if err != nil {
+			return "", err
+		}
+	}
+	if exposureType != ocsv1.ExposureIngress {
+		err := r.deleteObjectStoreIngress(sc, reqLogger)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch exposureType {
+	case "":
+		return "", nil
+	case ocsv1.ExposureRoute:
+		return r.ensureObjectStoreRoute(sc, state, secure, reqLogger)
+	case ocsv1.ExposureIngress:
+		return r.ensureObjectStoreIngress(sc, state, reqLogger)
+	default:
+		message := fmt.Sprintf("Unsupported exposure type %q for the object store", exposureType)
+		reqLogger.Info(message)
+		statusutil.MapInvalidObjectStoreEndpoint(&state.conditions, "ObjectStoreExposureInvalid", message)
+		return "", nil
+	}
+}
+
+// ensureObjectStoreRoute ensures that the S3 endpoint is exposed by an
+// OpenShift Route. Certificates of the service-serving CA are trusted by the
+// router, so the Route re-encrypts to them. A user-supplied certificate is
+// passed through to the client as is.
+func (r *ReconcileStorageCluster) ensureObjectStoreRoute(sc *ocsv1.StorageCluster, state *reconcileState, secure bool, reqLogger logr.Logger) (string, error) {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGroupVersion.WithKind("Route"))
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, route)
+	switch {
+	case err == nil:
+	case errors.IsNotFound(err):
+		route.SetName(generateNameForCephObjectStore(sc))
+		route.SetNamespace(sc.Namespace)
+	case meta.IsNoMatchError(err):
+		message := "The Route API is not available, use an Ingress to expose the object store"
+		reqLogger.Info(message)
+		statusutil.MapInvalidObjectStoreEndpoint(&state.conditions, "ObjectStoreExposureInvalid", message)
+		return "", nil
+	default:
+		return "", err
+	}
+
+	desired := route.DeepCopy()
+	err = controllerutil.SetControllerReference(sc, desired, r.scheme)
+	if err != nil {
+		return "", err
+	}
+	// Only the fields owned by the operator are set, so that the host
+	// picked by the router and the defaults of the API are kept
+	if hostname := sc.Spec.ObjectStore.Exposure.Hostname; hostname != "" {
+		err = unstructured.SetNestedField(desired.Object, hostname, "spec", "host")
+		if err != nil {
+			return "", err
+		}
+	}
+	err = unstructured.SetNestedField(desired.Object, "Service", "spec", "to", "kind")
+	if err != nil {
+		return "", err
+	}
+	err = unstructured.SetNestedField(desired.Object, generateNameForCephRgwService(sc), "spec", "to", "name")
+	if err != nil {
+		return "", err
+	}
+	if secure {
+		termination := "passthrough"
+		if sc.Spec.ObjectStore.TLS.Source != ocsv1.CertificateSourceSecret {
+			termination = "reencrypt"
+		}
+		err = unstructured.SetNestedField(desired.Object, "https", "spec", "port", "targetPort")
+		if err != nil {
+			return "", err
+		}
+		err = unstructured.SetNestedField(desired.Object, termination, "spec", "tls", "termination")
+		if err != nil {
+			return "", err
+		}
+	} else {
+		err = unstructured.SetNestedField(desired.Object, "http", "spec", "port", "targetPort")
+		if err != nil {
+			return "", err
+		}
+		unstructured.RemoveNestedField(desired.Object, "spec", "tls")
+	}
+
+	if route.GetResourceVersion() == "" {
+		reqLogger.Info(fmt.Sprintf("Creating Route %s", desired.GetName()))
+		err = r.client.Create(context.TODO(), desired)
+		if err != nil {
+			return "", err
+		}
+	} else if !reflect.DeepEqual(desired.Object, route.Object) {
+		reqLogger.Info(fmt.Sprintf("Updating Route %s", desired.GetName()))
+		err = r.client.Update(context.TODO(), desired)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	host, _, _ := unstructured.NestedString(desired.Object, "spec", "host")
+	if host == "" {
+		message := fmt.Sprintf("Waiting for the router to assign a host to Route %s", desired.GetName())
+		reqLogger.Info(message)
+		statusutil.MapObjectStoreEndpointPending(&state.conditions, "ObjectStoreRoutePending", message)
+		return "", nil
+	}
+	if secure {
+		return fmt.Sprintf("https://%s", host), nil
+	}
+	return fmt.Sprintf("http://%s", host), nil
+}
+
+// ensureObjectStoreIngress ensures that the S3 endpoint is exposed by an
+// Ingress. The Ingress terminates TLS with a user-supplied certificate and
+// forwards plain HTTP to the gateway.
+func (r *ReconcileStorageCluster) ensureObjectStoreIngress(sc *ocsv1.StorageCluster, state *reconcileState, reqLogger logr.Logger) (string, error) {
+	hostname := sc.Spec.ObjectStore.Exposure.Hostname
+	if hostname == "" {
+		message := "The hostname of the object store must be set to expose it with an Ingress"
+		reqLogger.Info(message)
+		statusutil.MapInvalidObjectStoreEndpoint(&state.conditions, "ObjectStoreExposureInvalid", message)
+		return "", nil
+	}
+
+	ingress := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephObjectStore(sc),
+			Namespace: sc.Namespace,
+		},
+		Spec: networkingv1beta1.IngressSpec{
+			Rules: []networkingv1beta1.IngressRule{
+				{
+					Host: hostname,
+					IngressRuleValue: networkingv1beta1.IngressRuleValue{
+						HTTP: &networkingv1beta1.HTTPIngressRuleValue{
+							Paths: []networkingv1beta1.HTTPIngressPath{
+								{
+									Backend: networkingv1beta1.IngressBackend{
+										ServiceName: generateNameForCephRgwService(sc),
+										ServicePort: intstr.FromString("http"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	tls := sc.Spec.ObjectStore.TLS
+	secure := tls != nil && tls.Source == ocsv1.CertificateSourceSecret && tls.SecretName != ""
+	if secure {
+		ingress.Spec.TLS = []networkingv1beta1.IngressTLS{
+			{
+				Hosts:      []string{hostname},
+				SecretName: tls.SecretName,
+			},
+		}
+	}
+	err := controllerutil.SetControllerReference(sc, ingress, r.scheme)
+	if err != nil {
+		return "", err
+	}
+
+	found := &networkingv1beta1.Ingress{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: ingress.Name, Namespace: ingress.Namespace}, found)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return "", err
+		}
+		reqLogger.Info(fmt.Sprintf("Creating Ingress %s", ingress.Name))
+		err = r.client.Create(context.TODO(), ingress)
+		if err != nil {
+			return "", err
+		}
+	} else if !reflect.DeepEqual(ingress.Spec, found.Spec) {
+		reqLogger.Info(fmt.Sprintf("Updating Ingress %s", ingress.Name))
+		found.Spec = ingress.Spec
+		err = r.client.Update(context.TODO(), found)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if secure {
+		return fmt.Sprintf("https://%s", hostname), nil
+	}
+	return fmt.Sprintf("http://%s", hostname), nil
+}
+
+// deleteObjectStoreCertificate deletes the certificate Secret of the gateway,
+// if any
+func (r *ReconcileStorageCluster) deleteObjectStoreCertificate(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	return r.deleteControlledObject(sc, &corev1.Secret{}, "certificate Secret", generateNameForCephRgwCertSecret(sc), reqLogger)
+}
+
+// deleteObjectStoreRoute deletes the Route of the S3 endpoint, if any
+func (r *ReconcileStorageCluster) deleteObjectStoreRoute(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGroupVersion.WithKind("Route"))
+	return r.deleteControlledObject(sc, route, "Route", generateNameForCephObjectStore(sc), reqLogger)
+}
+
+// deleteObjectStoreIngress deletes the Ingress of the S3 endpoint, if any
+func (r *ReconcileStorageCluster) deleteObjectStoreIngress(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	return r.deleteControlledObject(sc, &networkingv1beta1.Ingress{}, "Ingress", generateNameForCephObjectStore(sc), reqLogger)
+}
+
+// deleteControlledObject deletes the named object of the namespace of the
+// StorageCluster if it is controlled by the StorageCluster. An object of
+// that name created by someone else is left alone.
+func (r *ReconcileStorageCluster) deleteControlledObject(sc *ocsv1.StorageCluster, obj runtime.Object, kind, name string, reqLogger logr.Logger) error {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: sc.Namespace}, obj)
+	if err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil
+		}
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if !metav1.IsControlledBy(accessor, sc) {
+		reqLogger.Info(fmt.Sprintf("%s %s is not owned by the StorageCluster, not deleting it", kind, name))
+		return nil
+	}
+
+	err = r.client.Delete(context.TODO(), obj)
+	switch {
+	case err == nil:
+		reqLogger.Info(fmt.Sprintf("Deleted %s %s", kind, name))
+	case errors.IsNotFound(err):
+	default:
+		return err
+	}
+	return nil
+}
+
+// objectStoreSecretMapper enqueues the StorageClusters whose object store
+// certificate is read from a Secret, so that rotated certificates are
+// picked up
+type objectStoreSecretMapper struct {
+	client client.Client
+}
+
+// Map implements handler.Mapper
+func (m *objectStoreSecretMapper) Map(obj handler.MapObject) []reconcile.Request {
+	scs := &ocsv1.StorageClusterList{}
+	err := m.client.List(context.TODO(), scs, client.InNamespace(obj.Meta.GetNamespace()))
+	if err != nil {
+		log.Error(err, "Failed to list StorageClusters")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, sc := range scs.Items {
+		tls := sc.Spec.ObjectStore.TLS
+		if tls == nil {
+			continue
+		}
+		name := obj.Meta.GetName()
+		if name == tls.SecretName || name == generateNameForCephRgwServingCertSecret(&sc) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace},
+			})
+		}
+	}
+	return requests
+}
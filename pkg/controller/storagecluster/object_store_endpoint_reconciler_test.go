@@ -0,0 +1,343 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func getObjectStoreEndpointObjects(sc *api.StorageCluster) (*cephv1.CephObjectStore, *corev1.Service) {
+	store := &cephv1.CephObjectStore{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephObjectStore(sc),
+			Namespace: sc.Namespace,
+		},
+		Spec: cephv1.ObjectStoreSpec{
+			Gateway: cephv1.GatewaySpec{
+				Port:      80,
+				Instances: 1,
+			},
+		},
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephRgwService(sc),
+			Namespace: sc.Namespace,
+		},
+	}
+	return store, service
+}
+
+func newTLSSecret(name, namespace, crt, key string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(crt),
+			corev1.TLSPrivateKeyKey: []byte(key),
+		},
+	}
+}
+
+func getCephObjectStore(t *testing.T, reconciler ReconcileStorageCluster, sc *api.StorageCluster) *cephv1.CephObjectStore {
+	store := &cephv1.CephObjectStore{}
+	err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, store)
+	assert.NoError(t, err)
+	return store
+}
+
+func TestObjectStoreServingCertificate(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ObjectStore.TLS = &api.ObjectStoreTLSSpec{}
+	store, service := getObjectStoreEndpointObjects(sc)
+	reconciler := createFakeStorageClusterReconciler(t, sc, store, service)
+	state := &reconcileState{}
+
+	// The service-serving CA has not issued the certificate yet
+	err := reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: service.Name, Namespace: service.Namespace}, service)
+	assert.NoError(t, err)
+	assert.Equal(t, generateNameForCephRgwServingCertSecret(sc), service.Annotations[servingCertAnnotation])
+	condition := conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionProgressing)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, "ObjectStoreCertificatePending", condition.Reason)
+	}
+	assert.Equal(t, "", getCephObjectStore(t, reconciler, sc).Spec.Gateway.SSLCertificateRef)
+
+	servingCert := newTLSSecret(generateNameForCephRgwServingCertSecret(sc), sc.Namespace, "CRT\n", "KEY\n")
+	err = reconciler.client.Create(context.TODO(), servingCert)
+	assert.NoError(t, err)
+	state = &reconcileState{}
+
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Nil(t, state.conditions)
+
+	certSecret := &corev1.Secret{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephRgwCertSecret(sc), Namespace: sc.Namespace}, certSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "CRT\nKEY\n", string(certSecret.Data[rgwCertKey]))
+
+	gateway := getCephObjectStore(t, reconciler, sc).Spec.Gateway
+	assert.Equal(t, int32(rgwSecurePort), gateway.SecurePort)
+	assert.Equal(t, certSecret.Name, gateway.SSLCertificateRef)
+	hash := gateway.Annotations[rgwCertHashAnnotation]
+	assert.NotEmpty(t, hash)
+
+	// A rotated certificate restarts the gateway with the new one
+	servingCert.Data[corev1.TLSCertKey] = []byte("NEWCRT\n")
+	err = reconciler.client.Update(context.TODO(), servingCert)
+	assert.NoError(t, err)
+
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: certSecret.Name, Namespace: certSecret.Namespace}, certSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, "NEWCRT\nKEY\n", string(certSecret.Data[rgwCertKey]))
+	gateway = getCephObjectStore(t, reconciler, sc).Spec.Gateway
+	assert.NotEqual(t, hash, gateway.Annotations[rgwCertHashAnnotation])
+
+	// Disabling TLS reverts the gateway to plain HTTP
+	sc.Spec.ObjectStore.TLS = nil
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	gateway = getCephObjectStore(t, reconciler, sc).Spec.Gateway
+	assert.Equal(t, int32(0), gateway.SecurePort)
+	assert.Equal(t, "", gateway.SSLCertificateRef)
+	assert.NotContains(t, gateway.Annotations, rgwCertHashAnnotation)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: certSecret.Name, Namespace: certSecret.Namespace}, certSecret)
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestObjectStoreUserCertificate(t *testing.T) {
+	cases := []struct {
+		label          string
+		tls            api.ObjectStoreTLSSpec
+		secret         *corev1.Secret
+		expectedReason string
+	}{
+		{
+			label:          "secretName is not set",
+			tls:            api.ObjectStoreTLSSpec{Source: api.CertificateSourceSecret},
+			expectedReason: "ObjectStoreCertificateInvalid",
+		},
+		{
+			label:          "Secret does not exist",
+			tls:            api.ObjectStoreTLSSpec{Source: api.CertificateSourceSecret, SecretName: "s3-cert"},
+			expectedReason: "ObjectStoreCertificateNotFound",
+		},
+		{
+			label:          "Secret has no private key",
+			tls:            api.ObjectStoreTLSSpec{Source: api.CertificateSourceSecret, SecretName: "s3-cert"},
+			secret:         newTLSSecret("s3-cert", mockStorageCluster.Namespace, "CRT", ""),
+			expectedReason: "ObjectStoreCertificateInvalid",
+		},
+		{
+			label:  "Secret is valid",
+			tls:    api.ObjectStoreTLSSpec{Source: api.CertificateSourceSecret, SecretName: "s3-cert"},
+			secret: newTLSSecret("s3-cert", mockStorageCluster.Namespace, "CRT", "KEY"),
+		},
+	}
+
+	for _, c := range cases {
+		sc := mockStorageCluster.DeepCopy()
+		sc.Spec.ObjectStore.TLS = &c.tls
+		store, _ := getObjectStoreEndpointObjects(sc)
+		objects := []runtime.Object{sc, store}
+		if c.secret != nil {
+			objects = append(objects, c.secret)
+		}
+		reconciler := createFakeStorageClusterReconciler(t, objects...)
+		state := &reconcileState{}
+
+		err := reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+		assert.NoError(t, err, c.label)
+
+		gateway := getCephObjectStore(t, reconciler, sc).Spec.Gateway
+		condition := conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionDegraded)
+		if c.expectedReason == "" {
+			assert.Nil(t, condition, c.label)
+			assert.Equal(t, generateNameForCephRgwCertSecret(sc), gateway.SSLCertificateRef, c.label)
+			continue
+		}
+		if assert.NotNil(t, condition, c.label) {
+			assert.Equal(t, c.expectedReason, condition.Reason, c.label)
+		}
+		assert.Equal(t, "", gateway.SSLCertificateRef, c.label)
+	}
+}
+
+func TestObjectStoreExposure(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ObjectStore.TLS = &api.ObjectStoreTLSSpec{}
+	sc.Spec.ObjectStore.Exposure = &api.ObjectStoreExposureSpec{Hostname: "s3.example.com"}
+	store, service := getObjectStoreEndpointObjects(sc)
+	servingCert := newTLSSecret(generateNameForCephRgwServingCertSecret(sc), sc.Namespace, "CRT", "KEY")
+	reconciler := createFakeStorageClusterReconciler(t, sc, store, service, servingCert)
+	state := &reconcileState{}
+
+	err := reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://s3.example.com", sc.Status.ObjectStoreEndpoint)
+
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGroupVersion.WithKind("Route"))
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, route)
+	assert.NoError(t, err)
+	to, _, _ := unstructured.NestedString(route.Object, "spec", "to", "name")
+	assert.Equal(t, service.Name, to)
+	termination, _, _ := unstructured.NestedString(route.Object, "spec", "tls", "termination")
+	assert.Equal(t, "reencrypt", termination)
+	assert.Len(t, route.GetOwnerReferences(), 1)
+
+	// Without a hostname the endpoint is pending until the router picks one
+	sc.Spec.ObjectStore.Exposure.Hostname = ""
+	err = reconciler.client.Delete(context.TODO(), route)
+	assert.NoError(t, err)
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "", sc.Status.ObjectStoreEndpoint)
+	condition := conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionProgressing)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, "ObjectStoreRoutePending", condition.Reason)
+	}
+
+	// An Ingress needs a hostname
+	state = &reconcileState{}
+	sc.Spec.ObjectStore.Exposure.Type = api.ExposureIngress
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	condition = conditionsv1.FindStatusCondition(state.conditions, conditionsv1.ConditionDegraded)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, "ObjectStoreExposureInvalid", condition.Reason)
+	}
+
+	// Switching to an Ingress removes the Route
+	sc.Spec.ObjectStore.Exposure.Hostname = "s3.example.com"
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://s3.example.com", sc.Status.ObjectStoreEndpoint)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, route)
+	assert.True(t, errors.IsNotFound(err))
+	ingress := &networkingv1beta1.Ingress{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, ingress)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3.example.com", ingress.Spec.Rules[0].Host)
+
+	// Removing the exposure removes the Ingress and the endpoint
+	sc.Spec.ObjectStore.Exposure = nil
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "", sc.Status.ObjectStoreEndpoint)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, ingress)
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestObjectStoreDisabled(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ObjectStore.TLS = &api.ObjectStoreTLSSpec{}
+	sc.Spec.ObjectStore.Exposure = &api.ObjectStoreExposureSpec{Hostname: "s3.example.com"}
+	store, service := getObjectStoreEndpointObjects(sc)
+	servingCert := newTLSSecret(generateNameForCephRgwServingCertSecret(sc), sc.Namespace, "CRT", "KEY")
+	reconciler := createFakeStorageClusterReconciler(t, sc, store, service, servingCert)
+	state := &reconcileState{}
+
+	err := reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://s3.example.com", sc.Status.ObjectStoreEndpoint)
+
+	// Disabling the object store removes its Route and certificate
+	sc.Spec.Components.DisableObjectStore = true
+	err = reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "", sc.Status.ObjectStoreEndpoint)
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGroupVersion.WithKind("Route"))
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephObjectStore(sc), Namespace: sc.Namespace}, route)
+	assert.True(t, errors.IsNotFound(err))
+	certSecret := &corev1.Secret{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephRgwCertSecret(sc), Namespace: sc.Namespace}, certSecret)
+	assert.True(t, errors.IsNotFound(err))
+}
+
+func TestObjectStoreCertificateNotOwned(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.UID = "storagecluster-uid"
+	store, _ := getObjectStoreEndpointObjects(sc)
+	certSecret := newTLSSecret(generateNameForCephRgwCertSecret(sc), sc.Namespace, "CRT", "KEY")
+	reconciler := createFakeStorageClusterReconciler(t, sc, store, certSecret)
+	state := &reconcileState{}
+
+	// A Secret of the same name created by someone else is kept
+	err := reconciler.ensureObjectStoreEndpoint(sc, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: certSecret.Name, Namespace: certSecret.Namespace}, certSecret)
+	assert.NoError(t, err)
+}
+
+func TestObjectStoreExposureNotOwned(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.UID = "storagecluster-uid"
+	store, service := getObjectStoreEndpointObjects(sc)
+	ingress := &networkingv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCephObjectStore(sc),
+			Namespace: sc.Namespace,
+		},
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, store, service, ingress)
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(routeGroupVersion.WithKind("Route"))
+	route.SetName(generateNameForCephObjectStore(sc))
+	route.SetNamespace(sc.Namespace)
+	err := reconciler.client.Create(context.TODO(), route)
+	assert.NoError(t, err)
+
+	// A Route and an Ingress of the same name created by someone else are
+	// kept while the object store is not exposed
+	err = reconciler.ensureObjectStoreEndpoint(sc, &reconcileState{}, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: route.GetName(), Namespace: sc.Namespace}, route)
+	assert.NoError(t, err)
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: ingress.Name, Namespace: sc.Namespace}, ingress)
+	assert.NoError(t, err)
+}
+
+func TestObjectStoreSecretMapper(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.ObjectStore.TLS = &api.ObjectStoreTLSSpec{Source: api.CertificateSourceSecret, SecretName: "s3-cert"}
+	other := mockStorageCluster.DeepCopy()
+	other.Name = "other"
+	reconciler := createFakeStorageClusterReconciler(t, sc, other)
+	mapper := &objectStoreSecretMapper{client: reconciler.client}
+
+	for name, expected := range map[string]int{"s3-cert": 1, "unrelated": 0} {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: sc.Namespace,
+			},
+		}
+		requests := mapper.Map(handler.MapObject{Meta: secret, Object: secret})
+		assert.Len(t, requests, expected, name)
+		for _, request := range requests {
+			assert.Equal(t, sc.Name, request.Name)
+		}
+	}
+}
@@ -0,0 +1,635 @@
+package storagecluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-logr/logr"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// Actions of a plannedChange
+const (
+	planActionCreate = "Create"
+	planActionUpdate = "Update"
+	planActionDelete = "Delete"
+)
+
+// Kinds of a plannedFieldChange
+const (
+	fieldAdded   = "Added"
+	fieldRemoved = "Removed"
+	fieldChanged = "Changed"
+)
+
+// reconcilePlan is the plan published for a StorageCluster whose
+// ReconcileStrategy is Plan
+type reconcilePlan struct {
+	// Generation is the generation of the StorageCluster planned for
+	Generation int64 `json:"generation"`
+	// Summary describes each change in a line
+	Summary []string `json:"summary"`
+	// Disruptive says whether any change restarts daemons or moves data
+	Disruptive bool `json:"disruptive"`
+	// Error is why the plan is incomplete, if it is
+	Error   string          `json:"error,omitempty"`
+	Changes []plannedChange `json:"changes"`
+}
+
+// plannedChange is a write to an object the reconcile would make
+type plannedChange struct {
+	Action     string               `json:"action"`
+	Kind       string               `json:"kind"`
+	Namespace  string               `json:"namespace,omitempty"`
+	Name       string               `json:"name"`
+	Disruptive bool                 `json:"disruptive"`
+	Fields     []plannedFieldChange `json:"fields,omitempty"`
+}
+
+// plannedFieldChange is a field of the live object an update changes. The
+// path of an element of a list of named objects, such as the device sets of
+// a CephCluster, ends in [name=<name>]. Old and New are only set for plain
+// values.
+type plannedFieldChange struct {
+	Path       string `json:"path"`
+	Change     string `json:"change"`
+	Old        string `json:"old,omitempty"`
+	New        string `json:"new,omitempty"`
+	Disruptive bool   `json:"disruptive"`
+}
+
+// isPlanStrategy says whether the changes for the StorageCluster are only to
+// be planned
+func isPlanStrategy(sc *ocsv1.StorageCluster) bool {
+	return sc.Spec.ReconcileStrategy == ocsv1.ReconcileStrategyPlan
+}
+
+// reconcilePlan runs the reconcile of a StorageCluster without writing to
+// any object, and publishes the changes it would have made in the plan
+// ConfigMap of the StorageCluster
+func (r *ReconcileStorageCluster) reconcilePlan(instance *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	planner := newPlanningClient(r.client, r.scheme)
+	p := *r
+	p.client = planner
+
+	sc := instance.DeepCopy()
+	err := p.planSteps(sc, reqLogger)
+
+	plan := planner.plan()
+	plan.Generation = instance.Generation
+	if err != nil {
+		reqLogger.Error(err, "Failed to plan StorageCluster changes")
+		plan.Error = err.Error()
+	}
+	reqLogger.Info("Planned StorageCluster changes", "Changes", len(plan.Changes), "Disruptive", plan.Disruptive)
+
+	pErr := r.publishPlan(instance, plan)
+	if pErr != nil {
+		return pErr
+	}
+	return err
+}
+
+// planSteps runs the steps of the reconcile that write to objects other
+// than the StorageCluster
+func (r *ReconcileStorageCluster) planSteps(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	for _, f := range []func(*ocsv1.StorageCluster, logr.Logger) error{
+		r.ensureManagedNodes,
+		r.reconcileNodeTopologyMap,
+		r.ensureFailureDomain,
+	} {
+		err := f(sc, reqLogger)
+		if err != nil {
+			return err
+		}
+	}
+
+	if sc.Status.FailureDomain == "" {
+		sc.Status.FailureDomainKey = determineFailureDomainKey(sc)
+		sc.Status.FailureDomain = determineFailureDomain(sc)
+	}
+
+	for _, step := range r.reconcileSteps(&reconcileState{}) {
+		if !step.IsApplicable(sc) {
+			continue
+		}
+		err := step.Ensure(sc, reqLogger)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishPlan writes the plan to the plan ConfigMap of the StorageCluster,
+// unless it holds the same plan already
+func (r *ReconcileStorageCluster) publishPlan(sc *ocsv1.StorageCluster, plan *reconcilePlan) error {
+	data, err := yaml.Marshal(plan)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForReconcilePlan(sc),
+			Namespace: sc.Namespace,
+		},
+		Data: map[string]string{
+			"plan": string(data),
+		},
+	}
+	err = controllerutil.SetControllerReference(sc, cm, r.scheme)
+	if err != nil {
+		return err
+	}
+
+	found := &corev1.ConfigMap{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return r.client.Create(context.TODO(), cm)
+		}
+		return err
+	}
+	if reflect.DeepEqual(found.Data, cm.Data) {
+		return nil
+	}
+	found.Data = cm.Data
+	return r.client.Update(context.TODO(), found)
+}
+
+// planningClient reads through to the cluster, and records the writes made
+// through it instead of making them. Like the apiserver, it sets the
+// selfLink of the objects it is asked to create.
+type planningClient struct {
+	client.Client
+	scheme  *runtime.Scheme
+	changes map[string]*pendingChange
+}
+
+// pendingChange is a write recorded by the planningClient, compared with
+// the live object when the plan is made
+type pendingChange struct {
+	action  string
+	kind    string
+	key     types.NamespacedName
+	live    runtime.Object
+	desired runtime.Object
+}
+
+var _ client.Client = &planningClient{}
+
+func newPlanningClient(c client.Client, scheme *runtime.Scheme) *planningClient {
+	return &planningClient{Client: c, scheme: scheme, changes: map[string]*pendingChange{}}
+}
+
+func (c *planningClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	statusutil.SetSelfLink(c.scheme, obj)
+	return c.record(planActionCreate, obj)
+}
+
+func (c *planningClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return c.record(planActionUpdate, obj)
+}
+
+func (c *planningClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return c.record(planActionDelete, obj)
+}
+
+func (c *planningClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.StrategicMergePatchType {
+		return fmt.Errorf("Unable to plan a %s patch", patch.Type())
+	}
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, data, obj)
+	if err != nil {
+		return err
+	}
+	desired, err := c.scheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(patched, desired)
+	if err != nil {
+		return err
+	}
+	return c.record(planActionUpdate, desired)
+}
+
+func (c *planningClient) Status() client.StatusWriter {
+	return &planningStatusWriter{}
+}
+
+// planningStatusWriter drops status writes, as they are not part of a plan
+type planningStatusWriter struct{}
+
+func (w *planningStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return nil
+}
+
+func (w *planningStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return nil
+}
+
+func (c *planningClient) record(action string, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Name: accessor.GetName(), Namespace: accessor.GetNamespace()}
+	id := fmt.Sprintf("%s/%s/%s", gvk.Kind, key.Namespace, key.Name)
+
+	change, ok := c.changes[id]
+	if !ok {
+		change = &pendingChange{action: action, kind: gvk.Kind, key: key}
+		if action != planActionCreate {
+			live, err := c.getLive(obj, gvk, key)
+			if err != nil {
+				return err
+			}
+			change.live = live
+		}
+		c.changes[id] = change
+	} else if action == planActionDelete || change.action == planActionDelete {
+		change.action = action
+	}
+	change.desired = obj.DeepCopyObject()
+	return nil
+}
+
+// getLive reads the object as it is in the cluster
+func (c *planningClient) getLive(obj runtime.Object, gvk schema.GroupVersionKind, key types.NamespacedName) (runtime.Object, error) {
+	var live runtime.Object
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(gvk)
+		live = u
+	} else {
+		newObj, err := c.scheme.New(gvk)
+		if err != nil {
+			return nil, err
+		}
+		live = newObj
+	}
+	err := c.Client.Get(context.TODO(), key, live)
+	if err != nil {
+		return nil, err
+	}
+	return live, nil
+}
+
+// plan compares the recorded writes with the live objects. Updates that
+// change nothing are left out.
+func (c *planningClient) plan() *reconcilePlan {
+	ids := []string{}
+	for id := range c.changes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	plan := &reconcilePlan{Summary: []string{}, Changes: []plannedChange{}}
+	for _, id := range ids {
+		pending := c.changes[id]
+		change := plannedChange{
+			Action:    pending.action,
+			Kind:      pending.kind,
+			Namespace: pending.key.Namespace,
+			Name:      pending.key.Name,
+		}
+		switch pending.action {
+		case planActionUpdate:
+			change.Fields = diffObjects(pending.kind, pending.live, pending.desired)
+			if len(change.Fields) == 0 {
+				continue
+			}
+			for _, field := range change.Fields {
+				change.Disruptive = change.Disruptive || field.Disruptive
+			}
+		case planActionDelete:
+			change.Disruptive = true
+		}
+		plan.Changes = append(plan.Changes, change)
+		plan.Summary = append(plan.Summary, describeChange(change))
+		plan.Disruptive = plan.Disruptive || change.Disruptive
+	}
+	return plan
+}
+
+// ignoredMetadataFields are set by the apiserver rather than by the operator
+var ignoredMetadataFields = []string{
+	"creationTimestamp",
+	"generation",
+	"managedFields",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// diffObjects returns the fields an update of the live object to the
+// desired one changes, other than the status and the fields set by the
+// apiserver
+func diffObjects(kind string, live, desired runtime.Object) []plannedFieldChange {
+	liveFields, err := toFields(live)
+	if err != nil {
+		return []plannedFieldChange{{Path: "", Change: fieldChanged, Disruptive: true}}
+	}
+	desiredFields, err := toFields(desired)
+	if err != nil {
+		return []plannedFieldChange{{Path: "", Change: fieldChanged, Disruptive: true}}
+	}
+
+	changes := []plannedFieldChange{}
+	diffFields("", liveFields, desiredFields, &changes)
+	for i := range changes {
+		changes[i].Disruptive = isDisruptiveFieldChange(kind, changes[i])
+	}
+	return changes
+}
+
+func toFields(obj runtime.Object) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		fields = runtime.DeepCopyJSON(u.Object)
+	} else {
+		var err error
+		fields, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+	}
+	delete(fields, "apiVersion")
+	delete(fields, "kind")
+	delete(fields, "status")
+	if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+		for _, field := range ignoredMetadataFields {
+			delete(metadata, field)
+		}
+	}
+	return fields, nil
+}
+
+// diffFields appends the differences between two decoded JSON values
+func diffFields(path string, live, desired interface{}, changes *[]plannedFieldChange) {
+	if reflect.DeepEqual(live, desired) || (isEmptyField(live) && isEmptyField(desired)) {
+		return
+	}
+	switch {
+	case isEmptyField(live):
+		*changes = append(*changes, plannedFieldChange{Path: path, Change: fieldAdded, New: plainValue(desired)})
+		return
+	case isEmptyField(desired):
+		*changes = append(*changes, plannedFieldChange{Path: path, Change: fieldRemoved, Old: plainValue(live)})
+		return
+	}
+
+	liveMap, liveOk := live.(map[string]interface{})
+	desiredMap, desiredOk := desired.(map[string]interface{})
+	if liveOk && desiredOk {
+		keys := []string{}
+		for key := range liveMap {
+			keys = append(keys, key)
+		}
+		for key := range desiredMap {
+			if _, ok := liveMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffFields(joinFieldPath(path, key), liveMap[key], desiredMap[key], changes)
+		}
+		return
+	}
+
+	liveList, liveOk := live.([]interface{})
+	desiredList, desiredOk := desired.([]interface{})
+	if liveOk && desiredOk {
+		liveNamed, liveOk := byName(liveList)
+		desiredNamed, desiredOk := byName(desiredList)
+		if liveOk && desiredOk {
+			names := []string{}
+			for name := range liveNamed {
+				names = append(names, name)
+			}
+			for name := range desiredNamed {
+				if _, ok := liveNamed[name]; !ok {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				diffFields(fmt.Sprintf("%s[name=%s]", path, name), liveNamed[name], desiredNamed[name], changes)
+			}
+			return
+		}
+	}
+
+	*changes = append(*changes, plannedFieldChange{Path: path, Change: fieldChanged, Old: plainValue(live), New: plainValue(desired)})
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// byName maps the elements of a list of objects by their names, if they all
+// have one
+func byName(list []interface{}) (map[string]interface{}, bool) {
+	named := map[string]interface{}{}
+	for _, element := range list {
+		m, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok {
+			return nil, false
+		}
+		named[name] = m
+	}
+	return named, true
+}
+
+func isEmptyField(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(v) == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// plainValue formats a value that is neither an object nor a list
+func plainValue(value interface{}) string {
+	switch value.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// poolFields are the fields of the pools that place their data
+var poolFields = []string{
+	"spec.dataPools",
+	"spec.erasureCoded",
+	"spec.failureDomain",
+	"spec.metadataPool",
+	"spec.replicated",
+}
+
+// isDisruptiveFieldChange says whether a change of the field restarts
+// daemons or moves data. Metadata changes never do. Of the CephCluster spec
+// only adding device sets and growing them are not disruptive, of the pools
+// only the fields not placing their data, and of the nodes only adding
+// labels.
+func isDisruptiveFieldChange(kind string, field plannedFieldChange) bool {
+	if strings.HasPrefix(field.Path, "metadata.") {
+		return false
+	}
+	switch kind {
+	case "CephCluster":
+		deviceSet, subPath := splitDeviceSetPath(field.Path)
+		switch {
+		case deviceSet == "":
+			return true
+		case subPath == "":
+			return field.Change != fieldAdded
+		case subPath == "count":
+			oldCount, oldErr := strconv.Atoi(field.Old)
+			newCount, newErr := strconv.Atoi(field.New)
+			return oldErr != nil || newErr != nil || newCount < oldCount
+		}
+		return true
+	case "CephBlockPool", "CephFilesystem", "CephObjectStore":
+		for _, poolField := range poolFields {
+			if field.Path == poolField || strings.HasPrefix(field.Path, poolField+".") || strings.HasPrefix(field.Path, poolField+"[") {
+				return true
+			}
+		}
+		return false
+	case "Node":
+		return !(strings.HasPrefix(field.Path, "metadata.labels") && field.Change == fieldAdded)
+	case "NooBaa":
+		return true
+	}
+	return false
+}
+
+// splitDeviceSetPath returns the name of the CephCluster device set a field
+// path is in, and the path within the device set
+func splitDeviceSetPath(path string) (string, string) {
+	prefix := "spec.storage.storageClassDeviceSets[name="
+	if !strings.HasPrefix(path, prefix) {
+		return "", ""
+	}
+	rest := path[len(prefix):]
+	end := strings.Index(rest, "]")
+	if end < 0 {
+		return "", ""
+	}
+	return rest[:end], strings.TrimPrefix(rest[end+1:], ".")
+}
+
+// describeChange summarizes a change in a line. The changes of the device
+// sets and resources of a CephCluster are spelled out.
+func describeChange(change plannedChange) string {
+	name := change.Name
+	if change.Namespace != "" {
+		name = change.Namespace + "/" + name
+	}
+	description := fmt.Sprintf("%s %s %s", change.Action, change.Kind, name)
+
+	details := []string{}
+	switch {
+	case change.Action != planActionUpdate:
+	case change.Kind == "CephCluster":
+		groups := map[string][]string{}
+		var other []string
+		for _, field := range change.Fields {
+			deviceSet, subPath := splitDeviceSetPath(field.Path)
+			switch {
+			case deviceSet != "" && subPath == "" && field.Change == fieldAdded:
+				groups["device sets added"] = appendOnce(groups["device sets added"], deviceSet)
+			case deviceSet != "" && subPath == "" && field.Change == fieldRemoved:
+				groups["device sets removed"] = appendOnce(groups["device sets removed"], deviceSet)
+			case deviceSet != "" && subPath == "count" && !field.Disruptive:
+				groups["device sets grown"] = appendOnce(groups["device sets grown"], deviceSet)
+			case deviceSet != "":
+				groups["device sets changed"] = appendOnce(groups["device sets changed"], deviceSet)
+			case strings.HasPrefix(field.Path, "spec.resources."):
+				daemon := strings.SplitN(strings.TrimPrefix(field.Path, "spec.resources."), ".", 2)[0]
+				groups["resources changed"] = appendOnce(groups["resources changed"], daemon)
+			default:
+				other = append(other, field.Path)
+			}
+		}
+		for _, group := range []string{"device sets added", "device sets grown", "device sets changed", "device sets removed", "resources changed"} {
+			if len(groups[group]) > 0 {
+				details = append(details, fmt.Sprintf("%s: %s", group, strings.Join(groups[group], ", ")))
+			}
+		}
+		if len(other) > 0 {
+			details = append(details, fmt.Sprintf("fields changed: %s", strings.Join(other, ", ")))
+		}
+	default:
+		paths := []string{}
+		for _, field := range change.Fields {
+			paths = append(paths, field.Path)
+		}
+		details = append(details, fmt.Sprintf("fields changed: %s", strings.Join(paths, ", ")))
+	}
+
+	if len(details) > 0 {
+		description = fmt.Sprintf("%s: %s", description, strings.Join(details, "; "))
+	}
+	if change.Disruptive {
+		description += " (disruptive)"
+	}
+	return description
+}
+
+func appendOnce(slice []string, s string) []string {
+	if contains(slice, s) {
+		return slice
+	}
+	return append(slice, s)
+}
@@ -0,0 +1,164 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// getPublishedPlan reads the plan published for the StorageCluster
+func getPublishedPlan(t *testing.T, reconciler ReconcileStorageCluster, sc *api.StorageCluster) *reconcilePlan {
+	cm := &corev1.ConfigMap{}
+	err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForReconcilePlan(sc), Namespace: sc.Namespace}, cm)
+	assert.NoError(t, err)
+	plan := &reconcilePlan{}
+	err = yaml.Unmarshal([]byte(cm.Data["plan"]), plan)
+	assert.NoError(t, err)
+	return plan
+}
+
+func findPlannedChange(plan *reconcilePlan, kind, action string) *plannedChange {
+	for i := range plan.Changes {
+		if plan.Changes[i].Kind == kind && plan.Changes[i].Action == action {
+			return &plan.Changes[i]
+		}
+	}
+	return nil
+}
+
+func TestReconcilePlan(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.StorageDeviceSets = []api.StorageDeviceSet{*mockDeviceSets[0].DeepCopy()}
+	sc.Spec.ReconcileStrategy = api.ReconcileStrategyPlan
+	reconciler := createFakeStorageClusterReconciler(t, sc, mockNodeList.DeepCopy())
+
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	sc.Status.FailureDomainKey = determineFailureDomainKey(sc)
+	sc.Status.FailureDomain = determineFailureDomain(sc)
+	live := newCephCluster(sc, "")
+	live.SelfLink = "/apis/ceph.rook.io/v1/namespaces/storage-test-ns/cephclusters/" + live.Name
+	err = reconciler.client.Create(context.TODO(), live)
+	assert.NoError(t, err)
+
+	// Growing the device sets is planned, but not applied. Without a
+	// Replica, the Count is spread across three device sets.
+	sc.Spec.StorageDeviceSets[0].Count += 3
+	err = reconciler.reconcilePlan(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	plan := getPublishedPlan(t, reconciler, sc)
+	assert.Empty(t, plan.Error)
+	change := findPlannedChange(plan, "CephCluster", planActionUpdate)
+	assert.NotNil(t, change)
+	assert.False(t, change.Disruptive)
+	assert.Contains(t, plan.Summary, describeChange(*change))
+	assert.Contains(t, describeChange(*change), "device sets grown")
+
+	found := &cephv1.CephCluster{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: live.Name, Namespace: live.Namespace}, found)
+	assert.NoError(t, err)
+	assert.Equal(t, live.Spec.Storage.StorageClassDeviceSets, found.Spec.Storage.StorageClassDeviceSets)
+
+	// Changing the resources of the daemons restarts them
+	sc.Spec.Resources = map[string]corev1.ResourceRequirements{"mon": {}}
+	err = reconciler.reconcilePlan(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	plan = getPublishedPlan(t, reconciler, sc)
+	change = findPlannedChange(plan, "CephCluster", planActionUpdate)
+	assert.NotNil(t, change)
+	assert.True(t, change.Disruptive)
+	assert.True(t, plan.Disruptive)
+	assert.Contains(t, describeChange(*change), "resources changed: mon")
+}
+
+func TestReconcilePlanWritesNothing(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.StorageDeviceSets = mockDeviceSets
+	sc.Spec.ReconcileStrategy = api.ReconcileStrategyPlan
+	sc.Status = api.StorageClusterStatus{}
+	reconciler := createFakeStorageClusterReconciler(t, sc, mockNodeList.DeepCopy())
+
+	_, err := reconciler.Reconcile(mockStorageClusterRequest)
+	assert.NoError(t, err)
+
+	plan := getPublishedPlan(t, reconciler, sc)
+	assert.NotNil(t, findPlannedChange(plan, "CephCluster", planActionCreate))
+	assert.NotNil(t, findPlannedChange(plan, "CephBlockPool", planActionCreate))
+	assert.False(t, plan.Disruptive)
+
+	cephCluster := &cephv1.CephCluster{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, cephCluster)
+	assert.True(t, errors.IsNotFound(err))
+
+	found := &api.StorageCluster{}
+	err = reconciler.client.Get(context.TODO(), mockStorageClusterRequest.NamespacedName, found)
+	assert.NoError(t, err)
+	assert.Empty(t, found.Finalizers)
+	assert.Equal(t, api.StorageClusterStatus{}, found.Status)
+}
+
+func TestIsDisruptiveFieldChange(t *testing.T) {
+	deviceSet := "spec.storage.storageClassDeviceSets[name=set-0]"
+	cases := []struct {
+		kind       string
+		field      plannedFieldChange
+		disruptive bool
+	}{
+		{"CephCluster", plannedFieldChange{Path: deviceSet, Change: fieldAdded}, false},
+		{"CephCluster", plannedFieldChange{Path: deviceSet, Change: fieldRemoved}, true},
+		{"CephCluster", plannedFieldChange{Path: deviceSet + ".count", Change: fieldChanged, Old: "1", New: "2"}, false},
+		{"CephCluster", plannedFieldChange{Path: deviceSet + ".count", Change: fieldChanged, Old: "2", New: "1"}, true},
+		{"CephCluster", plannedFieldChange{Path: deviceSet + ".portable", Change: fieldChanged}, true},
+		{"CephCluster", plannedFieldChange{Path: "spec.resources.mgr.limits.cpu", Change: fieldChanged}, true},
+		{"CephCluster", plannedFieldChange{Path: "metadata.labels.app", Change: fieldAdded}, false},
+		{"CephBlockPool", plannedFieldChange{Path: "spec.failureDomain", Change: fieldChanged}, true},
+		{"CephBlockPool", plannedFieldChange{Path: "spec.replicated.size", Change: fieldChanged}, true},
+		{"CephFilesystem", plannedFieldChange{Path: "spec.metadataServer.activeCount", Change: fieldChanged}, false},
+		{"Node", plannedFieldChange{Path: "metadata.labels.topology.rook.io/rack", Change: fieldAdded}, false},
+		{"Node", plannedFieldChange{Path: "spec.taints", Change: fieldAdded}, true},
+		{"StorageClass", plannedFieldChange{Path: "parameters.pool", Change: fieldChanged}, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.disruptive, isDisruptiveFieldChange(c.kind, c.field), c.kind+" "+c.field.Path)
+	}
+}
+
+func TestDiffFields(t *testing.T) {
+	live := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"sets": []interface{}{
+				map[string]interface{}{"name": "a", "count": int64(1)},
+				map[string]interface{}{"name": "b", "count": int64(1)},
+			},
+			"image": "ceph:v14.2.4",
+		},
+	}
+	desired := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"sets": []interface{}{
+				map[string]interface{}{"name": "a", "count": int64(2)},
+				map[string]interface{}{"name": "c", "count": int64(1)},
+			},
+			"image": "ceph:v14.2.4",
+			"mon":   map[string]interface{}{"count": int64(3)},
+		},
+	}
+
+	changes := []plannedFieldChange{}
+	diffFields("", live, desired, &changes)
+	assert.Equal(t, []plannedFieldChange{
+		{Path: "spec.mon", Change: fieldAdded},
+		{Path: "spec.sets[name=a].count", Change: fieldChanged, Old: "1", New: "2"},
+		{Path: "spec.sets[name=b]", Change: fieldRemoved},
+		{Path: "spec.sets[name=c]", Change: fieldAdded},
+	}, changes)
+}
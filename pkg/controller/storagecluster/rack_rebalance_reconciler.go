@@ -0,0 +1,306 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/openshift/ocs-operator/pkg/controller/placement"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// rackMoveSettlePeriod is the least time between two rack moves, so
+	// that Ceph has started to move the data of the last one before its
+	// health is checked
+	rackMoveSettlePeriod = 5 * time.Minute
+
+	// cephHealthOK is the health of a Ceph cluster with nothing to repair
+	cephHealthOK = "HEALTH_OK"
+)
+
+// osdPodApps are the app labels of the pods running or preparing OSDs
+var osdPodApps = map[string]bool{
+	"rook-ceph-osd":         true,
+	"rook-ceph-osd-prepare": true,
+}
+
+// ensureRackRebalance proposes moves of storage nodes between the racks made
+// up by the operator whenever the numbers of nodes in the racks differ by
+// more than one, and applies them one node at a time if asked to. A move is
+// only applied once Ceph is healthy and the previous move has settled.
+// Only nodes without OSDs are moved: relabeling a node does not move its
+// OSDs to another rack in CRUSH, so racks made uneven by nodes running OSDs
+// stay uneven. The node labels are read and changed under the nodes lock.
+func (r *ReconcileStorageCluster) ensureRackRebalance(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	if !sc.Spec.RackRebalance.Enabled || !isRackFailureDomainMadeUp(sc) {
+		sc.Status.RackRebalance = nil
+		return nil
+	}
+
+	defer r.locks.lock(nodesLockKey)()
+
+	nodes, err := r.getStorageNodes()
+	if err != nil {
+		return err
+	}
+
+	status := sc.Status.RackRebalance
+	if status == nil {
+		status = &ocsv1.RackRebalanceStatus{}
+	}
+	osdNodes, err := r.getOSDNodes(sc)
+	if err != nil {
+		return err
+	}
+	racks := getNodeRacks(sc, nodes)
+	status.ProposedMoves = proposeRackMoves(racks, nodes, osdNodes, getTopologyKeys(sc))
+	status.Message = describeRacks(racks, status.ProposedMoves)
+	sc.Status.RackRebalance = status
+
+	if len(status.ProposedMoves) == 0 || !sc.Spec.RackRebalance.Apply {
+		return nil
+	}
+
+	if status.LastMoveTime != nil {
+		next := status.LastMoveTime.Add(rackMoveSettlePeriod)
+		if time.Now().Before(next) {
+			status.Message = fmt.Sprintf("%s. Waiting until %s before the next move", status.Message, next.UTC().Format(time.RFC3339))
+			return nil
+		}
+	}
+
+	cephCluster := &cephv1.CephCluster{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, cephCluster)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	health := ""
+	if err == nil && cephCluster.Status.CephStatus != nil {
+		health = cephCluster.Status.CephStatus.Health
+	}
+	if health != cephHealthOK {
+		if health == "" {
+			health = "unknown"
+		}
+		status.Message = fmt.Sprintf("%s. Waiting for Ceph to be healthy before the next move, its health is %s", status.Message, health)
+		return nil
+	}
+
+	move := status.ProposedMoves[0]
+	node := &corev1.Node{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: move.Node}, node)
+	if err == nil && node.Labels[defaults.RackTopologyKey] != move.FromRack {
+		// The moves are proposed again from the current racks
+		reqLogger.Info("Storage node to move changed racks, skipping the move", "Node", move.Node)
+		return nil
+	}
+	if err == nil {
+		reqLogger.Info("Moving storage node to another rack", "Node", node.Name, "Label", defaults.RackTopologyKey, "FromRack", move.FromRack, "ToRack", move.ToRack)
+		node.Labels[defaults.RackTopologyKey] = move.ToRack
+		err = r.client.Update(context.TODO(), node)
+	}
+	if err != nil {
+		// The moves are proposed again for the nodes left
+		if errors.IsNotFound(err) {
+			reqLogger.Info("Storage node to move is gone, skipping the move", "Node", move.Node)
+			return nil
+		}
+		return err
+	}
+
+	// The status is written even if the rest of the reconcile fails, so
+	// the next move waits for this one
+	now := metav1.Now()
+	status.LastMove = &move
+	status.LastMoveTime = &now
+	racks[move.FromRack] = remove(racks[move.FromRack], move.Node)
+	racks[move.ToRack] = append(racks[move.ToRack], move.Node)
+	status.ProposedMoves = status.ProposedMoves[1:]
+	status.Message = describeRacks(racks, status.ProposedMoves)
+	return nil
+}
+
+// isRackFailureDomainMadeUp says whether the failure domain of the
+// StorageCluster is the racks the operator labels the storage nodes with
+func isRackFailureDomainMadeUp(sc *ocsv1.StorageCluster) bool {
+	if _, specKey := getSpecFailureDomain(sc); specKey != "" {
+		return false
+	}
+	return sc.Status.FailureDomain == "rack" &&
+		(sc.Status.FailureDomainKey == "" || sc.Status.FailureDomainKey == defaults.RackTopologyKey)
+}
+
+// getNodeRacks returns the names of the storage nodes by rack. The racks
+// known to the NodeTopologies are included even if they are empty.
+func getNodeRacks(sc *ocsv1.StorageCluster, nodes *corev1.NodeList) map[string][]string {
+	racks := map[string][]string{}
+	if sc.Status.NodeTopologies != nil {
+		for _, rack := range sc.Status.NodeTopologies.Labels[defaults.RackTopologyKey] {
+			racks[rack] = []string{}
+		}
+	}
+	for _, node := range nodes.Items {
+		if rack, ok := node.Labels[defaults.RackTopologyKey]; ok {
+			racks[rack] = append(racks[rack], node.Name)
+		}
+	}
+	for rack := range racks {
+		sort.Strings(racks[rack])
+	}
+	return racks
+}
+
+// getOSDNodes returns the names of the nodes running or preparing OSDs of the
+// StorageCluster
+func (r *ReconcileStorageCluster) getOSDNodes(sc *ocsv1.StorageCluster) (map[string]bool, error) {
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(sc.Namespace))
+	if err != nil {
+		return nil, err
+	}
+
+	osdNodes := map[string]bool{}
+	for _, pod := range pods.Items {
+		if osdPodApps[pod.Labels["app"]] && pod.Spec.NodeName != "" {
+			osdNodes[pod.Spec.NodeName] = true
+		}
+	}
+	return osdNodes, nil
+}
+
+// proposeRackMoves returns the moves that leave the racks differing by at
+// most one node, as far as the nodes not pinned to their rack allow. Like
+// placement.DetermineRack, a node is only moved to a rack that is empty or
+// holds nodes of its own zone. Each move takes a node from a rack to one
+// holding at least two nodes less, so there are only finitely many.
+func proposeRackMoves(racks map[string][]string, nodes *corev1.NodeList, pinned map[string]bool, topologyKeys []string) []ocsv1.RackMove {
+	nodeZones := map[string]string{}
+	for _, node := range nodes.Items {
+		nodeZones[node.Name] = placement.NodeZone(node, topologyKeys)
+	}
+
+	layout := map[string][]string{}
+	rackNames := []string{}
+	for rack, nodeNames := range racks {
+		layout[rack] = append([]string{}, nodeNames...)
+		rackNames = append(rackNames, rack)
+	}
+
+	moves := []ocsv1.RackMove{}
+	for {
+		// Take from the fullest racks first, and move to the emptiest
+		sort.Slice(rackNames, func(i, j int) bool {
+			if len(layout[rackNames[i]]) != len(layout[rackNames[j]]) {
+				return len(layout[rackNames[i]]) > len(layout[rackNames[j]])
+			}
+			return rackNames[i] < rackNames[j]
+		})
+
+		var move *ocsv1.RackMove
+		for _, from := range rackNames {
+			for _, nodeName := range layout[from] {
+				if pinned[nodeName] {
+					continue
+				}
+				to := ""
+				for _, rack := range rackNames {
+					if len(layout[rack]) >= len(layout[from])-1 ||
+						!isRackInZone(layout[rack], nodeZones, nodeZones[nodeName]) {
+						continue
+					}
+					if to == "" || len(layout[rack]) < len(layout[to]) {
+						to = rack
+					}
+				}
+				if to != "" {
+					move = &ocsv1.RackMove{Node: nodeName, FromRack: from, ToRack: to}
+					break
+				}
+			}
+			if move != nil {
+				break
+			}
+		}
+		if move == nil {
+			return moves
+		}
+
+		layout[move.FromRack] = remove(layout[move.FromRack], move.Node)
+		layout[move.ToRack] = append(layout[move.ToRack], move.Node)
+		sort.Strings(layout[move.ToRack])
+		moves = append(moves, *move)
+	}
+}
+
+// isRackInZone says whether the rack holding the given nodes may take a node
+// of the zone
+func isRackInZone(nodeNames []string, nodeZones map[string]string, zone string) bool {
+	for _, nodeName := range nodeNames {
+		if nodeZones[nodeName] != zone {
+			return false
+		}
+	}
+	return true
+}
+
+// describeRacks explains the layout of the racks and the moves proposed
+func describeRacks(racks map[string][]string, moves []ocsv1.RackMove) string {
+	rackNames := []string{}
+	for rack := range racks {
+		rackNames = append(rackNames, rack)
+	}
+	sort.Strings(rackNames)
+	counts := []string{}
+	for _, rack := range rackNames {
+		counts = append(counts, fmt.Sprintf("%s: %d", rack, len(racks[rack])))
+	}
+
+	message := fmt.Sprintf("Storage nodes per rack: %s", strings.Join(counts, ", "))
+	if len(moves) == 0 {
+		if isRackLayoutUneven(racks) {
+			return message + ". No storage node without OSDs can be moved to balance the racks"
+		}
+		return message + ". The racks are balanced"
+	}
+	return fmt.Sprintf("%s. Moving %d storage nodes balances the racks", message, len(moves))
+}
+
+// isRackLayoutUneven says whether the numbers of nodes in the racks differ by
+// more than one
+func isRackLayoutUneven(racks map[string][]string) bool {
+	min, max := -1, 0
+	for _, nodeNames := range racks {
+		if min < 0 || len(nodeNames) < min {
+			min = len(nodeNames)
+		}
+		if len(nodeNames) > max {
+			max = len(nodeNames)
+		}
+	}
+	return max-min > 1
+}
+
+// getRackRebalanceRequeueAfter returns how long until the next proposed rack
+// move may be applied, or zero if none is waiting on time
+func getRackRebalanceRequeueAfter(sc *ocsv1.StorageCluster, now time.Time) time.Duration {
+	status := sc.Status.RackRebalance
+	if !sc.Spec.RackRebalance.Apply || status == nil || len(status.ProposedMoves) == 0 || status.LastMoveTime == nil {
+		return 0
+	}
+	after := status.LastMoveTime.Add(rackMoveSettlePeriod).Sub(now)
+	if after <= 0 {
+		return 0
+	}
+	return after
+}
@@ -0,0 +1,203 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/openshift/ocs-operator/pkg/controller/fault"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newRackNodes returns storage nodes in the given racks, all in one zone
+func newRackNodes(nodeRacks map[string]string) *corev1.NodeList {
+	nodes := &corev1.NodeList{}
+	for name, rack := range nodeRacks {
+		node := newCandidateNode(name, "zone1")
+		node.Labels[defaults.NodeAffinityKey] = ""
+		node.Labels[defaults.RackTopologyKey] = rack
+		nodes.Items = append(nodes.Items, *node)
+	}
+	return nodes
+}
+
+func TestProposeRackMoves(t *testing.T) {
+	racks := map[string][]string{
+		"rack0": {"a", "b", "c", "d"},
+		"rack1": {"e"},
+		"rack2": {},
+	}
+	nodes := newRackNodes(map[string]string{"a": "rack0", "b": "rack0", "c": "rack0", "d": "rack0", "e": "rack1"})
+
+	moves := proposeRackMoves(racks, nodes, nil, defaults.TopologyKeys)
+	assert.Equal(t, []api.RackMove{
+		{Node: "a", FromRack: "rack0", ToRack: "rack2"},
+		{Node: "b", FromRack: "rack0", ToRack: "rack1"},
+	}, moves)
+
+	// Balanced racks are left alone
+	racks = map[string][]string{
+		"rack0": {"a", "b"},
+		"rack1": {"c"},
+		"rack2": {"d", "e"},
+	}
+	assert.Empty(t, proposeRackMoves(racks, nodes, nil, defaults.TopologyKeys))
+}
+
+func TestProposeRackMovesKeepsOSDNodes(t *testing.T) {
+	racks := map[string][]string{
+		"rack0": {"a", "b", "c", "d"},
+		"rack1": {"e"},
+		"rack2": {},
+	}
+	nodes := newRackNodes(map[string]string{"a": "rack0", "b": "rack0", "c": "rack0", "d": "rack0", "e": "rack1"})
+
+	moves := proposeRackMoves(racks, nodes, map[string]bool{"a": true, "b": true, "c": true}, defaults.TopologyKeys)
+	assert.Equal(t, []api.RackMove{
+		{Node: "d", FromRack: "rack0", ToRack: "rack2"},
+	}, moves)
+}
+
+func TestProposeRackMovesKeepsZones(t *testing.T) {
+	racks := map[string][]string{
+		"rack0": {"a", "b", "c", "d"},
+		"rack1": {"e"},
+		"rack2": {},
+	}
+	nodes := newRackNodes(map[string]string{"a": "rack0", "b": "rack0", "c": "rack0", "d": "rack0", "e": "rack1"})
+	for i := range nodes.Items {
+		if nodes.Items[i].Name == "e" {
+			nodes.Items[i].Labels[zoneTopologyLabel] = "zone2"
+		} else {
+			nodes.Items[i].Labels[zoneTopologyLabel] = "zone1"
+		}
+	}
+
+	// rack1 only takes nodes of zone2
+	moves := proposeRackMoves(racks, nodes, nil, defaults.TopologyKeys)
+	assert.Equal(t, []api.RackMove{
+		{Node: "a", FromRack: "rack0", ToRack: "rack2"},
+		{Node: "b", FromRack: "rack0", ToRack: "rack2"},
+	}, moves)
+}
+
+func TestEnsureRackRebalance(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.RackRebalance = api.RackRebalanceSpec{Enabled: true, Apply: true}
+	sc.Status.FailureDomain = "rack"
+	sc.Status.FailureDomainKey = defaults.RackTopologyKey
+	sc.Status.NodeTopologies = api.NewNodeTopologyMap()
+	for _, rack := range []string{"rack0", "rack1", "rack2"} {
+		sc.Status.NodeTopologies.Add(defaults.RackTopologyKey, rack)
+	}
+	cephCluster := newMockCephCluster(sc)
+	cephCluster.Status.CephStatus = &cephv1.CephStatus{Health: "HEALTH_WARN"}
+	objects := []runtime.Object{sc, cephCluster}
+	nodes := newRackNodes(map[string]string{"node1": "rack0", "node2": "rack0", "node3": "rack0", "node4": "rack1"})
+	for i := range nodes.Items {
+		objects = append(objects, &nodes.Items[i])
+	}
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	// The moves are proposed, but wait for Ceph to be healthy
+	err := reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RackMove{{Node: "node1", FromRack: "rack0", ToRack: "rack2"}}, sc.Status.RackRebalance.ProposedMoves)
+	assert.Contains(t, sc.Status.RackRebalance.Message, "HEALTH_WARN")
+	assert.Nil(t, sc.Status.RackRebalance.LastMove)
+
+	// A node is moved once Ceph is healthy
+	cephCluster.Status.CephStatus.Health = cephHealthOK
+	err = reconciler.client.Update(context.TODO(), cephCluster)
+	assert.NoError(t, err)
+	err = reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RackMove{Node: "node1", FromRack: "rack0", ToRack: "rack2"}, sc.Status.RackRebalance.LastMove)
+	assert.NotNil(t, sc.Status.RackRebalance.LastMoveTime)
+	assert.Empty(t, sc.Status.RackRebalance.ProposedMoves)
+
+	node := &corev1.Node{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "node1"}, node)
+	assert.NoError(t, err)
+	assert.Equal(t, "rack2", node.Labels[defaults.RackTopologyKey])
+
+	// The next move waits for the last one to settle
+	sc.Status.NodeTopologies.Add(defaults.RackTopologyKey, "rack3")
+	err = reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Len(t, sc.Status.RackRebalance.ProposedMoves, 1)
+	assert.Contains(t, sc.Status.RackRebalance.Message, "Waiting until")
+	requeueAfter := getRackRebalanceRequeueAfter(sc, time.Now())
+	assert.True(t, requeueAfter > 0 && requeueAfter <= rackMoveSettlePeriod)
+
+	// Turning the rebalancing off clears its status
+	sc.Spec.RackRebalance.Enabled = false
+	err = reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Nil(t, sc.Status.RackRebalance)
+}
+
+func TestEnsureRackRebalanceProposeOnly(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.RackRebalance = api.RackRebalanceSpec{Enabled: true}
+	sc.Status.FailureDomain = "rack"
+	objects := []runtime.Object{sc}
+	nodes := newRackNodes(map[string]string{"node1": "rack0", "node2": "rack0", "node3": "rack0", "node4": "rack1"})
+	for i := range nodes.Items {
+		objects = append(objects, &nodes.Items[i])
+	}
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	err := reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.RackMove{{Node: "node1", FromRack: "rack0", ToRack: "rack1"}}, sc.Status.RackRebalance.ProposedMoves)
+	assert.Equal(t, "Storage nodes per rack: rack0: 3, rack1: 1. Moving 1 storage nodes balances the racks", sc.Status.RackRebalance.Message)
+	assert.Nil(t, sc.Status.RackRebalance.LastMove)
+
+	// Racks of a label named by the spec are not the operator's to move
+	sc.Spec.FailureDomain = "example.com/rack"
+	err = reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Nil(t, sc.Status.RackRebalance)
+}
+
+func TestEnsureRackRebalanceSkipsOSDNodes(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.RackRebalance = api.RackRebalanceSpec{Enabled: true, Apply: true}
+	sc.Status.FailureDomain = "rack"
+	cephCluster := newMockCephCluster(sc)
+	cephCluster.Status.CephStatus = &cephv1.CephStatus{Health: cephHealthOK}
+	osd := newNodePod("rook-ceph-osd-0", sc.Namespace, "node1")
+	osd.Labels = map[string]string{"app": "rook-ceph-osd"}
+	objects := []runtime.Object{sc, cephCluster, osd}
+	nodes := newRackNodes(map[string]string{"node1": "rack0", "node2": "rack0", "node3": "rack0", "node4": "rack1"})
+	for i := range nodes.Items {
+		objects = append(objects, &nodes.Items[i])
+	}
+	reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+	// A node gone by the time it is moved is skipped
+	reconciler.client = fault.NewClient(reconciler.client, reconciler.scheme, fault.Scenario{
+		{Verb: fault.Get, Kind: "Node", Type: fault.Error, Err: errors.NewNotFound(corev1.Resource("nodes"), "node2"), Times: 1},
+	})
+	err := reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Nil(t, sc.Status.RackRebalance.LastMove)
+	assert.Nil(t, sc.Status.RackRebalance.LastMoveTime)
+
+	// node1 runs an OSD, so node2 is moved in its place
+	err = reconciler.ensureRackRebalance(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, &api.RackMove{Node: "node2", FromRack: "rack0", ToRack: "rack1"}, sc.Status.RackRebalance.LastMove)
+	node := &corev1.Node{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "node2"}, node)
+	assert.NoError(t, err)
+	assert.Equal(t, "rack1", node.Labels[defaults.RackTopologyKey])
+}
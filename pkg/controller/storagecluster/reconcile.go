@@ -5,10 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"reflect"
 	"sort"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/operator-framework/operator-sdk/pkg/ready"
@@ -19,6 +20,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/tools/reference"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -27,14 +29,16 @@ import (
 	objectreferencesv1 "github.com/openshift/custom-resource-status/objectreferences/v1"
 	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/openshift/ocs-operator/pkg/controller/placement"
 	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	rook "github.com/rook/rook/pkg/apis/rook.io/v1alpha2"
 )
 
 const (
-	rookConfigMapName = "rook-config-override"
-	rookConfigData    = `[osd]
+	rookConfigMapName   = "rook-config-override"
+	rookDataDirHostPath = "/var/lib/rook"
+	rookConfigData      = `[osd]
 osd_memory_target_cgroup_limit_ratio = 0.5
 `
 )
@@ -43,12 +47,6 @@ var monCount = defaults.MonCount
 
 var storageClusterFinalizer = "storagecluster.ocs.openshift.io"
 
-var validTopologyLabelKeys = []string{
-	"failure-domain.beta.kubernetes.io",
-	"failure-domain.kubernetes.io",
-	"topology.rook.io",
-}
-
 func init() {
 	monCountStr := os.Getenv("MON_COUNT_OVERRIDE")
 	if monCountStr == "" {
@@ -90,6 +88,86 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 		return reconcile.Result{}, err
 	}
 
+	// The status is worked out in memory along the reconcile, and written
+	// once at the end, whatever the outcome
+	original := instance.Status.DeepCopy()
+	result, err := r.reconcilePhases(instance, request, reqLogger)
+	statusErr := r.updateStatus(instance, original)
+	if statusErr != nil {
+		reqLogger.Error(statusErr, "Failed to update status")
+		// don't want to overwrite the actual reconcile failure
+		if err == nil {
+			return reconcile.Result{}, statusErr
+		}
+	}
+	return result, err
+}
+
+// updateStatus writes the status worked out by a reconcile, along with the
+// generation it was worked out for, unless it is the status the reconcile
+// started from. Only the changes are written, as a merge patch holding the
+// resourceVersion read. On a conflict, the StorageCluster is read again and
+// the changes are applied to it.
+func (r *ReconcileStorageCluster) updateStatus(sc *ocsv1.StorageCluster, original *ocsv1.StorageClusterStatus) error {
+	sc.Status.ObservedGeneration = sc.Generation
+	if reflect.DeepEqual(*original, sc.Status) {
+		return nil
+	}
+
+	data, err := client.MergeFrom(&ocsv1.StorageCluster{Status: *original}).Data(&ocsv1.StorageCluster{Status: sc.Status})
+	if err != nil {
+		return err
+	}
+	patch := map[string]interface{}{}
+	err = json.Unmarshal(data, &patch)
+	if err != nil {
+		return err
+	}
+
+	resourceVersion := sc.ResourceVersion
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		patch["metadata"] = map[string]interface{}{"resourceVersion": resourceVersion}
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return err
+		}
+		// The patched object is read back into a copy, keeping the status
+		// worked out by the reconcile
+		err = r.client.Status().Patch(context.TODO(), sc.DeepCopy(), client.ConstantPatch(types.MergePatchType, data))
+		switch {
+		case errors.IsNotFound(err):
+			// The StorageCluster is gone once its finalizer is removed
+			return nil
+		case errors.IsConflict(err):
+			// The cache may lag behind, so the latest StorageCluster is
+			// read from the apiserver
+			latest := &ocsv1.StorageCluster{}
+			getErr := r.apiReader.Get(context.TODO(), types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace}, latest)
+			if getErr != nil {
+				return getErr
+			}
+			resourceVersion = latest.ResourceVersion
+		}
+		return err
+	})
+}
+
+// updateMetadata writes the metadata of the StorageCluster, such as its
+// finalizers. The update answers with the status stored, which would undo
+// the one worked out by the reconcile so far, so it is made on a copy.
+func (r *ReconcileStorageCluster) updateMetadata(sc *ocsv1.StorageCluster) error {
+	updated := sc.DeepCopy()
+	err := r.client.Update(context.TODO(), updated)
+	if err != nil {
+		return err
+	}
+	sc.ObjectMeta = updated.ObjectMeta
+	return nil
+}
+
+// reconcilePhases brings the StorageCluster through the phases of its
+// lifecycle, working out its status on the way
+func (r *ReconcileStorageCluster) reconcilePhases(instance *ocsv1.StorageCluster, request reconcile.Request, reqLogger logr.Logger) (reconcile.Result, error) {
 	// Check for active StorageCluster only if Create request is made
 	// and ignore it if there's another active StorageCluster
 	// If Update request is made and StorageCluster is PhaseIgnored, no need to
@@ -102,24 +180,22 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 		}
 		if !isActive {
 			instance.Status.Phase = statusutil.PhaseIgnored
-			phaseErr := r.client.Status().Update(context.TODO(), instance)
-			if phaseErr != nil {
-				reqLogger.Error(phaseErr, "Failed to set PhaseIgnored")
-				return reconcile.Result{}, phaseErr
-			}
 			return reconcile.Result{}, nil
 		}
 	} else if instance.Status.Phase == statusutil.PhaseIgnored {
 		return reconcile.Result{}, nil
 	}
 
+	// Only publish what would be changed if asked to plan
+	if isPlanStrategy(instance) && instance.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, r.reconcilePlan(instance, reqLogger)
+	}
+
 	if instance.Status.Phase != statusutil.PhaseReady &&
-		instance.Status.Phase != statusutil.PhaseClusterExpanding {
+		instance.Status.Phase != statusutil.PhaseClusterExpanding &&
+		instance.Status.Phase != statusutil.PhaseDeleting &&
+		instance.Status.Phase != statusutil.PhaseProgressing {
 		instance.Status.Phase = statusutil.PhaseProgressing
-		phaseErr := r.client.Status().Update(context.TODO(), instance)
-		if phaseErr != nil {
-			reqLogger.Error(phaseErr, "Failed to set PhaseProgressing")
-		}
 	}
 
 	// Add conditions if there are none
@@ -127,11 +203,6 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 		reason := ocsv1.ReconcileInit
 		message := "Initializing StorageCluster"
 		statusutil.SetProgressingCondition(&instance.Status.Conditions, reason, message)
-		err = r.client.Status().Update(context.TODO(), instance)
-		if err != nil {
-			reqLogger.Error(err, "Failed to add conditions to status")
-			return reconcile.Result{}, err
-		}
 	}
 
 	// Check GetDeletionTimestamp to determine if the object is under deletion
@@ -139,7 +210,7 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 		if !contains(instance.GetFinalizers(), storageClusterFinalizer) {
 			reqLogger.Info("Finalizer not found for storagecluster. Adding finalizer")
 			instance.ObjectMeta.Finalizers = append(instance.ObjectMeta.Finalizers, storageClusterFinalizer)
-			if err := r.client.Update(context.TODO(), instance); err != nil {
+			if err := r.updateMetadata(instance); err != nil {
 				reqLogger.Error(err, "Failed to update storagecluster with finalizer")
 				return reconcile.Result{}, err
 			}
@@ -156,19 +227,27 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 				reqLogger.Info("Removing finalizer")
 				// Once all finalizers have been removed, the object will be deleted
 				instance.ObjectMeta.Finalizers = remove(instance.ObjectMeta.Finalizers, storageClusterFinalizer)
-				if err := r.client.Update(context.TODO(), instance); err != nil {
+				if err := r.updateMetadata(instance); err != nil {
 					reqLogger.Error(err, "Failed to remove finalizer from storagecluster")
 					return reconcile.Result{}, err
 				}
 			} else {
-				// Watch resources and events and reconcile.
-				return reconcile.Result{}, nil
+				// Not every resource being deleted is watched, so
+				// check back periodically
+				return reconcile.Result{RequeueAfter: uninstallRequeueInterval}, nil
 			}
 		}
 		reqLogger.Info("Object is terminated, skipping reconciliation")
 		return reconcile.Result{}, nil
 	}
 
+	// Pick the storage nodes if they are managed by the operator
+	err := r.ensureManagedNodes(instance, reqLogger)
+	if err != nil {
+		reqLogger.Error(err, "Failed to ensure managed storage nodes")
+		return reconcile.Result{}, err
+	}
+
 	// Get storage node topology labels
 	err = r.reconcileNodeTopologyMap(instance, reqLogger)
 	if err != nil {
@@ -176,6 +255,13 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 		return reconcile.Result{}, err
 	}
 
+	// Apply a change of the failure domain asked for by the spec
+	err = r.ensureFailureDomain(instance, reqLogger)
+	if err != nil {
+		reqLogger.Error(err, "Failed to ensure failure domain")
+		return reconcile.Result{}, err
+	}
+
 	// Check for StorageClusterInitialization
 	scinit := &ocsv1.StorageClusterInitialization{}
 	err = r.client.Get(context.TODO(), request.NamespacedName, scinit)
@@ -186,15 +272,15 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 			// if the StorageClusterInitialization object doesn't exist
 			// ensure we re-reconcile on all initialization resources
 			instance.Status.StorageClassesCreated = false
+			instance.Status.SnapshotClassesCreated = false
+			instance.Status.BucketStorageClassCreated = false
 			instance.Status.CephObjectStoresCreated = false
 			instance.Status.CephBlockPoolsCreated = false
 			instance.Status.CephObjectStoreUsersCreated = false
 			instance.Status.CephFilesystemsCreated = false
-			instance.Status.FailureDomain = determineFailureDomain(instance)
-			err = r.client.Status().Update(context.TODO(), instance)
-			if err != nil {
-				return reconcile.Result{}, err
-			}
+			failureDomain := determineFailureDomain(instance)
+			instance.Status.FailureDomainKey = determineFailureDomainKey(instance)
+			instance.Status.FailureDomain = failureDomain
 
 			scinit.Name = request.Name
 			scinit.Namespace = request.Namespace
@@ -218,55 +304,22 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 		}
 	}
 
-	// in-memory conditions should start off empty. It will only ever hold
-	// negative conditions (!Available, Degraded, Progressing)
-	r.conditions = nil
-	// Start with empty r.phase
-	r.phase = ""
-
-	for _, f := range []func(*ocsv1.StorageCluster, logr.Logger) error{
-		// Add support for additional resources here
-		r.ensureStorageClasses,
-		r.ensureCephObjectStores,
-		r.ensureCephObjectStoreUsers,
-		r.ensureCephBlockPools,
-		r.ensureCephFilesystems,
-
-		r.ensureCephConfig,
-		r.ensureCephCluster,
-		r.ensureNoobaaSystem,
-	} {
-		err = f(instance, reqLogger)
-		if r.phase == statusutil.PhaseClusterExpanding {
-			instance.Status.Phase = statusutil.PhaseClusterExpanding
-			phaseErr := r.client.Status().Update(context.TODO(), instance)
-			if phaseErr != nil {
-				reqLogger.Error(phaseErr, "Failed to set PhaseClusterExpanding")
-			}
-		} else {
-			if instance.Status.Phase != statusutil.PhaseReady {
-				instance.Status.Phase = statusutil.PhaseProgressing
-				phaseErr := r.client.Status().Update(context.TODO(), instance)
-				if phaseErr != nil {
-					reqLogger.Error(phaseErr, "Failed to set PhaseProgressing")
-				}
-			}
-		}
-		if err != nil {
-			reason := ocsv1.ReconcileFailed
-			message := fmt.Sprintf("Error while reconciling: %v", err)
-			statusutil.SetErrorCondition(&instance.Status.Conditions, reason, message)
-			instance.Status.Phase = statusutil.PhaseError
-			// don't want to overwrite the actual reconcile failure
-			uErr := r.client.Status().Update(context.TODO(), instance)
-			if uErr != nil {
-				reqLogger.Error(uErr, "Failed to update status")
-			}
-			return reconcile.Result{}, err
-		}
+	state := &reconcileState{}
+	err = r.runSteps(instance, r.reconcileSteps(state), reqLogger)
+	if state.phase == statusutil.PhaseClusterExpanding {
+		instance.Status.Phase = statusutil.PhaseClusterExpanding
+	} else if instance.Status.Phase != statusutil.PhaseReady {
+		instance.Status.Phase = statusutil.PhaseProgressing
+	}
+	if err != nil {
+		reason := ocsv1.ReconcileFailed
+		message := fmt.Sprintf("Error while reconciling: %v", err)
+		statusutil.SetErrorCondition(&instance.Status.Conditions, reason, message)
+		instance.Status.Phase = statusutil.PhaseError
+		return reconcile.Result{}, err
 	}
 	// All component operators are in a happy state.
-	if r.conditions == nil {
+	if state.conditions == nil {
 		reqLogger.Info("No component operator reported negatively")
 		reason := ocsv1.ReconcileCompleted
 		message := ocsv1.ReconcileCompletedMessage
@@ -288,12 +341,12 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 		// the instance while preserving it's lastTransitionTime.
 		// For example, consider the resource has the Available condition
 		// type with type "False". When reconciling the resource we would
-		// add it to the in-memory representation of OCS's conditions (r.conditions)
+		// add it to the in-memory representation of OCS's conditions (state.conditions)
 		// and here we are simply writing it back to the server.
 		// One shortcoming is that only one failure of a particular condition can be
 		// captured at one time (ie. if resource1 and resource2 are both reporting !Available,
 		// you will only see resource2q as it updates last).
-		for _, condition := range r.conditions {
+		for _, condition := range state.conditions {
 			conditionsv1.SetStatusCondition(&instance.Status.Conditions, condition)
 		}
 		reason := ocsv1.ReconcileCompleted
@@ -324,18 +377,28 @@ func (r *ReconcileStorageCluster) Reconcile(request reconcile.Request) (reconcil
 			}
 		}
 	}
-	phaseErr := r.client.Status().Update(context.TODO(), instance)
-	if phaseErr != nil {
-		reqLogger.Error(phaseErr, "Failed to update status")
+	// Check back when the next stale topology label is due to be pruned,
+	// the next rack move may be applied, or the NooBaa system may be
+	// created
+	now := time.Now()
+	requeueAfter := getNodeTopologyRequeueAfter(instance, now)
+	for _, after := range []time.Duration{
+		getRackRebalanceRequeueAfter(instance, now),
+		r.getNoobaaCreateRequeueAfter(instance, now),
+	} {
+		if after > 0 && (requeueAfter == 0 || after < requeueAfter) {
+			requeueAfter = after
+		}
 	}
-	return reconcile.Result{}, phaseErr
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
 }
 
 // reconcileNodeTopologyMap builds the map of all topology labels on all nodes
-// in the storage cluster
+// in the storage cluster. Values no longer found on any storage node are
+// pruned once they have been missing for nodeTopologyGracePeriod.
 func (r *ReconcileStorageCluster) reconcileNodeTopologyMap(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
 	minNodes := defaults.DeviceSetReplica
-	for _, deviceSet := range sc.Spec.StorageDeviceSets {
+	for _, deviceSet := range getStorageDeviceSets(sc) {
 		if deviceSet.Replica > minNodes {
 			minNodes = deviceSet.Replica
 		}
@@ -352,7 +415,7 @@ func (r *ReconcileStorageCluster) reconcileNodeTopologyMap(sc *ocsv1.StorageClus
 	if sc.Status.NodeTopologies == nil || sc.Status.NodeTopologies.Labels == nil {
 		sc.Status.NodeTopologies = ocsv1.NewNodeTopologyMap()
 	}
-	topologyMap := sc.Status.NodeTopologies
+	topologyMap := ocsv1.NewNodeTopologyMap()
 	updated := false
 	nodeRacks := ocsv1.NewNodeTopologyMap()
 
@@ -360,48 +423,110 @@ func (r *ReconcileStorageCluster) reconcileNodeTopologyMap(sc *ocsv1.StorageClus
 		return fmt.Errorf("Not enough nodes found: Expected %d, found %d", minNodes, len(nodes.Items))
 	}
 
+	topologyKeys := getTopologyKeys(sc)
+	nodeLabels := map[string]map[string]string{}
+	hasRack := map[string]bool{}
 	for _, node := range nodes.Items {
-		labels := node.Labels
-		for label, value := range labels {
-			for _, key := range validTopologyLabelKeys {
-				if strings.Contains(label, key) {
-					if !topologyMap.Contains(label, value) {
-						reqLogger.Info("Adding topology label from node", "Node", node.Name, "Label", label, "Value", value)
-						topologyMap.Add(label, value)
-						updated = true
-					}
-				}
+		for _, key := range topologyKeys {
+			value, ok := node.Labels[key]
+			if !ok {
+				continue
 			}
-			if strings.Contains(label, "rack") {
+			if nodeLabels[node.Name] == nil {
+				nodeLabels[node.Name] = map[string]string{}
+			}
+			nodeLabels[node.Name][key] = value
+			if !topologyMap.Contains(key, value) {
+				topologyMap.Add(key, value)
+			}
+			if placement.KeyType(key) == "rack" {
+				hasRack[node.Name] = true
 				if !nodeRacks.Contains(value, node.Name) {
 					nodeRacks.Add(value, node.Name)
 				}
 			}
 		}
-
 	}
 
-	if determineFailureDomain(sc) == "rack" {
-		err = r.ensureNodeRacks(nodes, minNodes, nodeRacks, topologyMap, reqLogger)
+	// Values of departed nodes still count towards the failure domain
+	// until they are pruned
+	recorded := sc.DeepCopy()
+	for key, values := range topologyMap.Labels {
+		for _, value := range values {
+			if !recorded.Status.NodeTopologies.Contains(key, value) {
+				recorded.Status.NodeTopologies.Add(key, value)
+			}
+		}
+	}
+	// Racks are only made up if the spec does not name the label of the
+	// failure domain
+	if _, specKey := getSpecFailureDomain(sc); determineFailureDomain(recorded) == "rack" && specKey == "" {
+		err = r.ensureNodeRacks(sc, nodes, minNodes, nodeRacks, topologyMap, topologyKeys, reqLogger)
 		if err != nil {
 			return err
 		}
+		// Pick up the racks the nodes were just labeled with
+		for rack, nodeNames := range nodeRacks.Labels {
+			for _, nodeName := range nodeNames {
+				if hasRack[nodeName] {
+					continue
+				}
+				if nodeLabels[nodeName] == nil {
+					nodeLabels[nodeName] = map[string]string{}
+				}
+				nodeLabels[nodeName][defaults.RackTopologyKey] = rack
+			}
+		}
+	}
+
+	topologyMap, staleTopologies := mergeNodeTopologies(sc, topologyMap, metav1.Now(), reqLogger)
+	if !reflect.DeepEqual(topologyMap, sc.Status.NodeTopologies) {
+		sc.Status.NodeTopologies = topologyMap
+		updated = true
+	}
+	if len(staleTopologies) != len(sc.Status.StaleNodeTopologies) ||
+		(len(staleTopologies) > 0 && !reflect.DeepEqual(staleTopologies, sc.Status.StaleNodeTopologies)) {
+		sc.Status.StaleNodeTopologies = staleTopologies
+		updated = true
+	}
+
+	storageNodes := []ocsv1.StorageNodeTopology{}
+	for _, node := range nodes.Items {
+		storageNodes = append(storageNodes, ocsv1.StorageNodeTopology{
+			Name:   node.Name,
+			Labels: nodeLabels[node.Name],
+		})
+	}
+	sort.Slice(storageNodes, func(i, j int) bool {
+		return storageNodes[i].Name < storageNodes[j].Name
+	})
+	if !reflect.DeepEqual(storageNodes, sc.Status.StorageNodes) {
+		sc.Status.StorageNodes = storageNodes
+		updated = true
+	}
+
+	// StorageClusters deployed before the FailureDomainKey was recorded
+	// get it from the labels they were deployed with
+	if sc.Status.FailureDomain != "" && sc.Status.FailureDomainKey == "" {
+		sc.Status.FailureDomainKey = determineFailureDomainKey(sc)
+		if sc.Status.FailureDomainKey != "" {
+			reqLogger.Info("Recording failure domain key", "FailureDomain", sc.Status.FailureDomain, "Label", sc.Status.FailureDomainKey)
+			updated = true
+		}
 	}
 
 	if updated {
 		reqLogger.Info("Updating node topology map for StorageCluster")
-		err = r.client.Status().Update(context.TODO(), sc)
-		if err != nil {
-			return err
-		}
 	}
 
 	return nil
 }
 
 // ensureNodeRacks iterates through the list of storage nodes and ensures
-// all nodes have a rack topology label.
-func (r *ReconcileStorageCluster) ensureNodeRacks(nodes *corev1.NodeList, minRacks int, nodeRacks, topologyMap *ocsv1.NodeTopologyMap, reqLogger logr.Logger) error {
+// all nodes have a rack topology label. The StorageCluster is recorded on
+// the nodes with made up racks.
+func (r *ReconcileStorageCluster) ensureNodeRacks(sc *ocsv1.StorageCluster, nodes *corev1.NodeList, minRacks int, nodeRacks, topologyMap *ocsv1.NodeTopologyMap, topologyKeys []string, reqLogger logr.Logger) error {
+	defer r.locks.lock(nodesLockKey)()
 
 	for _, node := range nodes.Items {
 		hasRack := false
@@ -418,8 +543,10 @@ func (r *ReconcileStorageCluster) ensureNodeRacks(nodes *corev1.NodeList, minRac
 			}
 		}
 
+		newNode := node.DeepCopy()
+		changed := false
 		if !hasRack {
-			rack := determinePlacementRack(nodes, node, minRacks, nodeRacks)
+			rack := placement.DetermineRack(nodes, node, minRacks, nodeRacks, topologyKeys)
 			nodeRacks.Add(rack, node.Name)
 			if !topologyMap.Contains(defaults.RackTopologyKey, rack) {
 				reqLogger.Info("Adding rack label from node", "Node", node.Name, "Label", defaults.RackTopologyKey, "Value", rack)
@@ -427,16 +554,27 @@ func (r *ReconcileStorageCluster) ensureNodeRacks(nodes *corev1.NodeList, minRac
 			}
 
 			reqLogger.Info("Labeling node with rack label", "Node", node.Name, "Label", defaults.RackTopologyKey, "Value", rack)
-			newNode := node.DeepCopy()
 			newNode.Labels[defaults.RackTopologyKey] = rack
-			patch, err := generateStrategicPatch(node, newNode)
-			if err != nil {
-				return err
-			}
-			err = r.client.Patch(context.TODO(), &node, patch)
-			if err != nil {
-				return err
-			}
+			changed = true
+		}
+
+		// The StorageCluster relies on the racks made up by the operator,
+		// whichever StorageCluster made them up
+		rack, ok := newNode.Labels[defaults.RackTopologyKey]
+		if ok && generatedRackName.MatchString(rack) && setNodeChangeOwner(sc, newNode, nodeRackChange, true) {
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+		patch, err := generateStrategicPatch(node, newNode)
+		if err != nil {
+			return err
+		}
+		err = r.client.Patch(context.TODO(), &node, patch)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -462,91 +600,6 @@ func generateStrategicPatch(oldObj, newObj interface{}) (client.Patch, error) {
 	return client.ConstantPatch(types.StrategicMergePatchType, patch), nil
 }
 
-// determinePlacementRack sorts the list of known racks in alphabetical order,
-// counts the number of Nodes in each rack, then returns the first rack with
-// the fewest number of Nodes. If there are fewer than three racks, define new
-// racks so that there are at least three. It also ensures that only racks with
-// either no nodes or nodes in the same AZ are considered valid racks.
-func determinePlacementRack(nodes *corev1.NodeList, node corev1.Node, minRacks int, nodeRacks *ocsv1.NodeTopologyMap) string {
-	rackList := []string{}
-
-	if len(nodeRacks.Labels) < minRacks {
-		for i := len(nodeRacks.Labels); i < minRacks; i++ {
-			for j := 0; j <= i; j++ {
-				newRack := fmt.Sprintf("rack%d", j)
-				if _, ok := nodeRacks.Labels[newRack]; !ok {
-					nodeRacks.Labels[newRack] = ocsv1.TopologyLabelValues{}
-					break
-				}
-			}
-		}
-	}
-
-	targetAZ := ""
-	for label, value := range node.Labels {
-		for _, key := range validTopologyLabelKeys {
-			if strings.Contains(label, key) && strings.Contains(label, "zone") {
-				targetAZ = value
-				break
-			}
-		}
-		if targetAZ != "" {
-			break
-		}
-	}
-
-	if len(targetAZ) > 0 {
-		for rack := range nodeRacks.Labels {
-			nodeNames := nodeRacks.Labels[rack]
-			if len(nodeNames) == 0 {
-				rackList = append(rackList, rack)
-				continue
-			}
-
-			validRack := false
-			for _, nodeName := range nodeNames {
-				for _, n := range nodes.Items {
-					if n.Name == nodeName {
-						for label, value := range n.Labels {
-							for _, key := range validTopologyLabelKeys {
-								if strings.Contains(label, key) && strings.Contains(label, "zone") && value == targetAZ {
-									validRack = true
-									break
-								}
-							}
-							if validRack {
-								break
-							}
-						}
-						break
-					}
-				}
-				if validRack {
-					break
-				}
-			}
-			if validRack {
-				rackList = append(rackList, rack)
-			}
-		}
-	} else {
-		for rack := range nodeRacks.Labels {
-			rackList = append(rackList, rack)
-		}
-	}
-
-	sort.Strings(rackList)
-	rack := rackList[0]
-
-	for _, r := range rackList {
-		if len(nodeRacks.Labels[r]) < len(nodeRacks.Labels[rack]) {
-			rack = r
-		}
-	}
-
-	return rack
-}
-
 // ensureCephConfig ensures that a ConfigMap resource exists with its Spec in
 // the desired state.
 func (r *ReconcileStorageCluster) ensureCephConfig(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
@@ -572,31 +625,37 @@ func (r *ReconcileStorageCluster) ensureCephConfig(sc *ocsv1.StorageCluster, req
 	if err != nil {
 		if errors.IsNotFound(err) {
 			reqLogger.Info("Creating Ceph ConfigMap")
-			err = r.client.Create(context.TODO(), cm)
-			if err != nil {
-				return err
-			}
+			return r.client.Create(context.TODO(), cm)
 		}
 		return err
 	}
 
 	ownerRefFound := false
-	for _, ownerRef := range found.OwnerReferences {
-		if ownerRef.UID == sc.UID {
+	for _, ref := range found.OwnerReferences {
+		if ref.UID == sc.UID {
 			ownerRefFound = true
 		}
 	}
 	val, ok := found.Data["config"]
 	if ok != true || val != rookConfigData || ownerRefFound != true {
 		reqLogger.Info("Updating Ceph ConfigMap")
-		return r.client.Update(context.TODO(), cm)
+		// Update the object read, so that the update fails on a conflict
+		// rather than overwriting a newer version
+		if !ownerRefFound {
+			found.OwnerReferences = append(found.OwnerReferences, ownerRef)
+		}
+		if found.Data == nil {
+			found.Data = map[string]string{}
+		}
+		found.Data["config"] = rookConfigData
+		return r.client.Update(context.TODO(), found)
 	}
 	return nil
 }
 
 // ensureCephCluster ensures that a CephCluster resource exists with its Spec in
 // the desired state.
-func (r *ReconcileStorageCluster) ensureCephCluster(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+func (r *ReconcileStorageCluster) ensureCephCluster(sc *ocsv1.StorageCluster, state *reconcileState, reqLogger logr.Logger) error {
 	// Define a new CephCluster object
 	cephCluster := newCephCluster(sc, r.cephImage)
 
@@ -616,21 +675,27 @@ func (r *ReconcileStorageCluster) ensureCephCluster(sc *ocsv1.StorageCluster, re
 		return err
 	}
 
+	// Rook cannot move the data of a running cluster, so a CephCluster
+	// created with the data dir shared by all StorageClusters keeps it
+	if found.Spec.DataDirHostPath != "" {
+		cephCluster.Spec.DataDirHostPath = found.Spec.DataDirHostPath
+	}
+
 	// Update the CephCluster if it is not in the desired state
 	if !reflect.DeepEqual(cephCluster.Spec, found.Spec) {
 		reqLogger.Info("Updating spec for CephCluster")
 		// Check if Cluster is Expanding
 		if len(found.Spec.Storage.StorageClassDeviceSets) < len(cephCluster.Spec.Storage.StorageClassDeviceSets) {
-			r.phase = statusutil.PhaseClusterExpanding
+			state.phase = statusutil.PhaseClusterExpanding
 		} else if len(found.Spec.Storage.StorageClassDeviceSets) == len(cephCluster.Spec.Storage.StorageClassDeviceSets) {
 			for _, countInFoundSpec := range found.Spec.Storage.StorageClassDeviceSets {
 				for _, countInCephClusterSpec := range cephCluster.Spec.Storage.StorageClassDeviceSets {
 					if countInFoundSpec.Name == countInCephClusterSpec.Name && countInCephClusterSpec.Count > countInFoundSpec.Count {
-						r.phase = statusutil.PhaseClusterExpanding
+						state.phase = statusutil.PhaseClusterExpanding
 						break
 					}
 				}
-				if r.phase == statusutil.PhaseClusterExpanding {
+				if state.phase == statusutil.PhaseClusterExpanding {
 					break
 				}
 			}
@@ -645,45 +710,27 @@ func (r *ReconcileStorageCluster) ensureCephCluster(sc *ocsv1.StorageCluster, re
 		return err
 	}
 	objectreferencesv1.SetObjectReference(&sc.Status.RelatedObjects, *objectRef)
-
-	// Handle CephCluster resource status
-	if found.Status.State == "" {
-		reqLogger.Info("CephCluster resource is not reporting status.")
-		// What does this mean to OCS status? Assuming progress.
-		reason := "CephClusterStatus"
-		message := "CephCluster resource is not reporting status"
-		statusutil.MapCephClusterNoConditions(&r.conditions, reason, message)
-	} else {
-		// Interpret CephCluster status and set any negative conditions
-		statusutil.MapCephClusterNegativeConditions(&r.conditions, found)
-	}
-
-	// When phase is expanding, wait for CephCluster state to be updating
-	// this means expansion is in progress and overall system is progressing
-	// else expansion is not yet triggered
-	if sc.Status.Phase == statusutil.PhaseClusterExpanding &&
-		found.Status.State != cephv1.ClusterStateUpdating {
-		r.phase = statusutil.PhaseClusterExpanding
-	}
 	return nil
 }
 
 // determineFailureDomain determines the appropriate Ceph failure domain based
-// on the storage cluster's topology map
+// on the spec, or else on the storage cluster's topology map
 func determineFailureDomain(sc *ocsv1.StorageCluster) string {
 	if sc.Status.FailureDomain != "" {
 		return sc.Status.FailureDomain
 	}
-	topologyMap := sc.Status.NodeTopologies
-	failureDomain := "rack"
-	for label, labelValues := range topologyMap.Labels {
-		if strings.Contains(label, "zone") {
-			if len(labelValues) >= 3 {
-				failureDomain = "zone"
-			}
-		}
+	if sc.Spec.FailureDomain != "" {
+		failureDomain, _ := getSpecFailureDomain(sc)
+		return failureDomain
 	}
-	return failureDomain
+	return placement.DefaultFailureDomain(sc.Status.NodeTopologies)
+}
+
+// getDataDirHostPath returns the host path the Ceph daemons of the
+// StorageCluster keep their data in. Each StorageCluster has its own, so
+// that the cleanup of one leaves the data of the others on the same nodes.
+func getDataDirHostPath(sc *ocsv1.StorageCluster) string {
+	return path.Join(rookDataDirHostPath, fmt.Sprintf("%s-%s", sc.Namespace, sc.Name))
 }
 
 // newCephCluster returns a CephCluster object.
@@ -712,7 +759,7 @@ func newCephCluster(sc *ocsv1.StorageCluster, cephImage string) *cephv1.CephClus
 					cephv1.Module{Name: "pg_autoscaler", Enabled: true},
 				},
 			},
-			DataDirHostPath: "/var/lib/rook",
+			DataDirHostPath: getDataDirHostPath(sc),
 			DisruptionManagement: cephv1.DisruptionManagementSpec{
 				ManagePodBudgets:                 true,
 				ManageMachineDisruptionBudgets:   false,
@@ -726,7 +773,7 @@ func newCephCluster(sc *ocsv1.StorageCluster, cephImage string) *cephv1.CephClus
 			},
 			Monitoring: cephv1.MonitoringSpec{
 				Enabled:        true,
-				RulesNamespace: "openshift-storage",
+				RulesNamespace: sc.Namespace,
 			},
 			Storage: rook.StorageScopeSpec{
 				StorageClassDeviceSets: newStorageClassDeviceSets(sc),
@@ -735,7 +782,7 @@ func newCephCluster(sc *ocsv1.StorageCluster, cephImage string) *cephv1.CephClus
 			Placement: rook.PlacementSpec{
 				"all": defaults.DaemonPlacements["all"],
 			},
-			Resources: newCephDaemonResources(sc.Spec.Resources),
+			Resources: newCephDaemonResources(getResourceProfile(sc), sc.Spec.Resources),
 		},
 	}
 
@@ -744,8 +791,8 @@ func newCephCluster(sc *ocsv1.StorageCluster, cephImage string) *cephv1.CephClus
 	// first StorageDeviceSet for providing the Mon PVs
 	if sc.Spec.MonPVCTemplate != nil {
 		cephCluster.Spec.Mon.VolumeClaimTemplate = sc.Spec.MonPVCTemplate
-	} else if len(sc.Spec.StorageDeviceSets) > 0 {
-		ds := sc.Spec.StorageDeviceSets[0]
+	} else if deviceSets := getStorageDeviceSets(sc); len(deviceSets) > 0 {
+		ds := deviceSets[0]
 		cephCluster.Spec.Mon.VolumeClaimTemplate = &corev1.PersistentVolumeClaim{
 			Spec: corev1.PersistentVolumeClaimSpec{
 				StorageClassName: ds.DataPVCTemplate.Spec.StorageClassName,
@@ -761,97 +808,60 @@ func newCephCluster(sc *ocsv1.StorageCluster, cephImage string) *cephv1.CephClus
 	return cephCluster
 }
 
-func newCephDaemonResources(custom map[string]corev1.ResourceRequirements) map[string]corev1.ResourceRequirements {
-	resources := map[string]corev1.ResourceRequirements{
-		"mon": defaults.GetDaemonResources("mon", custom),
-		"mgr": defaults.GetDaemonResources("mgr", custom),
-	}
-
-	for k := range resources {
-		if r, ok := custom[k]; ok {
-			resources[k] = r
-		}
+func newCephDaemonResources(profile string, custom map[string]corev1.ResourceRequirements) map[string]corev1.ResourceRequirements {
+	return map[string]corev1.ResourceRequirements{
+		"mon": defaults.GetDaemonResources("mon", profile, custom),
+		"mgr": defaults.GetDaemonResources("mgr", profile, custom),
 	}
-
-	return resources
 }
 
 // newStorageClassDeviceSets converts a list of StorageDeviceSets into a list of Rook StorageClassDeviceSets
 func newStorageClassDeviceSets(sc *ocsv1.StorageCluster) []rook.StorageClassDeviceSet {
-	storageDeviceSets := sc.Spec.StorageDeviceSets
-	topologyMap := sc.Status.NodeTopologies
+	storageDeviceSets := getStorageDeviceSets(sc)
 
 	var storageClassDeviceSets []rook.StorageClassDeviceSet
 
 	for _, ds := range storageDeviceSets {
 		resources := ds.Resources
 		if resources.Requests == nil && resources.Limits == nil {
-			resources = defaults.DaemonResources["osd"]
-		}
-
-		topologyKey := ds.TopologyKey
-		topologyKeyValues := []string{}
-		noPlacement := ds.Placement.NodeAffinity == nil && ds.Placement.PodAffinity == nil && ds.Placement.PodAntiAffinity == nil
-
-		if noPlacement {
-			if topologyKey == "" {
-				topologyKey = determineFailureDomain(sc)
-			}
-			if topologyMap != nil {
-				topologyKey, topologyKeyValues = topologyMap.GetKeyValues(topologyKey)
-			}
+			resources = defaults.GetDaemonResources("osd", getResourceProfile(sc), sc.Spec.Resources)
 		}
 
-		count := ds.Count
-		replica := ds.Replica
-		if replica == 0 {
-			replica = defaults.DeviceSetReplica
-
-			// This is a temporary hack in place due to limitations
-			// in the current implementation of the OCP console.
-			// The console is hardcoded to create a StorageCluster
-			// with a Count of 3, as made sense for the previous
-			// behavior, but it cannot be updated until the next
-			// z-stream release of OCP 4.2. This workaround is to
-			// enable the new behavior while the console is waiting
-			// to be updated.
-			// TODO: Remove this behavior when OCP console is updated
-			count = count / 3
-		}
+		dsPlacement := placement.PlaceDeviceSet(ds, getPlacementTopology(sc))
+		_, count := placement.DeviceSetReplica(ds)
 
-		for i := 0; i < replica; i++ {
-			placement := rook.Placement{}
+		for i, value := range dsPlacement.Values {
+			rookPlacement := rook.Placement{}
 			portable := ds.Portable
 
-			if noPlacement {
+			if !dsPlacement.Custom {
 				in := defaults.DaemonPlacements["osd"]
-				(&in).DeepCopyInto(&placement)
+				(&in).DeepCopyInto(&rookPlacement)
 
-				if len(topologyKeyValues) >= replica {
+				if value != "" {
 					portable = true
-					podAffinityTerms := placement.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
-					podAffinityTerms[0].PodAffinityTerm.TopologyKey = topologyKey
+					podAffinityTerms := rookPlacement.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+					podAffinityTerms[0].PodAffinityTerm.TopologyKey = dsPlacement.TopologyKey
 
-					topologyIndex := i % len(topologyKeyValues)
 					nodeZoneSelector := corev1.NodeSelectorRequirement{
-						Key:      topologyKey,
+						Key:      dsPlacement.TopologyKey,
 						Operator: corev1.NodeSelectorOpIn,
-						Values:   []string{topologyKeyValues[topologyIndex]},
+						Values:   []string{value},
 					}
-					nodeSelectorTerms := placement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+					nodeSelectorTerms := rookPlacement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
 					nodeSelectorTerms[0].MatchExpressions = append(nodeSelectorTerms[0].MatchExpressions, nodeZoneSelector)
 				} else {
 					portable = false
 				}
 			} else {
-				placement = ds.Placement
+				rookPlacement = ds.Placement
 			}
 
 			set := rook.StorageClassDeviceSet{
 				Name:                 fmt.Sprintf("%s-%d", ds.Name, i),
 				Count:                count,
 				Resources:            resources,
-				Placement:            placement,
+				Placement:            rookPlacement,
 				Config:               ds.Config.ToMap(),
 				VolumeClaimTemplates: []corev1.PersistentVolumeClaim{ds.DataPVCTemplate},
 				Portable:             portable,
@@ -863,6 +873,9 @@ func newStorageClassDeviceSets(sc *ocsv1.StorageCluster) []rook.StorageClassDevi
 	return storageClassDeviceSets
 }
 
+// isActiveStorageCluster tells whether the StorageCluster is the one to be
+// reconciled in its namespace. StorageClusters of different namespaces are
+// all reconciled, but Rook runs a single CephCluster per namespace.
 func (r *ReconcileStorageCluster) isActiveStorageCluster(instance *ocsv1.StorageCluster) (bool, error) {
 	storageClusterList := ocsv1.StorageClusterList{}
 
@@ -905,12 +918,6 @@ func (r *ReconcileStorageCluster) isActiveStorageCluster(instance *ocsv1.Storage
 	return true, nil
 }
 
-func (r *ReconcileStorageCluster) deleteResources(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
-	// NoobaaSystem is dependent upon ceph for volume provisioning.
-	// We want to make sure we delete noobaasystem before we delete cephcluster, to get a clean uninstall.
-	return r.deleteNoobaaSystems(sc, reqLogger)
-}
-
 // Checks whether a string is contained within a slice
 func contains(slice []string, s string) bool {
 	for _, item := range slice {
@@ -0,0 +1,129 @@
+package render
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/storagecluster"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+// Run `go test ./pkg/controller/storagecluster/render/ -run TestGolden -update` to
+// write the objects rendered for the fixtures to their golden files
+var updateGolden = flag.Bool("update", false, "update the golden files of TestGolden")
+
+const goldenDir = "testdata/golden"
+
+// TestGolden renders the StorageCluster and the nodes of every directory in
+// testdata/golden, and compares the objects the operator creates for them
+// with the expected.yaml of the directory
+func TestGolden(t *testing.T) {
+	cases, err := ioutil.ReadDir(goldenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := newDecoder(t)
+
+	for _, c := range cases {
+		if !c.IsDir() {
+			continue
+		}
+		dir := filepath.Join(goldenDir, c.Name())
+		t.Run(c.Name(), func(t *testing.T) {
+			sc := &api.StorageCluster{}
+			decodeGoldenFixture(t, decoder, filepath.Join(dir, "storagecluster.yaml"), sc)
+			nodes := &corev1.NodeList{}
+			decodeGoldenFixture(t, decoder, filepath.Join(dir, "nodes.yaml"), nodes)
+
+			result, err := Render(sc, nodes.Items, storagecluster.ReconcilerOptions{
+				CephImage:       "ceph/ceph:v14.2.4",
+				NoobaaCoreImage: "noobaa/noobaa-core:5.2.11",
+				NoobaaDBImage:   "centos/mongodb-36-centos7",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := result.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			goldenFile := filepath.Join(dir, "expected.yaml")
+			if *updateGolden {
+				err = ioutil.WriteFile(goldenFile, actual, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(goldenFile)
+			if os.IsNotExist(err) {
+				t.Fatalf("%s is missing, run the test with -update to write it", goldenFile)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(expected) != string(actual) {
+				t.Errorf("Objects differ from %s, run the test with -update to accept the change:\n%s",
+					goldenFile, diff.StringDiff(string(expected), string(actual)))
+			}
+		})
+	}
+}
+
+// TestGoldenStable makes sure that rendering is repeatable, as the golden
+// files would be of no use otherwise
+func TestGoldenStable(t *testing.T) {
+	sc, nodes := getTestFixtures(t)
+
+	rendered := []string{}
+	for i := 0; i < 3; i++ {
+		result, err := Render(sc, nodes, storagecluster.ReconcilerOptions{})
+		assert.NoError(t, err)
+		data, err := result.Marshal()
+		assert.NoError(t, err)
+		rendered = append(rendered, string(data))
+	}
+	assert.Equal(t, rendered[0], rendered[1])
+	assert.Equal(t, rendered[0], rendered[2])
+}
+
+func newDecoder(t *testing.T) runtime.Decoder {
+	scheme, err := NewScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serializer.NewCodecFactory(scheme).UniversalDeserializer()
+}
+
+// getTestFixtures returns the StorageCluster and the nodes of the three-zones
+// golden fixture
+func getTestFixtures(t *testing.T) (*api.StorageCluster, []corev1.Node) {
+	decoder := newDecoder(t)
+	dir := filepath.Join(goldenDir, "three-zones")
+	sc := &api.StorageCluster{}
+	decodeGoldenFixture(t, decoder, filepath.Join(dir, "storagecluster.yaml"), sc)
+	nodes := &corev1.NodeList{}
+	decodeGoldenFixture(t, decoder, filepath.Join(dir, "nodes.yaml"), nodes)
+	return sc, nodes.Items
+}
+
+func decodeGoldenFixture(t *testing.T, decoder runtime.Decoder, file string, into runtime.Object) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = decoder.Decode(data, nil, into)
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", file, err)
+	}
+}
@@ -0,0 +1,238 @@
+// Package render runs the StorageCluster controller against an in-memory
+// cluster, to show the objects it creates without a cluster to run against.
+// It is kept apart from the controller so that the operator does not link
+// the fake client.
+package render
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/ghodss/yaml"
+	obv1 "github.com/kube-object-storage/lib-bucket-provisioner/pkg/apis/objectbucket.io/v1alpha1"
+	nbapis "github.com/noobaa/noobaa-operator/v2/pkg/apis"
+	secv1 "github.com/openshift/api/security/v1"
+	"github.com/openshift/ocs-operator/pkg/apis"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/storagecluster"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// Result holds what the operator makes of a StorageCluster
+type Result struct {
+	// StorageCluster carries the computed status, such as the node
+	// topologies and the failure domain
+	StorageCluster *ocsv1.StorageCluster
+	// Objects are the objects the operator creates for the StorageCluster,
+	// sorted by kind, namespace and name
+	Objects []runtime.Object
+}
+
+// NewScheme returns a scheme knowing every type the StorageCluster controller
+// creates
+func NewScheme() (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		apis.AddToScheme,
+		cephv1.AddToScheme,
+		storagev1.AddToScheme,
+		nbapis.AddToScheme,
+		corev1.AddToScheme,
+		batchv1.AddToScheme,
+		obv1.AddToScheme,
+		networkingv1beta1.AddToScheme,
+		secv1.Install,
+	} {
+		err := addToScheme(scheme)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scheme, nil
+}
+
+// Render runs the StorageCluster controller against an in-memory cluster
+// holding only the StorageCluster and the nodes, and returns every object it
+// creates. Rook is taken to bring up the CephCluster right away, so that the
+// objects waiting on it are rendered too.
+func Render(sc *ocsv1.StorageCluster, nodes []corev1.Node, opts storagecluster.ReconcilerOptions) (*Result, error) {
+	r, recorder, err := newInMemoryReconciler(sc, nodes, opts)
+	if err != nil {
+		return nil, err
+	}
+	fakeClient := recorder.Client
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace}}
+
+	_, err = r.Reconcile(request)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to reconcile StorageCluster %s: %v", sc.Name, err)
+	}
+
+	found := &ocsv1.StorageCluster{}
+	err = fakeClient.Get(context.TODO(), request.NamespacedName, found)
+	if err != nil {
+		return nil, err
+	}
+	cephClusters := &cephv1.CephClusterList{}
+	err = fakeClient.List(context.TODO(), cephClusters, client.InNamespace(sc.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	if len(cephClusters.Items) > 0 {
+		for i := range cephClusters.Items {
+			cephCluster := &cephClusters.Items[i]
+			cephCluster.Status.State = cephv1.ClusterStateCreated
+			err = fakeClient.Update(context.TODO(), cephCluster)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = r.Reconcile(request)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to reconcile StorageCluster %s: %v", sc.Name, err)
+		}
+		found = &ocsv1.StorageCluster{}
+		err = fakeClient.Get(context.TODO(), request.NamespacedName, found)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The conditions carry timestamps and the steps durations, which would
+	// make the output differ from run to run
+	found.Status.Conditions = nil
+	for i := range found.Status.ReconcileSteps {
+		found.Status.ReconcileSteps[i].Duration = nil
+	}
+	clearServerFields(found)
+	found.SetGroupVersionKind(ocsv1.SchemeGroupVersion.WithKind("StorageCluster"))
+
+	return &Result{StorageCluster: found, Objects: recorder.sortedObjects()}, nil
+}
+
+// newInMemoryReconciler returns a reconciler working against an in-memory
+// cluster holding only the StorageCluster and the nodes, along with the
+// client recording what it creates
+func newInMemoryReconciler(sc *ocsv1.StorageCluster, nodes []corev1.Node, opts storagecluster.ReconcilerOptions) (*storagecluster.ReconcileStorageCluster, *recordingClient, error) {
+	scheme, err := NewScheme()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objects := []runtime.Object{sc.DeepCopy()}
+	for i := range nodes {
+		objects = append(objects, nodes[i].DeepCopy())
+	}
+	for _, obj := range objects {
+		statusutil.SetSelfLink(scheme, obj)
+	}
+	recorder := &recordingClient{
+		Client:  fake.NewFakeClientWithScheme(scheme, objects...),
+		scheme:  scheme,
+		objects: map[string]runtime.Object{},
+	}
+
+	return storagecluster.NewReconciler(recorder, recorder, scheme, opts), recorder, nil
+}
+
+// Marshal serializes the StorageCluster and the objects as YAML documents
+func (result *Result) Marshal() ([]byte, error) {
+	out := &bytes.Buffer{}
+	for _, obj := range append([]runtime.Object{result.StorageCluster}, result.Objects...) {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+	return out.Bytes(), nil
+}
+
+// recordingClient keeps the last state of every object created or updated
+// through it, other than the StorageClusters and the nodes it is given. Like
+// the apiserver, it sets the selfLink of the objects it creates, which the
+// object references in the status are made from.
+type recordingClient struct {
+	client.Client
+	scheme  *runtime.Scheme
+	objects map[string]runtime.Object
+}
+
+func (c *recordingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	statusutil.SetSelfLink(c.scheme, obj)
+	err := c.Client.Create(ctx, obj, opts...)
+	if err == nil {
+		c.record(obj)
+	}
+	return err
+}
+
+func (c *recordingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	err := c.Client.Update(ctx, obj, opts...)
+	if err == nil {
+		c.record(obj)
+	}
+	return err
+}
+
+func (c *recordingClient) record(obj runtime.Object) {
+	switch obj.(type) {
+	case *ocsv1.StorageCluster, *corev1.Node:
+		return
+	}
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	copied := obj.DeepCopyObject()
+	copied.GetObjectKind().SetGroupVersionKind(gvk)
+	clearServerFields(copied)
+	c.objects[fmt.Sprintf("%s/%s/%s", gvk.Kind, accessor.GetNamespace(), accessor.GetName())] = copied
+}
+
+// sortedObjects returns the recorded objects sorted by kind, namespace and
+// name
+func (c *recordingClient) sortedObjects() []runtime.Object {
+	keys := []string{}
+	for key := range c.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	objects := []runtime.Object{}
+	for _, key := range keys {
+		objects = append(objects, c.objects[key])
+	}
+	return objects
+}
+
+// clearServerFields removes the fields set by the in-memory cluster
+func clearServerFields(obj runtime.Object) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	accessor.SetSelfLink("")
+	accessor.SetResourceVersion("")
+	accessor.SetUID("")
+	accessor.SetCreationTimestamp(metav1.Time{})
+}
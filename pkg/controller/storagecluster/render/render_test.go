@@ -0,0 +1,47 @@
+package render
+
+import (
+	"testing"
+
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	"github.com/openshift/ocs-operator/pkg/controller/storagecluster"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+func TestRender(t *testing.T) {
+	sc, nodes := getTestFixtures(t)
+
+	result, err := Render(sc, nodes, storagecluster.ReconcilerOptions{CephImage: "ceph/ceph:v14"})
+	assert.NoError(t, err)
+
+	// The computed topology and failure domain come with the objects
+	assert.Equal(t, "zone", result.StorageCluster.Status.FailureDomain)
+	assert.Equal(t, "topology.kubernetes.io/zone", result.StorageCluster.Status.FailureDomainKey)
+	assert.Len(t, result.StorageCluster.Status.StorageNodes, 3)
+	assert.Empty(t, result.StorageCluster.Status.Conditions)
+
+	kinds := map[string]int{}
+	for _, obj := range result.Objects {
+		kinds[obj.GetObjectKind().GroupVersionKind().Kind]++
+		switch o := obj.(type) {
+		case *cephv1.CephCluster:
+			assert.Equal(t, "ceph/ceph:v14", o.Spec.CephVersion.Image)
+			assert.Empty(t, o.ResourceVersion)
+		case *storagev1.StorageClass:
+			assert.NotEmpty(t, o.Provisioner)
+		case *nbv1.NooBaa:
+			assert.Equal(t, sc.Namespace, o.Namespace)
+		}
+	}
+	assert.Equal(t, 1, kinds["CephCluster"])
+	assert.Equal(t, 1, kinds["NooBaa"])
+	assert.NotZero(t, kinds["CephBlockPool"])
+	assert.NotZero(t, kinds["StorageClass"])
+
+	// The objects come in the same order every time
+	for i := 1; i < len(result.Objects); i++ {
+		assert.True(t, result.Objects[i-1].GetObjectKind().GroupVersionKind().Kind <= result.Objects[i].GetObjectKind().GroupVersionKind().Kind)
+	}
+}
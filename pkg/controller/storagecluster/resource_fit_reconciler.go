@@ -0,0 +1,282 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/openshift/ocs-operator/pkg/controller/placement"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// scaleDownProfiles lists the resource profiles from the largest to the
+// smallest
+var scaleDownProfiles = []string{
+	defaults.ResourceProfilePerformance,
+	defaults.ResourceProfileBalanced,
+	defaults.ResourceProfileLean,
+}
+
+// getResourceProfile returns the resource profile the daemons are deployed
+// with. This is the one picked by ensureResourceFit, if any.
+func getResourceProfile(sc *ocsv1.StorageCluster) string {
+	if sc.Status.ResourceProfile != "" {
+		return sc.Status.ResourceProfile
+	}
+	return getDesiredResourceProfile(sc)
+}
+
+// daemonInstance is a single pod of a daemon, as far as its resource
+// requests are concerned
+type daemonInstance struct {
+	name string
+	// spread instances are placed on distinct nodes as long as possible
+	spread    bool
+	resources corev1.ResourceRequirements
+}
+
+// nodeLoad holds the requests of the daemons placed on a node
+type nodeLoad struct {
+	node    *corev1.Node
+	cpu     int64
+	memory  int64
+	daemons map[string]int
+}
+
+// ensureResourceFit checks that the requests of all daemons fit on the
+// allocatable resources of the storage nodes. With AutoScaleDown, lower
+// resource profiles are tried until one fits, as long as the cluster has not
+// been deployed yet, so that running daemons are never scaled down behind
+// the back of the user. The result is reported by the
+// ResourcesInsufficient condition. Reconcile only stops if the daemons do not
+// fit before the cluster is first deployed or when it is expanded; a running
+// cluster keeps its resource profile.
+func (r *ReconcileStorageCluster) ensureResourceFit(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	current := sc.Status.ResourceProfile
+	profile := getDesiredResourceProfile(sc)
+	sc.Status.ResourceProfile = profile
+
+	if sc.Spec.ResourceFit.DisableCheck {
+		conditionsv1.RemoveStatusCondition(&sc.Status.Conditions, ocsv1.ConditionResourcesInsufficient)
+		return nil
+	}
+
+	nodeList, err := r.getStorageNodes()
+	if err != nil {
+		return err
+	}
+	nodes := []corev1.Node{}
+	for _, node := range nodeList.Items {
+		// Nodes that just joined may not report their allocatable
+		// resources yet. Cordoned nodes are counted, as they are
+		// usually only drained for a while.
+		if len(node.Status.Allocatable) == 0 {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		reqLogger.Info("No storage nodes report allocatable resources, skipping resource fit check")
+		return nil
+	}
+
+	cephCluster, err := r.getDeployedCephCluster(sc)
+	if err != nil {
+		return err
+	}
+
+	candidates := []string{profile}
+	if sc.Spec.ResourceFit.AutoScaleDown && cephCluster == nil {
+		for i, p := range scaleDownProfiles {
+			if p == profile {
+				candidates = append(candidates, scaleDownProfiles[i+1:]...)
+				break
+			}
+		}
+	}
+
+	var shortfalls []string
+	for _, candidate := range candidates {
+		sc.Status.ResourceProfile = candidate
+		shortfalls = getResourceShortfalls(newDaemonInstances(sc, candidate), nodes)
+		if len(shortfalls) > 0 {
+			continue
+		}
+
+		condition := conditionsv1.Condition{
+			Type:    ocsv1.ConditionResourcesInsufficient,
+			Status:  corev1.ConditionFalse,
+			Reason:  "ResourcesSufficient",
+			Message: fmt.Sprintf("The daemons fit on %d storage nodes with the %s resource profile", len(nodes), candidate),
+		}
+		if candidate != profile {
+			reqLogger.Info(fmt.Sprintf("Scaled down resource profile from %s to %s", profile, candidate))
+			condition.Reason = "ResourceProfileScaledDown"
+			condition.Message = fmt.Sprintf("The %s resource profile does not fit on the storage nodes, using the %s resource profile", profile, candidate)
+		}
+		conditionsv1.SetStatusCondition(&sc.Status.Conditions, condition)
+		return nil
+	}
+
+	message := fmt.Sprintf("The daemons do not fit on the storage nodes with the %s resource profile. Missing requests: %s",
+		sc.Status.ResourceProfile, strings.Join(shortfalls, ", "))
+	reqLogger.Info(message)
+	conditionsv1.SetStatusCondition(&sc.Status.Conditions, conditionsv1.Condition{
+		Type:    ocsv1.ConditionResourcesInsufficient,
+		Status:  corev1.ConditionTrue,
+		Reason:  "ResourcesInsufficient",
+		Message: message,
+	})
+
+	if !isResourceFitEnforced(sc, cephCluster) {
+		if current != "" {
+			sc.Status.ResourceProfile = current
+		}
+		return nil
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// getDeployedCephCluster returns the CephCluster of the StorageCluster, or
+// nil if it has not been deployed yet
+func (r *ReconcileStorageCluster) getDeployedCephCluster(sc *ocsv1.StorageCluster) (*cephv1.CephCluster, error) {
+	found := &cephv1.CephCluster{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, found)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return found, nil
+}
+
+// isResourceFitEnforced tells whether reconcile has to stop when the daemons
+// do not fit. This is the case before the CephCluster is first deployed and
+// when more OSDs are asked for than it has. Stopping the reconcile of a
+// running cluster would not free any resources.
+func isResourceFitEnforced(sc *ocsv1.StorageCluster, cephCluster *cephv1.CephCluster) bool {
+	if cephCluster == nil {
+		return true
+	}
+
+	deployed := 0
+	for _, ds := range cephCluster.Spec.Storage.StorageClassDeviceSets {
+		deployed += ds.Count
+	}
+	desired := 0
+	for _, ds := range getStorageDeviceSets(sc) {
+		replica, count := placement.DeviceSetReplica(ds)
+		desired += replica * count
+	}
+	return desired > deployed
+}
+
+// newDaemonInstances returns all daemon pods of the StorageCluster with their
+// resources for the given resource profile
+func newDaemonInstances(sc *ocsv1.StorageCluster, profile string) []daemonInstance {
+	resources := newEffectiveResources(sc, profile)
+	instances := []daemonInstance{}
+	add := func(name string, count int, spread bool) {
+		for i := 0; i < count; i++ {
+			instances = append(instances, daemonInstance{name: name, spread: spread, resources: resources[name]})
+		}
+	}
+
+	for _, ds := range getStorageDeviceSets(sc) {
+		replica := ds.Replica
+		if replica == 0 {
+			replica = defaults.DeviceSetReplica
+		}
+		add(fmt.Sprintf("osd-%s", ds.Name), ds.Count*replica, true)
+	}
+	add("mon", monCount, true)
+	add("mgr", 1, false)
+	// One active and one standby metadata server
+	add("mds", 2, true)
+	if !sc.Spec.Components.DisableObjectStore {
+		add("rgw", 1, false)
+	}
+	if !sc.Spec.Components.DisableMultiCloudGateway {
+		add("noobaa-core", 1, false)
+		add("noobaa-db", 1, false)
+	}
+
+	return instances
+}
+
+// getResourceShortfalls places the daemon instances on the nodes the way the
+// scheduler spreads them, on the least loaded node, and returns the CPU and
+// memory missing on each node that can not hold its share
+func getResourceShortfalls(instances []daemonInstance, nodes []corev1.Node) []string {
+	loads := make([]*nodeLoad, len(nodes))
+	for i := range nodes {
+		loads[i] = &nodeLoad{node: &nodes[i], daemons: map[string]int{}}
+	}
+
+	for _, instance := range instances {
+		cpu := getRequest(instance.resources, corev1.ResourceCPU)
+		memory := getRequest(instance.resources, corev1.ResourceMemory)
+
+		var best *nodeLoad
+		for _, load := range loads {
+			if best == nil || lessLoaded(load, best, instance) {
+				best = load
+			}
+		}
+		best.cpu += cpu.MilliValue()
+		best.memory += memory.Value()
+		best.daemons[instance.name]++
+	}
+
+	shortfalls := []string{}
+	for _, load := range loads {
+		missing := []string{}
+		allocatable := load.node.Status.Allocatable
+		if cpu := load.cpu - allocatable.Cpu().MilliValue(); cpu > 0 {
+			missing = append(missing, fmt.Sprintf("cpu %s", resource.NewMilliQuantity(cpu, resource.DecimalSI)))
+		}
+		if memory := load.memory - allocatable.Memory().Value(); memory > 0 {
+			missing = append(missing, fmt.Sprintf("memory %s", resource.NewQuantity(memory, resource.BinarySI)))
+		}
+		if len(missing) > 0 {
+			shortfalls = append(shortfalls, fmt.Sprintf("%s (%s)", load.node.Name, strings.Join(missing, ", ")))
+		}
+	}
+	sort.Strings(shortfalls)
+
+	return shortfalls
+}
+
+// lessLoaded says whether node a is a better fit than node b for the given
+// instance
+func lessLoaded(a, b *nodeLoad, instance daemonInstance) bool {
+	if instance.spread && a.daemons[instance.name] != b.daemons[instance.name] {
+		return a.daemons[instance.name] < b.daemons[instance.name]
+	}
+	if a.cpu != b.cpu {
+		return a.cpu < b.cpu
+	}
+	if a.memory != b.memory {
+		return a.memory < b.memory
+	}
+	return a.node.Name < b.node.Name
+}
+
+// getRequest returns the request of a resource, which defaults to its limit
+// like it does for the scheduler
+func getRequest(resources corev1.ResourceRequirements, name corev1.ResourceName) resource.Quantity {
+	if q, ok := resources.Requests[name]; ok {
+		return q
+	}
+	return resources.Limits[name]
+}
@@ -0,0 +1,214 @@
+package storagecluster
+
+import (
+	"testing"
+
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// newSizedNodeList returns the mock storage nodes with the given allocatable
+// resources
+func newSizedNodeList(cpu, memory string) *corev1.NodeList {
+	nodeList := mockNodeList.DeepCopy()
+	for i := range nodeList.Items {
+		nodeList.Items[i].Status.Allocatable = corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}
+	}
+	return nodeList
+}
+
+func TestEnsureResourceFit(t *testing.T) {
+	cases := []struct {
+		label           string
+		cpu             string
+		memory          string
+		profile         string
+		autoScaleDown   bool
+		disableCheck    bool
+		expectedErr     bool
+		expectedStatus  corev1.ConditionStatus
+		expectedReason  string
+		expectedProfile string
+	}{
+		{
+			label:           "balanced profile fits",
+			cpu:             "16",
+			memory:          "64Gi",
+			expectedStatus:  corev1.ConditionFalse,
+			expectedReason:  "ResourcesSufficient",
+			expectedProfile: defaults.ResourceProfileBalanced,
+		},
+		{
+			label:           "performance profile does not fit",
+			cpu:             "8",
+			memory:          "32Gi",
+			profile:         defaults.ResourceProfilePerformance,
+			expectedErr:     true,
+			expectedStatus:  corev1.ConditionTrue,
+			expectedReason:  "ResourcesInsufficient",
+			expectedProfile: defaults.ResourceProfilePerformance,
+		},
+		{
+			label:           "performance profile is scaled down",
+			cpu:             "16",
+			memory:          "64Gi",
+			profile:         defaults.ResourceProfilePerformance,
+			autoScaleDown:   true,
+			expectedStatus:  corev1.ConditionFalse,
+			expectedReason:  "ResourceProfileScaledDown",
+			expectedProfile: defaults.ResourceProfileBalanced,
+		},
+		{
+			label:           "nothing fits",
+			cpu:             "1",
+			memory:          "2Gi",
+			autoScaleDown:   true,
+			expectedErr:     true,
+			expectedStatus:  corev1.ConditionTrue,
+			expectedReason:  "ResourcesInsufficient",
+			expectedProfile: defaults.ResourceProfileLean,
+		},
+		{
+			label:           "check is disabled",
+			cpu:             "1",
+			memory:          "2Gi",
+			profile:         defaults.ResourceProfilePerformance,
+			disableCheck:    true,
+			expectedProfile: defaults.ResourceProfilePerformance,
+		},
+	}
+
+	for _, c := range cases {
+		sc := mockStorageCluster.DeepCopy()
+		sc.Spec.StorageDeviceSets = mockDeviceSets
+		sc.Spec.ResourceProfile = c.profile
+		sc.Spec.ResourceFit = api.ResourceFitSpec{
+			AutoScaleDown: c.autoScaleDown,
+			DisableCheck:  c.disableCheck,
+		}
+		reconciler := createFakeStorageClusterReconciler(t, sc, newSizedNodeList(c.cpu, c.memory))
+
+		err := reconciler.ensureResourceFit(sc, reconciler.reqLogger)
+		if c.expectedErr {
+			assert.Error(t, err, c.label)
+		} else {
+			assert.NoError(t, err, c.label)
+		}
+		assert.Equal(t, c.expectedProfile, sc.Status.ResourceProfile, c.label)
+		assert.Equal(t, c.expectedProfile, getResourceProfile(sc), c.label)
+
+		condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionResourcesInsufficient)
+		if c.expectedStatus == "" {
+			assert.Nil(t, condition, c.label)
+			continue
+		}
+		if assert.NotNil(t, condition, c.label) {
+			assert.Equal(t, c.expectedStatus, condition.Status, c.label)
+			assert.Equal(t, c.expectedReason, condition.Reason, c.label)
+			if c.expectedStatus == corev1.ConditionTrue {
+				assert.Contains(t, condition.Message, "node1 (cpu ", c.label)
+			}
+		}
+	}
+}
+
+func TestEnsureResourceFitWithoutAllocatable(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.StorageDeviceSets = mockDeviceSets
+	reconciler := createFakeStorageClusterReconciler(t, sc, mockNodeList.DeepCopy())
+
+	err := reconciler.ensureResourceFit(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Nil(t, conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionResourcesInsufficient))
+}
+
+func TestGetResourceShortfalls(t *testing.T) {
+	nodes := newSizedNodeList("2", "4Gi").Items
+	daemon := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+	}
+	limitOnly := corev1.ResourceRequirements{
+		Limits: daemon.Requests,
+	}
+
+	// Six instances spread evenly fill the three nodes
+	instances := []daemonInstance{}
+	for i := 0; i < 3; i++ {
+		instances = append(instances, daemonInstance{name: "mon", spread: true, resources: daemon})
+		instances = append(instances, daemonInstance{name: "mgr", resources: limitOnly})
+	}
+	assert.Empty(t, getResourceShortfalls(instances, nodes))
+
+	// A seventh one is short on the first node it lands on
+	instances = append(instances, daemonInstance{name: "rgw", resources: daemon})
+	assert.Equal(t, []string{"node1 (cpu 1, memory 2Gi)"}, getResourceShortfalls(instances, nodes))
+}
+
+func TestEnsureResourceFitRunningCluster(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.StorageDeviceSets = []api.StorageDeviceSet{*mockDeviceSets[0].DeepCopy()}
+	sc.Spec.ResourceProfile = defaults.ResourceProfilePerformance
+	sc.Status.ResourceProfile = defaults.ResourceProfileBalanced
+	cephCluster := newCephCluster(sc, "")
+	reconciler := createFakeStorageClusterReconciler(t, sc, cephCluster, newSizedNodeList("8", "32Gi"))
+
+	// A running cluster only reports the shortfall and keeps its profile
+	err := reconciler.ensureResourceFit(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults.ResourceProfileBalanced, sc.Status.ResourceProfile)
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionResourcesInsufficient)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, corev1.ConditionTrue, condition.Status)
+	}
+
+	// Expanding it needs the daemons to fit
+	sc.Spec.StorageDeviceSets[0].Count = 6
+	err = reconciler.ensureResourceFit(sc, reconciler.reqLogger)
+	assert.Error(t, err)
+}
+
+func TestEnsureResourceFitRunningClusterNoScaleDown(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.StorageDeviceSets = []api.StorageDeviceSet{*mockDeviceSets[0].DeepCopy()}
+	sc.Spec.ResourceProfile = defaults.ResourceProfilePerformance
+	sc.Spec.ResourceFit.AutoScaleDown = true
+	cephCluster := newCephCluster(sc, "")
+	reconciler := createFakeStorageClusterReconciler(t, sc, cephCluster, newSizedNodeList("16", "64Gi"))
+
+	// A cluster deployed before profiles were picked keeps its daemons as
+	// they are, even though a lower profile would fit
+	err := reconciler.ensureResourceFit(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, defaults.ResourceProfilePerformance, sc.Status.ResourceProfile)
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionResourcesInsufficient)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, corev1.ConditionTrue, condition.Status)
+	}
+}
+
+func TestEnsureResourceFitCordonedNodes(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.StorageDeviceSets = mockDeviceSets
+	nodeList := newSizedNodeList("1", "2Gi")
+	for i := range nodeList.Items {
+		nodeList.Items[i].Spec.Unschedulable = true
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+
+	err := reconciler.ensureResourceFit(sc, reconciler.reqLogger)
+	assert.Error(t, err)
+	condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionResourcesInsufficient)
+	if assert.NotNil(t, condition) {
+		assert.Equal(t, corev1.ConditionTrue, condition.Status)
+	}
+}
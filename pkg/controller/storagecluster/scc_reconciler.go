@@ -0,0 +1,112 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	secv1 "github.com/openshift/api/security/v1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/ocsinitialization"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The SCCs are created by the OCSInitialization controller for the service
+// accounts of the operator namespace. Rook runs the Ceph daemons of other
+// namespaces with the service accounts of their own namespace, which each
+// StorageCluster adds to the SCC it shares with the others.
+
+// rookCephSCCName is the name of the SCC the Ceph daemons run with
+const rookCephSCCName = "rook-ceph"
+
+// getSCCUsers returns the service accounts Rook runs the Ceph daemons of the
+// namespace with
+func getSCCUsers(namespace string) []string {
+	return []string{
+		fmt.Sprintf("system:serviceaccount:%s:default", namespace),
+		fmt.Sprintf("system:serviceaccount:%s:rook-ceph-mgr", namespace),
+		fmt.Sprintf("system:serviceaccount:%s:rook-ceph-osd", namespace),
+	}
+}
+
+// getCleanupSCCUser returns the service account the node cleanup Jobs of
+// the StorageCluster run with
+func getCleanupSCCUser(sc *ocsv1.StorageCluster) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", sc.Namespace, generateNameForCleanupServiceAccount(sc))
+}
+
+// ensureSCCUsers adds the service accounts of the namespace of the
+// StorageCluster to the SCC of the Ceph daemons
+func (r *ReconcileStorageCluster) ensureSCCUsers(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	defer r.locks.lock(clusterScopedLockKey("SecurityContextConstraints", rookCephSCCName))()
+
+	// The SCCs are read from the apiserver, as they are not cached
+	scc := &secv1.SecurityContextConstraints{}
+	err := r.apiReader.Get(context.TODO(), types.NamespacedName{Name: rookCephSCCName}, scc)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			reqLogger.Info(fmt.Sprintf("Waiting on the OCSInitialization to create SecurityContextConstraints %s", rookCephSCCName))
+			return nil
+		}
+		if meta.IsNoMatchError(err) {
+			// Not an OpenShift cluster
+			return nil
+		}
+		return err
+	}
+
+	updated := false
+	for _, user := range append(getSCCUsers(sc.Namespace), getCleanupSCCUser(sc)) {
+		if !contains(scc.Users, user) {
+			scc.Users = append(scc.Users, user)
+			updated = true
+		}
+	}
+	if !updated {
+		return nil
+	}
+
+	reqLogger.Info(fmt.Sprintf("Adding the service accounts of namespace %s to SecurityContextConstraints %s", sc.Namespace, rookCephSCCName))
+	return r.client.Update(context.TODO(), scc)
+}
+
+// deleteSCCUsers removes the service accounts of the namespace of the
+// StorageCluster from the SCC of the Ceph daemons. Those of the Ceph daemons
+// of the operator namespace are left to the OCSInitialization.
+func (r *ReconcileStorageCluster) deleteSCCUsers(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	namespaceUsers := []string{getCleanupSCCUser(sc)}
+	if sc.Namespace != ocsinitialization.InitNamespacedName().Namespace {
+		namespaceUsers = append(namespaceUsers, getSCCUsers(sc.Namespace)...)
+	}
+
+	defer r.locks.lock(clusterScopedLockKey("SecurityContextConstraints", rookCephSCCName))()
+
+	scc := &secv1.SecurityContextConstraints{}
+	err := r.apiReader.Get(context.TODO(), types.NamespacedName{Name: rookCephSCCName}, scc)
+	if err != nil {
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	users := []string{}
+	for _, user := range scc.Users {
+		if !contains(namespaceUsers, user) {
+			users = append(users, user)
+		}
+	}
+	if len(users) == len(scc.Users) {
+		return true, nil
+	}
+
+	reqLogger.Info(fmt.Sprintf("Removing the service accounts of namespace %s from SecurityContextConstraints %s", sc.Namespace, rookCephSCCName))
+	scc.Users = users
+	err = r.client.Update(context.TODO(), scc)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,73 @@
+package storagecluster
+
+import (
+	"context"
+	"testing"
+
+	secv1 "github.com/openshift/api/security/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/ocsinitialization"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func getRookCephSCC(t *testing.T, reconciler *ReconcileStorageCluster) *secv1.SecurityContextConstraints {
+	scc := &secv1.SecurityContextConstraints{}
+	err := reconciler.client.Get(context.TODO(), types.NamespacedName{Name: rookCephSCCName}, scc)
+	assert.NoError(t, err)
+	return scc
+}
+
+func TestEnsureSCCUsers(t *testing.T) {
+	sc := newNamespacedStorageCluster("cluster-a", "ns-a")
+	operatorUser := "system:serviceaccount:openshift-storage:rook-ceph-osd"
+	scc := &secv1.SecurityContextConstraints{
+		ObjectMeta: metav1.ObjectMeta{Name: rookCephSCCName},
+		Users:      []string{operatorUser},
+	}
+	reconciler := createFakeStorageClusterReconciler(t, scc)
+
+	err := reconciler.ensureSCCUsers(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	found := getRookCephSCC(t, &reconciler)
+	assert.Equal(t, append(append([]string{operatorUser}, getSCCUsers("ns-a")...), getCleanupSCCUser(sc)), found.Users)
+
+	// The service accounts are only added once
+	err = reconciler.ensureSCCUsers(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, found.Users, getRookCephSCC(t, &reconciler).Users)
+
+	// Only the service accounts of the namespace are removed
+	done, err := reconciler.deleteSCCUsers(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, []string{operatorUser}, getRookCephSCC(t, &reconciler).Users)
+}
+
+func TestEnsureSCCUsersNotCreated(t *testing.T) {
+	sc := newNamespacedStorageCluster("cluster-a", "ns-a")
+	reconciler := createFakeStorageClusterReconciler(t)
+
+	// The SCC is left to the OCSInitialization to create
+	err := reconciler.ensureSCCUsers(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	done, err := reconciler.deleteSCCUsers(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+}
+
+func TestDeleteSCCUsersOperatorNamespace(t *testing.T) {
+	sc := newNamespacedStorageCluster("cluster-a", ocsinitialization.InitNamespacedName().Namespace)
+	scc := &secv1.SecurityContextConstraints{
+		ObjectMeta: metav1.ObjectMeta{Name: rookCephSCCName},
+		Users:      append(getSCCUsers(sc.Namespace), getCleanupSCCUser(sc)),
+	}
+	reconciler := createFakeStorageClusterReconciler(t, scc)
+
+	// The service accounts of the Ceph daemons of the operator namespace
+	// belong to the OCSInitialization, only the cleanup one is removed
+	done, err := reconciler.deleteSCCUsers(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, getSCCUsers(sc.Namespace), getRookCephSCC(t, &reconciler).Users)
+}
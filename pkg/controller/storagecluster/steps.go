@@ -0,0 +1,240 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// reconcileStep is a part of the reconcile of a StorageCluster, bringing
+// some of its objects to their desired state
+type reconcileStep interface {
+	// Name identifies the step in the status of the StorageCluster
+	Name() string
+	// DependsOn names the steps that have to succeed, or be skipped, for
+	// this one to run. They come before it in the reconcile.
+	DependsOn() []string
+	// IsApplicable tells whether the step has anything to do for the
+	// StorageCluster
+	IsApplicable(sc *ocsv1.StorageCluster) bool
+	// Ensure brings the objects of the step to their desired state
+	Ensure(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error
+	// MapStatus maps the state reported by the objects of the step into the
+	// state of the reconcile. It is called once Ensure succeeded.
+	MapStatus(sc *ocsv1.StorageCluster) error
+}
+
+// reconcileState holds what a reconcile of a StorageCluster finds out along
+// the way. It belongs to a single reconcile, so that StorageClusters can be
+// reconciled concurrently.
+type reconcileState struct {
+	// conditions start off empty. They only ever hold negative conditions
+	// (!Available, Degraded, Progressing).
+	conditions []conditionsv1.Condition
+	// phase is set by the steps finding the StorageCluster in a phase of
+	// its own, such as Expanding
+	phase string
+}
+
+// ensureStep is a reconcileStep made of functions of the reconciler. The
+// ensure functions finding a problem with the spec, such as an invalid
+// setting, record it in the conditions of the reconcile right away.
+type ensureStep struct {
+	name       string
+	dependsOn  []string
+	applicable func(*ocsv1.StorageCluster) bool
+	ensure     func(*ocsv1.StorageCluster, logr.Logger) error
+	mapStatus  func(*ocsv1.StorageCluster) error
+}
+
+var _ reconcileStep = &ensureStep{}
+
+func (s *ensureStep) Name() string {
+	return s.name
+}
+
+func (s *ensureStep) DependsOn() []string {
+	return s.dependsOn
+}
+
+func (s *ensureStep) IsApplicable(sc *ocsv1.StorageCluster) bool {
+	return s.applicable == nil || s.applicable(sc)
+}
+
+func (s *ensureStep) Ensure(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	return s.ensure(sc, reqLogger)
+}
+
+func (s *ensureStep) MapStatus(sc *ocsv1.StorageCluster) error {
+	if s.mapStatus == nil {
+		return nil
+	}
+	return s.mapStatus(sc)
+}
+
+// reconcileSteps returns the steps of a reconcile bringing the objects of a
+// StorageCluster to their desired state, in the order they are run
+func (r *ReconcileStorageCluster) reconcileSteps(state *reconcileState) []reconcileStep {
+	withState := func(ensure func(*ocsv1.StorageCluster, *reconcileState, logr.Logger) error) func(*ocsv1.StorageCluster, logr.Logger) error {
+		return func(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+			return ensure(sc, state, reqLogger)
+		}
+	}
+	objectStoreEnabled := func(sc *ocsv1.StorageCluster) bool {
+		return !sc.Spec.Components.DisableObjectStore
+	}
+	noobaaEnabled := func(sc *ocsv1.StorageCluster) bool {
+		return !sc.Spec.Components.DisableMultiCloudGateway
+	}
+
+	return []reconcileStep{
+		// Add support for additional resources here
+		&ensureStep{name: "resourceFit", ensure: r.ensureResourceFit},
+		&ensureStep{name: "dedicatedNodes", ensure: r.ensureDedicatedNodes},
+		&ensureStep{name: "daemonResources", dependsOn: []string{"resourceFit"}, ensure: r.ensureDaemonResources},
+		&ensureStep{name: "storageClasses", ensure: r.ensureStorageClasses},
+		&ensureStep{name: "snapshotClasses", ensure: r.ensureSnapshotClasses},
+		&ensureStep{name: "bucketStorageClasses", ensure: r.ensureBucketStorageClasses},
+		&ensureStep{name: "cephObjectStores", dependsOn: []string{"resourceFit"}, applicable: objectStoreEnabled, ensure: r.ensureCephObjectStores},
+		&ensureStep{name: "cephObjectStoreUsers", dependsOn: []string{"cephObjectStores"}, applicable: objectStoreEnabled, ensure: r.ensureCephObjectStoreUsers},
+		&ensureStep{name: "objectStoreEndpoint", dependsOn: []string{"cephObjectStores"}, ensure: withState(r.ensureObjectStoreEndpoint)},
+		&ensureStep{name: "cephBlockPools", ensure: r.ensureCephBlockPools},
+		&ensureStep{name: "cephFilesystems", dependsOn: []string{"resourceFit"}, ensure: r.ensureCephFilesystems},
+
+		&ensureStep{name: "cephConfig", ensure: r.ensureCephConfig},
+		&ensureStep{name: "sccUsers", ensure: r.ensureSCCUsers},
+		&ensureStep{
+			name:      "cephCluster",
+			dependsOn: []string{"resourceFit", "daemonResources", "cephConfig", "sccUsers"},
+			ensure:    withState(r.ensureCephCluster),
+			mapStatus: func(sc *ocsv1.StorageCluster) error {
+				return r.mapCephClusterStatus(sc, state)
+			},
+		},
+		&ensureStep{name: "rackRebalance", dependsOn: []string{"cephCluster"}, ensure: r.ensureRackRebalance},
+		&ensureStep{
+			name:       "noobaaSystem",
+			dependsOn:  []string{"resourceFit", "storageClasses", "cephCluster"},
+			applicable: noobaaEnabled,
+			ensure:     r.ensureNoobaaSystem,
+			mapStatus: func(sc *ocsv1.StorageCluster) error {
+				return r.mapNoobaaStatus(sc, state)
+			},
+		},
+		// Also run with the multi-cloud gateway disabled, to delete what
+		// was created for it
+		&ensureStep{name: "multiCloudGateway", dependsOn: []string{"noobaaSystem"}, ensure: withState(r.ensureMultiCloudGateway)},
+	}
+}
+
+// runSteps runs the steps in order, recording the outcome of each of them in
+// the status of the StorageCluster. A step is not run unless the steps it
+// depends on succeeded, but the steps independent of a failed one still are.
+// It returns the errors of the failed steps.
+func (r *ReconcileStorageCluster) runSteps(sc *ocsv1.StorageCluster, steps []reconcileStep, reqLogger logr.Logger) error {
+	results := map[string]ocsv1.ReconcileStepResult{}
+	statuses := []ocsv1.ReconcileStepStatus{}
+	errs := []error{}
+
+	for _, step := range steps {
+		status := ocsv1.ReconcileStepStatus{Name: step.Name()}
+
+		blockedBy := []string{}
+		for _, dependency := range step.DependsOn() {
+			result := results[dependency]
+			if result != ocsv1.ReconcileStepSucceeded && result != ocsv1.ReconcileStepSkipped {
+				blockedBy = append(blockedBy, dependency)
+			}
+		}
+
+		switch {
+		case len(blockedBy) > 0:
+			status.Result = ocsv1.ReconcileStepBlocked
+			status.Error = fmt.Sprintf("Blocked by %s", strings.Join(blockedBy, ", "))
+		case !step.IsApplicable(sc):
+			status.Result = ocsv1.ReconcileStepSkipped
+		default:
+			start := time.Now()
+			err := step.Ensure(sc, reqLogger)
+			if err == nil {
+				err = step.MapStatus(sc)
+			}
+			status.Duration = &metav1.Duration{Duration: time.Since(start)}
+			if err != nil {
+				reqLogger.Error(err, "Reconcile step failed", "Step", step.Name())
+				status.Result = ocsv1.ReconcileStepFailed
+				status.Error = err.Error()
+				errs = append(errs, fmt.Errorf("%s: %v", step.Name(), err))
+			} else {
+				status.Result = ocsv1.ReconcileStepSucceeded
+			}
+		}
+
+		results[step.Name()] = status.Result
+		statuses = append(statuses, status)
+	}
+
+	sc.Status.ReconcileSteps = statuses
+	return utilerrors.NewAggregate(errs)
+}
+
+// mapCephClusterStatus maps the state of the CephCluster into the state of
+// the reconcile
+func (r *ReconcileStorageCluster) mapCephClusterStatus(sc *ocsv1.StorageCluster, state *reconcileState) error {
+	found := &cephv1.CephCluster{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, found)
+	if err != nil {
+		// A CephCluster just created may not be read back yet
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if found.Status.State == "" {
+		// What does this mean to OCS status? Assuming progress.
+		reason := "CephClusterStatus"
+		message := "CephCluster resource is not reporting status"
+		statusutil.MapCephClusterNoConditions(&state.conditions, reason, message)
+	} else {
+		// Interpret CephCluster status and set any negative conditions
+		statusutil.MapCephClusterNegativeConditions(&state.conditions, found)
+	}
+
+	// When phase is expanding, wait for CephCluster state to be updating
+	// this means expansion is in progress and overall system is progressing
+	// else expansion is not yet triggered
+	if sc.Status.Phase == statusutil.PhaseClusterExpanding &&
+		found.Status.State != cephv1.ClusterStateUpdating {
+		state.phase = statusutil.PhaseClusterExpanding
+	}
+	return nil
+}
+
+// mapNoobaaStatus maps the state of the NooBaa system into the state of the
+// reconcile
+func (r *ReconcileStorageCluster) mapNoobaaStatus(sc *ocsv1.StorageCluster, state *reconcileState) error {
+	found := &nbv1.NooBaa{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: "noobaa", Namespace: sc.Namespace}, found)
+	if err != nil {
+		// The NooBaa system waits on the CephCluster
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	statusutil.MapNoobaaNegativeConditions(&state.conditions, found)
+	return nil
+}
@@ -0,0 +1,93 @@
+package storagecluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-logr/logr"
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcileStepsDependencies(t *testing.T) {
+	reconciler := createFakeStorageClusterReconciler(t)
+	seen := map[string]bool{}
+	for _, step := range reconciler.reconcileSteps(&reconcileState{}) {
+		assert.False(t, seen[step.Name()], "step %s listed twice", step.Name())
+		for _, dependency := range step.DependsOn() {
+			assert.True(t, seen[dependency], "step %s depends on %s, which does not come before it", step.Name(), dependency)
+		}
+		seen[step.Name()] = true
+	}
+}
+
+func TestReconcileStepsResourceFit(t *testing.T) {
+	reconciler := createFakeStorageClusterReconciler(t)
+	// The steps that size daemons wait on the resource fit check
+	sizing := map[string]bool{
+		"daemonResources":  true,
+		"cephObjectStores": true,
+		"cephFilesystems":  true,
+		"cephCluster":      true,
+		"noobaaSystem":     true,
+	}
+	for _, step := range reconciler.reconcileSteps(&reconcileState{}) {
+		if sizing[step.Name()] {
+			assert.Contains(t, step.DependsOn(), "resourceFit", step.Name())
+		}
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+	ran := []string{}
+	newStep := func(name string, err error, dependsOn ...string) *ensureStep {
+		return &ensureStep{
+			name:      name,
+			dependsOn: dependsOn,
+			ensure: func(*api.StorageCluster, logr.Logger) error {
+				ran = append(ran, name)
+				return err
+			},
+		}
+	}
+	skipped := newStep("skipped", nil)
+	skipped.applicable = func(*api.StorageCluster) bool { return false }
+	steps := []reconcileStep{
+		newStep("first", nil),
+		newStep("failing", fmt.Errorf("pool not ready")),
+		newStep("dependent", nil, "first", "failing"),
+		newStep("transitive", nil, "dependent"),
+		skipped,
+		newStep("independent", nil, "first", "skipped"),
+	}
+
+	reconciler := createFakeStorageClusterReconciler(t)
+	sc := mockStorageCluster.DeepCopy()
+	err := reconciler.runSteps(sc, steps, reconciler.reqLogger)
+	assert.EqualError(t, err, "failing: pool not ready")
+	assert.Equal(t, []string{"first", "failing", "independent"}, ran)
+
+	expected := []struct {
+		name   string
+		result api.ReconcileStepResult
+		err    string
+	}{
+		{"first", api.ReconcileStepSucceeded, ""},
+		{"failing", api.ReconcileStepFailed, "pool not ready"},
+		{"dependent", api.ReconcileStepBlocked, "Blocked by failing"},
+		{"transitive", api.ReconcileStepBlocked, "Blocked by dependent"},
+		{"skipped", api.ReconcileStepSkipped, ""},
+		{"independent", api.ReconcileStepSucceeded, ""},
+	}
+	if assert.Len(t, sc.Status.ReconcileSteps, len(expected)) {
+		for i, e := range expected {
+			status := sc.Status.ReconcileSteps[i]
+			assert.Equal(t, e.name, status.Name)
+			assert.Equal(t, e.result, status.Result, "step %s", e.name)
+			assert.Equal(t, e.err, status.Error, "step %s", e.name)
+			// Only the steps that ran take time
+			ran := e.result == api.ReconcileStepSucceeded || e.result == api.ReconcileStepFailed
+			assert.Equal(t, ran, status.Duration != nil, "step %s", e.name)
+		}
+	}
+}
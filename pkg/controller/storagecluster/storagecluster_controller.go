@@ -3,13 +3,16 @@ package storagecluster
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/go-logr/logr"
-	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
 	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
 	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -24,6 +27,10 @@ import (
 
 var log = logf.Log.WithName("controller_storagecluster")
 
+// maxConcurrentReconcilesEnvVar names the environment variable setting how
+// many StorageClusters are reconciled at the same time
+const maxConcurrentReconcilesEnvVar = "MAX_CONCURRENT_RECONCILES"
+
 /**
 * USER ACTION REQUIRED: This is a scaffold file intended for the user to modify with their own Controller
 * business logic.  Delete these comments after modifying this file.*
@@ -61,8 +68,12 @@ func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 
 	r := &ReconcileStorageCluster{
 		client:    mgr.GetClient(),
+		apiReader: mgr.GetAPIReader(),
 		scheme:    mgr.GetScheme(),
 		reqLogger: log,
+		locks:     newKeyedLocks(),
+
+		noobaaCreateDelay: defaultNoobaaCreateDelay,
 	}
 
 	err := r.initializeImageVars()
@@ -73,10 +84,60 @@ func newReconciler(mgr manager.Manager) reconcile.Reconciler {
 	return r
 }
 
+// ReconcilerOptions are the settings the reconciler otherwise takes from the
+// environment of the operator
+type ReconcilerOptions struct {
+	CephImage       string
+	NoobaaCoreImage string
+	NoobaaDBImage   string
+	// NoobaaCreateDelay is how long to wait once the CephCluster is created
+	// before creating the NooBaa system. Without a cluster there is nothing
+	// to quiesce, so it is left at zero.
+	NoobaaCreateDelay time.Duration
+}
+
+// NewReconciler returns a reconciler of StorageClusters working against the
+// given clients. It is meant for driving the controller without a manager,
+// as ocs-render does.
+func NewReconciler(c client.Client, apiReader client.Reader, scheme *runtime.Scheme, opts ReconcilerOptions) *ReconcileStorageCluster {
+	return &ReconcileStorageCluster{
+		client:          c,
+		apiReader:       apiReader,
+		scheme:          scheme,
+		reqLogger:       log,
+		locks:           newKeyedLocks(),
+		cephImage:       opts.CephImage,
+		noobaaCoreImage: opts.NoobaaCoreImage,
+		noobaaDBImage:   opts.NoobaaDBImage,
+
+		noobaaCreateDelay: opts.NoobaaCreateDelay,
+	}
+}
+
+// getMaxConcurrentReconciles returns the number of StorageClusters reconciled
+// at the same time. A StorageCluster is never reconciled by two workers at
+// once.
+func getMaxConcurrentReconciles() (int, error) {
+	value := os.Getenv(maxConcurrentReconcilesEnvVar)
+	if value == "" {
+		return 1, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("%s must be a positive number, not %q", maxConcurrentReconcilesEnvVar, value)
+	}
+	return count, nil
+}
+
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
 func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	maxConcurrentReconciles, err := getMaxConcurrentReconciles()
+	if err != nil {
+		return err
+	}
+
 	// Create a new controller
-	c, err := controller.New("storagecluster-controller", mgr, controller.Options{MaxConcurrentReconciles: 1, Reconciler: r})
+	c, err := controller.New("storagecluster-controller", mgr, controller.Options{MaxConcurrentReconciles: maxConcurrentReconciles, Reconciler: r})
 	if err != nil {
 		return err
 	}
@@ -113,12 +174,61 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	err = c.Watch(&source.Kind{Type: &nbv1.BackingStore{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &ocsv1.StorageCluster{},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &nbv1.BucketClass{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &ocsv1.StorageCluster{},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &batchv1.Job{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &ocsv1.StorageCluster{},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &networkingv1beta1.Ingress{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &ocsv1.StorageCluster{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Certificates of the object store are read from Secrets that are not
+	// owned by the StorageCluster, and can be rotated at any time
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: &objectStoreSecretMapper{client: mgr.GetClient()},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The storage nodes and their topology labels are read from Nodes
+	err = c.Watch(&source.Kind{Type: &corev1.Node{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: &nodeMapper{client: mgr.GetClient()},
+	}, nodeLabelsChangedPredicate)
+	if err != nil {
+		return err
+	}
+
 	pred := predicate.Funcs{
 		DeleteFunc: func(e event.DeleteEvent) bool {
 			// Evaluates to false if the object has been confirmed deleted.
 			return !e.DeleteStateUnknown
 		},
-	  }
+	}
 	err = c.Watch(&source.Kind{Type: &corev1.PersistentVolumeClaim{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &ocsv1.StorageCluster{},
@@ -136,12 +246,19 @@ var _ reconcile.Reconciler = &ReconcileStorageCluster{}
 type ReconcileStorageCluster struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client          client.Client
-	scheme          *runtime.Scheme
-	reqLogger       logr.Logger
-	conditions      []conditionsv1.Condition
-	phase           string
+	client client.Client
+	// apiReader reads directly from the apiserver. It is used for objects
+	// outside of the watched namespace, which are not in the cache.
+	apiReader client.Reader
+	scheme    *runtime.Scheme
+	reqLogger logr.Logger
+	// locks serialize the changes the concurrent reconciles make to the
+	// cluster-scoped objects
+	locks           *keyedLocks
 	cephImage       string
 	noobaaDBImage   string
 	noobaaCoreImage string
+	// noobaaCreateDelay is how long to wait once the CephCluster is created
+	// before creating the NooBaa system
+	noobaaCreateDelay time.Duration
 }
@@ -1,25 +1,36 @@
 package storagecluster
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 
+	obv1 "github.com/kube-object-storage/lib-bucket-provisioner/pkg/apis/objectbucket.io/v1alpha1"
 	"github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	secv1 "github.com/openshift/api/security/v1"
 	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
 	rookCephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
 	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 
 	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
 	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
 )
 
 const (
@@ -149,6 +160,31 @@ func TestReconcilerImplInterface(t *testing.T) {
 	assert.True(t, ok)
 }
 
+func TestGetMaxConcurrentReconciles(t *testing.T) {
+	defer os.Unsetenv(maxConcurrentReconcilesEnvVar)
+
+	cases := []struct {
+		value    string
+		expected int
+		valid    bool
+	}{
+		{"", 1, true},
+		{"4", 4, true},
+		{"0", 0, false},
+		{"many", 0, false},
+	}
+	for _, c := range cases {
+		os.Setenv(maxConcurrentReconcilesEnvVar, c.value)
+		count, err := getMaxConcurrentReconciles()
+		if c.valid {
+			assert.NoError(t, err, c.value)
+			assert.Equal(t, c.expected, count, c.value)
+		} else {
+			assert.Error(t, err, c.value)
+		}
+	}
+}
+
 func TestNonWatchedResourceNameNotFound(t *testing.T) {
 	request := reconcile.Request{
 		NamespacedName: types.NamespacedName{
@@ -246,10 +282,7 @@ func TestNodeTopologyMapPreexistingRack(t *testing.T) {
 	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
 	assert.NoError(t, err)
 
-	actual := &api.StorageCluster{}
-	err = reconciler.client.Get(nil, mockStorageClusterRequest.NamespacedName, actual)
-	assert.NoError(t, err)
-	assert.Equal(t, nodeTopologyMap, actual.Status.NodeTopologies)
+	assert.Equal(t, nodeTopologyMap, sc.Status.NodeTopologies)
 }
 
 func TestNodeTopologyMapTwoAZ(t *testing.T) {
@@ -276,10 +309,7 @@ func TestNodeTopologyMapTwoAZ(t *testing.T) {
 	nodeTopologyMap.Add(defaults.RackTopologyKey, "rack1")
 	nodeTopologyMap.Add(defaults.RackTopologyKey, "rack2")
 
-	actual := &api.StorageCluster{}
-	err = reconciler.client.Get(nil, mockStorageClusterRequest.NamespacedName, actual)
-	assert.NoError(t, err)
-	assert.Equal(t, nodeTopologyMap, actual.Status.NodeTopologies)
+	assert.Equal(t, nodeTopologyMap, sc.Status.NodeTopologies)
 }
 
 func TestNodeTopologyMapThreeAZ(t *testing.T) {
@@ -302,10 +332,7 @@ func TestNodeTopologyMapThreeAZ(t *testing.T) {
 	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
 	assert.NoError(t, err)
 
-	actual := &api.StorageCluster{}
-	err = reconciler.client.Get(nil, mockStorageClusterRequest.NamespacedName, actual)
-	assert.NoError(t, err)
-	assert.Equal(t, nodeTopologyMap, actual.Status.NodeTopologies)
+	assert.Equal(t, nodeTopologyMap, sc.Status.NodeTopologies)
 }
 
 func TestFailureDomain(t *testing.T) {
@@ -334,7 +361,8 @@ func TestEnsureCephClusterCreate(t *testing.T) {
 	cc.ObjectMeta.Name = "doesn't exist"
 
 	reconciler := createFakeStorageClusterReconciler(t, mockStorageCluster, cc)
-	err := reconciler.ensureCephCluster(mockStorageCluster, reconciler.reqLogger)
+	state := &reconcileState{}
+	err := reconciler.ensureCephCluster(mockStorageCluster, state, reconciler.reqLogger)
 	assert.NoError(t, err)
 
 	expected := newCephCluster(mockStorageCluster, "")
@@ -348,7 +376,8 @@ func TestEnsureCephClusterCreate(t *testing.T) {
 
 func TestEnsureCephClusterUpdate(t *testing.T) {
 	reconciler := createFakeStorageClusterReconciler(t, mockCephCluster)
-	err := reconciler.ensureCephCluster(mockStorageCluster, reconciler.reqLogger)
+	state := &reconcileState{}
+	err := reconciler.ensureCephCluster(mockStorageCluster, state, reconciler.reqLogger)
 	assert.NoError(t, err)
 
 	expected := newCephCluster(mockStorageCluster, "")
@@ -360,14 +389,57 @@ func TestEnsureCephClusterUpdate(t *testing.T) {
 	assert.Equal(t, expected.Spec, actual.Spec)
 }
 
+func TestEnsureCephClusterKeepsDataDir(t *testing.T) {
+	cc := newCephCluster(mockStorageCluster, "")
+	cc.Spec.DataDirHostPath = rookDataDirHostPath
+	cc.ObjectMeta.SelfLink = "/apis/ceph.rook.io/v1/namespaces/storage-test-ns/cephclusters/storage-test-cephcluster"
+	reconciler := createFakeStorageClusterReconciler(t, cc)
+	state := &reconcileState{}
+	err := reconciler.ensureCephCluster(mockStorageCluster, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	actual := &rookCephv1.CephCluster{}
+	err = reconciler.client.Get(nil, mockCephClusterNamespacedName, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, rookDataDirHostPath, actual.Spec.DataDirHostPath)
+}
+
+func TestEnsureCephConfig(t *testing.T) {
+	reconciler := createFakeStorageClusterReconciler(t, mockStorageCluster)
+	err := reconciler.ensureCephConfig(mockStorageCluster, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	key := types.NamespacedName{Name: rookConfigMapName, Namespace: mockStorageCluster.Namespace}
+	cm := &corev1.ConfigMap{}
+	err = reconciler.client.Get(nil, key, cm)
+	assert.NoError(t, err)
+	assert.Equal(t, rookConfigData, cm.Data["config"])
+
+	// Changes are reverted, keeping the owners added by others
+	cm.Data["config"] = "[global]\n"
+	cm.OwnerReferences = []metav1.OwnerReference{{UID: "other", Name: "other"}}
+	err = reconciler.client.Update(nil, cm)
+	assert.NoError(t, err)
+	err = reconciler.ensureCephConfig(mockStorageCluster, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	err = reconciler.client.Get(nil, key, cm)
+	assert.NoError(t, err)
+	assert.Equal(t, rookConfigData, cm.Data["config"])
+	assert.Len(t, cm.OwnerReferences, 2)
+}
+
 func TestEnsureCephClusterNoConditions(t *testing.T) {
 	cc := newCephCluster(mockStorageCluster, "")
 	cc.ObjectMeta.SelfLink = "/api/v1/namespaces/ceph/secrets/pvc-ceph-client-key" //for test purpose
 	reconciler := createFakeStorageClusterReconciler(t, cc)
-	err := reconciler.ensureCephCluster(mockStorageCluster, reconciler.reqLogger)
+	state := &reconcileState{}
+	err := reconciler.ensureCephCluster(mockStorageCluster, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.mapCephClusterStatus(mockStorageCluster, state)
 	assert.NoError(t, err)
-	assert.NotEmpty(t, reconciler.conditions)
-	assert.Len(t, reconciler.conditions, 3)
+	assert.NotEmpty(t, state.conditions)
+	assert.Len(t, state.conditions, 3)
 
 	expectedConditions := map[conditionsv1.ConditionType]corev1.ConditionStatus{
 		conditionsv1.ConditionAvailable:   corev1.ConditionFalse,
@@ -375,7 +447,7 @@ func TestEnsureCephClusterNoConditions(t *testing.T) {
 		conditionsv1.ConditionUpgradeable: corev1.ConditionFalse,
 	}
 	for cType, status := range expectedConditions {
-		found := assertCondition(reconciler.conditions, cType, status)
+		found := assertCondition(state.conditions, cType, status)
 		assert.True(t, found, "expected status condition not found", cType, status)
 	}
 }
@@ -385,9 +457,12 @@ func TestEnsureCephClusterNegativeConditions(t *testing.T) {
 	cc.ObjectMeta.SelfLink = "/api/v1/namespaces/ceph/secrets/pvc-ceph-client-key"
 	cc.Status.State = rookCephv1.ClusterStateCreated
 	reconciler := createFakeStorageClusterReconciler(t, cc)
-	err := reconciler.ensureCephCluster(mockStorageCluster, reconciler.reqLogger)
+	state := &reconcileState{}
+	err := reconciler.ensureCephCluster(mockStorageCluster, state, reconciler.reqLogger)
+	assert.NoError(t, err)
+	err = reconciler.mapCephClusterStatus(mockStorageCluster, state)
 	assert.NoError(t, err)
-	assert.Empty(t, reconciler.conditions)
+	assert.Empty(t, state.conditions)
 }
 
 func TestStorageClusterCephClusterCreation(t *testing.T) {
@@ -518,14 +593,20 @@ func TestStorageClusterFinalizer(t *testing.T) {
 	err = reconciler.client.Delete(nil, noobaa)
 	assert.NoError(t, err)
 
-	result, err = reconciler.Reconcile(mockStorageClusterRequest)
-	assert.NoError(t, err)
+	// The uninstall takes several reconciles, and the node cleanup Jobs
+	// have to complete along the way
+	for i := 0; i < 10 && len(sc.ObjectMeta.GetFinalizers()) > 0; i++ {
+		result, err = reconciler.Reconcile(mockStorageClusterRequest)
+		assert.NoError(t, err)
+		completeCleanupJobs(t, reconciler)
+
+		sc = &api.StorageCluster{}
+		err = reconciler.client.Get(nil, mockStorageClusterRequest.NamespacedName, sc)
+		assert.NoError(t, err)
+	}
 	assert.Equal(t, reconcile.Result{}, result)
 
 	// Finalizer is removed
-	sc = &api.StorageCluster{}
-	err = reconciler.client.Get(nil, mockStorageClusterRequest.NamespacedName, sc)
-	assert.NoError(t, err)
 	assert.Len(t, sc.ObjectMeta.GetFinalizers(), 0)
 
 	noobaa = &v1alpha1.NooBaa{}
@@ -533,6 +614,131 @@ func TestStorageClusterFinalizer(t *testing.T) {
 	assert.True(t, errors.IsNotFound(err))
 }
 
+func TestStorageClusterDeletionBlocked(t *testing.T) {
+	rbdSCName := generateNameForCephBlockPoolSC(mockStorageCluster)
+	appPVC := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-data",
+			Namespace: "app-ns",
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &rbdSCName,
+		},
+	}
+	snapshotClass := &unstructured.Unstructured{}
+	snapshotClass.SetGroupVersionKind(volumeSnapshotGroupVersion.WithKind("VolumeSnapshotClass"))
+	snapshotClass.SetName("rbd-snapshots")
+	snapshotClass.Object["snapshotter"] = fmt.Sprintf("%s.rbd.csi.ceph.com", mockStorageCluster.Namespace)
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGroupVersion.WithKind("VolumeSnapshot"))
+	snapshot.SetName("app-data-snap")
+	snapshot.SetNamespace("app-ns")
+	snapshot.Object["spec"] = map[string]interface{}{
+		"snapshotClassName": "rbd-snapshots",
+		"source": map[string]interface{}{
+			"kind": "PersistentVolumeClaim",
+			"name": "deleted-pvc",
+		},
+	}
+	obc := &obv1.ObjectBucketClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-bucket",
+			Namespace: "app-ns",
+		},
+		Spec: obv1.ObjectBucketClaimSpec{
+			StorageClassName: generateNameForNooBaaOBCSC(mockStorageCluster),
+		},
+	}
+
+	cases := []struct {
+		label            string
+		objects          []runtime.Object
+		forceAnnotation  bool
+		expectedBlocked  corev1.ConditionStatus
+		expectedConsumer string
+	}{
+		{
+			label:           "no consumers",
+			expectedBlocked: corev1.ConditionFalse,
+		},
+		{
+			label:            "PVC on the RBD StorageClass",
+			objects:          []runtime.Object{appPVC},
+			expectedBlocked:  corev1.ConditionTrue,
+			expectedConsumer: "PersistentVolumeClaim app-ns/app-data",
+		},
+		{
+			label:            "VolumeSnapshot taken through the RBD driver",
+			objects:          []runtime.Object{snapshotClass, snapshot},
+			expectedBlocked:  corev1.ConditionTrue,
+			expectedConsumer: "VolumeSnapshot app-ns/app-data-snap",
+		},
+		{
+			label:            "OBC on the NooBaa StorageClass",
+			objects:          []runtime.Object{obc},
+			expectedBlocked:  corev1.ConditionTrue,
+			expectedConsumer: "ObjectBucketClaim app-ns/app-bucket",
+		},
+		{
+			label:           "consumers overridden by the force deletion annotation",
+			objects:         []runtime.Object{appPVC, snapshotClass, snapshot, obc},
+			forceAnnotation: true,
+			expectedBlocked: corev1.ConditionFalse,
+		},
+	}
+
+	for _, c := range cases {
+		sc := &api.StorageCluster{}
+		mockStorageCluster.DeepCopyInto(sc)
+		sc.Status.Phase = statusutil.PhaseReady
+		sc.SetFinalizers([]string{storageClusterFinalizer})
+		now := metav1.Now()
+		sc.SetDeletionTimestamp(&now)
+		if c.forceAnnotation {
+			sc.SetAnnotations(map[string]string{api.ForceDeletionAnnotation: "true"})
+		}
+		objects := []runtime.Object{sc}
+		for _, obj := range c.objects {
+			objects = append(objects, obj.DeepCopyObject())
+		}
+		reconciler := createFakeStorageClusterReconciler(t, objects...)
+
+		_, err := reconciler.Reconcile(mockStorageClusterRequest)
+		assert.NoError(t, err, c.label)
+
+		sc = &api.StorageCluster{}
+		err = reconciler.client.Get(nil, mockStorageClusterRequest.NamespacedName, sc)
+		assert.NoError(t, err, c.label)
+
+		condition := conditionsv1.FindStatusCondition(sc.Status.Conditions, api.ConditionDeletionBlocked)
+		if c.expectedBlocked == corev1.ConditionTrue {
+			// Nothing has been deleted, and the finalizer stays in place
+			assert.Len(t, sc.GetFinalizers(), 1, c.label)
+			if assert.NotNil(t, condition, c.label) {
+				assert.Equal(t, corev1.ConditionTrue, condition.Status, c.label)
+				assert.Contains(t, condition.Message, c.expectedConsumer, c.label)
+			}
+			continue
+		}
+		// The uninstall moved past the consumer check, so the condition
+		// was only persisted if a later step is still in progress
+		if condition != nil {
+			assert.Equal(t, corev1.ConditionFalse, condition.Status, c.label)
+		}
+	}
+}
+
+func TestFormatStorageConsumers(t *testing.T) {
+	consumers := []string{}
+	for i := 0; i < maxListedConsumers+2; i++ {
+		consumers = append(consumers, fmt.Sprintf("PersistentVolumeClaim ns/pvc-%d", i))
+	}
+	assert.Equal(t, "PersistentVolumeClaim ns/pvc-0", formatStorageConsumers(consumers[:1]))
+	formatted := formatStorageConsumers(consumers)
+	assert.Contains(t, formatted, fmt.Sprintf("pvc-%d and 2 more", maxListedConsumers-1))
+	assert.NotContains(t, formatted, fmt.Sprintf("pvc-%d", maxListedConsumers))
+}
+
 func assertExpectedCondition(t *testing.T, conditions []conditionsv1.Condition) {
 	expectedConditions := map[conditionsv1.ConditionType]corev1.ConditionStatus{
 		api.ConditionReconcileComplete:    corev1.ConditionTrue,
@@ -560,15 +766,49 @@ func assertCondition(conditions []conditionsv1.Condition, conditionType conditio
 
 func createFakeStorageClusterReconciler(t *testing.T, obj ...runtime.Object) ReconcileStorageCluster {
 	scheme := createFakeScheme(t)
-	client := fake.NewFakeClientWithScheme(scheme, obj...)
+	typed := []runtime.Object{}
+	reader := &unstructuredReader{}
+	for _, o := range obj {
+		if u, ok := o.(*unstructured.Unstructured); ok {
+			reader.objects = append(reader.objects, u)
+		} else {
+			typed = append(typed, o)
+		}
+	}
+	fakeClient := fake.NewFakeClientWithScheme(scheme, typed...)
+	reader.Reader = fakeClient
 
 	return ReconcileStorageCluster{
-		client:    client,
+		client:    fakeClient,
+		apiReader: reader,
 		scheme:    scheme,
 		reqLogger: logf.Log.WithName("controller_storagecluster_test"),
+		locks:     newKeyedLocks(),
 	}
 }
 
+// unstructuredReader serves unstructured lists from a fixed set of objects,
+// as the fake client is unable to decode them
+type unstructuredReader struct {
+	client.Reader
+	objects []*unstructured.Unstructured
+}
+
+func (r *unstructuredReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	ulist, ok := list.(*unstructured.UnstructuredList)
+	if !ok {
+		return r.Reader.List(ctx, list, opts...)
+	}
+	gvk := ulist.GroupVersionKind()
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	for _, obj := range r.objects {
+		if obj.GroupVersionKind() == gvk {
+			ulist.Items = append(ulist.Items, *obj.DeepCopy())
+		}
+	}
+	return nil
+}
+
 func createFakeScheme(t *testing.T) *runtime.Scheme {
 	scheme, err := api.SchemeBuilder.Build()
 	if err != nil {
@@ -582,5 +822,29 @@ func createFakeScheme(t *testing.T) *runtime.Scheme {
 	if err != nil {
 		assert.Fail(t, "failed to add rookCephv1 scheme")
 	}
+	err = storagev1.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add storagev1 scheme")
+	}
+	err = batchv1.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add batchv1 scheme")
+	}
+	err = obv1.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add objectbucket.io scheme")
+	}
+	err = networkingv1beta1.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add networkingv1beta1 scheme")
+	}
+	err = v1alpha1.SchemeBuilder.AddToScheme(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add noobaa scheme")
+	}
+	err = secv1.Install(scheme)
+	if err != nil {
+		assert.Fail(t, "failed to add security/v1 scheme")
+	}
 	return scheme
 }
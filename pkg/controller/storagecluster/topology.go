@@ -0,0 +1,213 @@
+package storagecluster
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/openshift/ocs-operator/pkg/controller/placement"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// nodeTopologyGracePeriod is how long a topology label value may be missing
+// from all storage nodes before it is pruned from the NodeTopologies, so that
+// a node that briefly leaves the cluster does not move any OSDs
+const nodeTopologyGracePeriod = 10 * time.Minute
+
+// legacyTopologyKeys are the deprecated zone and region labels, in the order
+// they were preferred before the FailureDomainKey was recorded
+var legacyTopologyKeys = []string{
+	"failure-domain.beta.kubernetes.io/zone",
+	"failure-domain.beta.kubernetes.io/region",
+	"failure-domain.kubernetes.io/zone",
+	"failure-domain.kubernetes.io/region",
+}
+
+// getTopologyKeys returns the node labels collected into the NodeTopologies
+// of the StorageCluster, in order of preference. The labels holding the
+// failure domain are always collected, except for the hostname.
+func getTopologyKeys(sc *ocsv1.StorageCluster) []string {
+	keys := defaults.TopologyKeys
+	if len(sc.Spec.TopologyKeys) > 0 {
+		keys = sc.Spec.TopologyKeys
+	}
+
+	_, specKey := getSpecFailureDomain(sc)
+	for _, key := range []string{specKey, sc.Status.FailureDomainKey} {
+		if key == "" || key == corev1.LabelHostname {
+			continue
+		}
+		found := false
+		for _, k := range keys {
+			if k == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			keys = append(append([]string{}, keys...), key)
+		}
+	}
+	return keys
+}
+
+// resolveTopologyKey returns the node label for the given topology key. A
+// key is either a label itself, or a failure domain such as "zone" that is
+// looked up among the topology keys. The recorded FailureDomainKey always
+// wins for the FailureDomain.
+func resolveTopologyKey(sc *ocsv1.StorageCluster, key string) string {
+	return getPlacementTopology(sc).ResolveKey(key)
+}
+
+// getPlacementTopology returns the topology the device sets of the
+// StorageCluster are placed on
+func getPlacementTopology(sc *ocsv1.StorageCluster) placement.Topology {
+	return placement.Topology{
+		NodeTopologies:   sc.Status.NodeTopologies,
+		TopologyKeys:     getTopologyKeys(sc),
+		FailureDomain:    determineFailureDomain(sc),
+		FailureDomainKey: sc.Status.FailureDomainKey,
+	}
+}
+
+// determineFailureDomainKey returns the node label holding the failure
+// domain of the storage nodes. StorageClusters whose FailureDomain was set
+// before its key was recorded keep to the deprecated labels they were
+// deployed with, so that their device sets do not move.
+func determineFailureDomainKey(sc *ocsv1.StorageCluster) string {
+	if sc.Status.FailureDomainKey != "" {
+		return sc.Status.FailureDomainKey
+	}
+	if _, specKey := getSpecFailureDomain(sc); specKey != "" && sc.Status.FailureDomain == "" {
+		return specKey
+	}
+
+	keys := getTopologyKeys(sc)
+	if sc.Status.FailureDomain != "" {
+		keys = append(append([]string{}, legacyTopologyKeys...), keys...)
+	}
+	return placement.FindKey(sc.Status.NodeTopologies, keys, determineFailureDomain(sc))
+}
+
+// mergeNodeTopologies returns the NodeTopologies of the StorageCluster brought
+// in line with the topology of the current storage nodes, along with the
+// values that are kept although no storage node has them. The order of the
+// values already recorded is kept, since the device sets are spread by it.
+func mergeNodeTopologies(sc *ocsv1.StorageCluster, current *ocsv1.NodeTopologyMap, now metav1.Time, reqLogger logr.Logger) (*ocsv1.NodeTopologyMap, []ocsv1.StaleTopologyLabel) {
+	missingSince := map[string]map[string]metav1.Time{}
+	for _, stale := range sc.Status.StaleNodeTopologies {
+		if missingSince[stale.Label] == nil {
+			missingSince[stale.Label] = map[string]metav1.Time{}
+		}
+		missingSince[stale.Label][stale.Value] = stale.MissingSince
+	}
+
+	topologyMap := ocsv1.NewNodeTopologyMap()
+	staleTopologies := []ocsv1.StaleTopologyLabel{}
+	if sc.Status.NodeTopologies != nil {
+		for key, values := range sc.Status.NodeTopologies.Labels {
+			for _, value := range values {
+				if current.Contains(key, value) {
+					topologyMap.Add(key, value)
+					continue
+				}
+				since, ok := missingSince[key][value]
+				if !ok {
+					reqLogger.Info("Topology label no longer found on any storage node", "Label", key, "Value", value)
+					since = now
+				}
+				if now.Sub(since.Time) >= nodeTopologyGracePeriod {
+					reqLogger.Info("Pruning topology label from node topology map", "Label", key, "Value", value)
+					continue
+				}
+				topologyMap.Add(key, value)
+				staleTopologies = append(staleTopologies, ocsv1.StaleTopologyLabel{
+					Label:        key,
+					Value:        value,
+					MissingSince: since,
+				})
+			}
+		}
+	}
+
+	keys := []string{}
+	for key := range current.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range current.Labels[key] {
+			if !topologyMap.Contains(key, value) {
+				reqLogger.Info("Adding topology label from node", "Label", key, "Value", value)
+				topologyMap.Add(key, value)
+			}
+		}
+	}
+
+	sort.Slice(staleTopologies, func(i, j int) bool {
+		if staleTopologies[i].Label != staleTopologies[j].Label {
+			return staleTopologies[i].Label < staleTopologies[j].Label
+		}
+		return staleTopologies[i].Value < staleTopologies[j].Value
+	})
+	return topologyMap, staleTopologies
+}
+
+// getNodeTopologyRequeueAfter returns how long until the next stale topology
+// label value is due to be pruned, or zero if there are none
+func getNodeTopologyRequeueAfter(sc *ocsv1.StorageCluster, now time.Time) time.Duration {
+	var requeueAfter time.Duration
+	for _, stale := range sc.Status.StaleNodeTopologies {
+		after := stale.MissingSince.Add(nodeTopologyGracePeriod).Sub(now)
+		if after <= 0 {
+			after = time.Second
+		}
+		if requeueAfter == 0 || after < requeueAfter {
+			requeueAfter = after
+		}
+	}
+	return requeueAfter
+}
+
+// nodeMapper enqueues all StorageClusters when a node changes, since any
+// node may become or stop being a storage node
+type nodeMapper struct {
+	client client.Client
+}
+
+// Map implements handler.Mapper
+func (m *nodeMapper) Map(obj handler.MapObject) []reconcile.Request {
+	scs := &ocsv1.StorageClusterList{}
+	err := m.client.List(context.TODO(), scs)
+	if err != nil {
+		log.Error(err, "Failed to list StorageClusters")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, sc := range scs.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: sc.Name, Namespace: sc.Namespace},
+		})
+	}
+	return requests
+}
+
+// nodeLabelsChangedPredicate filters out node updates that do not change
+// the labels, such as the periodic status heartbeats
+var nodeLabelsChangedPredicate = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return !reflect.DeepEqual(e.MetaOld.GetLabels(), e.MetaNew.GetLabels())
+	},
+}
@@ -0,0 +1,239 @@
+package storagecluster
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+const (
+	gaZoneTopologyLabel   = "topology.kubernetes.io/zone"
+	betaZoneTopologyLabel = "failure-domain.beta.kubernetes.io/zone"
+)
+
+func newZoneTopologyMap(labels ...string) *api.NodeTopologyMap {
+	topologyMap := api.NewNodeTopologyMap()
+	for _, label := range labels {
+		for _, zone := range []string{"zone1", "zone2", "zone3"} {
+			topologyMap.Add(label, zone)
+		}
+	}
+	return topologyMap
+}
+
+func TestNodeTopologyMapExactLabels(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	nodeList := mockNodeList.DeepCopy()
+	for i := range nodeList.Items {
+		labels := nodeList.Items[i].Labels
+		labels[gaZoneTopologyLabel] = labels[zoneTopologyLabel]
+		labels["topology.rook.io/rack-owner"] = "team"
+		labels["example.com/zone-name"] = "east"
+	}
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	assert.Equal(t, newZoneTopologyMap(gaZoneTopologyLabel, zoneTopologyLabel), sc.Status.NodeTopologies)
+}
+
+func TestNodeTopologyMapCustomKeys(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Spec.TopologyKeys = []string{"example.com/zone"}
+	nodeList := mockNodeList.DeepCopy()
+	for i := range nodeList.Items {
+		nodeList.Items[i].Labels["example.com/zone"] = nodeList.Items[i].Labels[zoneTopologyLabel]
+	}
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	assert.Equal(t, newZoneTopologyMap("example.com/zone"), sc.Status.NodeTopologies)
+	assert.Equal(t, "zone", determineFailureDomain(sc))
+	assert.Equal(t, "example.com/zone", determineFailureDomainKey(sc))
+}
+
+func TestNodeTopologyMapRecordsLegacyFailureDomainKey(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Status.FailureDomain = "zone"
+	sc.Status.NodeTopologies = newZoneTopologyMap(betaZoneTopologyLabel)
+	nodeList := mockNodeList.DeepCopy()
+	for i := range nodeList.Items {
+		labels := nodeList.Items[i].Labels
+		labels[gaZoneTopologyLabel] = labels[zoneTopologyLabel]
+		labels[betaZoneTopologyLabel] = labels[zoneTopologyLabel]
+	}
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	// The existing entries are kept, and the deprecated label the device
+	// sets were spread with stays the failure domain key
+	assert.Equal(t, api.TopologyLabelValues{"zone1", "zone2", "zone3"}, sc.Status.NodeTopologies.Labels[betaZoneTopologyLabel])
+	assert.Contains(t, sc.Status.NodeTopologies.Labels, gaZoneTopologyLabel)
+	assert.Equal(t, "zone", sc.Status.FailureDomain)
+	assert.Equal(t, betaZoneTopologyLabel, sc.Status.FailureDomainKey)
+
+	sc.Spec.StorageDeviceSets = mockDeviceSets
+	for _, scds := range newStorageClassDeviceSets(sc) {
+		matchExpressions := scds.Placement.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions
+		assert.Equal(t, betaZoneTopologyLabel, matchExpressions[1].Key)
+	}
+}
+
+func TestDetermineFailureDomainKey(t *testing.T) {
+	// New StorageClusters prefer the GA labels
+	sc := mockStorageCluster.DeepCopy()
+	sc.Status.NodeTopologies = newZoneTopologyMap(betaZoneTopologyLabel, gaZoneTopologyLabel)
+	assert.Equal(t, gaZoneTopologyLabel, determineFailureDomainKey(sc))
+	assert.Equal(t, gaZoneTopologyLabel, resolveTopologyKey(sc, "zone"))
+
+	// Existing ones keep the deprecated labels
+	sc.Status.FailureDomain = "zone"
+	assert.Equal(t, betaZoneTopologyLabel, determineFailureDomainKey(sc))
+
+	// The recorded key wins
+	sc.Status.FailureDomainKey = zoneTopologyLabel
+	assert.Equal(t, zoneTopologyLabel, determineFailureDomainKey(sc))
+	assert.Equal(t, zoneTopologyLabel, resolveTopologyKey(sc, "zone"))
+
+	// Labels are resolved to themselves
+	assert.Equal(t, betaZoneTopologyLabel, resolveTopologyKey(sc, betaZoneTopologyLabel))
+
+	// Racks are only known by the Rook label
+	sc = mockStorageCluster.DeepCopy()
+	sc.Status.NodeTopologies = api.NewNodeTopologyMap()
+	sc.Status.NodeTopologies.Add(defaults.RackTopologyKey, "rack0")
+	assert.Equal(t, defaults.RackTopologyKey, determineFailureDomainKey(sc))
+}
+
+func TestGetKeyValuesExactMatch(t *testing.T) {
+	topologyMap := newZoneTopologyMap(gaZoneTopologyLabel)
+
+	key, values := topologyMap.GetKeyValues(gaZoneTopologyLabel)
+	assert.Equal(t, gaZoneTopologyLabel, key)
+	assert.Equal(t, []string{"zone1", "zone2", "zone3"}, values)
+
+	key, values = topologyMap.GetKeyValues("zone")
+	assert.Equal(t, "zone", key)
+	assert.Empty(t, values)
+}
+
+func TestNodeTopologyMapPrunesDepartedNodes(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Status.NodeTopologies = newZoneTopologyMap(zoneTopologyLabel)
+	sc.Status.NodeTopologies.Add(zoneTopologyLabel, "zone4")
+	nodeList := mockNodeList.DeepCopy()
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	// The zone of the departed node is kept for the grace period
+	assert.Equal(t, api.TopologyLabelValues{"zone1", "zone2", "zone3", "zone4"}, sc.Status.NodeTopologies.Labels[zoneTopologyLabel])
+	assert.Len(t, sc.Status.StaleNodeTopologies, 1)
+	stale := sc.Status.StaleNodeTopologies[0]
+	assert.Equal(t, zoneTopologyLabel, stale.Label)
+	assert.Equal(t, "zone4", stale.Value)
+	requeueAfter := getNodeTopologyRequeueAfter(sc, stale.MissingSince.Time)
+	assert.Equal(t, nodeTopologyGracePeriod, requeueAfter)
+
+	// It stays stale across reconciles within the grace period
+	err = reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, []api.StaleTopologyLabel{stale}, sc.Status.StaleNodeTopologies)
+
+	// And is pruned once the grace period is over
+	sc.Status.StaleNodeTopologies[0].MissingSince = metav1.NewTime(time.Now().Add(-nodeTopologyGracePeriod))
+	err = reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, newZoneTopologyMap(zoneTopologyLabel), sc.Status.NodeTopologies)
+	assert.Empty(t, sc.Status.StaleNodeTopologies)
+	assert.Zero(t, getNodeTopologyRequeueAfter(sc, time.Now()))
+}
+
+func TestNodeTopologyMapReturningNode(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Status.NodeTopologies = newZoneTopologyMap(zoneTopologyLabel)
+	sc.Status.StaleNodeTopologies = []api.StaleTopologyLabel{
+		{Label: zoneTopologyLabel, Value: "zone3", MissingSince: metav1.NewTime(time.Now().Add(-time.Minute))},
+	}
+	nodeList := mockNodeList.DeepCopy()
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	assert.Equal(t, newZoneTopologyMap(zoneTopologyLabel), sc.Status.NodeTopologies)
+	assert.Empty(t, sc.Status.StaleNodeTopologies)
+}
+
+func TestNodeTopologyMapKeepsValueOrder(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Status.NodeTopologies = api.NewNodeTopologyMap()
+	sc.Status.NodeTopologies.Add(zoneTopologyLabel, "zone3")
+	sc.Status.NodeTopologies.Add(zoneTopologyLabel, "zone1")
+	nodeList := mockNodeList.DeepCopy()
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	// New values are added after the recorded ones
+	assert.Equal(t, api.TopologyLabelValues{"zone3", "zone1", "zone2"}, sc.Status.NodeTopologies.Labels[zoneTopologyLabel])
+}
+
+func TestNodeTopologyMapStorageNodes(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	sc.Status.FailureDomain = "rack"
+	nodeList := mockNodeList.DeepCopy()
+	nodeList.Items[0].Labels[defaults.RackTopologyKey] = "rack1"
+
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+	err := reconciler.reconcileNodeTopologyMap(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+
+	assert.Len(t, sc.Status.StorageNodes, 3)
+	for i, storageNode := range sc.Status.StorageNodes {
+		node := &corev1.Node{}
+		err = reconciler.client.Get(nil, types.NamespacedName{Name: storageNode.Name}, node)
+		assert.NoError(t, err)
+		assert.Equal(t, nodeList.Items[i].Name, storageNode.Name)
+		assert.Equal(t, map[string]string{
+			zoneTopologyLabel:        node.Labels[zoneTopologyLabel],
+			defaults.RackTopologyKey: node.Labels[defaults.RackTopologyKey],
+		}, storageNode.Labels)
+		// The StorageCluster relies on the racks made up before it too
+		assert.Equal(t, `{"storage-test-ns/storage-test":["rack"]}`, node.Annotations[storageClusterAnnotation], node.Name)
+	}
+	assert.Equal(t, "rack1", sc.Status.StorageNodes[0].Labels[defaults.RackTopologyKey])
+}
+
+func TestNodeMapper(t *testing.T) {
+	sc := mockStorageCluster.DeepCopy()
+	reconciler := createFakeStorageClusterReconciler(t, sc)
+	mapper := &nodeMapper{client: reconciler.client}
+
+	node := mockNodeList.Items[0].DeepCopy()
+	requests := mapper.Map(handler.MapObject{Meta: node, Object: node})
+	assert.Len(t, requests, 1)
+	assert.Equal(t, mockStorageClusterRequest.NamespacedName, requests[0].NamespacedName)
+
+	// Only label changes are of interest
+	newNode := node.DeepCopy()
+	newNode.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	assert.False(t, nodeLabelsChangedPredicate.Update(event.UpdateEvent{MetaOld: node, ObjectOld: node, MetaNew: newNode, ObjectNew: newNode}))
+	newNode.Labels[zoneTopologyLabel] = "zone4"
+	assert.True(t, nodeLabelsChangedPredicate.Update(event.UpdateEvent{MetaOld: node, ObjectOld: node, MetaNew: newNode, ObjectNew: newNode}))
+}
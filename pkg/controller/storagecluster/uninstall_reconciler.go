@@ -0,0 +1,737 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	obv1 "github.com/kube-object-storage/lib-bucket-provisioner/pkg/apis/objectbucket.io/v1alpha1"
+	conditionsv1 "github.com/openshift/custom-resource-status/conditions/v1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	uninstallReason = "UninstallInProgress"
+
+	// maxListedConsumers caps the number of consumers named in the
+	// DeletionBlocked condition message
+	maxListedConsumers = 10
+
+	// cleanupJobBackoffLimit is the number of retries of a node cleanup
+	// Job before it is considered failed
+	cleanupJobBackoffLimit int32 = 3
+
+	// uninstallRequeueInterval is how often an uninstall in progress is
+	// checked on
+	uninstallRequeueInterval = 10 * time.Second
+
+	// cleanupDataDirAnnotation records on the StorageCluster the data dir
+	// of its CephCluster, for the cleanup Jobs to wipe once it is gone
+	cleanupDataDirAnnotation = "uninstall.ocs.openshift.io/data-dir-host-path"
+	// cleanupNodesAnnotation records on the StorageCluster the nodes its
+	// Ceph daemons ran on, as a comma-separated list
+	cleanupNodesAnnotation = "uninstall.ocs.openshift.io/nodes"
+)
+
+// generatedRackName matches the rack names given to nodes by ensureNodeRacks
+var generatedRackName = regexp.MustCompile(`^rack[0-9]+$`)
+
+// uninstallStep is a single stage of the StorageCluster uninstall. It returns
+// true once the stage has completed.
+type uninstallStep struct {
+	message string
+	f       func(*ocsv1.StorageCluster, logr.Logger) (bool, error)
+}
+
+// getUninstallMode returns the uninstall mode of the StorageCluster, falling
+// back to graceful if none is set. The ForceDeletionAnnotation overrides the
+// mode in the spec.
+func getUninstallMode(sc *ocsv1.StorageCluster) ocsv1.UninstallModeType {
+	if sc.GetAnnotations()[ocsv1.ForceDeletionAnnotation] == "true" {
+		return ocsv1.UninstallModeForced
+	}
+	if sc.Spec.Uninstall.Mode == "" {
+		return ocsv1.UninstallModeGraceful
+	}
+	return sc.Spec.Uninstall.Mode
+}
+
+// deleteResources removes everything the operator created for the
+// StorageCluster. It returns true once all resources have been removed and
+// the finalizer can be dropped.
+func (r *ReconcileStorageCluster) deleteResources(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	mode := getUninstallMode(sc)
+	reqLogger.Info("Uninstalling StorageCluster", "Mode", mode)
+
+	if mode == ocsv1.UninstallModeGraceful {
+		consumers, err := r.getStorageConsumers(sc)
+		if err != nil {
+			return false, err
+		}
+		if len(consumers) > 0 {
+			message := fmt.Sprintf("Deletion blocked by %d consumers of the StorageClasses: %s. Delete them, or set the %s annotation to \"true\" to force the deletion",
+				len(consumers), formatStorageConsumers(consumers), ocsv1.ForceDeletionAnnotation)
+			reqLogger.Info(message)
+			conditionsv1.SetStatusCondition(&sc.Status.Conditions, conditionsv1.Condition{
+				Type:    ocsv1.ConditionDeletionBlocked,
+				Status:  corev1.ConditionTrue,
+				Reason:  "StorageConsumersFound",
+				Message: message,
+			})
+			r.setUninstallProgress(sc, message)
+			return false, nil
+		}
+		conditionsv1.SetStatusCondition(&sc.Status.Conditions, conditionsv1.Condition{
+			Type:    ocsv1.ConditionDeletionBlocked,
+			Status:  corev1.ConditionFalse,
+			Reason:  "NoStorageConsumers",
+			Message: "No consumers of the StorageClasses remain",
+		})
+	} else {
+		conditionsv1.SetStatusCondition(&sc.Status.Conditions, conditionsv1.Condition{
+			Type:    ocsv1.ConditionDeletionBlocked,
+			Status:  corev1.ConditionFalse,
+			Reason:  "ForcedDeletion",
+			Message: "Deletion is forced, remaining consumers of the StorageClasses are ignored",
+		})
+	}
+
+	for _, step := range []uninstallStep{
+		// NoobaaSystem is dependent upon ceph for volume provisioning.
+		// We want to make sure we delete noobaasystem before we delete cephcluster, to get a clean uninstall.
+		{"Waiting on NooBaa system to be deleted", r.deleteNoobaaSystems},
+		{"Waiting on Ceph resources to be deleted", r.deleteCephResources},
+		{"Waiting on node cleanup Jobs to complete", r.ensureCleanupJobs},
+		{"Rolling back dedicated storage nodes", r.deleteDedicatedNodeChanges},
+		{"Removing rack labels from nodes", r.deleteNodeRackLabels},
+		{"Releasing managed storage nodes", r.deleteManagedNodeLabels},
+		{"Deleting Ceph ConfigMap", r.deleteCephConfig},
+		{"Removing the service accounts from the SCC", r.deleteSCCUsers},
+		{"Deleting VolumeSnapshotClasses", r.deleteSnapshotClasses},
+		{"Deleting StorageClasses", r.deleteStorageClasses},
+	} {
+		done, err := step.f(sc, reqLogger)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			r.setUninstallProgress(sc, step.message)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// setUninstallProgress records the current stage of the uninstall in the
+// StorageCluster status
+func (r *ReconcileStorageCluster) setUninstallProgress(sc *ocsv1.StorageCluster, message string) {
+	sc.Status.Phase = statusutil.PhaseDeleting
+	statusutil.SetProgressingCondition(&sc.Status.Conditions, uninstallReason, message)
+}
+
+// formatStorageConsumers joins the names of the consumers, leaving out all
+// but the first maxListedConsumers
+func formatStorageConsumers(consumers []string) string {
+	if len(consumers) <= maxListedConsumers {
+		return strings.Join(consumers, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(consumers[:maxListedConsumers], ", "), len(consumers)-maxListedConsumers)
+}
+
+// getStorageConsumers returns the PVCs, VolumeSnapshots and OBCs across all
+// namespaces that still use one of the StorageClasses created for the
+// StorageCluster, under either of their names
+func (r *ReconcileStorageCluster) getStorageConsumers(sc *ocsv1.StorageCluster) ([]string, error) {
+	consumers := []string{}
+
+	storageClassNames := map[string]bool{}
+	// Buckets are provisioned through the bucket StorageClasses, the one of
+	// NooBaa included
+	for _, name := range []string{generateNameForCephFilesystemSC(sc), generateNameForCephBlockPoolSC(sc), generateNameForCephRgwSC(sc)} {
+		names, err := r.getClusterScopedNames(sc, &storagev1.StorageClass{}, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			storageClassNames[name] = true
+		}
+	}
+	storageClassNames[generateNameForNooBaaOBCSC(sc)] = true
+
+	scs, err := r.newStorageClasses(sc)
+	if err != nil {
+		return nil, err
+	}
+	provisioners := map[string]bool{}
+	for _, storageClass := range scs {
+		provisioners[storageClass.Provisioner] = true
+	}
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	err = r.apiReader.List(context.TODO(), pvcs)
+	if err != nil {
+		return nil, err
+	}
+	ocsPVCs := map[types.NamespacedName]bool{}
+	for _, pvc := range pvcs.Items {
+		if pvc.Spec.StorageClassName == nil || !storageClassNames[*pvc.Spec.StorageClassName] {
+			continue
+		}
+		ocsPVCs[types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}] = true
+		// The NooBaa DB volume is removed together with the NooBaa system
+		if pvc.Namespace == sc.Namespace && pvc.Labels["noobaa-core"] == "noobaa" {
+			continue
+		}
+		consumers = append(consumers, fmt.Sprintf("PersistentVolumeClaim %s/%s", pvc.Namespace, pvc.Name))
+	}
+
+	snapshotConsumers, err := r.getSnapshotConsumers(provisioners, ocsPVCs)
+	if err != nil {
+		return nil, err
+	}
+	consumers = append(consumers, snapshotConsumers...)
+
+	obcs := &obv1.ObjectBucketClaimList{}
+	err = r.apiReader.List(context.TODO(), obcs)
+	if err != nil {
+		return nil, err
+	}
+	for _, obc := range obcs.Items {
+		if storageClassNames[obc.Spec.StorageClassName] {
+			consumers = append(consumers, fmt.Sprintf("ObjectBucketClaim %s/%s", obc.Namespace, obc.Name))
+		}
+	}
+
+	return consumers, nil
+}
+
+// getSnapshotConsumers returns the VolumeSnapshots that were taken through
+// one of the given CSI drivers, or of one of the given PVCs. The snapshot API
+// is used unstructured, as it is not available on every cluster.
+func (r *ReconcileStorageCluster) getSnapshotConsumers(provisioners map[string]bool, ocsPVCs map[types.NamespacedName]bool) ([]string, error) {
+	consumers := []string{}
+
+	snapshotClasses := &unstructured.UnstructuredList{}
+	snapshotClasses.SetGroupVersionKind(volumeSnapshotGroupVersion.WithKind("VolumeSnapshotClassList"))
+	err := r.apiReader.List(context.TODO(), snapshotClasses)
+	if err != nil {
+		if meta.IsNoMatchError(err) {
+			return consumers, nil
+		}
+		return nil, err
+	}
+	snapshotClassNames := map[string]bool{}
+	for _, snapshotClass := range snapshotClasses.Items {
+		snapshotter, _, _ := unstructured.NestedString(snapshotClass.Object, "snapshotter")
+		if provisioners[snapshotter] {
+			snapshotClassNames[snapshotClass.GetName()] = true
+		}
+	}
+
+	snapshots := &unstructured.UnstructuredList{}
+	snapshots.SetGroupVersionKind(volumeSnapshotGroupVersion.WithKind("VolumeSnapshotList"))
+	err = r.apiReader.List(context.TODO(), snapshots)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots.Items {
+		className, _, _ := unstructured.NestedString(snapshot.Object, "spec", "snapshotClassName")
+		kind, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "kind")
+		source, _, _ := unstructured.NestedString(snapshot.Object, "spec", "source", "name")
+		isOCSSource := kind == "PersistentVolumeClaim" && ocsPVCs[types.NamespacedName{Name: source, Namespace: snapshot.GetNamespace()}]
+		if snapshotClassNames[className] || isOCSSource {
+			consumers = append(consumers, fmt.Sprintf("VolumeSnapshot %s/%s", snapshot.GetNamespace(), snapshot.GetName()))
+		}
+	}
+
+	return consumers, nil
+}
+
+// deleteOwnedObject deletes the named object if it is owned by the
+// StorageCluster. It returns true once the object no longer exists or if it
+// is not owned by the StorageCluster.
+func (r *ReconcileStorageCluster) deleteOwnedObject(sc *ocsv1.StorageCluster, obj runtime.Object, name string, reqLogger logr.Logger) (bool, error) {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: sc.Namespace}, obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	objMeta, ok := obj.(metav1.Object)
+	if !ok {
+		return false, fmt.Errorf("unable to get metadata of %s", name)
+	}
+
+	isOwned := false
+	for _, ref := range objMeta.GetOwnerReferences() {
+		if ref.UID == sc.UID {
+			isOwned = true
+			break
+		}
+	}
+	if !isOwned {
+		reqLogger.Info("Object found, but ownerReference not set to storagecluster. Skipping", "Name", name)
+		return true, nil
+	}
+
+	if objMeta.GetDeletionTimestamp().IsZero() {
+		reqLogger.Info("Deleting object", "Name", name)
+		err = r.client.Delete(context.TODO(), obj)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// deleteCephResources deletes the Ceph resources created for the
+// StorageCluster, with the CephCluster last, and waits for them to be gone
+func (r *ReconcileStorageCluster) deleteCephResources(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	allDeleted := true
+	for _, obj := range []struct {
+		obj  runtime.Object
+		name string
+	}{
+		{&cephv1.CephObjectStoreUser{}, generateNameForCephObjectStoreUser(sc)},
+		{&cephv1.CephObjectStore{}, generateNameForCephObjectStore(sc)},
+		{&cephv1.CephFilesystem{}, generateNameForCephFilesystem(sc)},
+		{&cephv1.CephBlockPool{}, generateNameForCephBlockPool(sc)},
+	} {
+		deleted, err := r.deleteOwnedObject(sc, obj.obj, obj.name, reqLogger)
+		if err != nil {
+			return false, err
+		}
+		allDeleted = allDeleted && deleted
+	}
+	if !allDeleted {
+		return false, nil
+	}
+
+	err := r.recordCleanupTargets(sc, reqLogger)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := r.deleteOwnedObject(sc, &cephv1.CephCluster{}, generateNameForCephCluster(sc), reqLogger)
+	if err != nil || !deleted {
+		return false, err
+	}
+
+	// The Ceph daemons are garbage collected after the CephCluster is gone,
+	// and must be stopped before their data can be wiped
+	pods, err := r.getCephDaemonPods(sc)
+	if err != nil {
+		return false, err
+	}
+	return len(pods.Items) == 0, nil
+}
+
+// getCephDaemonPods returns the pods Rook runs the Ceph daemons of the
+// StorageCluster in
+func (r *ReconcileStorageCluster) getCephDaemonPods(sc *ocsv1.StorageCluster) (*corev1.PodList, error) {
+	pods := &corev1.PodList{}
+	err := r.client.List(context.TODO(), pods, client.InNamespace(sc.Namespace), client.MatchingLabels(map[string]string{"rook_cluster": sc.Namespace}))
+	return pods, err
+}
+
+// recordCleanupTargets records on the StorageCluster the data dir of its
+// CephCluster and the nodes its Ceph daemons run on, before the CephCluster
+// is deleted. The cleanup Jobs only wipe these once the daemons are gone.
+func (r *ReconcileStorageCluster) recordCleanupTargets(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	if _, ok := sc.GetAnnotations()[cleanupNodesAnnotation]; ok {
+		return nil
+	}
+
+	cephCluster := &cephv1.CephCluster{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCephCluster(sc), Namespace: sc.Namespace}, cephCluster)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	pods, err := r.getCephDaemonPods(sc)
+	if err != nil {
+		return err
+	}
+	nodes := []string{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName != "" && !contains(nodes, pod.Spec.NodeName) {
+			nodes = append(nodes, pod.Spec.NodeName)
+		}
+	}
+	sort.Strings(nodes)
+
+	reqLogger.Info("Recording the nodes to clean up", "Nodes", nodes, "DataDirHostPath", cephCluster.Spec.DataDirHostPath)
+	annotations := sc.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[cleanupDataDirAnnotation] = cephCluster.Spec.DataDirHostPath
+	annotations[cleanupNodesAnnotation] = strings.Join(nodes, ",")
+	sc.SetAnnotations(annotations)
+	return r.updateMetadata(sc)
+}
+
+// getStorageNodes returns all nodes labeled as storage nodes
+func (r *ReconcileStorageCluster) getStorageNodes() (*corev1.NodeList, error) {
+	nodes := &corev1.NodeList{}
+	nodeMatchLabel := map[string]string{defaults.NodeAffinityKey: ""}
+	err := r.client.List(context.TODO(), nodes, client.MatchingLabels(nodeMatchLabel))
+	return nodes, err
+}
+
+// getNodeDevices returns the local device paths, grouped by hostname, of the
+// released PersistentVolumes that backed the StorageCluster's OSDs
+func (r *ReconcileStorageCluster) getNodeDevices(sc *ocsv1.StorageCluster) (map[string][]string, error) {
+	nodeDevices := map[string][]string{}
+
+	pvs := &corev1.PersistentVolumeList{}
+	err := r.client.List(context.TODO(), pvs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pv := range pvs.Items {
+		if pv.Spec.Local == nil || pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != sc.Namespace {
+			continue
+		}
+		isDeviceSetPV := false
+		for _, ds := range getStorageDeviceSets(sc) {
+			if strings.HasPrefix(pv.Spec.ClaimRef.Name, ds.Name+"-") {
+				isDeviceSetPV = true
+				break
+			}
+		}
+		if !isDeviceSetPV || pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+			continue
+		}
+		for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				if expr.Key == corev1.LabelHostname && len(expr.Values) > 0 {
+					nodeDevices[expr.Values[0]] = append(nodeDevices[expr.Values[0]], pv.Spec.Local.Path)
+				}
+			}
+		}
+	}
+
+	return nodeDevices, nil
+}
+
+// ensureCleanupJobs runs a cleanup Job on every node the Ceph daemons of
+// the StorageCluster ran on, and waits for all of them to finish. In
+// graceful mode a failed Job blocks the uninstall.
+func (r *ReconcileStorageCluster) ensureCleanupJobs(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	annotations := sc.GetAnnotations()
+	dataDir := annotations[cleanupDataDirAnnotation]
+	if _, ok := annotations[cleanupNodesAnnotation]; !ok || dataDir == "" {
+		reqLogger.Info("No nodes recorded for cleanup, skipping the cleanup Jobs")
+		return true, nil
+	}
+	cleanupNodes := strings.Split(annotations[cleanupNodesAnnotation], ",")
+
+	nodes, err := r.getStorageNodes()
+	if err != nil {
+		return false, err
+	}
+
+	err = r.ensureCleanupServiceAccount(sc, reqLogger)
+	if err != nil {
+		return false, err
+	}
+
+	nodeDevices := map[string][]string{}
+	if sc.Spec.Uninstall.WipeDevices {
+		nodeDevices, err = r.getNodeDevices(sc)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	allDone := true
+	for _, node := range nodes.Items {
+		if !contains(cleanupNodes, node.Name) {
+			continue
+		}
+		job := newCleanupJob(sc, node, r.cephImage, dataDir, nodeDevices[node.Labels[corev1.LabelHostname]])
+		err = controllerutil.SetControllerReference(sc, job, r.scheme)
+		if err != nil {
+			return false, err
+		}
+
+		found := &batchv1.Job{}
+		err = r.client.Get(context.TODO(), types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, found)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				reqLogger.Info("Creating cleanup Job", "Node", node.Name)
+				err = r.client.Create(context.TODO(), job)
+				if err != nil {
+					return false, err
+				}
+				allDone = false
+				continue
+			}
+			return false, err
+		}
+
+		if found.Status.Succeeded > 0 {
+			continue
+		}
+		failed := false
+		for _, condition := range found.Status.Conditions {
+			if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+				failed = true
+				break
+			}
+		}
+		if failed {
+			if getUninstallMode(sc) == ocsv1.UninstallModeForced {
+				reqLogger.Info("Cleanup Job failed, ignoring in forced mode", "Job", found.Name)
+				continue
+			}
+			reqLogger.Info("Cleanup Job failed, switch to forced mode to proceed", "Job", found.Name)
+		}
+		allDone = false
+	}
+
+	return allDone, nil
+}
+
+// ensureCleanupServiceAccount creates the ServiceAccount the cleanup Jobs
+// run with. It is one of the users of the SCC of the Ceph daemons, which
+// lets it mount the host paths.
+func (r *ReconcileStorageCluster) ensureCleanupServiceAccount(sc *ocsv1.StorageCluster, reqLogger logr.Logger) error {
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCleanupServiceAccount(sc),
+			Namespace: sc.Namespace,
+		},
+	}
+	err := controllerutil.SetControllerReference(sc, sa, r.scheme)
+	if err != nil {
+		return err
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: sa.Name, Namespace: sa.Namespace}, &corev1.ServiceAccount{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			reqLogger.Info("Creating cleanup ServiceAccount", "Name", sa.Name)
+			return r.client.Create(context.TODO(), sa)
+		}
+		return err
+	}
+	return nil
+}
+
+// getCleanupScript returns the commands wiping the data of the StorageCluster
+// from the data dir. The data dir shared by all StorageClusters may hold the
+// data dirs of the others, so only what Rook keeps there for the namespace
+// and for the mons is removed from it.
+func getCleanupScript(sc *ocsv1.StorageCluster, dataDir string) string {
+	if path.Clean(dataDir) == rookDataDirHostPath {
+		return fmt.Sprintf("rm -rf %s %s", path.Join(dataDir, sc.Namespace), path.Join(dataDir, "mon-*"))
+	}
+	return fmt.Sprintf("rm -rf %s/*", dataDir)
+}
+
+// newCleanupJob returns a Job that wipes the given data dir, and optionally
+// the given devices, on the given node
+func newCleanupJob(sc *ocsv1.StorageCluster, node corev1.Node, cephImage, dataDir string, devices []string) *batchv1.Job {
+	backoffLimit := cleanupJobBackoffLimit
+	privileged := true
+	labels := map[string]string{
+		"app": "ocs-cleanup",
+	}
+
+	script := []string{getCleanupScript(sc, dataDir)}
+	volumes := []corev1.Volume{
+		corev1.Volume{
+			Name: "data-dir-host-path",
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: dataDir},
+			},
+		},
+	}
+	volumeMounts := []corev1.VolumeMount{
+		corev1.VolumeMount{Name: "data-dir-host-path", MountPath: dataDir},
+	}
+
+	for i, device := range devices {
+		name := fmt.Sprintf("device-%d", i)
+		mountPath := fmt.Sprintf("/wipe/%s", name)
+		volumes = append(volumes, corev1.Volume{
+			Name: name,
+			VolumeSource: corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: device},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{Name: name, MountPath: mountPath})
+		script = append(script,
+			fmt.Sprintf("sgdisk --zap-all %s", mountPath),
+			fmt.Sprintf("dd if=/dev/zero of=%s bs=1M count=100 oflag=direct,dsync", mountPath),
+		)
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      generateNameForCleanupJob(sc, node.Name),
+			Namespace: sc.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					NodeName:           node.Name,
+					ServiceAccountName: generateNameForCleanupServiceAccount(sc),
+					RestartPolicy:      corev1.RestartPolicyOnFailure,
+					Tolerations:        defaults.DaemonPlacements["all"].Tolerations,
+					Containers: []corev1.Container{
+						corev1.Container{
+							Name:    "cleanup",
+							Image:   cephImage,
+							Command: []string{"/bin/bash", "-c", strings.Join(script, " && ")},
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: &privileged,
+							},
+							VolumeMounts: volumeMounts,
+						},
+					},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+// deleteNodeRackLabels removes the rack labels that were added to the
+// storage nodes by ensureNodeRacks, unless another StorageCluster relies on
+// them
+func (r *ReconcileStorageCluster) deleteNodeRackLabels(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	defer r.locks.lock(nodesLockKey)()
+
+	nodes, err := r.getStorageNodes()
+	if err != nil {
+		return false, err
+	}
+
+	for _, node := range nodes.Items {
+		rack, ok := node.Labels[defaults.RackTopologyKey]
+		if !ok || !generatedRackName.MatchString(rack) {
+			continue
+		}
+
+		newNode := node.DeepCopy()
+		changed := setNodeChangeOwner(sc, newNode, nodeRackChange, false)
+		if !hasOtherNodeChangeOwner(sc, node, nodeRackChange) {
+			reqLogger.Info("Removing rack label from node", "Node", node.Name, "Label", defaults.RackTopologyKey, "Value", rack)
+			delete(newNode.Labels, defaults.RackTopologyKey)
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		err = r.client.Update(context.TODO(), newNode)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// deleteCephConfig deletes the Rook config override ConfigMap
+func (r *ReconcileStorageCluster) deleteCephConfig(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	return r.deleteOwnedObject(sc, &corev1.ConfigMap{}, rookConfigMapName, reqLogger)
+}
+
+// deleteStorageClasses deletes the cluster-scoped StorageClasses created for
+// the StorageCluster, under either of their names
+func (r *ReconcileStorageCluster) deleteStorageClasses(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	for _, name := range []string{generateNameForCephFilesystemSC(sc), generateNameForCephBlockPoolSC(sc), generateNameForCephRgwSC(sc)} {
+		for _, name := range []string{name, generateNameForNamespacedClusterScoped(sc, name)} {
+			err := r.deleteClusterScopedObject(sc, &storagev1.StorageClass{}, "StorageClass", name, reqLogger)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// deleteSnapshotClasses deletes the cluster-scoped VolumeSnapshotClasses
+// created for the StorageCluster, under either of their names
+func (r *ReconcileStorageCluster) deleteSnapshotClasses(sc *ocsv1.StorageCluster, reqLogger logr.Logger) (bool, error) {
+	for _, name := range []string{generateNameForCephFilesystemVSC(sc), generateNameForCephBlockPoolVSC(sc)} {
+		for _, name := range []string{name, generateNameForNamespacedClusterScoped(sc, name)} {
+			existing := &unstructured.Unstructured{}
+			existing.SetGroupVersionKind(volumeSnapshotGroupVersion.WithKind("VolumeSnapshotClass"))
+			err := r.deleteClusterScopedObject(sc, existing, "VolumeSnapshotClass", name, reqLogger)
+			if meta.IsNoMatchError(err) {
+				return true, nil
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// deleteClusterScopedObject deletes a cluster-scoped object created for the
+// StorageCluster. An object of the same name created for another
+// StorageCluster is left alone.
+func (r *ReconcileStorageCluster) deleteClusterScopedObject(sc *ocsv1.StorageCluster, obj runtime.Object, kind, name string, reqLogger logr.Logger) error {
+	defer r.locks.lock(clusterScopedLockKey(kind, name))()
+
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: name}, obj)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	if !isClusterScopedOwner(sc, accessor) {
+		reqLogger.Info(fmt.Sprintf("Not deleting %s %s of StorageCluster %s", kind, name, accessor.GetAnnotations()[storageClusterAnnotation]))
+		return nil
+	}
+
+	reqLogger.Info(fmt.Sprintf("Deleting %s %s", kind, name))
+	err = r.client.Delete(context.TODO(), obj)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+package storagecluster
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	api "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestUninstallGracefulBlockedByConsumers(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	rbdSCName := generateNameForCephBlockPoolSC(sc)
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-data",
+			Namespace: "app-ns",
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &rbdSCName,
+		},
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, pvc)
+
+	done, err := reconciler.deleteResources(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.Equal(t, statusutil.PhaseDeleting, sc.Status.Phase)
+
+	found := false
+	for _, condition := range sc.Status.Conditions {
+		if condition.Type == "Progressing" {
+			found = true
+			assert.Equal(t, corev1.ConditionTrue, condition.Status)
+			assert.Equal(t, uninstallReason, condition.Reason)
+			assert.Contains(t, condition.Message, "PersistentVolumeClaim app-ns/app-data")
+		}
+	}
+	assert.True(t, found, "expected Progressing condition not found")
+
+	// Nothing must have been removed while the uninstall is blocked
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "app-data", Namespace: "app-ns"}, pvc)
+	assert.NoError(t, err)
+}
+
+func TestUninstallForcedIgnoresConsumers(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	sc.Annotations = map[string]string{api.ForceDeletionAnnotation: "true"}
+	sc.Status.Phase = statusutil.PhaseReady
+	sc.Finalizers = []string{storageClusterFinalizer}
+	now := metav1.Now()
+	sc.DeletionTimestamp = &now
+	rbdSCName := generateNameForCephBlockPoolSC(sc)
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-data",
+			Namespace: "app-ns",
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &rbdSCName,
+		},
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, pvc)
+
+	consumers, err := reconciler.getStorageConsumers(sc)
+	assert.NoError(t, err)
+	assert.Len(t, consumers, 1)
+
+	// The consumer does not hold the uninstall back, so the finalizer is
+	// removed
+	_, err = reconciler.Reconcile(mockStorageClusterRequest)
+	assert.NoError(t, err)
+	found := &api.StorageCluster{}
+	err = reconciler.client.Get(context.TODO(), mockStorageClusterRequest.NamespacedName, found)
+	assert.NoError(t, err)
+	assert.NotContains(t, found.Finalizers, storageClusterFinalizer)
+	assert.NotEqual(t, "StorageConsumersFound", getDeletionBlockedReason(found))
+
+	// The consumer itself is left alone
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "app-data", Namespace: "app-ns"}, pvc)
+	assert.NoError(t, err)
+}
+
+// getDeletionBlockedReason returns the reason of the DeletionBlocked
+// condition of the StorageCluster, if any
+func getDeletionBlockedReason(sc *api.StorageCluster) string {
+	for _, condition := range sc.Status.Conditions {
+		if condition.Type == api.ConditionDeletionBlocked {
+			return condition.Reason
+		}
+	}
+	return ""
+}
+
+func TestStorageConsumersIgnoreNooBaaDB(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	rbdSCName := generateNameForCephBlockPoolSC(sc)
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "db-noobaa-core-0",
+			Namespace: sc.Namespace,
+			Labels:    map[string]string{"noobaa-core": "noobaa"},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &rbdSCName,
+		},
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, pvc)
+
+	consumers, err := reconciler.getStorageConsumers(sc)
+	assert.NoError(t, err)
+	assert.Len(t, consumers, 0)
+}
+
+func TestEnsureCleanupJobs(t *testing.T) {
+	cases := []struct {
+		label        string
+		mode         api.UninstallModeType
+		expectedDone bool
+	}{
+		{
+			label:        "graceful uninstall is blocked by a failed Job",
+			mode:         api.UninstallModeGraceful,
+			expectedDone: false,
+		},
+		{
+			label:        "forced uninstall ignores a failed Job",
+			mode:         api.UninstallModeForced,
+			expectedDone: true,
+		},
+	}
+
+	for _, c := range cases {
+		sc := &api.StorageCluster{}
+		mockStorageCluster.DeepCopyInto(sc)
+		sc.Spec.Uninstall.Mode = c.mode
+		sc.Annotations = map[string]string{
+			cleanupDataDirAnnotation: getDataDirHostPath(sc),
+			cleanupNodesAnnotation:   "node1,node2",
+		}
+		nodeList := &corev1.NodeList{}
+		mockNodeList.DeepCopyInto(nodeList)
+		reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+
+		// The first pass creates one Job per node the daemons ran on
+		done, err := reconciler.ensureCleanupJobs(sc, reconciler.reqLogger)
+		assert.NoError(t, err, c.label)
+		assert.False(t, done, c.label)
+
+		jobs := &batchv1.JobList{}
+		err = reconciler.client.List(context.TODO(), jobs)
+		assert.NoError(t, err, c.label)
+		assert.Len(t, jobs.Items, 2, c.label)
+		for _, job := range jobs.Items {
+			assert.NotEqual(t, "node3", job.Spec.Template.Spec.NodeName, c.label)
+			assert.Equal(t, generateNameForCleanupServiceAccount(sc), job.Spec.Template.Spec.ServiceAccountName, c.label)
+		}
+		err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: generateNameForCleanupServiceAccount(sc), Namespace: sc.Namespace}, &corev1.ServiceAccount{})
+		assert.NoError(t, err, c.label)
+
+		for i, job := range jobs.Items {
+			if i == 0 {
+				job.Status.Conditions = []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue},
+				}
+			} else {
+				job.Status.Succeeded = 1
+			}
+			err = reconciler.client.Update(context.TODO(), &job)
+			assert.NoError(t, err, c.label)
+		}
+
+		done, err = reconciler.ensureCleanupJobs(sc, reconciler.reqLogger)
+		assert.NoError(t, err, c.label)
+		assert.Equal(t, c.expectedDone, done, c.label)
+	}
+}
+
+func TestEnsureCleanupJobsNothingRecorded(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	nodeList := &corev1.NodeList{}
+	mockNodeList.DeepCopyInto(nodeList)
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+
+	// Without a CephCluster to clean up after, no node is wiped
+	done, err := reconciler.ensureCleanupJobs(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	jobs := &batchv1.JobList{}
+	err = reconciler.client.List(context.TODO(), jobs)
+	assert.NoError(t, err)
+	assert.Len(t, jobs.Items, 0)
+}
+
+func TestRecordCleanupTargets(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	cephCluster := newCephCluster(sc, "")
+	pods := &corev1.PodList{}
+	for i, node := range []string{"node2", "node1", "node2", ""} {
+		pods.Items = append(pods.Items, corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("rook-ceph-daemon-%d", i),
+				Namespace: sc.Namespace,
+				Labels:    map[string]string{"rook_cluster": sc.Namespace},
+			},
+			Spec: corev1.PodSpec{NodeName: node},
+		})
+	}
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other",
+			Namespace: sc.Namespace,
+		},
+		Spec: corev1.PodSpec{NodeName: "node3"},
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, cephCluster, pods, otherPod)
+
+	err := reconciler.recordCleanupTargets(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, getDataDirHostPath(sc), sc.Annotations[cleanupDataDirAnnotation])
+	assert.Equal(t, "node1,node2", sc.Annotations[cleanupNodesAnnotation])
+
+	// What is recorded is kept once the daemons are gone
+	for _, pod := range pods.Items {
+		err = reconciler.client.Delete(context.TODO(), &pod)
+		assert.NoError(t, err)
+	}
+	err = reconciler.recordCleanupTargets(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1,node2", sc.Annotations[cleanupNodesAnnotation])
+}
+
+func TestNewCleanupJob(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	node := mockNodeList.Items[0]
+	dataDir := getDataDirHostPath(sc)
+
+	job := newCleanupJob(sc, node, "ceph/ceph:v14", dataDir, nil)
+	assert.Equal(t, sc.Namespace, job.Namespace)
+	assert.Equal(t, node.Name, job.Spec.Template.Spec.NodeName)
+	assert.Len(t, job.Spec.Template.Spec.Volumes, 1)
+	assert.Equal(t, dataDir, job.Spec.Template.Spec.Volumes[0].HostPath.Path)
+	assert.Equal(t, fmt.Sprintf("rm -rf %s/*", dataDir), job.Spec.Template.Spec.Containers[0].Command[2])
+
+	job = newCleanupJob(sc, node, "ceph/ceph:v14", dataDir, []string{"/dev/sdb", "/dev/sdc"})
+	assert.Len(t, job.Spec.Template.Spec.Volumes, 3)
+	assert.Equal(t, 2, strings.Count(job.Spec.Template.Spec.Containers[0].Command[2], "sgdisk --zap-all"))
+
+	node.Name = strings.Repeat("a", 80)
+	job = newCleanupJob(sc, node, "ceph/ceph:v14", dataDir, nil)
+	assert.True(t, len(job.Name) <= 63, "Job name too long: %s", job.Name)
+}
+
+func TestGetCleanupScriptSharedDataDir(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+
+	// The data dirs of the other StorageClusters are left in the shared
+	// one
+	script := getCleanupScript(sc, "/var/lib/rook/")
+	assert.Equal(t, fmt.Sprintf("rm -rf /var/lib/rook/%s /var/lib/rook/mon-*", sc.Namespace), script)
+}
+
+func TestDeleteNodeRackLabels(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	nodeList := &corev1.NodeList{}
+	mockNodeList.DeepCopyInto(nodeList)
+	nodeList.Items[0].Labels[defaults.RackTopologyKey] = "rack0"
+	nodeList.Items[1].Labels[defaults.RackTopologyKey] = "rack1"
+	nodeList.Items[2].Labels[defaults.RackTopologyKey] = "my-rack"
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+
+	done, err := reconciler.deleteNodeRackLabels(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+
+	expected := map[string]string{
+		"node1": "",
+		"node2": "",
+		"node3": "my-rack",
+	}
+	for name, rack := range expected {
+		node := &corev1.Node{}
+		err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: name}, node)
+		assert.NoError(t, err)
+		assert.Equal(t, rack, node.Labels[defaults.RackTopologyKey], name)
+	}
+}
+
+func TestDeleteNodeRackLabelsSharedWithOtherStorageCluster(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	other := newNamespacedStorageCluster(sc.Name, "other-ns")
+	nodeList := &corev1.NodeList{}
+	mockNodeList.DeepCopyInto(nodeList)
+	for i := range nodeList.Items {
+		nodeList.Items[i].Labels[defaults.RackTopologyKey] = fmt.Sprintf("rack%d", i)
+		setNodeChangeOwner(sc, &nodeList.Items[i], nodeRackChange, true)
+	}
+	setNodeChangeOwner(other, &nodeList.Items[0], nodeRackChange, true)
+	reconciler := createFakeStorageClusterReconciler(t, sc, nodeList)
+
+	// The rack of node1 is kept for the other StorageCluster
+	done, err := reconciler.deleteNodeRackLabels(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	expected := map[string]string{
+		"node1": "rack0",
+		"node2": "",
+		"node3": "",
+	}
+	for name, rack := range expected {
+		node := &corev1.Node{}
+		err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: name}, node)
+		assert.NoError(t, err)
+		assert.Equal(t, rack, node.Labels[defaults.RackTopologyKey], name)
+		assert.False(t, hasOtherNodeChangeOwner(other, *node, nodeRackChange), name)
+	}
+
+	done, err = reconciler.deleteNodeRackLabels(other, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+	node := &corev1.Node{}
+	err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: "node1"}, node)
+	assert.NoError(t, err)
+	assert.NotContains(t, node.Labels, defaults.RackTopologyKey)
+	assert.NotContains(t, node.Annotations, storageClusterAnnotation)
+}
+
+func TestStorageConsumersClusterScopedNames(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	other := newNamespacedStorageCluster(sc.Name, "other-ns")
+	// The other StorageCluster got to the legacy name first
+	rbdSCName := generateNameForCephBlockPoolSC(sc)
+	storageClass := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: rbdSCName}}
+	setClusterScopedOwner(other, storageClass)
+	namespacedSCName := generateNameForNamespacedClusterScoped(sc, rbdSCName)
+	newPVC := func(name, storageClassName string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "app-ns",
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				StorageClassName: &storageClassName,
+			},
+		}
+	}
+	reconciler := createFakeStorageClusterReconciler(t, sc, storageClass, newPVC("other-data", rbdSCName), newPVC("app-data", namespacedSCName))
+
+	consumers, err := reconciler.getStorageConsumers(sc)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PersistentVolumeClaim app-ns/app-data"}, consumers)
+
+	consumers, err = reconciler.getStorageConsumers(other)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PersistentVolumeClaim app-ns/other-data"}, consumers)
+}
+
+func TestDeleteStorageClasses(t *testing.T) {
+	sc := &api.StorageCluster{}
+	mockStorageCluster.DeepCopyInto(sc)
+	reconciler := createFakeStorageClusterReconciler(t, sc)
+
+	scs, err := reconciler.newStorageClasses(sc)
+	assert.NoError(t, err)
+	for _, storageClass := range scs {
+		err = reconciler.client.Create(context.TODO(), storageClass)
+		assert.NoError(t, err)
+	}
+
+	done, err := reconciler.deleteStorageClasses(sc, reconciler.reqLogger)
+	assert.NoError(t, err)
+	assert.True(t, done)
+
+	for _, storageClass := range scs {
+		err = reconciler.client.Get(context.TODO(), types.NamespacedName{Name: storageClass.Name}, &storagev1.StorageClass{})
+		assert.True(t, errors.IsNotFound(err), storageClass.Name)
+	}
+}
+
+// completeCleanupJobs marks all node cleanup Jobs as succeeded
+func completeCleanupJobs(t *testing.T, reconciler ReconcileStorageCluster) {
+	jobs := &batchv1.JobList{}
+	err := reconciler.client.List(context.TODO(), jobs)
+	assert.NoError(t, err)
+	for _, job := range jobs.Items {
+		if job.Status.Succeeded > 0 {
+			continue
+		}
+		job.Status.Succeeded = 1
+		err = reconciler.client.Update(context.TODO(), &job)
+		assert.NoError(t, err)
+	}
+}
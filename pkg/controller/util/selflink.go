@@ -0,0 +1,32 @@
+package util
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// SetSelfLink sets the selfLink of an object the way the apiserver does. The
+// clients standing in for the apiserver set it, as the object references in
+// the status are made from it.
+func SetSelfLink(scheme *runtime.Scheme, obj runtime.Object) {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+	plural, _ := meta.UnsafeGuessKindToResource(gvk)
+	selfLink := "/apis/" + gvk.GroupVersion().String()
+	if gvk.Group == "" {
+		selfLink = "/api/" + gvk.Version
+	}
+	if accessor.GetNamespace() != "" {
+		selfLink += "/namespaces/" + accessor.GetNamespace()
+	}
+	accessor.SetSelfLink(fmt.Sprintf("%s/%s/%s", selfLink, plural.Resource, accessor.GetName()))
+}
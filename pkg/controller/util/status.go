@@ -25,6 +25,8 @@ var (
 	PhaseNotReady = "Not Ready"
 	// PhaseClusterExpanding is used when cluster is expanding capacity
 	PhaseClusterExpanding = "Expanding Capacity"
+	// PhaseDeleting is used when the resource is being uninstalled
+	PhaseDeleting = "Deleting"
 )
 
 // SetProgressingCondition sets the ProgressingCondition to True and other conditions to
@@ -231,3 +233,108 @@ func MapNoobaaNegativeConditions(conditions *[]conditionsv1.Condition, found *nb
 	}
 
 }
+
+// MapBackingStoreNegativeConditions records the phase of a NooBaa BackingStore
+// This will only look for negative conditions: Degraded, Progressing
+func MapBackingStoreNegativeConditions(conditions *[]conditionsv1.Condition, found *nbv1.BackingStore) {
+	switch found.Status.Phase {
+	case nbv1.BackingStorePhaseRejected:
+		setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+			Type:    conditionsv1.ConditionDegraded,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BackingStoreRejected",
+			Message: fmt.Sprintf("BackingStore %s is rejected by the noobaa operator", found.Name),
+		})
+	case "", nbv1.BackingStorePhaseVerifying, nbv1.BackingStorePhaseConnecting, nbv1.BackingStorePhaseCreating:
+		setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+			Type:    conditionsv1.ConditionProgressing,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BackingStoreInitializing",
+			Message: fmt.Sprintf("Waiting on BackingStore %s to become ready", found.Name),
+		})
+	case nbv1.BackingStorePhaseReady:
+		// no-op. Ready isn't a negative case
+	default:
+		setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+			Type:    conditionsv1.ConditionDegraded,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BackingStorePhaseUnknown",
+			Message: fmt.Sprintf("BackingStore %s phase %s is unknown", found.Name, found.Status.Phase),
+		})
+	}
+}
+
+// MapBucketClassNegativeConditions records the phase of a NooBaa BucketClass
+// This will only look for negative conditions: Degraded, Progressing
+func MapBucketClassNegativeConditions(conditions *[]conditionsv1.Condition, found *nbv1.BucketClass) {
+	switch found.Status.Phase {
+	case nbv1.BucketClassPhaseRejected:
+		setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+			Type:    conditionsv1.ConditionDegraded,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BucketClassRejected",
+			Message: fmt.Sprintf("BucketClass %s is rejected by the noobaa operator", found.Name),
+		})
+	case "", nbv1.BucketClassPhaseVerifying, nbv1.BucketClassPhaseConfiguring:
+		setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+			Type:    conditionsv1.ConditionProgressing,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BucketClassInitializing",
+			Message: fmt.Sprintf("Waiting on BucketClass %s to become ready", found.Name),
+		})
+	case nbv1.BucketClassPhaseReady:
+		// no-op. Ready isn't a negative case
+	default:
+		setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+			Type:    conditionsv1.ConditionDegraded,
+			Status:  corev1.ConditionTrue,
+			Reason:  "BucketClassPhaseUnknown",
+			Message: fmt.Sprintf("BucketClass %s phase %s is unknown", found.Name, found.Status.Phase),
+		})
+	}
+}
+
+// MapInvalidBackingStore records a BackingStore that can not be created
+// because of an invalid spec or missing credentials
+func MapInvalidBackingStore(conditions *[]conditionsv1.Condition, reason string, message string) {
+	setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+		Type:    conditionsv1.ConditionDegraded,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// MapMultiCloudGatewayObjectNotOwned records a BackingStore or BucketClass
+// of the spec that exists already and is not controlled by the
+// StorageCluster, which is left alone
+func MapMultiCloudGatewayObjectNotOwned(conditions *[]conditionsv1.Condition, reason string, message string) {
+	setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+		Type:    conditionsv1.ConditionDegraded,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// MapObjectStoreEndpointPending records an object store endpoint that is
+// waiting on another component, like the service-serving CA or the router
+func MapObjectStoreEndpointPending(conditions *[]conditionsv1.Condition, reason string, message string) {
+	setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+		Type:    conditionsv1.ConditionProgressing,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// MapInvalidObjectStoreEndpoint records an object store endpoint that can
+// not be set up because of an invalid spec or certificate
+func MapInvalidObjectStoreEndpoint(conditions *[]conditionsv1.Condition, reason string, message string) {
+	setStatusConditionIfNotPresent(conditions, conditionsv1.Condition{
+		Type:    conditionsv1.ConditionDegraded,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
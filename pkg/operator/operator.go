@@ -0,0 +1,95 @@
+// Package operator sets up the manager running the OCS controllers
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	obv1 "github.com/kube-object-storage/lib-bucket-provisioner/pkg/apis/objectbucket.io/v1alpha1"
+	nbapis "github.com/noobaa/noobaa-operator/v2/pkg/apis"
+	secv1 "github.com/openshift/api/security/v1"
+	"github.com/openshift/ocs-operator/pkg/apis"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller"
+	"github.com/openshift/ocs-operator/pkg/controller/ocsinitialization"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("operator")
+
+// AddToScheme adds every type the controllers work with to the scheme
+func AddToScheme(scheme *runtime.Scheme) error {
+	for _, s := range []struct {
+		name        string
+		addToScheme func(*runtime.Scheme) error
+	}{
+		{"ocs apis", apis.AddToScheme},
+		{"cephv1", cephv1.AddToScheme},
+		{"storage/v1", storagev1.AddToScheme},
+		{"noobaa apis", nbapis.AddToScheme},
+		{"monitoring/v1 apis", monitoringv1.AddToScheme},
+		{"core/v1", corev1.AddToScheme},
+		{"batch/v1", batchv1.AddToScheme},
+		{"objectbucket.io/v1alpha1", obv1.AddToScheme},
+		{"security/v1", secv1.Install},
+	} {
+		if err := s.addToScheme(scheme); err != nil {
+			return fmt.Errorf("Failed adding %s to scheme: %v", s.name, err)
+		}
+	}
+	return nil
+}
+
+// NewManager returns a manager running all controllers
+func NewManager(cfg *rest.Config, options manager.Options) (manager.Manager, error) {
+	mgr, err := manager.New(cfg, options)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Registering Components.")
+
+	// Setup Scheme for all api resources
+	if err := AddToScheme(mgr.GetScheme()); err != nil {
+		return nil, err
+	}
+
+	// Setup all Controllers
+	if err := controller.AddToManager(mgr); err != nil {
+		return nil, err
+	}
+
+	return mgr, nil
+}
+
+// CreateOCSInitialization creates the OCSInitialization resource if it's not
+// there
+func CreateOCSInitialization(c client.Client) error {
+	ocsNamespacedName := ocsinitialization.InitNamespacedName()
+	err := c.Create(context.TODO(), &ocsv1.OCSInitialization{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ocsNamespacedName.Name,
+			Namespace: ocsNamespacedName.Namespace,
+		},
+	})
+	switch {
+	case err == nil:
+		log.Info("Created OCSInitialization resource")
+	case errors.IsAlreadyExists(err):
+		log.Info("OCSInitialization resource already exists")
+	default:
+		return fmt.Errorf("Failed to create OCSInitialization custom resource: %v", err)
+	}
+	return nil
+}
@@ -25,7 +25,11 @@ const (
 	pathStatusNoobaaSystem   = "/status/noobaaSystemCreated"
 	pathStatusRelatedObjs    = "/status/relatedObjects/"
 	pathStatusNodeTopologies = "/status/nodeTopologies/"
+	pathStatusStaleSince     = "/status/staleNodeTopologies/missingSince"
+	pathStatusLastRackMove   = "/status/rackRebalance/lastMoveTime"
+	pathStatusStepDuration   = "/status/reconcileSteps/duration"
 	pathSpecMonPVCTemplate   = "/spec/monPVCTemplate/"
+	pathPVPoolResources      = "/spec/multiCloudGateway/backingStores/pvPool/resources/"
 )
 
 func TestSampleCustomResources(t *testing.T) {
@@ -84,6 +88,10 @@ func TestCompleteCRD(t *testing.T) {
 			pathStatusRelatedObjs,
 			pathSpecMonPVCTemplate,
 			pathStatusNodeTopologies,
+			pathStatusStaleSince,
+			pathStatusLastRackMove,
+			pathStatusStepDuration,
+			pathPVPoolResources,
 		}
 		for _, missing := range missingEntries {
 			skipAsOmission := false
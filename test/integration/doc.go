@@ -0,0 +1,6 @@
+// Package integration runs the operator against a local kube-apiserver and
+// etcd. The tests are built with the integration tag, and need the binaries
+// of the apiserver and etcd, found in /usr/local/kubebuilder/bin or in
+// $KUBEBUILDER_ASSETS. Run them with `make integration-test`, which
+// downloads the binaries first.
+package integration
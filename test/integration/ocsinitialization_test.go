@@ -0,0 +1,92 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/ocsinitialization"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSCCsCreated(t *testing.T) {
+	for _, name := range []string{"rook-ceph", "rook-ceph-csi"} {
+		eventually(t, "SecurityContextConstraints "+name+" not created", func() (bool, error) {
+			_, err := secClient.SecurityContextConstraints().Get(name, metav1.GetOptions{})
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return err == nil, err
+		})
+	}
+}
+
+func TestOCSInitializationRecreated(t *testing.T) {
+	key := ocsinitialization.InitNamespacedName()
+	initial := &ocsv1.OCSInitialization{}
+	eventually(t, "OCSInitialization not created", func() (bool, error) {
+		err := k8sClient.Get(context.TODO(), key, initial)
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+
+	err := k8sClient.Delete(context.TODO(), initial)
+	assert.NoError(t, err)
+
+	eventually(t, "OCSInitialization not recreated", func() (bool, error) {
+		found := &ocsv1.OCSInitialization{}
+		err := k8sClient.Get(context.TODO(), key, found)
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil && found.UID != initial.UID, err
+	})
+}
+
+func TestCephToolsDeployment(t *testing.T) {
+	key := ocsinitialization.InitNamespacedName()
+	deploymentKey := types.NamespacedName{Name: "rook-ceph-tools", Namespace: namespace}
+
+	setCephTools := func(enabled bool) {
+		eventually(t, "Failed to update OCSInitialization", func() (bool, error) {
+			found := &ocsv1.OCSInitialization{}
+			err := k8sClient.Get(context.TODO(), key, found)
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			found.Spec.EnableCephTools = enabled
+			err = k8sClient.Update(context.TODO(), found)
+			if errors.IsConflict(err) {
+				return false, nil
+			}
+			return err == nil, err
+		})
+	}
+
+	setCephTools(true)
+	eventually(t, "Ceph tools Deployment not created", func() (bool, error) {
+		err := k8sClient.Get(context.TODO(), deploymentKey, &appsv1.Deployment{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+
+	setCephTools(false)
+	eventually(t, "Ceph tools Deployment not deleted", func() (bool, error) {
+		err := k8sClient.Get(context.TODO(), deploymentKey, &appsv1.Deployment{})
+		return errors.IsNotFound(err), nil
+	})
+}
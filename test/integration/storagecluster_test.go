@@ -0,0 +1,217 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	nbv1 "github.com/noobaa/noobaa-operator/v2/pkg/apis/noobaa/v1alpha1"
+	ocsv1 "github.com/openshift/ocs-operator/pkg/apis/ocs/v1"
+	"github.com/openshift/ocs-operator/pkg/controller/defaults"
+	statusutil "github.com/openshift/ocs-operator/pkg/controller/util"
+	cephv1 "github.com/rook/rook/pkg/apis/ceph.rook.io/v1"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const storageClusterName = "ocs-storagecluster"
+
+var storageClusterKey = types.NamespacedName{Name: storageClusterName, Namespace: namespace}
+
+func newStorageNodes() []*corev1.Node {
+	nodes := []*corev1.Node{}
+	for i, zone := range []string{"us-east-1a", "us-east-1b", "us-east-1c"} {
+		name := fmt.Sprintf("worker-%d", i)
+		nodes = append(nodes, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					defaults.NodeAffinityKey:      "",
+					corev1.LabelHostname:          name,
+					"topology.kubernetes.io/zone": zone,
+				},
+			},
+		})
+	}
+	return nodes
+}
+
+func newStorageCluster() *ocsv1.StorageCluster {
+	storageClassName := "gp2"
+	volumeMode := corev1.PersistentVolumeBlock
+	return &ocsv1.StorageCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      storageClusterName,
+			Namespace: namespace,
+		},
+		Spec: ocsv1.StorageClusterSpec{
+			StorageDeviceSets: []ocsv1.StorageDeviceSet{
+				{
+					Name:  "ocs-deviceset",
+					Count: 3,
+					DataPVCTemplate: corev1.PersistentVolumeClaim{
+						Spec: corev1.PersistentVolumeClaimSpec{
+							AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceStorage: resource.MustParse("2Ti"),
+								},
+							},
+							StorageClassName: &storageClassName,
+							VolumeMode:       &volumeMode,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// getStorageCluster returns the StorageCluster, or nil if it is not found
+func getStorageCluster(t *testing.T) *ocsv1.StorageCluster {
+	sc := &ocsv1.StorageCluster{}
+	err := k8sClient.Get(context.TODO(), storageClusterKey, sc)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	assert.NoError(t, err)
+	return sc
+}
+
+// exists tells whether the object is found under the key
+func exists(key types.NamespacedName, obj runtime.Object) (bool, error) {
+	err := k8sClient.Get(context.TODO(), key, obj)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// waitForStorageClusterPhase waits until the StorageCluster is in the phase
+func waitForStorageClusterPhase(t *testing.T, phase string) {
+	eventually(t, "StorageCluster not "+phase, func() (bool, error) {
+		sc := getStorageCluster(t)
+		return sc != nil && sc.Status.Phase == phase, nil
+	})
+}
+
+func getCephCluster(t *testing.T) *cephv1.CephCluster {
+	cephCluster := &cephv1.CephCluster{}
+	err := k8sClient.Get(context.TODO(), types.NamespacedName{Name: storageClusterName + "-cephcluster", Namespace: namespace}, cephCluster)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	assert.NoError(t, err)
+	return cephCluster
+}
+
+func TestStorageClusterLifecycle(t *testing.T) {
+	for _, node := range newStorageNodes() {
+		err := k8sClient.Create(context.TODO(), node)
+		assert.NoError(t, err)
+	}
+
+	t.Run("create", func(t *testing.T) {
+		err := k8sClient.Create(context.TODO(), newStorageCluster())
+		assert.NoError(t, err)
+
+		eventually(t, "CephCluster not created", func() (bool, error) {
+			cephCluster := getCephCluster(t)
+			return cephCluster != nil && cephCluster.Status.State == cephv1.ClusterStateCreated, nil
+		})
+		sc := getStorageCluster(t)
+		assert.Contains(t, sc.GetFinalizers(), "storagecluster.ocs.openshift.io")
+		assert.Equal(t, "zone", sc.Status.FailureDomain)
+		owner := metav1.GetControllerOf(getCephCluster(t))
+		if assert.NotNil(t, owner) {
+			assert.Equal(t, sc.UID, owner.UID)
+		}
+
+		eventually(t, "StorageClusterInitialization not created", func() (bool, error) {
+			return exists(storageClusterKey, &ocsv1.StorageClusterInitialization{})
+		})
+		// The NooBaa system waits on the CephCluster
+		eventually(t, "NooBaa system not created", func() (bool, error) {
+			return exists(types.NamespacedName{Name: "noobaa", Namespace: namespace}, &nbv1.NooBaa{})
+		})
+		waitForStorageClusterPhase(t, statusutil.PhaseReady)
+	})
+
+	t.Run("expand", func(t *testing.T) {
+		eventually(t, "Failed to expand StorageCluster", func() (bool, error) {
+			sc := getStorageCluster(t)
+			sc.Spec.StorageDeviceSets[0].Count = 6
+			err := k8sClient.Update(context.TODO(), sc)
+			if errors.IsConflict(err) {
+				return false, nil
+			}
+			return err == nil, err
+		})
+
+		eventually(t, "CephCluster not expanded", func() (bool, error) {
+			cephCluster := getCephCluster(t)
+			if cephCluster == nil {
+				return false, nil
+			}
+			for _, set := range cephCluster.Spec.Storage.StorageClassDeviceSets {
+				if set.Count != 2 {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+		waitForStorageClusterPhase(t, statusutil.PhaseReady)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		err := k8sClient.Delete(context.TODO(), getStorageCluster(t))
+		assert.NoError(t, err)
+
+		waitForStorageClusterPhase(t, statusutil.PhaseDeleting)
+		// The finalizer holds the StorageCluster until the node cleanup
+		// Jobs complete
+		eventually(t, "StorageCluster not deleted", func() (bool, error) {
+			if getStorageCluster(t) == nil {
+				return true, nil
+			}
+			return false, completeCleanupJobs()
+		})
+
+		assert.Nil(t, getCephCluster(t))
+		found, err := exists(types.NamespacedName{Name: "noobaa", Namespace: namespace}, &nbv1.NooBaa{})
+		assert.NoError(t, err)
+		assert.False(t, found)
+	})
+}
+
+// completeCleanupJobs marks the node cleanup Jobs as succeeded, as there is
+// no job controller to run them
+func completeCleanupJobs() error {
+	jobs := &batchv1.JobList{}
+	err := k8sClient.List(context.TODO(), jobs, client.InNamespace(namespace))
+	if err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Status.Succeeded > 0 {
+			continue
+		}
+		job.Status.Succeeded = 1
+		err = k8sClient.Status().Update(context.TODO(), job)
+		if err != nil && !errors.IsConflict(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghodss/yaml"
+	secscheme "github.com/openshift/client-go/security/clientset/versioned/scheme"
+	secv1client "github.com/openshift/client-go/security/clientset/versioned/typed/security/v1"
+	fakesecv1 "github.com/openshift/client-go/security/clientset/versioned/typed/security/v1/fake"
+	"github.com/openshift/ocs-operator/pkg/controller/ocsinitialization"
+	"github.com/openshift/ocs-operator/pkg/controller/simulator"
+	"github.com/openshift/ocs-operator/pkg/operator"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+const (
+	namespace    = "openshift-storage"
+	pollInterval = 250 * time.Millisecond
+	pollTimeout  = 60 * time.Second
+)
+
+// The CRDs of the component operators, from the latest bundle
+var componentCRDs = []string{
+	"cephblockpool.crd.yaml",
+	"cephcluster.crd.yaml",
+	"cephfilesystem.crd.yaml",
+	"cephobjectstore.crd.yaml",
+	"cephobjectstoreuser.crd.yaml",
+	"backingstore.crd.yaml",
+	"bucketclass.crd.yaml",
+	"noobaa.crd.yaml",
+	"objectbucket.crd.yaml",
+	"objectbucketclaim.crd.yaml",
+}
+
+var componentCRDDir = filepath.Join("..", "..", "deploy", "olm-catalog", "ocs-operator", "0.0.2")
+
+var (
+	// k8sClient reads from and writes to the apiserver directly
+	k8sClient client.Client
+	// secClient serves the SecurityContextConstraints from memory, as the
+	// apiserver does not know about them
+	secClient secv1client.SecurityV1Interface
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+// run starts the apiserver and the manager, with simulated Rook and NooBaa
+// operators, and runs the tests
+func run(m *testing.M) int {
+	logf.SetLogger(logf.ZapLogger(true))
+
+	crds, err := loadCRDs(componentCRDDir, componentCRDs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths: []string{filepath.Join("..", "..", "deploy", "crds")},
+		CRDs:              crds,
+	}
+	cfg, err := testEnv.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start the apiserver: %v\n", err)
+		return 1
+	}
+	defer testEnv.Stop()
+
+	for name, value := range map[string]string{
+		"WATCH_NAMESPACE":   namespace,
+		"ROOK_CEPH_IMAGE":   "rook/ceph:v1.1.0",
+		"CEPH_IMAGE":        "ceph/ceph:v14.2.4",
+		"NOOBAA_CORE_IMAGE": "noobaa/noobaa-core:5.2.11",
+		"NOOBAA_DB_IMAGE":   "centos/mongodb-36-centos7",
+	} {
+		os.Setenv(name, value)
+	}
+
+	tracker := clienttesting.NewObjectTracker(secscheme.Scheme, secscheme.Codecs.UniversalDecoder())
+	fakeSecClient := &fakesecv1.FakeSecurityV1{Fake: &clienttesting.Fake{}}
+	fakeSecClient.AddReactor("*", "*", clienttesting.ObjectReaction(tracker))
+	secClient = fakeSecClient
+	ocsinitialization.NewSecurityClient = func(*rest.Config) secv1client.SecurityV1Interface {
+		return secClient
+	}
+
+	mgr, err := operator.NewManager(cfg, manager.Options{Namespace: namespace, MetricsBindAddress: "0"})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create the manager: %v\n", err)
+		return 1
+	}
+	k8sClient, err = client.New(cfg, client.Options{Scheme: mgr.GetScheme()})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = k8sClient.Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create namespace %s: %v\n", namespace, err)
+		return 1
+	}
+	err = operator.CreateOCSInitialization(k8sClient)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		err := mgr.Start(stop)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Manager exited non-zero: %v\n", err)
+		}
+	}()
+	go simulator.Run([]simulator.Operator{
+		simulator.NewRookOperator(k8sClient, simulator.CephClusterCreated),
+		simulator.NewNooBaaOperator(k8sClient, simulator.NooBaaReady),
+	}, pollInterval, stop)
+
+	return m.Run()
+}
+
+// loadCRDs reads the CRDs from the files in dir
+func loadCRDs(dir string, files []string) ([]*apiextensionsv1beta1.CustomResourceDefinition, error) {
+	crds := []*apiextensionsv1beta1.CustomResourceDefinition{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+		crd := &apiextensionsv1beta1.CustomResourceDefinition{}
+		err = yaml.Unmarshal(data, crd)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CRD from %s: %v", file, err)
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+// eventually polls the condition until it is met, and fails the test if it is
+// not within pollTimeout
+func eventually(t *testing.T, message string, condition func() (bool, error)) {
+	t.Helper()
+	err := wait.PollImmediate(pollInterval, pollTimeout, condition)
+	if err != nil {
+		t.Fatalf("%s: %v", message, err)
+	}
+}